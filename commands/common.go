@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/bloomapi/gce-docker/providers"
+	"google.golang.org/api/compute/v1"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// GCECommand holds the configuration shared by every command running against
+// the instance the command is executed on.
+type GCECommand struct {
+	LogLevel     string
+	LogFile      string
+	Backoff      providers.Backoff
+	APIRateLimit float64
+
+	project  string
+	zone     string
+	instance string
+	client   *http.Client
+}
+
+func (c *GCECommand) setup() error {
+	if err := c.checkGCE(); err != nil {
+		return err
+	}
+
+	if err := c.loadMetadataInfo(); err != nil {
+		return err
+	}
+
+	if err := c.setupLogging(); err != nil {
+		return err
+	}
+
+	if err := c.Backoff.Validate(); err != nil {
+		return err
+	}
+
+	providers.DefaultBackoff = c.Backoff
+
+	return c.buildComputeClient()
+}
+
+func (c *GCECommand) checkGCE() error {
+	if !metadata.OnGCE() {
+		return fmt.Errorf("gce-docker driver only runs on Google Compute Engine")
+	}
+
+	return nil
+}
+
+func (c *GCECommand) loadMetadataInfo() error {
+	var err error
+	c.instance, err = metadata.InstanceName()
+	if err != nil {
+		return fmt.Errorf("error retrieving instance name: %s", err)
+	}
+
+	c.zone, err = metadata.Zone()
+	if err != nil {
+		return fmt.Errorf("error retrieving zone: %s", err)
+	}
+
+	c.project, err = metadata.ProjectID()
+	if err != nil {
+		return fmt.Errorf("error retrieving project: %s", err)
+	}
+
+	return nil
+}
+
+func (c *GCECommand) setupLogging() error {
+	lvl, err := log15.LvlFromString(c.LogLevel)
+	if err != nil {
+		return fmt.Errorf("unknown log level name %q", c.LogLevel)
+	}
+
+	handler := log15.StdoutHandler
+	format := log15.LogfmtFormat()
+
+	if c.LogFile != "" {
+		handler = log15.MultiHandler(handler, log15.Must.FileHandler(c.LogFile, format))
+	}
+
+	handler = log15.LvlFilterHandler(lvl, handler)
+
+	if lvl == log15.LvlDebug {
+		handler = log15.CallerFileHandler(log15.LvlFilterHandler(lvl, handler))
+	}
+
+	log15.Root().SetHandler(handler)
+	return nil
+}
+
+func (c *GCECommand) buildComputeClient() error {
+	ctx := context.Background()
+
+	var err error
+	c.client, err = google.DefaultClient(ctx, compute.ComputeScope)
+	if err != nil {
+		return fmt.Errorf("error building compute client: %s", err)
+	}
+
+	return providers.RateLimit(c.client, c.APIRateLimit)
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bloomapi/gce-docker/plugin"
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type CopySnapshotCommand struct {
+	*GCECommand
+	Region       string
+	Freeze       bool
+	PreSnapshot  string
+	PostSnapshot string
+}
+
+func NewCopySnapshotCommand(c *GCECommand) *CopySnapshotCommand {
+	return &CopySnapshotCommand{GCECommand: c}
+}
+
+func (c *CopySnapshotCommand) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "copy-snapshot <disk>",
+		Short: "snapshot a disk into another region, for disaster recovery",
+		RunE:  c.Execute,
+	}
+
+	cmd.Flags().StringVar(&c.Region, "region", "", "destination region of the snapshot")
+	cmd.Flags().BoolVar(&c.Freeze, "freeze", false, "freeze the filesystem of the disk while the snapshot is taken, if it's mounted")
+	cmd.Flags().StringVar(&c.PreSnapshot, "pre-snapshot-hook", "", "shell command run on the host before the snapshot, e.g. to flush the application")
+	cmd.Flags().StringVar(&c.PostSnapshot, "post-snapshot-hook", "", "shell command run on the host after the snapshot, even if it failed")
+	return cmd
+}
+
+func (c *CopySnapshotCommand) Execute(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("a disk name is required")
+	}
+
+	if c.Region == "" {
+		return fmt.Errorf("a destination region is required, use --region")
+	}
+
+	if err := c.setup(); err != nil {
+		return err
+	}
+
+	d, err := providers.NewDisk(c.client, c.project, c.zone, c.instance)
+	if err != nil {
+		return err
+	}
+
+	hooks := plugin.NewSnapshotHooks(plugin.NewFilesystem())
+	hooks.Freeze = c.Freeze
+	hooks.PreSnapshot = c.PreSnapshot
+	hooks.PostSnapshot = c.PostSnapshot
+
+	start := time.Now()
+	log15.Info("creating snapshot", "disk", args[0], "zone", c.zone, "region", c.Region, "freeze", c.Freeze)
+
+	config := &providers.DiskConfig{Name: args[0]}
+	var s *compute.Snapshot
+	err = hooks.Run(config, func(taken func()) error {
+		s, err = d.SnapshotToRegion(config, c.Region, taken)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log15.Info("snapshot created", "disk", args[0], "snapshot", s.Name, "region", c.Region, "elapsed", time.Since(start))
+	return nil
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/spf13/cobra"
+)
+
+type RecommendCommand struct {
+	Workload providers.Workload
+}
+
+func NewRecommendCommand() *RecommendCommand {
+	return &RecommendCommand{}
+}
+
+func (c *RecommendCommand) Command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recommend",
+		Short: "recommend the disk type and size for a workload, with its estimated cost",
+		RunE:  c.Execute,
+	}
+
+	cmd.Flags().Float64Var(&c.Workload.Iops, "iops", 0, "read IOPS required")
+	cmd.Flags().Float64Var(&c.Workload.Throughput, "throughput", 0, "throughput required, in MB/s")
+	cmd.Flags().Int64Var(&c.Workload.SizeGb, "size-gb", 0, "capacity required, in GB")
+	return cmd
+}
+
+func (c *RecommendCommand) Execute(cmd *cobra.Command, args []string) error {
+	if err := c.Workload.Validate(); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tSIZE GB\tIOPS\tTHROUGHPUT MB/S\tCOST USD/MONTH\t")
+	for i, r := range providers.Recommend(&c.Workload) {
+		if r.Err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\tunsuitable, %s\n", r.Type, r.Err)
+			continue
+		}
+
+		var notes []string
+		if i == 0 {
+			notes = append(notes, "recommended")
+		}
+
+		if r.Provisioned {
+			notes = append(notes, "provisioned")
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%.0f\t%.2f\t%s\n",
+			r.Type, r.SizeGb, r.Iops, r.Throughput, r.MonthlyCost, strings.Join(notes, ", "),
+		)
+	}
+
+	fmt.Fprintln(w, "\nEstimated with the published per disk limits and us-central1 list prices, the instance may limit the performance further.")
+	return w.Flush()
+}
@@ -1,33 +1,81 @@
 package commands
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"golang.org/x/net/context"
-	"golang.org/x/oauth2/google"
-
-	"google.golang.org/api/compute/v1"
 	"cloud.google.com/go/compute/metadata"
-
 	"gopkg.in/inconshreveable/log15.v2"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/bloomapi/gce-docker/plugin"
+	"github.com/bloomapi/gce-docker/providers"
 	"github.com/bloomapi/gce-docker/watcher"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
 )
 
-type RootCommand struct {
-	LogLevel string
-	LogFile  string
+var ShutdownTimeout = 10 * time.Second
 
-	project  string
-	zone     string
-	instance string
-	client   *http.Client
+type RootCommand struct {
+	GCECommand
+
+	HTTPAddress       string
+	AdminAddress      string
+	CSISocket         string
+	Root              string
+	MetricsDiskLabels []string
+	CheckMounts       bool
+	ReconcileWorkers  int
+	ResponseTimeout   time.Duration
+	WaitStatusTimeout time.Duration
+	WaitDeviceTimeout time.Duration
+	DefaultKmsKey     string
+	DefaultSize       string
+	DefaultType       string
+	IgnoreDrift       bool
+	MkfsOptions       map[string]string
+	DebugAttach       bool
+	RepairDirtyMounts bool
+	Fsck              bool
+	UIDOffset         int
+	GIDOffset         int
+	BlkioCgroup       string
+	Scope             string
+	SourceProjects    []string
+	Projects          []string
+	DetachedPolicy    string
+	OwnerToken        string
+	CloudLogging      bool
+	MaxManagedDisks   int
+	ErrorCodes        bool
+	ResourcePolicies  []string
+	TLS               TLSConfig
+	FlushOnUnmount    bool
+	UnmountRetries    int
+	LazyUnmount       bool
+	CleanupMounts     bool
+	SnapshotOnRemove  bool
+	ProfilesFile      string
+	TrimInterval      time.Duration
+	ZoneRefresh       time.Duration
+
+	volume       *plugin.Volume
+	server       *http.Server
+	adminServer  *http.Server
+	csiServer    *grpc.Server
+	cloudLogging *providers.CloudLogging
 }
 
 func NewRootCommand() *RootCommand {
@@ -41,26 +89,100 @@ func (c *RootCommand) Command() *cobra.Command {
 		RunE:  c.Execute,
 	}
 
-	cmd.Flags().StringVar(&c.LogFile, "log-file", "", "log file")
-	cmd.Flags().StringVar(&c.LogLevel, "log-level", "info", "max log level enabled")
+	root := os.Getenv("GCE_DOCKER_ROOT")
+	if root == "" {
+		root = plugin.DefaultRoot
+	}
+
+	cmd.PersistentFlags().StringVar(&c.LogFile, "log-file", "", "log file")
+	cmd.PersistentFlags().StringVar(&c.LogLevel, "log-level", "info", "max log level enabled")
+	cmd.PersistentFlags().StringVar((*string)(&c.Backoff.Strategy), "retry-backoff", string(providers.DefaultBackoff.Strategy), "backoff between retries of failed GCE requests: constant, exponential or exponential-jitter")
+	cmd.PersistentFlags().DurationVar(&c.Backoff.Initial, "retry-initial-delay", providers.DefaultBackoff.Initial, "delay before the first retry of a failed GCE request")
+	cmd.PersistentFlags().DurationVar(&c.Backoff.Max, "retry-max-delay", providers.DefaultBackoff.Max, "max. delay between retries of a failed GCE request")
+	cmd.PersistentFlags().Float64Var(&c.Backoff.Multiplier, "retry-multiplier", providers.DefaultBackoff.Multiplier, "factor the delay grows by after every retry with the exponential backoffs")
+	cmd.PersistentFlags().Float64Var(&c.APIRateLimit, "api-rate-limit", 0, "max. GCE API requests per second made by the plugin, shared by all its operations and the reconcile at startup, 0 disables it")
+	cmd.Flags().StringVar(&c.Root, "root", root, "directory the disks are mounted under, on a local filesystem of the host, env GCE_DOCKER_ROOT")
+	cmd.Flags().StringVar(&c.HTTPAddress, "http-address", "", "address to serve metrics and status on, disabled if empty")
+	cmd.Flags().StringVar(&c.AdminAddress, "admin-address", "", "loopback address to serve the /drain endpoint on, e.g. 127.0.0.1:8081, disabled if empty")
+	cmd.Flags().StringVar(&c.CSISocket, "csi-socket", "", "unix socket to serve the CSI services on, for Swarm cluster volumes when installed as a managed plugin, e.g. /run/docker/plugins/csi.sock, disabled if empty")
+	cmd.Flags().StringVar(&c.TLS.CertFile, "tls-cert", "", "certificate file of the http server, served over TLS if set, requires --tls-key")
+	cmd.Flags().StringVar(&c.TLS.KeyFile, "tls-key", "", "private key file of the http server certificate")
+	cmd.Flags().StringVar(&c.TLS.MinVersion, "tls-min-version", "1.2", "min. TLS version accepted by the http server: 1.2 or 1.3")
+	cmd.Flags().StringSliceVar(&c.TLS.CipherSuites, "tls-cipher-suites", DefaultCipherSuites, "TLS 1.2 cipher suites accepted by the http server, the TLS 1.3 ones aren't configurable")
+	cmd.Flags().StringSliceVar(&c.MetricsDiskLabels, "metrics-disk-labels", plugin.DefaultMetricsDiskLabels, "GCE disk labels exported in the disk_info metric")
+	cmd.Flags().BoolVar(&c.CheckMounts, "check-mounts", false, "verify the health of the already mounted volumes at startup")
+	cmd.Flags().IntVar(&c.ReconcileWorkers, "reconcile-workers", plugin.DefaultReconcileWorkers, "number of mounted volumes reconciled concurrently at startup")
+	cmd.Flags().DurationVar(&c.ResponseTimeout, "response-timeout", plugin.DefaultResponseTimeout, "max. time to answer a volume request, keep it below the Docker plugin timeout, 0 disables it")
+	cmd.Flags().StringVar(&c.DefaultKmsKey, "default-kms-key", "", "KMS key used to encrypt the created disks without KmsKeyName, as projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>")
+	cmd.Flags().StringVar(&c.DefaultSize, "default-size", os.Getenv("GCE_DOCKER_DEFAULT_SIZE"), "size of the blank disks created without SizeGb, e.g. 100G, GCE's default if empty, env GCE_DOCKER_DEFAULT_SIZE")
+	cmd.Flags().StringVar(&c.DefaultType, "default-type", os.Getenv("GCE_DOCKER_DEFAULT_TYPE"), "type of the disks created without Type, e.g. pd-balanced, GCE's default if empty, env GCE_DOCKER_DEFAULT_TYPE")
+	cmd.Flags().BoolVar(&c.IgnoreDrift, "ignore-drift", false, "use an existing disk whose type or source snapshot doesn't match the volume options, logging a warning, instead of failing the create")
+	cmd.Flags().StringToStringVar(&c.MkfsOptions, "mkfs-options", nil, "mkfs arguments by filesystem type of the volumes without MkfsOptions, e.g. ext4=-m 0 -E lazy_itable_init=1")
+	cmd.Flags().IntVar(&c.UIDOffset, "userns-uid-offset", 0, "first host uid of the remapped user namespace, owner of the root of the filesystems owned by the host root, for rootless or userns-remap Docker")
+	cmd.Flags().IntVar(&c.GIDOffset, "userns-gid-offset", 0, "first host gid of the remapped user namespace, group of the root of the filesystems owned by the host root, for rootless or userns-remap Docker")
+	cmd.Flags().BoolVar(&c.DebugAttach, "debug-attach", false, "log at debug level the attached disk as seen by the instance, including its device name and index")
+	cmd.Flags().BoolVar(&c.Fsck, "fsck", false, "check and repair the filesystem before every mount, unless the volume sets Fsck=false")
+	cmd.Flags().BoolVar(&c.RepairDirtyMounts, "repair-dirty-mounts", false, "label the mounted disks and repair the filesystem of the ones not cleanly unmounted, e.g. after a crash, before mounting them again")
+	cmd.Flags().StringVar(&c.Scope, "scope", plugin.ScopeLocal, "scope advertised to Docker: local, or global when all the nodes of the swarm share the zone and project of the disks")
+	cmd.Flags().StringSliceVar(&c.SourceProjects, "allowed-source-projects", nil, "projects the disks can be created from with SourceImage or SourceSnapshot, besides the instance project, any if empty")
+	cmd.Flags().StringSliceVar(&c.Projects, "allowed-projects", nil, "projects the disks can be created in and used from with Project, besides the instance project, any if empty")
+	cmd.Flags().StringVar(&c.DetachedPolicy, "detached-policy", plugin.DetachedMarkFailed, "what to do at startup with the mounted volumes whose disk isn't attached anymore: mark-failed, remount or drop")
+	cmd.Flags().StringVar(&c.OwnerToken, "owner-token", "", "token labeling the created disks, only the disks with it are attached or removed, e.g. a UUID per host generation, disabled if empty")
+	cmd.Flags().BoolVar(&c.CloudLogging, "cloud-logging", false, "write the volume events to Cloud Logging too, requires the logging.write scope")
+	cmd.Flags().IntVar(&c.MaxManagedDisks, "max-managed-disks", 0, "max. number of disks created or mounted by the plugin and not removed, new ones are refused once reached, 0 disables it")
+	cmd.Flags().StringSliceVar(&c.ResourcePolicies, "default-resource-policies", nil, "resource policies attached to every created disk, e.g. a snapshot schedule, merged with the ResourcePolicies of the volume")
+	cmd.Flags().BoolVar(&c.FlushOnUnmount, "flush-on-unmount", false, "run sync and flush the device buffers after unmounting a disk, before detaching it")
+	cmd.Flags().IntVar(&c.UnmountRetries, "unmount-retries", plugin.DefaultUnmountRetries, "times a busy mountpoint is unmounted again, a second apart, before failing or, with --lazy-unmount, unmounting it lazily")
+	cmd.Flags().BoolVar(&c.CleanupMounts, "cleanup-stale-mounts", false, "on startup, unmount the mounts under the root whose device is gone or whose disk isn't attached, and remove the empty mountpoints, instead of following --detached-policy")
+	cmd.Flags().BoolVar(&c.LazyUnmount, "lazy-unmount", false, "unmount lazily, with umount -l, the mountpoints still busy after the retries, instead of failing")
+	cmd.Flags().BoolVar(&c.SnapshotOnRemove, "snapshot-on-remove", false, "snapshot the disks before deleting them on volume removal, unless the volume sets SnapshotOnRemove=false")
+	cmd.Flags().StringVar(&c.ProfilesFile, "profiles", "", "JSON file of named sets of volume options, selected with -o profile=<name>")
+	cmd.Flags().BoolVar(&c.ErrorCodes, "error-codes", false, "prefix the error responses with their code, e.g. [not-found], for clients branching on the kind of error")
+	cmd.Flags().StringVar(&c.BlkioCgroup, "blkio-cgroup", plugin.BlkioCgroup, "cgroup the I/O limits of the volumes are set on, the parent cgroup of the containers")
+	cmd.Flags().DurationVar(&c.WaitStatusTimeout, "wait-status-timeout", plugin.WaitStatusTimeout, "max. time to wait for a created disk to be ready")
+	cmd.Flags().DurationVar(&c.WaitDeviceTimeout, "wait-device-timeout", plugin.WaitDeviceTimeout, "max. time to wait for the device of an attached disk to appear on the instance")
+	cmd.Flags().DurationVar(&c.ZoneRefresh, "zone-refresh-interval", plugin.DefaultZoneRefresh, "interval the zone of the instance is read again from the metadata server at, dropping the zone scoped caches when it changes, 0 disables it")
+	cmd.Flags().DurationVar(&c.TrimInterval, "trim-interval", 0, "interval fstrim is run on the mounted volumes at, e.g. 24h, to reclaim the space of the deleted files when not mounted with discard, 0 disables it")
+
+	cmd.AddCommand(NewCopySnapshotCommand(&c.GCECommand).Command())
+	cmd.AddCommand(NewRecommendCommand().Command())
 	return cmd
 }
 
 func (c *RootCommand) Execute(cmd *cobra.Command, args []string) error {
-	if err := c.checkGCE(); err != nil {
+	if err := c.setup(); err != nil {
 		return err
 	}
 
-	if err := c.loadMetadataInfo(); err != nil {
+	if err := c.setupCloudLogging(); err != nil {
 		return err
 	}
 
-	if err := c.setupLogging(); err != nil {
+	if err := c.buildVolume(); err != nil {
 		return err
 	}
 
-	if err := c.buildComputeClient(); err != nil {
-		return err
+	if c.HTTPAddress != "" {
+		var err error
+		if c.server, err = c.buildHTTPServer(); err != nil {
+			return err
+		}
+	}
+
+	if c.AdminAddress != "" {
+		var err error
+		if c.adminServer, err = c.buildAdminServer(); err != nil {
+			return err
+		}
+	}
+
+	if c.CSISocket != "" {
+		c.csiServer = c.buildCSIServer()
+		go func() {
+			if err := c.runCSIServer(); err != nil {
+				log15.Crit(err.Error())
+			}
+		}()
 	}
 
 	go func() {
@@ -75,100 +197,265 @@ func (c *RootCommand) Execute(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	select {}
-	return nil
+	if c.server != nil {
+		go func() {
+			if err := c.runHTTPServer(); err != nil {
+				log15.Crit(err.Error())
+			}
+		}()
+	}
+
+	if c.adminServer != nil {
+		go func() {
+			if err := c.runAdminServer(); err != nil {
+				log15.Crit(err.Error())
+			}
+		}()
+	}
+
+	return c.waitShutdown()
 }
 
-func (c *RootCommand) checkGCE() error {
-	if !metadata.OnGCE() {
-		return fmt.Errorf("gce-docker driver only runs on Google Compute Engine")
+// waitShutdown blocks until the process is asked to stop, then stops the
+// servers and closes the volume plugin, which refuses the new requests, stops
+// the trim and the reconcile, waits for the operations still running and
+// saves the state.
+func (c *RootCommand) waitShutdown() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log15.Info("shutting down", "signal", <-signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if c.server != nil {
+		if err := c.server.Shutdown(ctx); err != nil {
+			log15.Warn("error stopping http server", "error", err)
+		}
+	}
+
+	if c.adminServer != nil {
+		if err := c.adminServer.Shutdown(ctx); err != nil {
+			log15.Warn("error stopping admin server", "error", err)
+		}
+	}
+
+	if c.csiServer != nil {
+		c.csiServer.GracefulStop()
+	}
+
+	if err := c.volume.Close(); err != nil {
+		return fmt.Errorf("error closing volume plugin: %s", err)
+	}
+
+	if c.cloudLogging != nil {
+		return c.cloudLogging.Close()
 	}
 
 	return nil
 }
 
-func (c *RootCommand) loadMetadataInfo() error {
-	var err error
-	c.instance, err = metadata.InstanceName()
+// setupCloudLogging adds a handler writing the volume events, the records
+// about a disk, to Cloud Logging.
+func (c *RootCommand) setupCloudLogging() error {
+	if !c.CloudLogging {
+		return nil
+	}
+
+	id, err := metadata.InstanceID()
 	if err != nil {
-		return fmt.Errorf("error retrieving instance name: %s", err)
+		return fmt.Errorf("error retrieving instance id: %s", err)
 	}
 
-	c.zone, err = metadata.Zone()
+	c.cloudLogging, err = providers.NewCloudLogging(c.client, c.project, c.zone, c.instance, id)
+	if err != nil {
+		return fmt.Errorf("error creating cloud logging client: %s", err)
+	}
+
+	root := log15.Root()
+	root.SetHandler(log15.MultiHandler(
+		root.GetHandler(), log15.LvlFilterHandler(log15.LvlInfo, c.cloudLogging),
+	))
+
+	log15.Info("writing volume events to cloud logging", "log", providers.CloudLoggingName)
+	return nil
+}
+
+func (c *RootCommand) runWatcher() error {
+	log15.Info("starting watcher", "project", c.project, "zone", c.zone, "instance", c.instance)
+	d, err := docker.NewClientFromEnv()
 	if err != nil {
-		return fmt.Errorf("error retrieving zone: %s", err)
+		return fmt.Errorf("error creating docker client: %s", err)
 	}
 
-	c.project, err = metadata.ProjectID()
+	w, err := watcher.NewWatcher(d, c.client, c.project, c.zone, c.instance)
 	if err != nil {
-		return fmt.Errorf("error retrieving project: %s", err)
+		return fmt.Errorf("error creating watcher: %s", err)
+	}
+
+	if err := w.Watch(); err != nil {
+		return fmt.Errorf("error starting watcher: %s", err)
 	}
 
 	return nil
 }
 
-func (c *RootCommand) setupLogging() error {
-	lvl, err := log15.LvlFromString(c.LogLevel)
+func (c *RootCommand) buildVolume() error {
+	if c.Scope != plugin.ScopeLocal && c.Scope != plugin.ScopeGlobal {
+		return fmt.Errorf("invalid scope %q, must be %s or %s", c.Scope, plugin.ScopeLocal, plugin.ScopeGlobal)
+	}
+
+	if c.OwnerToken != providers.LabelValue(c.OwnerToken) {
+		return fmt.Errorf("invalid owner token %q, only lowercase letters, numbers, - and _ are allowed", c.OwnerToken)
+	}
+
+	switch c.DetachedPolicy {
+	case plugin.DetachedMarkFailed, plugin.DetachedRemount, plugin.DetachedDrop:
+	default:
+		return fmt.Errorf("invalid detached policy %q, must be %s, %s or %s",
+			c.DetachedPolicy, plugin.DetachedMarkFailed, plugin.DetachedRemount, plugin.DetachedDrop,
+		)
+	}
+
+	if c.DefaultKmsKey != "" {
+		if err := providers.CheckKmsKey(c.client, c.DefaultKmsKey); err != nil {
+			return fmt.Errorf("error checking default kms key: %s", err)
+		}
+
+		log15.Info("encrypting created disks by default", "kms-key", c.DefaultKmsKey)
+	}
+
+	var err error
+	c.volume, err = plugin.NewVolume(c.client, c.project, c.zone, c.instance)
 	if err != nil {
-		return fmt.Errorf("unknown log level name %q", c.LogLevel)
+		return fmt.Errorf("error creating volume plugin: %s", err)
+	}
+
+	c.volume.Root = c.Root
+	if err := c.volume.CheckRoot(); err != nil {
+		return err
+	}
+
+	log15.Info("mounting disks under root", "root", c.Root)
+	c.volume.DefaultKmsKeyName = c.DefaultKmsKey
+	c.volume.IgnoreDrift = c.IgnoreDrift
+	c.volume.MkfsOptions = c.MkfsOptions
+	if err := c.setDefaults(); err != nil {
+		return err
 	}
 
-	handler := log15.StdoutHandler
-	format := log15.LogfmtFormat()
+	c.volume.UIDOffset = c.UIDOffset
+	c.volume.RepairDirtyMounts = c.RepairDirtyMounts
+	c.volume.Fsck = c.Fsck
+	c.volume.GIDOffset = c.GIDOffset
+	c.volume.CheckMounts = c.CheckMounts
+	c.volume.ReconcileWorkers = c.ReconcileWorkers
+	c.volume.ResponseTimeout = c.ResponseTimeout
+	c.volume.Scope = c.Scope
+	c.volume.DetachedPolicy = c.DetachedPolicy
+	c.volume.OwnerToken = c.OwnerToken
+	c.volume.MaxManagedDisks = c.MaxManagedDisks
+	c.volume.FlushOnUnmount = c.FlushOnUnmount
+	c.volume.UnmountRetries = c.UnmountRetries
+	c.volume.LazyUnmount = c.LazyUnmount
+	c.volume.SnapshotOnRemove = c.SnapshotOnRemove
+	if len(c.ResourcePolicies) != 0 {
+		c.volume.ResourcePolicies = c.ResourcePolicies
+		if err := c.volume.CheckResourcePolicies(); err != nil {
+			return fmt.Errorf("error checking default resource policies: %s", err)
+		}
 
-	if c.LogFile != "" {
-		handler = log15.MultiHandler(handler, log15.Must.FileHandler(c.LogFile, format))
+		log15.Info("attaching resource policies to created disks by default", "policies", c.ResourcePolicies)
 	}
 
-	handler = log15.LvlFilterHandler(lvl, handler)
+	if c.ProfilesFile != "" {
+		if err := c.loadProfiles(); err != nil {
+			return err
+		}
+	}
 
-	if lvl == log15.LvlDebug {
-		handler = log15.CallerFileHandler(log15.LvlFilterHandler(lvl, handler))
+	if len(c.SourceProjects) != 0 {
+		c.volume.SourceProjects = append(c.SourceProjects, c.project)
+		log15.Info("restricting disk sources", "projects", c.volume.SourceProjects)
 	}
 
-	log15.Root().SetHandler(handler)
+	if len(c.Projects) != 0 {
+		c.volume.Projects = append(c.Projects, c.project)
+		log15.Info("restricting disk projects", "projects", c.volume.Projects)
+	}
+	plugin.WaitStatusTimeout = c.WaitStatusTimeout
+	plugin.WaitDeviceTimeout = c.WaitDeviceTimeout
+	plugin.BlkioCgroup = c.BlkioCgroup
+	plugin.IncludeErrorCodes = c.ErrorCodes
+	providers.DebugAttach = c.DebugAttach
 	return nil
 }
 
-func (c *RootCommand) buildComputeClient() error {
-	ctx := context.Background()
+// setDefaults sets the size and type of the disks created without them.
+func (c *RootCommand) setDefaults() error {
+	if c.DefaultSize != "" {
+		size, err := plugin.ParseSize(c.DefaultSize)
+		if err != nil {
+			return fmt.Errorf("invalid default size: %s", err)
+		}
 
-	var err error
-	c.client, err = google.DefaultClient(ctx, compute.ComputeScope)
-	if err != nil {
-		return fmt.Errorf("error building compute client: %s", err)
+		c.volume.DefaultSizeGb = size
+	}
+
+	c.volume.DefaultType = c.DefaultType
+	if c.DefaultSize != "" || c.DefaultType != "" {
+		log15.Info("creating disks with defaults", "size", c.volume.DefaultSizeGb, "type", c.DefaultType)
 	}
 
 	return nil
 }
 
-func (c *RootCommand) runWatcher() error {
-	log15.Info("starting watcher", "project", c.project, "zone", c.zone, "instance", c.instance)
-	d, err := docker.NewClientFromEnv()
+func (c *RootCommand) loadProfiles() error {
+	f, err := os.Open(c.ProfilesFile)
 	if err != nil {
-		return fmt.Errorf("error creating docker client: %s", err)
+		return fmt.Errorf("error opening profiles: %s", err)
 	}
 
-	w, err := watcher.NewWatcher(d, c.client, c.project, c.zone, c.instance)
+	defer f.Close()
+
+	c.volume.Profiles, err = plugin.LoadProfiles(f)
 	if err != nil {
-		return fmt.Errorf("error creating watcher: %s", err)
+		return err
 	}
 
-	if err := w.Watch(); err != nil {
-		return fmt.Errorf("error starting watcher: %s", err)
+	if err := c.volume.CheckProfiles(); err != nil {
+		return fmt.Errorf("error checking profiles: %s", err)
 	}
 
+	log15.Info("volume profiles loaded", "file", c.ProfilesFile, "profiles", len(c.volume.Profiles))
 	return nil
 }
 
 func (c *RootCommand) runVolumePlugin() error {
 	log15.Info("starting volume driver", "project", c.project, "zone", c.zone, "instance", c.instance)
-	d, err := plugin.NewVolume(c.client, c.project, c.zone, c.instance)
-	if err != nil {
-		return fmt.Errorf("error creating volume plugin: %s", err)
+	if err := c.volume.LoadState(); err != nil {
+		log15.Error("error loading state", "error", err)
 	}
 
-	h := volume.NewHandler(d)
+	if c.CleanupMounts {
+		if err := c.volume.CleanupMountpoints(); err != nil {
+			log15.Error("error cleaning up stale mountpoints", "error", err)
+		}
+	}
+
+	if err := c.volume.Reconcile(); err != nil {
+		log15.Error("error reconciling mounts", "error", err)
+	}
+
+	if c.TrimInterval > 0 {
+		go c.volume.RunTrim(c.TrimInterval)
+	}
+
+	if c.ZoneRefresh > 0 {
+		go c.volume.RunZoneRefresh(c.ZoneRefresh, metadata.Zone)
+	}
+
+	h := volume.NewHandler(c.volume)
 	if err := h.ServeUnix("docker", "gce"); err != nil {
 		return fmt.Errorf("error starting volume driver server: %s", err)
 	}
@@ -176,6 +463,133 @@ func (c *RootCommand) runVolumePlugin() error {
 	return nil
 }
 
+func (c *RootCommand) buildHTTPServer() (*http.Server, error) {
+	server := &http.Server{Addr: c.HTTPAddress}
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		var err error
+		if server.TLSConfig, err = c.TLS.Config(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := plugin.CheckMetricsDiskLabels(c.MetricsDiskLabels); err != nil {
+		return nil, err
+	}
+
+	prometheus.MustRegister(c.volume.DiskCollector(c.MetricsDiskLabels))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/status", c.serveStatus)
+	server.Handler = mux
+
+	return server, nil
+}
+
+// buildAdminServer builds the server of the endpoints changing the plugin,
+// like /drain, which aren't authenticated, so it only listens on a loopback
+// address, reachable from the host but not from the network.
+func (c *RootCommand) buildAdminServer() (*http.Server, error) {
+	host, _, err := net.SplitHostPort(c.AdminAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin address %q: %s", c.AdminAddress, err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("invalid admin address %q, it must be a loopback address, like 127.0.0.1:8081", c.AdminAddress)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drain", c.serveDrain)
+	return &http.Server{Addr: c.AdminAddress, Handler: mux}, nil
+}
+
+// buildCSIServer builds the server of the CSI identity, controller and node
+// services, used by Swarm for the cluster volumes.
+func (c *RootCommand) buildCSIServer() *grpc.Server {
+	s := plugin.NewCSI(c.volume, c.zone, c.instance)
+
+	server := grpc.NewServer()
+	csi.RegisterIdentityServer(server, s)
+	csi.RegisterControllerServer(server, s)
+	csi.RegisterNodeServer(server, s)
+	return server
+}
+
+func (c *RootCommand) runCSIServer() error {
+	log15.Info("starting csi server", "socket", c.CSISocket)
+	if err := os.Remove(c.CSISocket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing csi socket: %s", err)
+	}
+
+	l, err := net.Listen("unix", c.CSISocket)
+	if err != nil {
+		return fmt.Errorf("error starting csi server: %s", err)
+	}
+
+	if err := c.csiServer.Serve(l); err != nil && err != grpc.ErrServerStopped {
+		return fmt.Errorf("error starting csi server: %s", err)
+	}
+
+	return nil
+}
+
+func (c *RootCommand) runHTTPServer() error {
+	log15.Info("starting http server", "address", c.HTTPAddress, "tls", c.server.TLSConfig != nil)
+
+	var err error
+	if c.server.TLSConfig != nil {
+		err = c.server.ListenAndServeTLS("", "")
+	} else {
+		err = c.server.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error starting http server: %s", err)
+	}
+
+	return nil
+}
+
+func (c *RootCommand) runAdminServer() error {
+	log15.Info("starting admin server", "address", c.AdminAddress)
+	if err := c.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error starting admin server: %s", err)
+	}
+
+	return nil
+}
+
+func (c *RootCommand) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.volume.Status()); err != nil {
+		log15.Error("error encoding status", "error", err)
+	}
+}
+
+// serveDrain enables the drain mode with a POST, releasing the volumes with
+// release=true, and disables it with a DELETE.
+func (c *RootCommand) serveDrain(w http.ResponseWriter, r *http.Request) {
+	var result *plugin.DrainResult
+	switch r.Method {
+	case http.MethodPost:
+		result = c.volume.Drain(r.URL.Query().Get("release") == "true")
+	case http.MethodDelete:
+		c.volume.Undrain()
+		result = &plugin.DrainResult{}
+	case http.MethodGet:
+		result = &plugin.DrainResult{Draining: c.volume.Draining()}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log15.Error("error encoding drain result", "error", err)
+	}
+}
+
 var RootCmd = NewRootCommand().Command()
 
 func Execute() {
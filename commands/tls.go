@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// DefaultCipherSuites are the TLS 1.2 cipher suites enabled by default, the
+// ones with forward secrecy and authenticated encryption.
+var DefaultCipherSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// TLSConfig is the TLS configuration of the http server, disabled without a
+// certificate.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   string
+	CipherSuites []string
+}
+
+// Config validates the configuration and builds the tls.Config, the cipher
+// suites only apply to TLS 1.2, the TLS 1.3 ones can't be configured.
+func (c *TLSConfig) Config() (*tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("invalid tls config, both --tls-cert and --tls-key are required")
+	}
+
+	version, ok := tlsVersions[c.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid tls min. version %q, must be 1.2 or 1.3", c.MinVersion)
+	}
+
+	suites, err := cipherSuites(c.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading tls certificate: %s", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		CipherSuites: suites,
+	}, nil
+}
+
+// cipherSuites returns the ids of the named cipher suites, refusing the ones
+// with known security issues.
+func cipherSuites(names []string) ([]uint16, error) {
+	secure := make(map[string]uint16, 0)
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s.ID
+	}
+
+	insecure := make(map[string]bool, 0)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		id, ok := secure[name]
+		if insecure[name] {
+			return nil, fmt.Errorf("invalid tls cipher suite %q, it has known security issues", name)
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("invalid tls cipher suite %q, valid ones are: %s", name, strings.Join(secureNames(), ", "))
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func secureNames() []string {
+	var names []string
+	for _, s := range tls.CipherSuites() {
+		names = append(names, s.Name)
+	}
+
+	return names
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// CleanupMountpoints removes what a crash left under Root, before Reconcile:
+// the mounts whose device is gone or whose disk isn't attached to the
+// instance anymore are unmounted, and the empty mountpoints of the volumes
+// not mounted are removed. The mountpoints holding files are kept, they were
+// written to the host disk instead of a volume.
+func (v *Volume) CleanupMountpoints() error {
+	start := time.Now()
+	mounts, err := v.fs.Mounts()
+	if err != nil {
+		return err
+	}
+
+	attached, err := v.p.AttachedDisks()
+	if err != nil {
+		log15.Error("error listing attached disks, skipping detached disks cleanup", "error", err)
+	}
+
+	var cleaned []string
+	mounted := make(map[string]bool, 0)
+	for _, m := range mounts {
+		name, ok := v.mountName(m.Target)
+		if !ok {
+			continue
+		}
+
+		reason := v.staleReason(name, m, attached)
+		if reason == "" {
+			mounted[name] = true
+			continue
+		}
+
+		log15.Warn("unmounting stale mount", "disk", name, "mnt", m.Target, "source", m.Source, "reason", reason)
+		if err := v.fs.Unmount(m.Target); err != nil {
+			log15.Warn("error unmounting stale mount, unmounting it lazily", "disk", name, "mnt", m.Target, "error", err)
+			if err := v.fs.LazyUnmount(m.Target); err != nil {
+				log15.Error("error unmounting stale mount", "disk", name, "mnt", m.Target, "error", err)
+				mounted[name] = true
+				continue
+			}
+		}
+
+		cleaned = append(cleaned, m.Target)
+	}
+
+	entries, err := afero.ReadDir(v.fs, v.Root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || mounted[e.Name()] {
+			continue
+		}
+
+		target := filepath.Join(v.Root, e.Name())
+		if empty, err := afero.IsEmpty(v.fs, target); err != nil || !empty {
+			log15.Warn("keeping mountpoint of unmounted volume, it isn't empty", "mnt", target, "error", err)
+			continue
+		}
+
+		if err := v.fs.Remove(target); err != nil {
+			log15.Error("error removing stale mountpoint", "mnt", target, "error", err)
+			continue
+		}
+
+		log15.Info("stale mountpoint removed", "mnt", target)
+		cleaned = append(cleaned, target)
+	}
+
+	log15.Info("stale mountpoints cleaned", "cleaned", len(cleaned), "elapsed", time.Since(start))
+	return nil
+}
+
+// staleReason returns why the mount is stale, empty if it isn't.
+func (v *Volume) staleReason(name string, m *MountInfo, attached map[string]bool) string {
+	if _, err := v.fs.Stat(m.Source); os.IsNotExist(err) {
+		return "device is gone"
+	}
+
+	if attached != nil && !attached[name] {
+		return "disk is not attached to the instance"
+	}
+
+	return ""
+}
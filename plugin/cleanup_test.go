@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestCleanupMountpoints(c *C) {
+	for _, name := range []string{"foo", "bar", "qux", "empty", "data"} {
+		c.Assert(s.fs.MkdirAll("/mnt/"+name, 0755), IsNil)
+	}
+
+	c.Assert(afero.WriteFile(s.fs, "/mnt/data/file", []byte("foo"), 0644), IsNil)
+	c.Assert(afero.WriteFile(s.fs, "/dev/sdb", nil, 0644), IsNil)
+	c.Assert(afero.WriteFile(s.fs, "/dev/sdd", nil, 0644), IsNil)
+
+	s.p.attached["foo"] = true
+	s.p.attached["bar"] = true
+	s.fs.Mounted["/mnt/foo"] = "/dev/sdb"
+	s.fs.Mounted["/mnt/bar"] = "/dev/sdc"
+	s.fs.Mounted["/mnt/qux"] = "/dev/sdd"
+
+	c.Assert(s.v.CleanupMountpoints(), IsNil)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/sdb")
+	c.Assert(s.fs.Mounted["/mnt/bar"], Equals, "")
+	c.Assert(s.fs.Mounted["/mnt/qux"], Equals, "")
+
+	for dir, exists := range map[string]bool{
+		"/mnt/foo": true, "/mnt/bar": false, "/mnt/qux": false, "/mnt/empty": false, "/mnt/data": true,
+	} {
+		ok, err := afero.DirExists(s.fs, dir)
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, exists, Commentf(dir))
+	}
+}
+
+func (s *VolumeSuite) TestCleanupMountpointsLazy(c *C) {
+	c.Assert(s.fs.MkdirAll("/mnt/foo", 0755), IsNil)
+	s.fs.Mounted["/mnt/foo"] = "/dev/sdb"
+	s.fs.Failures["/mnt/foo"] = []error{fmt.Errorf("umount: /mnt/foo: Stale file handle")}
+
+	c.Assert(s.v.CleanupMountpoints(), IsNil)
+	c.Assert(s.fs.Lazy["/mnt/foo"], Equals, true)
+}
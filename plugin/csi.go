@@ -0,0 +1,380 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bloomapi/gce-docker/providers"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/docker/go-plugins-helpers/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	// CSIDriverName is the name the plugin reports to Swarm over CSI.
+	CSIDriverName = "csi.gce-docker"
+	// CSIVendorVersion is the version the plugin reports to Swarm over CSI.
+	CSIVendorVersion = "1.0.0"
+	// CSITopologyZone is the topology key holding the zone of the disks and
+	// of the nodes, a disk is only accessible from its zone.
+	CSITopologyZone = "gce-docker/zone"
+)
+
+// CSI serves the volumes as Swarm cluster volumes, through the CSI
+// identity, controller and node services. The controller creates and
+// removes the disks, in the zone of its instance, and the node attaches
+// them to its own instance when a task is published, so the controller
+// doesn't publish them.
+type CSI struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+
+	v        *Volume
+	zone     string
+	instance string
+}
+
+func NewCSI(v *Volume, zone, instance string) *CSI {
+	return &CSI{v: v, zone: zone, instance: instance}
+}
+
+func (s *CSI) GetPluginInfo(context.Context, *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: CSIDriverName, VendorVersion: CSIVendorVersion}, nil
+}
+
+func (s *CSI) GetPluginCapabilities(context.Context, *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	var capabilities []*csi.PluginCapability
+	for _, c := range []csi.PluginCapability_Service_Type{
+		csi.PluginCapability_Service_CONTROLLER_SERVICE,
+		csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+	} {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{Service: &csi.PluginCapability_Service{Type: c}},
+		})
+	}
+
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+func (s *CSI) Probe(context.Context, *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+func (s *CSI) ControllerGetCapabilities(context.Context, *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.ControllerServiceCapability{{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{
+				Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			}},
+		}},
+	}, nil
+}
+
+// CreateVolume creates the disk of a cluster volume, named after it and in
+// the zone of the instance, sized by the required capacity unless SizeGb is
+// given. The volume id is the volume name and the options are returned as
+// its context, given back to the nodes mounting it.
+func (s *CSI) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing volume name")
+	}
+
+	options, err := canonicalOptions(req.Parameters)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := checkAccessModes(req.VolumeCapabilities, options); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.checkAccessibility(req.AccessibilityRequirements); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	if err := sizeOption(req.CapacityRange, options); err != nil {
+		return nil, status.Error(codes.OutOfRange, err.Error())
+	}
+
+	r := volume.Request{Name: req.Name, Options: options}
+	if resp := s.v.Create(r); resp.Err != "" {
+		return nil, status.Error(codes.Internal, resp.Err)
+	}
+
+	config, err := s.v.createDiskConfig(r)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var capacity int64
+	if d, err := s.v.p.Get(config); err == nil {
+		capacity = d.SizeGb << 30
+	}
+
+	log15.Info("cluster volume created", "disk", req.Name, "capacity", capacity)
+	return &csi.CreateVolumeResponse{Volume: &csi.Volume{
+		VolumeId:           req.Name,
+		CapacityBytes:      capacity,
+		VolumeContext:      options,
+		AccessibleTopology: s.topology(config),
+	}}, nil
+}
+
+// checkAccessibility checks that the disk, created in the zone of the
+// instance, is in one of the requisite topologies, if any.
+func (s *CSI) checkAccessibility(r *csi.TopologyRequirement) error {
+	if r == nil || len(r.Requisite) == 0 {
+		return nil
+	}
+
+	var zones []string
+	for _, t := range r.Requisite {
+		zone, ok := t.Segments[CSITopologyZone]
+		if !ok || zone == s.zone {
+			return nil
+		}
+
+		zones = append(zones, zone)
+	}
+
+	return fmt.Errorf(
+		"unable to create the disk in zones %s, the disks are created in the zone of the controller, %s",
+		strings.Join(zones, ", "), s.zone,
+	)
+}
+
+// topology returns the zones the disk can be attached from, its replica
+// zones if regional.
+func (s *CSI) topology(c *providers.DiskConfig) []*csi.Topology {
+	zones := []string{s.zone}
+	if c.Regional {
+		zones = c.ReplicaZones
+	}
+
+	var topology []*csi.Topology
+	for _, z := range zones {
+		topology = append(topology, &csi.Topology{Segments: map[string]string{CSITopologyZone: z}})
+	}
+
+	return topology
+}
+
+// sizeOption sets the SizeGb option from the capacity range, rounded up to a
+// whole GB, unless the size is given by the options.
+func sizeOption(r *csi.CapacityRange, options map[string]string) error {
+	if r == nil || r.RequiredBytes == 0 {
+		return nil
+	}
+
+	if _, ok := options["SizeGb"]; ok {
+		return nil
+	}
+
+	if _, ok := options["Size"]; ok {
+		return nil
+	}
+
+	size := (r.RequiredBytes + 1<<30 - 1) >> 30
+	if r.LimitBytes != 0 && size<<30 > r.LimitBytes {
+		return fmt.Errorf(
+			"unable to size the disk between %d and %d bytes, GCE disks are sized in whole GB",
+			r.RequiredBytes, r.LimitBytes,
+		)
+	}
+
+	options["SizeGb"] = strconv.FormatInt(size, 10)
+	return nil
+}
+
+// checkAccessModes checks that the disk can be used as requested: mounted,
+// not as a block device, by a single node, by several nodes read-only with
+// Mode=ro, or written by several nodes with MultiWriter=true.
+func checkAccessModes(capabilities []*csi.VolumeCapability, options map[string]string) error {
+	if len(capabilities) == 0 {
+		return fmt.Errorf("missing volume capabilities")
+	}
+
+	for _, c := range capabilities {
+		if c.GetBlock() != nil {
+			return fmt.Errorf("block access isn't supported, only mount")
+		}
+
+		switch mode := c.GetAccessMode().GetMode(); mode {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+			if options["Mode"] != "ro" {
+				return fmt.Errorf("access mode %s requires Mode=ro, a disk is only attached to several instances read-only", mode)
+			}
+		case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+			if ok, _ := strconv.ParseBool(options["MultiWriter"]); !ok {
+				return fmt.Errorf("access mode %s requires MultiWriter=true", mode)
+			}
+		default:
+			return fmt.Errorf("access mode %s isn't supported", mode)
+		}
+	}
+
+	return nil
+}
+
+// DeleteVolume removes the disk of a cluster volume, a missing disk is
+// already removed.
+func (s *CSI) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing volume id")
+	}
+
+	r := volume.Request{Name: req.VolumeId}
+	config, err := s.v.createDiskConfig(r)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if _, err := s.v.p.Get(config); providers.IsNotFoundError(err) {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if resp := s.v.Remove(r); resp.Err != "" {
+		return nil, status.Error(codes.Internal, resp.Err)
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (s *CSI) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing volume id")
+	}
+
+	options, err := canonicalOptions(req.VolumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	config, err := s.v.createDiskConfig(volume.Request{Name: req.VolumeId, Options: options})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	_, err = s.v.p.Get(config)
+	if providers.IsNotFoundError(err) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := checkAccessModes(req.VolumeCapabilities, options); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.VolumeContext,
+			VolumeCapabilities: req.VolumeCapabilities,
+			Parameters:         req.Parameters,
+		},
+	}, nil
+}
+
+func (s *CSI) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+// NodeGetInfo reports the instance as the node, in its zone, so Swarm only
+// schedules the tasks using a disk on the nodes of the zone of the disk.
+func (s *CSI) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId:             s.instance,
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{CSITopologyZone: s.zone}},
+	}, nil
+}
+
+// NodePublishVolume attaches and mounts the disk like a local volume, the
+// target path counted as its reference, and binds it on the target path.
+func (s *CSI) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.VolumeId == "" || req.TargetPath == "" || req.VolumeCapability == nil {
+		return nil, status.Error(codes.InvalidArgument, "missing volume id, target path or volume capability")
+	}
+
+	options, err := canonicalOptions(req.VolumeContext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := checkAccessModes([]*csi.VolumeCapability{req.VolumeCapability}, options); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.Readonly && options["Mode"] == "" {
+		options["Mode"] = "ro"
+	}
+
+	r := volume.Request{Name: req.VolumeId, ID: req.TargetPath}
+	config, err := s.v.createDiskConfig(volume.Request{Name: req.VolumeId, Options: options})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if s.v.hasMountRef(config.Name, r.ID) {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	// the unpublish request only carries the id, the options are needed to
+	// detach the disk
+	s.v.setOptions(r.Name, options)
+	resp := s.v.Mount(r)
+	if resp.Err != "" {
+		return nil, status.Error(codes.Internal, resp.Err)
+	}
+
+	if err := s.v.fs.Bind(resp.Mountpoint, req.TargetPath, config.ReadOnly); err != nil {
+		if resp := s.v.Unmount(r); resp.Err != "" {
+			log15.Error("error releasing disk after failed bind", "disk", r.Name, "error", resp.Err)
+		}
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log15.Info("cluster volume published", "disk", r.Name, "target", req.TargetPath)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume unbinds the target path and releases its reference,
+// the disk is unmounted and detached once it was the last one.
+func (s *CSI) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.VolumeId == "" || req.TargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing volume id or target path")
+	}
+
+	r := volume.Request{Name: req.VolumeId, ID: req.TargetPath}
+	config, err := s.v.createDiskConfig(r)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if !s.v.hasMountRef(config.Name, r.ID) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
+	if err := s.v.fs.Unbind(req.TargetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if resp := s.v.Unmount(r); resp.Err != "" {
+		return nil, status.Error(codes.Internal, resp.Err)
+	}
+
+	log15.Info("cluster volume unpublished", "disk", r.Name, "target", req.TargetPath)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
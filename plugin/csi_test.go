@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	. "gopkg.in/check.v1"
+)
+
+type CSISuite struct {
+	s  *CSI
+	v  *Volume
+	fs *MemFilesystem
+	p  *DiskProviderFixture
+}
+
+var _ = Suite(&CSISuite{})
+
+func (s *CSISuite) SetUpTest(c *C) {
+	s.fs = NewMemFilesystem()
+	s.p = NewDiskProviderFixture()
+	s.v = newVolume(s.p, s.fs)
+	s.s = NewCSI(s.v, "zone-a", "instance")
+}
+
+func mountCapability(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func (s *CSISuite) TestCreateVolume(c *C) {
+	s.p.sizes["foo"] = 11
+
+	resp, err := s.s.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "foo",
+		Parameters:         map[string]string{"type": "pd-ssd"},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 10<<30 + 1},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.p.disks["foo"], Equals, true)
+	c.Assert(resp.Volume.VolumeId, Equals, "foo")
+	c.Assert(resp.Volume.CapacityBytes, Equals, int64(11<<30))
+	c.Assert(resp.Volume.VolumeContext, DeepEquals, map[string]string{"Type": "pd-ssd", "SizeGb": "11"})
+	c.Assert(resp.Volume.AccessibleTopology, HasLen, 1)
+	c.Assert(resp.Volume.AccessibleTopology[0].Segments[CSITopologyZone], Equals, "zone-a")
+}
+
+func (s *CSISuite) TestCreateVolumeInvalid(c *C) {
+	writer := []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}
+	for _, t := range []struct {
+		req  *csi.CreateVolumeRequest
+		code codes.Code
+	}{
+		{&csi.CreateVolumeRequest{Name: "foo"}, codes.InvalidArgument},
+		{&csi.CreateVolumeRequest{Name: "foo", VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}}}, codes.InvalidArgument},
+		{&csi.CreateVolumeRequest{Name: "foo", VolumeCapabilities: []*csi.VolumeCapability{
+			mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		}}, codes.InvalidArgument},
+		{&csi.CreateVolumeRequest{Name: "foo", VolumeCapabilities: []*csi.VolumeCapability{
+			mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+		}}, codes.InvalidArgument},
+		{&csi.CreateVolumeRequest{Name: "foo", VolumeCapabilities: writer, AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{CSITopologyZone: "zone-b"}}},
+		}}, codes.ResourceExhausted},
+		{&csi.CreateVolumeRequest{Name: "foo", VolumeCapabilities: writer, CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 10<<30 + 1, LimitBytes: 10<<30 + 2,
+		}}, codes.OutOfRange},
+	} {
+		_, err := s.s.CreateVolume(context.Background(), t.req)
+		c.Assert(status.Code(err), Equals, t.code, Commentf("%v", err))
+	}
+
+	c.Assert(s.p.disks, HasLen, 0)
+
+	_, err := s.s.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:       "foo",
+		Parameters: map[string]string{"Mode": "ro"},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+		},
+		AccessibilityRequirements: &csi.TopologyRequirement{Requisite: []*csi.Topology{
+			{Segments: map[string]string{CSITopologyZone: "zone-b"}},
+			{Segments: map[string]string{CSITopologyZone: "zone-a"}},
+		}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.p.disks["foo"], Equals, true)
+}
+
+func (s *CSISuite) TestDeleteVolume(c *C) {
+	s.p.disks["foo"] = true
+
+	_, err := s.s.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(s.p.disks["foo"], Equals, false)
+
+	s.p.getErr = &googleapi.Error{Code: 404, Message: "The resource 'foo' was not found"}
+	_, err = s.s.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "foo"})
+	c.Assert(err, IsNil)
+}
+
+func (s *CSISuite) TestValidateVolumeCapabilities(c *C) {
+	s.p.disks["foo"] = true
+
+	resp, err := s.s.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "foo",
+		VolumeContext:      map[string]string{"MultiWriter": "true", "Type": "hyperdisk-balanced"},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Confirmed, NotNil)
+
+	resp, err = s.s.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "foo",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Confirmed, IsNil)
+	c.Assert(resp.Message, Matches, ".* requires MultiWriter=true")
+
+	s.p.getErr = &googleapi.Error{Code: 404, Message: "The resource 'foo' was not found"}
+	_, err = s.s.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "foo",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+	})
+	c.Assert(status.Code(err), Equals, codes.NotFound)
+}
+
+func (s *CSISuite) TestNodeGetInfo(c *C) {
+	resp, err := s.s.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(resp.NodeId, Equals, "instance")
+	c.Assert(resp.AccessibleTopology.Segments[CSITopologyZone], Equals, "zone-a")
+}
+
+func (s *CSISuite) TestNodePublishVolume(c *C) {
+	s.p.disks["foo"] = true
+	publish := &csi.NodePublishVolumeRequest{
+		VolumeId:         "foo",
+		TargetPath:       "/data/published/foo",
+		VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		VolumeContext:    map[string]string{"mountoptions": "noatime"},
+	}
+
+	_, err := s.s.NodePublishVolume(context.Background(), publish)
+	c.Assert(err, IsNil)
+	c.Assert(s.p.attached["foo"], Equals, true)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Not(HasLen), 0)
+	c.Assert(s.fs.Options["/mnt/foo"], DeepEquals, []string{"discard", "defaults", "noatime"})
+	c.Assert(s.fs.Mounted["/data/published/foo"], Equals, "/mnt/foo")
+	c.Assert(s.fs.Options["/data/published/foo"], DeepEquals, []string{"bind"})
+
+	_, err = s.s.NodePublishVolume(context.Background(), publish)
+	c.Assert(err, IsNil)
+
+	unpublish := &csi.NodeUnpublishVolumeRequest{VolumeId: "foo", TargetPath: "/data/published/foo"}
+	_, err = s.s.NodeUnpublishVolume(context.Background(), unpublish)
+	c.Assert(err, IsNil)
+	c.Assert(s.p.attached["foo"], Equals, false)
+	c.Assert(s.fs.Mounted["/mnt/foo"], HasLen, 0)
+	_, ok := s.fs.Mounted["/data/published/foo"]
+	c.Assert(ok, Equals, false)
+
+	_, err = s.s.NodeUnpublishVolume(context.Background(), unpublish)
+	c.Assert(err, IsNil)
+}
+
+func (s *CSISuite) TestNodePublishVolumeReadOnly(c *C) {
+	s.p.disks["foo"] = true
+	s.fs.Formatted["/dev/disk/by-id/google-docker-volume-foo"] = "ext4"
+
+	_, err := s.s.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         "foo",
+		TargetPath:       "/data/published/foo",
+		VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+		Readonly:         true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.fs.Options["/data/published/foo"], DeepEquals, []string{"bind", "ro"})
+}
+
+func (s *CSISuite) TestNodePublishVolumeBindFailure(c *C) {
+	s.p.disks["foo"] = true
+	s.fs.Failures["/data/published/foo"] = []error{fmt.Errorf("bind mount failed")}
+
+	_, err := s.s.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         "foo",
+		TargetPath:       "/data/published/foo",
+		VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+	})
+	c.Assert(status.Code(err), Equals, codes.Internal)
+	c.Assert(s.p.attached["foo"], Equals, false)
+	c.Assert(s.v.hasMountRef("foo", "/data/published/foo"), Equals, false)
+}
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	DefaultResponseTimeout = 25 * time.Second
+	PendingResultTTL       = 5 * time.Minute
+)
+
+type pendingOperation struct {
+	method     string
+	request    volume.Request
+	name       string
+	done       chan struct{}
+	resp       volume.Response
+	finishedAt time.Time
+}
+
+// withDeadline runs the handler in the background and returns its response
+// if it finishes before ResponseTimeout, otherwise an error asking to retry.
+// The operation keeps running and a retry of the same request, by the same
+// caller, waits for it, or picks up its response if it already finished,
+// instead of starting it again. A response is only delivered once, and it's
+// dropped if not picked up within PendingResultTTL or once another operation
+// on the volume runs, the mounts dropped are released.
+func (v *Volume) withDeadline(method string, r volume.Request, h func(volume.Request) volume.Response) volume.Response {
+	h = v.tracked(method, h)
+	if v.ResponseTimeout == 0 {
+		if v.isClosed() {
+			return errorResponse(ErrClosed)
+		}
+
+		return h(r)
+	}
+
+	key := operationKey(method, r)
+	op := v.pendingOperation(key, r, h)
+	if op == nil {
+		return errorResponse(ErrClosed)
+	}
+
+	select {
+	case <-op.done:
+		v.deletePendingOperation(key, op)
+		return op.resp
+	case <-time.After(v.ResponseTimeout):
+		log15.Warn("request still in progress", "method", method, "name", r.Name, "timeout", v.ResponseTimeout)
+		return errorResponse(withCode(ErrorCodeInProgress, fmt.Errorf(
+			"%s of %q still in progress, retry to get the result", method, r.Name,
+		)))
+	}
+}
+
+// pendingOperation returns the operation of the request, started if it
+// isn't running yet, nil once closed.
+func (v *Volume) pendingOperation(key string, r volume.Request, h func(volume.Request) volume.Response) *pendingOperation {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.closed {
+		return nil
+	}
+
+	dropped := v.prunePendingOperations(key, r)
+	if op, ok := v.pending[key]; ok {
+		if len(dropped) != 0 && v.addBackground() {
+			go func() {
+				defer v.background.Done()
+				v.releaseDropped(dropped)
+			}()
+		}
+
+		return op
+	}
+
+	op := &pendingOperation{method: method, request: r, name: r.Name, done: make(chan struct{})}
+	v.pending[key] = op
+
+	v.addBackground()
+	go func() {
+		defer v.background.Done()
+		v.releaseDropped(dropped)
+		resp := h(r)
+
+		v.Lock()
+		op.resp = resp
+		op.finishedAt = time.Now()
+		v.Unlock()
+
+		close(op.done)
+	}()
+
+	return op
+}
+
+// prunePendingOperations drops the finished responses not picked up within
+// PendingResultTTL and, before an operation on the volume, the ones of the
+// other operations on it, stale once it runs. It returns the successful
+// mounts dropped, to be released, unless the request is the unmount of the
+// same caller. Holding the lock.
+func (v *Volume) prunePendingOperations(key string, r volume.Request) []*pendingOperation {
+	var dropped []*pendingOperation
+	for k, op := range v.pending {
+		if op.finishedAt.IsZero() {
+			continue
+		}
+
+		if time.Since(op.finishedAt) >= PendingResultTTL || (k != key && op.name == r.Name) {
+			log15.Debug("dropping response never picked up", "operation", k, "finished", op.finishedAt)
+			delete(v.pending, k)
+
+			if op.method == "mount" && op.resp.Err == "" && (op.name != r.Name || op.request.ID != r.ID) {
+				dropped = append(dropped, op)
+			}
+		}
+	}
+
+	return dropped
+}
+
+// releaseDropped releases the mounts whose response was never picked up, the
+// caller didn't get the mountpoint and won't unmount it. The disk is
+// unmounted and detached once no other caller uses it.
+func (v *Volume) releaseDropped(ops []*pendingOperation) {
+	for _, op := range ops {
+		if op.request.ID == "" && v.mountRefs(op.name) > 0 {
+			log15.Warn("mount never picked up, kept for its other users", "name", op.name)
+			continue
+		}
+
+		log15.Warn("releasing mount never picked up", "name", op.name, "id", op.request.ID)
+		if resp := v.unmount(op.request); resp.Err != "" {
+			log15.Error("error releasing mount never picked up", "name", op.name, "id", op.request.ID, "error", resp.Err)
+		}
+	}
+}
+
+func (v *Volume) deletePendingOperation(key string, op *pendingOperation) {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.pending[key] == op {
+		delete(v.pending, key)
+	}
+}
+
+// operationKey identifies the request of a caller, the same request retried
+// by the same caller gets the same key.
+func operationKey(method string, r volume.Request) string {
+	key := method + " " + r.Name
+	if r.ID != "" {
+		key += " " + r.ID
+	}
+
+	return key
+}
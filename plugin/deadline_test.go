@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestWithDeadline(c *C) {
+	s.v.ResponseTimeout = 10 * time.Millisecond
+	r := s.v.withDeadline("create", volume.Request{Name: "foo"}, func(volume.Request) volume.Response {
+		return volume.Response{Mountpoint: "foo"}
+	})
+
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "foo")
+	c.Assert(s.v.pending, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestWithDeadlineRetry(c *C) {
+	s.v.ResponseTimeout = 10 * time.Millisecond
+	var calls int
+	release := make(chan struct{})
+	h := func(volume.Request) volume.Response {
+		calls++
+		<-release
+		return volume.Response{Mountpoint: "foo"}
+	}
+
+	r := s.v.withDeadline("create", volume.Request{Name: "foo"}, h)
+	c.Assert(r.Err, Equals, `create of "foo" still in progress, retry to get the result`)
+
+	r = s.v.withDeadline("create", volume.Request{Name: "foo"}, h)
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	close(release)
+	time.Sleep(5 * time.Millisecond)
+
+	r = s.v.withDeadline("create", volume.Request{Name: "foo"}, h)
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "foo")
+	c.Assert(calls, Equals, 1)
+	c.Assert(s.v.pending, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestWithDeadlineStaleResult(c *C) {
+	s.v.ResponseTimeout = 10 * time.Millisecond
+	var calls int
+	release := make(chan struct{})
+	h := func(volume.Request) volume.Response {
+		calls++
+		<-release
+		return volume.Response{Mountpoint: "foo"}
+	}
+
+	r := s.v.withDeadline("mount", volume.Request{Name: "foo"}, h)
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	close(release)
+	time.Sleep(5 * time.Millisecond)
+
+	r = s.v.withDeadline("unmount", volume.Request{Name: "foo"}, func(volume.Request) volume.Response {
+		return volume.Response{}
+	})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.v.pending, HasLen, 0)
+
+	r = s.v.withDeadline("mount", volume.Request{Name: "foo"}, h)
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(calls, Equals, 2)
+}
+
+func (s *VolumeSuite) TestWithDeadlineExpiredResult(c *C) {
+	s.v.ResponseTimeout = 10 * time.Millisecond
+	done := make(chan struct{})
+	close(done)
+	s.v.pending["mount bar"] = &pendingOperation{
+		name: "bar", done: done, finishedAt: time.Now().Add(-PendingResultTTL),
+	}
+
+	s.v.pending["mount qux"] = &pendingOperation{
+		name: "qux", done: done, finishedAt: time.Now(),
+	}
+
+	r := s.v.withDeadline("mount", volume.Request{Name: "foo"}, func(volume.Request) volume.Response {
+		return volume.Response{}
+	})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.v.pending, HasLen, 1)
+	c.Assert(s.v.pending["mount qux"], NotNil)
+}
+
+func (s *VolumeSuite) TestWithDeadlineDisabled(c *C) {
+	s.v.ResponseTimeout = 0
+	r := s.v.withDeadline("create", volume.Request{Name: "foo"}, func(volume.Request) volume.Response {
+		time.Sleep(20 * time.Millisecond)
+		return volume.Response{Mountpoint: "foo"}
+	})
+
+	c.Assert(r.Err, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestWithDeadlineMountNeverPickedUp(c *C) {
+	s.v.ResponseTimeout = 10 * time.Millisecond
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	release := make(chan struct{})
+	r = s.v.withDeadline("mount", volume.Request{Name: "foo", ID: "a1"}, func(r volume.Request) volume.Response {
+		<-release
+		return s.v.mount(r)
+	})
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	close(release)
+	<-s.v.pending["mount foo a1"].done
+	c.Assert(s.p.attached["foo"], Equals, true)
+	c.Assert(s.v.hasMountRef("foo", "a1"), Equals, true)
+
+	s.v.Lock()
+	s.v.pending["mount foo a1"].finishedAt = time.Now().Add(-PendingResultTTL)
+	s.v.Unlock()
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	s.v.background.Wait()
+
+	c.Assert(s.v.pending, HasLen, 0)
+	c.Assert(s.v.hasMountRef("foo", "a1"), Equals, false)
+	c.Assert(s.fs.Mounted["/mnt/foo"], HasLen, 0)
+	c.Assert(s.p.attached["foo"], Equals, false)
+}
+
+func (s *VolumeSuite) TestWithDeadlineMountUnmountedByCaller(c *C) {
+	s.v.ResponseTimeout = 10 * time.Millisecond
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	release := make(chan struct{})
+	r = s.v.withDeadline("mount", volume.Request{Name: "foo", ID: "a1"}, func(r volume.Request) volume.Response {
+		<-release
+		return s.v.mount(r)
+	})
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	close(release)
+	<-s.v.pending["mount foo a1"].done
+
+	r = s.v.Unmount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+	s.v.background.Wait()
+	c.Assert(s.p.attached["foo"], Equals, false)
+}
@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var ErrDraining = errors.New("node draining, volumes can't be created or mounted")
+
+// DrainResult are the volumes unmounted and detached by Drain, and the errors
+// releasing the others.
+type DrainResult struct {
+	Draining bool              `json:"draining"`
+	Released []string          `json:"released,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// Drain puts the plugin in drain mode, refusing new creates and mounts while
+// unmounts and removes still work, before decommissioning the node. With
+// release it also unmounts and detaches the mounted volumes no container
+// uses anymore, the ones still in use, or whose mountpoint is busy, are kept
+// and reported. They're never unmounted lazily.
+func (v *Volume) Drain(release bool) *DrainResult {
+	v.setDraining(true)
+	log15.Warn("drain mode enabled", "release", release)
+
+	result := &DrainResult{Draining: true}
+	if !release {
+		return result
+	}
+
+	for _, disk := range v.mountedNames() {
+		name := v.diskVolumeName(disk)
+		var err string
+		if refs := v.mountRefs(disk); refs > 0 {
+			err = fmt.Sprintf("still used by %d containers, stop them first", refs)
+		} else if _, lazy := v.lazySource(disk); lazy {
+			err = "unmounted lazily, kept attached until the processes holding it exit"
+		} else {
+			err = v.withDeadline("unmount", volume.Request{Name: name}, recovered("unmount", v.drainUnmount)).Err
+		}
+
+		if err != "" {
+			if result.Errors == nil {
+				result.Errors = make(map[string]string, 0)
+			}
+
+			result.Errors[name] = err
+			continue
+		}
+
+		result.Released = append(result.Released, name)
+	}
+
+	log15.Info("volumes released", "released", len(result.Released), "failed", len(result.Errors))
+	return result
+}
+
+// drainUnmount unmounts a volume released by Drain, never lazily: a busy
+// mountpoint is still used by a process the drain must not cut off.
+func (v *Volume) drainUnmount(r volume.Request) volume.Response {
+	return v.unmountVolume(r, false)
+}
+
+// Undrain leaves the drain mode, accepting creates and mounts again.
+func (v *Volume) Undrain() {
+	v.setDraining(false)
+	log15.Info("drain mode disabled")
+}
+
+func (v *Volume) Draining() bool {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.draining
+}
+
+func (v *Volume) setDraining(draining bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.draining = draining
+}
+
+func (v *Volume) mountedNames() []string {
+	v.Lock()
+	defer v.Unlock()
+
+	var names []string
+	for name := range v.mounts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
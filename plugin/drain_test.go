@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestDrain(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	result := s.v.Drain(false)
+	c.Assert(result.Draining, Equals, true)
+	c.Assert(result.Released, HasLen, 0)
+	c.Assert(s.v.Status().Draining, Equals, true)
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, Equals, ErrDraining.Error())
+	c.Assert(s.p.disks["bar"], Equals, false)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, ErrDraining.Error())
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.Undrain()
+	c.Assert(s.v.Status().Draining, Equals, false)
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestDrainRelease(c *C) {
+	for _, name := range []string{"foo", "bar", "My_Data"} {
+		r := s.v.Create(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+
+		r = s.v.Mount(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	r := s.v.Create(volume.Request{Name: "qux"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "qux", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.UnmountRetries = 0
+	s.v.LazyUnmount = true
+	s.fs.Failures["/mnt/bar"] = []error{fmt.Errorf("target is busy")}
+
+	result := s.v.Drain(true)
+	c.Assert(result.Released, DeepEquals, []string{"foo", "My_Data"})
+	c.Assert(result.Errors, DeepEquals, map[string]string{
+		"bar": "unmount failed at unmount: target is busy",
+		"qux": "still used by 1 containers, stop them first",
+	})
+	c.Assert(s.p.attached, DeepEquals, map[string]bool{"bar": true, "qux": true})
+	c.Assert(s.fs.Lazy, HasLen, 0)
+	c.Assert(s.v.Status().Mounts, HasLen, 2)
+	c.Assert(s.v.refs["qux"], HasLen, 1)
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bloomapi/gce-docker/providers"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"google.golang.org/api/googleapi"
+)
+
+// IncludeErrorCodes prefixes the error responses with their code, e.g.
+// "[not-found] ...", for the clients branching on the kind of error.
+var IncludeErrorCodes = false
+
+// Error codes of the error responses, stable across releases.
+const (
+	ErrorCodeInvalidArgument  = providers.ErrorCodeInvalidArgument
+	ErrorCodeNotFound         = providers.ErrorCodeNotFound
+	ErrorCodeAlreadyExists    = "already-exists"
+	ErrorCodeConflict         = providers.ErrorCodeConflict
+	ErrorCodeQuotaExceeded    = "quota-exceeded"
+	ErrorCodeLimitExceeded    = providers.ErrorCodeLimitExceeded
+	ErrorCodePermissionDenied = providers.ErrorCodePermissionDenied
+	ErrorCodeRateLimited      = "rate-limited"
+	ErrorCodeUnavailable      = "unavailable"
+	ErrorCodeInProgress       = "in-progress"
+	ErrorCodeDraining         = "draining"
+	ErrorCodeInternal         = "internal"
+	ErrorCodeUnknown          = "unknown"
+)
+
+// CodedError is an error with its error code, the provider errors have it
+// too.
+type CodedError = providers.CodedError
+
+func withCode(code string, err error) error {
+	return &CodedError{Code: code, Err: err}
+}
+
+// ErrorCode returns the error code of err, or of the error it wraps, unknown
+// if it can't be classified.
+func ErrorCode(err error) string {
+	switch e := err.(type) {
+	case *CodedError:
+		return e.Code
+	case *StepError:
+		return ErrorCode(e.Err)
+	case *googleapi.Error:
+		return apiErrorCode(e)
+	case *providers.OperationError:
+		return operationErrorCode(e)
+	}
+
+	switch err {
+	case ErrDraining:
+		return ErrorCodeDraining
+	case ErrClosed:
+		return ErrorCodeUnavailable
+	}
+
+	if wrapped := errors.Unwrap(err); wrapped != nil {
+		return ErrorCode(wrapped)
+	}
+
+	return ErrorCodeUnknown
+}
+
+func apiErrorCode(e *googleapi.Error) string {
+	for _, item := range e.Errors {
+		switch item.Reason {
+		case "quotaExceeded":
+			return ErrorCodeQuotaExceeded
+		case "rateLimitExceeded", "userRateLimitExceeded":
+			return ErrorCodeRateLimited
+		case "alreadyExists":
+			return ErrorCodeAlreadyExists
+		case "resourceInUseByAnotherResource":
+			return ErrorCodeConflict
+		}
+	}
+
+	switch {
+	case e.Code == 400:
+		return ErrorCodeInvalidArgument
+	case e.Code == 401, e.Code == 403:
+		return ErrorCodePermissionDenied
+	case e.Code == 404:
+		return ErrorCodeNotFound
+	case e.Code == 409:
+		return ErrorCodeConflict
+	case e.Code == 429:
+		return ErrorCodeRateLimited
+	case e.Code >= 500:
+		return ErrorCodeUnavailable
+	}
+
+	return ErrorCodeUnknown
+}
+
+func operationErrorCode(e *providers.OperationError) string {
+	for _, code := range e.Codes {
+		switch code {
+		case "QUOTA_EXCEEDED", "ZONE_RESOURCE_POOL_EXHAUSTED":
+			return ErrorCodeQuotaExceeded
+		case "RESOURCE_ALREADY_EXISTS", "ALREADY_EXISTS":
+			return ErrorCodeAlreadyExists
+		case "RESOURCE_NOT_FOUND", "NOT_FOUND":
+			return ErrorCodeNotFound
+		case "RESOURCE_IN_USE_BY_ANOTHER_RESOURCE", "RESOURCE_NOT_READY":
+			return ErrorCodeConflict
+		case "PERMISSIONS_ERROR", "FORBIDDEN":
+			return ErrorCodePermissionDenied
+		case "INVALID_FIELD_VALUE", "INVALID_USAGE", "BAD_REQUEST":
+			return ErrorCodeInvalidArgument
+		}
+	}
+
+	return ErrorCodeUnknown
+}
+
+func errorResponse(err error) volume.Response {
+	if IncludeErrorCodes {
+		return volume.Response{Err: fmt.Sprintf("[%s] %s", ErrorCode(err), err)}
+	}
+
+	return volume.Response{Err: err.Error()}
+}
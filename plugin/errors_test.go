@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/docker/go-plugins-helpers/volume"
+	"google.golang.org/api/googleapi"
+	. "gopkg.in/check.v1"
+)
+
+type ErrorsSuite struct{}
+
+var _ = Suite(&ErrorsSuite{})
+
+func (s *ErrorsSuite) TearDownTest(c *C) {
+	IncludeErrorCodes = false
+}
+
+func (s *ErrorsSuite) TestErrorCode(c *C) {
+	c.Assert(ErrorCode(fmt.Errorf("foo")), Equals, ErrorCodeUnknown)
+	c.Assert(ErrorCode(ErrDraining), Equals, ErrorCodeDraining)
+	c.Assert(ErrorCode(withCode(ErrorCodeInvalidArgument, fmt.Errorf("foo"))), Equals, ErrorCodeInvalidArgument)
+	c.Assert(ErrorCode(&googleapi.Error{Code: 404}), Equals, ErrorCodeNotFound)
+	c.Assert(ErrorCode(&googleapi.Error{Code: 403}), Equals, ErrorCodePermissionDenied)
+	c.Assert(ErrorCode(&googleapi.Error{Code: 503}), Equals, ErrorCodeUnavailable)
+	c.Assert(ErrorCode(&googleapi.Error{
+		Code:   403,
+		Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}},
+	}), Equals, ErrorCodeQuotaExceeded)
+	c.Assert(ErrorCode(&providers.OperationError{Codes: []string{"RESOURCE_ALREADY_EXISTS"}}), Equals, ErrorCodeAlreadyExists)
+	c.Assert(ErrorCode(&StepError{Err: &googleapi.Error{Code: 409}}), Equals, ErrorCodeConflict)
+}
+
+func (s *ErrorsSuite) TestErrorCodeProvider(c *C) {
+	limit := &providers.CodedError{Code: providers.ErrorCodeLimitExceeded, Err: fmt.Errorf("limit")}
+	forbidden := &providers.CodedError{Code: providers.ErrorCodePermissionDenied, Err: fmt.Errorf("forbidden")}
+	drift := &providers.CodedError{Code: providers.ErrorCodeConflict, Err: fmt.Errorf("drift")}
+	size := &providers.CodedError{Code: providers.ErrorCodeInvalidArgument, Err: fmt.Errorf("size")}
+
+	for _, t := range []struct {
+		err  error
+		code string
+	}{
+		{limit, ErrorCodeLimitExceeded},
+		{forbidden, ErrorCodePermissionDenied},
+		{drift, ErrorCodeConflict},
+		{size, ErrorCodeInvalidArgument},
+		{fmt.Errorf("wrapped: %w", limit), ErrorCodeLimitExceeded},
+		{fmt.Errorf("unable to adopt disk %q: %w", "foo", &googleapi.Error{Code: 404}), ErrorCodeNotFound},
+		{fmt.Errorf("wrapped: %w", ErrClosed), ErrorCodeUnavailable},
+		{fmt.Errorf("wrapped: %s", &googleapi.Error{Code: 404}), ErrorCodeUnknown},
+	} {
+		c.Assert(ErrorCode(t.err), Equals, t.code, Commentf("%s", t.err))
+	}
+}
+
+func (s *ErrorsSuite) TestErrorResponse(c *C) {
+	v := newVolume(NewDiskProviderFixture(), NewMemFilesystem())
+	r := v.Create(volume.Request{Name: "foo", Options: map[string]string{"foo": "bar"}})
+	c.Assert(r.Err, Equals, `unknown option "foo"`)
+
+	IncludeErrorCodes = true
+	r = v.Create(volume.Request{Name: "foo", Options: map[string]string{"foo": "bar"}})
+	c.Assert(r.Err, Equals, `[invalid-argument] unknown option "foo"`)
+
+	v.Drain(false)
+	r = v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, "[draining] "+ErrDraining.Error())
+}
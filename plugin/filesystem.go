@@ -1,10 +1,19 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 	"gopkg.in/inconshreveable/log15.v2"
@@ -16,13 +25,48 @@ var (
 	HostFilesystem      = "/rootfs/"
 	MountNamespace      = "/rootfs/proc/1/ns/mnt"
 	CGroupFilename      = "/proc/1/cgroup"
+	NVMeIOTimeout       = "/sys/module/nvme_core/parameters/io_timeout"
+	NVMeDevices         = "/dev/nvme*n*"
+	HealthCheckTimeout  = 10 * time.Second
 )
 
 type Filesystem interface {
 	afero.Fs
-	Mount(source string, target string) error
+	Mount(source, target, fstype string, options []string) error
 	Unmount(target string) error
-	Format(source string) error
+	LazyUnmount(target string) error
+	Bind(source, target string, readOnly bool) error
+	Unbind(target string) error
+	Holders(target string) ([]string, error)
+	Flush(source string) error
+	Trim(target string) (int64, error)
+	Format(source, fstype string, force bool, options []string) error
+	Wipe(source, method string) error
+	SetOwner(target string, uid, gid int) error
+	SetOwnerRecursive(target string, uid, gid int) error
+	RemapOwner(target string, uid, gid int) error
+	SetMode(target string, mode uint32) error
+	Repair(source, fstype string) error
+	Grow(source, target, fstype string) error
+	Size(target string) (int64, error)
+	Rescan(source string) error
+	SetDeviceTimeout(source string, seconds int64) error
+	SetReadAhead(source string, kb int64) error
+	Freeze(target string, frozen bool) error
+	Hook(command string, env []string) error
+	Probe(source string) (string, error)
+	Signatures(source string) ([]string, error)
+	Device(source string) (string, error)
+	Resolve(source, deviceName string) (string, error)
+	Settle(timeout time.Duration) error
+	Mounts() ([]*MountInfo, error)
+	Check(source string, target string) error
+}
+
+type MountInfo struct {
+	Source string
+	Target string
+	FSType string
 }
 
 type OSFilesystem struct {
@@ -43,14 +87,29 @@ func NewFilesystem() Filesystem {
 	return &OSFilesystem{inContainer: inContainer, Fs: fs}
 }
 
+// LstatIfPossible implements afero.Lstater, telling the symlinks apart on
+// the host filesystem.
+func (fs *OSFilesystem) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if l, ok := fs.Fs.(afero.Lstater); ok {
+		return l.LstatIfPossible(name)
+	}
+
+	fi, err := fs.Stat(name)
+	return fi, false, err
+}
+
 var nsenterArgs = []string{
 	"nsenter",
 	fmt.Sprintf("--mount=%s", MountNamespace),
 	"--",
 }
 
-func (fs *OSFilesystem) Mount(source string, target string) error {
-	args := fs.getMountArgs(source, target, DefaultFStype, DefaultMountOptions)
+func (fs *OSFilesystem) Mount(source, target, fstype string, options []string) error {
+	if err := ValidateMountOptions(fstype, options); err != nil {
+		return err
+	}
+
+	args := fs.getMountArgs(source, target, fstype, options)
 
 	command := exec.Command(args[0], args[1:]...)
 	output, err := command.CombinedOutput()
@@ -64,6 +123,87 @@ func (fs *OSFilesystem) Mount(source string, target string) error {
 	return err
 }
 
+// GenericMountOptions are the mount options accepted by every filesystem.
+var GenericMountOptions = []string{
+	"defaults", "ro", "rw", "sync", "async", "dirsync", "remount",
+	"atime", "noatime", "diratime", "nodiratime", "relatime", "norelatime",
+	"strictatime", "nostrictatime", "lazytime", "nolazytime",
+	"dev", "nodev", "exec", "noexec", "suid", "nosuid", "mand", "nomand",
+	"context", "fscontext", "defcontext", "rootcontext",
+	"discard", "nodiscard",
+}
+
+// FSMountOptions are the filesystem specific mount options by fstype.
+var FSMountOptions = map[string][]string{
+	"ext4": {
+		"acl", "noacl", "user_xattr", "nouser_xattr", "barrier", "nobarrier",
+		"commit", "data", "data_err", "errors", "journal_checksum",
+		"nojournal_checksum", "journal_async_commit", "journal_ioprio",
+		"noload", "norecovery", "delalloc", "nodelalloc", "dioread_nolock",
+		"dioread_lock", "auto_da_alloc", "noauto_da_alloc", "stripe",
+		"max_batch_time", "min_batch_time", "init_itable", "noinit_itable",
+		"quota", "noquota", "usrquota", "grpquota", "prjquota", "resuid",
+		"resgid", "sb", "i_version", "nombcache", "dax",
+	},
+	"xfs": {
+		"allocsize", "attr2", "noattr2", "dax", "discard", "nodiscard",
+		"grpid", "nogrpid", "bsdgroups", "sysvgroups", "filestreams",
+		"ikeep", "noikeep", "inode32", "inode64", "largeio", "nolargeio",
+		"logbufs", "logbsize", "logdev", "rtdev", "noalign", "norecovery",
+		"nouuid", "noquota", "uquota", "usrquota", "quota", "uqnoenforce",
+		"qnoenforce", "gquota", "grpquota", "gqnoenforce", "pquota",
+		"prjquota", "pqnoenforce", "sunit", "swidth", "swalloc", "wsync",
+	},
+	"btrfs": {
+		"acl", "noacl", "autodefrag", "noautodefrag", "barrier", "nobarrier",
+		"check_int", "clear_cache", "commit", "compress", "compress-force",
+		"datacow", "nodatacow", "datasum", "nodatasum", "degraded", "device",
+		"fatal_errors", "flushoncommit", "noflushoncommit", "max_inline",
+		"rescan_uuid_tree", "space_cache", "nospace_cache", "ssd", "nossd",
+		"ssd_spread", "nossd_spread", "subvol", "subvolid", "thread_pool",
+		"treelog", "notreelog", "user_subvol_rm_allowed",
+	},
+}
+
+// ValidateMountOptions checks that every option is valid for the given
+// fstype, returning an error naming the filesystems that do accept it.
+func ValidateMountOptions(fstype string, options []string) error {
+	for _, o := range options {
+		name := strings.SplitN(o, "=", 2)[0]
+		if containsString(GenericMountOptions, name) || containsString(FSMountOptions[fstype], name) {
+			continue
+		}
+
+		var valid []string
+		for _, t := range []string{"btrfs", "ext4", "xfs"} {
+			if containsString(FSMountOptions[t], name) {
+				valid = append(valid, t)
+			}
+		}
+
+		if len(valid) == 0 {
+			return fmt.Errorf("invalid mount option %q, unknown option", o)
+		}
+
+		return fmt.Errorf(
+			"invalid mount option %q, %s is only valid for %s",
+			o, name, strings.Join(valid, ", "),
+		)
+	}
+
+	return nil
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, e := range haystack {
+		if e == needle {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (fs *OSFilesystem) getMountArgs(source, target, fstype string, options []string) []string {
 	var args []string
 	args = append(args, "mount")
@@ -79,11 +219,7 @@ func (fs *OSFilesystem) getMountArgs(source, target, fstype string, options []st
 	args = append(args, source)
 	args = append(args, target)
 
-	if fs.inContainer {
-		return append(nsenterArgs, args...)
-	}
-
-	return args
+	return fs.hostArgs(args...)
 }
 
 func (fs *OSFilesystem) Unmount(target string) error {
@@ -101,28 +237,526 @@ func (fs *OSFilesystem) Unmount(target string) error {
 	return nil
 }
 
-func (fs *OSFilesystem) getUnmountArgs(target string) []string {
-	var args []string
-	args = append(args, "umount", target)
+// LazyUnmount detaches the filesystem mounted at target from the hierarchy
+// right away, the kernel finishes the unmount once it isn't busy anymore.
+func (fs *OSFilesystem) LazyUnmount(target string) error {
+	args := fs.hostArgs("umount", "-l", target)
 
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"lazy unmount failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+// Bind mounts the mounted disk at source on target, a path of the plugin
+// mount namespace, like the propagated mount of a managed plugin, instead of
+// the host one, so the commands aren't run with nsenter and the source is
+// reached through the host filesystem.
+func (fs *OSFilesystem) Bind(source, target string, readOnly bool) error {
 	if fs.inContainer {
-		return append(nsenterArgs, args...)
+		source = filepath.Join(HostFilesystem, source)
 	}
 
-	return args
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return fmt.Errorf("error creating bind target %q: %s", target, err)
+	}
+
+	commands := [][]string{{"mount", "--bind", source, target}}
+	if readOnly {
+		commands = append(commands, []string{"mount", "-o", "remount,bind,ro", target})
+	}
+
+	for _, args := range commands {
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf(
+				"bind mount failed, arguments: %q\noutput: %s\n",
+				args, string(output),
+			)
+		}
+	}
+
+	return nil
+}
+
+// Unbind unmounts the bind mount at target, a path of the plugin mount
+// namespace, and removes it.
+func (fs *OSFilesystem) Unbind(target string) error {
+	output, err := exec.Command("umount", target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"bind unmount failed, target: %q\noutput: %s\n",
+			target, string(output),
+		)
+	}
+
+	return os.Remove(target)
+}
+
+// fuser exits with this status when no process uses the filesystem.
+const fuserNotFoundExitCode = 1
+
+// Holders returns the processes using the filesystem mounted at target, as
+// pid (command), the ones keeping it busy.
+func (fs *OSFilesystem) Holders(target string) ([]string, error) {
+	args := fs.hostArgs("fuser", "-m", target)
+
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == fuserNotFoundExitCode {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fuser failed, arguments: %q: %s", args, err)
+	}
+
+	var holders []string
+	for _, pid := range parseFuserPids(string(output)) {
+		comm, err := afero.ReadFile(fs, filepath.Join("/proc", pid, "comm"))
+		if err != nil {
+			holders = append(holders, pid)
+			continue
+		}
+
+		holders = append(holders, fmt.Sprintf("%s (%s)", pid, strings.TrimSpace(string(comm))))
+	}
+
+	return holders, nil
+}
+
+// parseFuserPids returns the pids printed by fuser, without the letters it
+// appends describing the kind of access, e.g. 1234c.
+func parseFuserPids(output string) []string {
+	var pids []string
+	for _, f := range strings.Fields(output) {
+		pid := strings.TrimRightFunc(f, func(r rune) bool { return r < '0' || r > '9' })
+		if pid != "" {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+// IsNotMountedError reports whether the unmount failed because nothing is
+// mounted at the target, e.g. the mount was lost while the plugin was down.
+func IsNotMountedError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "not mounted")
+}
+
+var busyErrorMessages = []string{"target is busy", "device is busy", "device or resource busy"}
+
+// IsBusyError reports whether the unmount failed because the filesystem is
+// still used by a process.
+func IsBusyError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range busyErrorMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Flush writes the dirty pages to the disks and flushes the buffers of the
+// source device, so no write is left in the guest when it's detached.
+func (fs *OSFilesystem) Flush(source string) error {
+	for _, args := range fs.getFlushArgs(source) {
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf(
+				"flush failed, arguments: %q\noutput: %s\n",
+				args, string(output),
+			)
+		}
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getFlushArgs(source string) [][]string {
+	return [][]string{
+		fs.hostArgs("sync"),
+		fs.hostArgs("blockdev", "--flushbufs", source),
+	}
+}
+
+// Trim discards the blocks unused by the filesystem mounted on target,
+// returning the bytes trimmed, so the space is reclaimed without mounting
+// with discard.
+func (fs *OSFilesystem) Trim(target string) (int64, error) {
+	args := fs.getTrimArgs(target)
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf(
+			"fstrim failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return parseTrimmed(string(output)), nil
+}
+
+func (fs *OSFilesystem) getTrimArgs(target string) []string {
+	return fs.hostArgs("fstrim", "-v", target)
+}
+
+var trimmedFormat = regexp.MustCompile(`(\d+) bytes`)
+
+// parseTrimmed parses the bytes trimmed from the fstrim -v output, e.g.
+// "/mnt/foo: 1 GiB (1073741824 bytes) trimmed", 0 if not found.
+func parseTrimmed(output string) int64 {
+	m := trimmedFormat.FindStringSubmatch(output)
+	if m == nil {
+		return 0
+	}
+
+	n, _ := strconv.ParseInt(m[1], 10, 64)
+	return n
+}
+
+func (fs *OSFilesystem) getUnmountArgs(target string) []string {
+	return fs.hostArgs("umount", target)
+}
+
+func (fs *OSFilesystem) Format(source, fstype string, force bool, options []string) error {
+	args := fs.getMkfsArgs(source, fstype, force, options)
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"%s failed, arguments: %q\noutput: %s\n",
+			args[0], args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getMkfsArgs(source, fstype string, force bool, options []string) []string {
+	args := []string{"mkfs." + fstype}
+	if force {
+		args = append(args, mkfsForceFlags[fstype])
+	}
+
+	args = append(args, options...)
+	args = append(args, source)
+	return fs.hostArgs(args...)
+}
+
+var mkfsForceFlags = map[string]string{
+	"ext4":  "-F",
+	"xfs":   "-f",
+	"btrfs": "-f",
+}
+
+// Wipe erases the content of the device, with blkdiscard for the discard
+// method or overwriting it with shred for zero and shred.
+func (fs *OSFilesystem) Wipe(source, method string) error {
+	args, err := fs.getWipeArgs(source, method)
+	if err != nil {
+		return err
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"%s failed, arguments: %q\noutput: %s\n",
+			args[0], args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getWipeArgs(source, method string) ([]string, error) {
+	switch method {
+	case "discard":
+		return fs.hostArgs("blkdiscard", source), nil
+	case "zero":
+		return fs.hostArgs("shred", "-n", "0", "-z", source), nil
+	case "shred":
+		return fs.hostArgs("shred", "-n", "1", "-z", source), nil
+	}
+
+	return nil, fmt.Errorf("unknown wipe method %q", method)
+}
+
+// SetOwner changes the owner of target, it runs chown on the host since the
+// target is usually a mountpoint created there. A -1 id is kept.
+func (fs *OSFilesystem) SetOwner(target string, uid, gid int) error {
+	var owner string
+	if uid >= 0 {
+		owner = strconv.Itoa(uid)
+	}
+
+	if gid >= 0 {
+		owner += ":" + strconv.Itoa(gid)
+	}
+
+	args := fs.hostArgs("chown", owner, target)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"chown failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+// SetOwnerRecursive changes the owner of target and all the files under it,
+// the symlinks themselves instead of the files they point to, which may be
+// out of the volume.
+func (fs *OSFilesystem) SetOwnerRecursive(target string, uid, gid int) error {
+	args := fs.getChownRecursiveArgs(target, uid, gid)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"chown failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getChownRecursiveArgs(target string, uid, gid int) []string {
+	return fs.hostArgs("chown", "-R", "-P", "-h", fmt.Sprintf("%d:%d", uid, gid), target)
+}
+
+// RemapOwner changes the owner of target to uid and gid only if it's owned
+// by the host root, as SetOwner on the host, keeping the owner given by the
+// user or a previous remap.
+func (fs *OSFilesystem) RemapOwner(target string, uid, gid int) error {
+	args := fs.hostArgs("chown", "--from=0:0", fmt.Sprintf("%d:%d", uid, gid), target)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"chown failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+// SetMode changes the permissions of target, as SetOwner on the host.
+func (fs *OSFilesystem) SetMode(target string, mode uint32) error {
+	args := fs.hostArgs("chmod", fmt.Sprintf("%04o", mode), target)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"chmod failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
 }
 
-func (fs *OSFilesystem) Format(source string) error {
-	if fs.isFormatted(source) {
+// Repair checks the filesystem of source, repairing the errors found. It
+// fails when the errors can't be repaired without manual intervention. The
+// dirty log of an XFS filesystem, left by a crash, is replayed mounting it,
+// xfs_repair refuses to run until then.
+func (fs *OSFilesystem) Repair(source, fstype string) error {
+	args := fs.getRepairArgs(source, fstype)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok && fstype == "xfs" && exitErr.ExitCode() == xfsRepairDirtyLogExitCode {
+		log15.Warn("xfs log is dirty, replaying it before repairing", "source", source)
+		if err := fs.replayLog(source, fstype); err != nil {
+			return fmt.Errorf("error replaying xfs log of %s: %s", source, err)
+		}
+
+		output, err = exec.Command(args[0], args[1:]...).CombinedOutput()
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok && fstype == "ext4" && exitErr.ExitCode() < e2fsckUncorrectedExitCode {
+		log15.Info("filesystem errors corrected", "source", source, "output", string(output))
 		return nil
 	}
 
-	args := fs.getMkfsExt4Args(source)
+	if err != nil {
+		return fmt.Errorf(
+			"%s failed, arguments: %q\noutput: %s\n",
+			args[0], args, string(output),
+		)
+	}
+
+	return nil
+}
+
+// Grow grows the filesystem mounted at target to the size of the device.
+func (fs *OSFilesystem) Grow(source, target, fstype string) error {
+	args := fs.getGrowArgs(source, target, fstype)
+
+	command := exec.Command(args[0], args[1:]...)
+	if output, err := command.CombinedOutput(); err != nil {
+		return fmt.Errorf(
+			"%s failed, arguments: %q\noutput: %s\n",
+			args[0], args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getGrowArgs(source, target, fstype string) []string {
+	switch fstype {
+	case "xfs":
+		return fs.hostArgs("xfs_growfs", target)
+	case "btrfs":
+		return fs.hostArgs("btrfs", "filesystem", "resize", "max", target)
+	}
+
+	return fs.hostArgs("resize2fs", source)
+}
+
+// Size returns the size in bytes of the filesystem mounted at target.
+func (fs *OSFilesystem) Size(target string) (int64, error) {
+	args := fs.hostArgs("stat", "-f", "-c", "%b %S", target)
+
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("stat failed, arguments: %q: %s", args, err)
+	}
+
+	return parseFilesystemSize(strings.TrimSpace(string(output)))
+}
+
+// parseFilesystemSize multiplies the blocks and block size printed by stat.
+func parseFilesystemSize(output string) (int64, error) {
+	var blocks, size int64
+	if _, err := fmt.Sscanf(output, "%d %d", &blocks, &size); err != nil {
+		return 0, fmt.Errorf("invalid filesystem size %q: %s", output, err)
+	}
+
+	return blocks * size, nil
+}
+
+// Rescan asks the kernel to read again the size of the device, which may be
+// stale after the disk is resized.
+func (fs *OSFilesystem) Rescan(source string) error {
+	device, err := fs.blockDevice(source)
+	if err != nil {
+		return err
+	}
+
+	rescan := filepath.Join("/sys/class/block", device, "device", "rescan")
+	return afero.WriteFile(fs, rescan, []byte("1"), 0200)
+}
+
+// SetDeviceTimeout sets the time after which the I/O requests to the device
+// fail instead of waiting for it. SCSI devices have their own timeout, the
+// NVMe one is global, set on the nvme_core module for all the devices.
+func (fs *OSFilesystem) SetDeviceTimeout(source string, seconds int64) error {
+	device, err := fs.blockDevice(source)
+	if err != nil {
+		return err
+	}
+
+	path := deviceTimeoutPath(device)
+	if path == NVMeIOTimeout {
+		log15.Warn("setting global nvme io timeout", "device", device, "timeout", seconds)
+	}
+
+	return afero.WriteFile(fs, path, []byte(strconv.FormatInt(seconds, 10)), 0200)
+}
+
+func deviceTimeoutPath(device string) string {
+	if strings.HasPrefix(device, "nvme") {
+		return NVMeIOTimeout
+	}
+
+	return filepath.Join("/sys/block", device, "device", "timeout")
+}
+
+// SetReadAhead sets the KB read ahead of the sequential reads of the device,
+// in its request queue.
+func (fs *OSFilesystem) SetReadAhead(source string, kb int64) error {
+	device, err := fs.blockDevice(source)
+	if err != nil {
+		return err
+	}
+
+	return afero.WriteFile(fs, readAheadPath(device), []byte(strconv.FormatInt(kb, 10)), 0200)
+}
+
+func readAheadPath(device string) string {
+	return filepath.Join("/sys/block", device, "queue", "read_ahead_kb")
+}
+
+// blockDevice returns the kernel name of the block device source links to,
+// e.g. sdb.
+func (fs *OSFilesystem) blockDevice(source string) (string, error) {
+	args := fs.hostArgs("readlink", "-f", source)
+
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("readlink failed, arguments: %q: %s", args, err)
+	}
+
+	return filepath.Base(strings.TrimSpace(string(output))), nil
+}
+
+// e2fsck exits with 1 or 2 when errors were corrected, and with this status
+// or higher when they weren't.
+const e2fsckUncorrectedExitCode = 4
+
+// xfs_repair exits with this status when the log of the filesystem is dirty
+// and must be replayed before repairing it.
+const xfsRepairDirtyLogExitCode = 2
+
+// replayLog mounts and unmounts the filesystem of source on a temporary
+// directory, so the kernel replays its log.
+func (fs *OSFilesystem) replayLog(source, fstype string) error {
+	dir, err := afero.TempDir(fs, "", "gce-docker-replay-")
+	if err != nil {
+		return err
+	}
+
+	defer fs.Remove(dir)
+
+	if err := fs.Mount(source, dir, fstype, nil); err != nil {
+		return err
+	}
+
+	return fs.Unmount(dir)
+}
+
+// Freeze suspends the writes to the filesystem mounted at target, flushing
+// it to the device, or resumes them.
+func (fs *OSFilesystem) Freeze(target string, frozen bool) error {
+	args := fs.getFreezeArgs(target, frozen)
+
 	command := exec.Command(args[0], args[1:]...)
 	output, err := command.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf(
-			"mkfs.ext4 failed, arguments: %q\noutput: %s\n",
+			"fsfreeze failed, arguments: %q\noutput: %s\n",
 			args, string(output),
 		)
 	}
@@ -130,33 +764,286 @@ func (fs *OSFilesystem) Format(source string) error {
 	return nil
 }
 
-func (fs *OSFilesystem) getMkfsExt4Args(source string) []string {
-	var args []string
-	args = append(args, "mkfs.ext4", source)
+func (fs *OSFilesystem) getFreezeArgs(target string, frozen bool) []string {
+	if frozen {
+		return fs.hostArgs("fsfreeze", "--freeze", target)
+	}
 
-	if fs.inContainer {
-		return append(nsenterArgs, args...)
+	return fs.hostArgs("fsfreeze", "--unfreeze", target)
+}
+
+// Hook runs a shell command on the host, with env added to its environment.
+func (fs *OSFilesystem) Hook(command string, env []string) error {
+	args := fs.hostArgs("sh", "-c", command)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("hook %q failed: %s\noutput: %s\n", command, err, string(output))
 	}
 
-	return args
+	return nil
+}
+
+func (fs *OSFilesystem) getRepairArgs(source, fstype string) []string {
+	switch fstype {
+	case "xfs":
+		return fs.hostArgs("xfs_repair", source)
+	case "btrfs":
+		return fs.hostArgs("btrfs", "check", source)
+	}
+
+	return fs.hostArgs("e2fsck", "-p", source)
 }
 
-func (fs *OSFilesystem) isFormatted(source string) bool {
+// blkid exits with this status when no filesystem is found on the device.
+const blkidNotFoundExitCode = 2
+
+// Probe returns the type of the filesystem present in source, or an empty
+// string if the device doesn't contain one. It reads the device itself
+// instead of the blkid cache, which can be stale for a just attached disk.
+func (fs *OSFilesystem) Probe(source string) (string, error) {
 	args := fs.getBlkidArgs(source)
 
 	command := exec.Command(args[0], args[1:]...)
-	_, err := command.CombinedOutput()
+	output, err := command.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == blkidNotFoundExitCode {
+		return "", nil
+	}
+
 	if err != nil {
-		return false
+		return "", fmt.Errorf("blkid failed, arguments: %q: %s", args, err)
 	}
 
-	return true
+	return strings.TrimSpace(string(output)), nil
 }
 
 func (fs *OSFilesystem) getBlkidArgs(source string) []string {
-	var args []string
-	args = append(args, "blkid", source)
+	return fs.hostArgs("blkid", "-p", "-o", "value", "-s", "TYPE", source)
+}
+
+// Signatures returns the types of all the signatures wipefs finds in source,
+// filesystems but also partition tables, RAID or LVM members, none if the
+// device is blank.
+func (fs *OSFilesystem) Signatures(source string) ([]string, error) {
+	args := fs.getSignaturesArgs(source)
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("wipefs failed, arguments: %q: %s", args, err)
+	}
+
+	return strings.Fields(string(output)), nil
+}
+
+func (fs *OSFilesystem) getSignaturesArgs(source string) []string {
+	return fs.hostArgs("wipefs", "--no-act", "--noheadings", "--output", "TYPE", source)
+}
+
+// Device returns the major:minor numbers of the block device source, in
+// decimal as used by the cgroup files.
+func (fs *OSFilesystem) Device(source string) (string, error) {
+	args := fs.getStatArgs(source)
+
+	output, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("stat failed, arguments: %q: %s", args, err)
+	}
+
+	return parseDeviceNumbers(strings.TrimSpace(string(output)))
+}
+
+func (fs *OSFilesystem) getStatArgs(source string) []string {
+	return fs.hostArgs("stat", "-L", "-c", "%t:%T", source)
+}
+
+// parseDeviceNumbers converts the hexadecimal major:minor printed by stat to
+// decimal.
+func parseDeviceNumbers(hex string) (string, error) {
+	var major, minor uint64
+	if _, err := fmt.Sscanf(hex, "%x:%x", &major, &minor); err != nil {
+		return "", fmt.Errorf("invalid device numbers %q: %s", hex, err)
+	}
+
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
+
+// nvmeNamespaceFormat matches the NVMe namespaces, not their partitions.
+var nvmeNamespaceFormat = regexp.MustCompile("^nvme[0-9]+n[0-9]+$")
+
+// nvmeVendorOffset is the offset of the vendor specific area of the NVMe
+// namespace identification, where GCE writes the disk metadata as JSON.
+const nvmeVendorOffset = 384
+
+// Resolve returns the path of the device attached as deviceName, source if
+// it exists. The disks attached through NVMe, on the newer machine types,
+// only get the google-* links from the udev rules of the guest environment,
+// without them the namespace is found by the device name GCE writes in its
+// identification. An empty path is returned if the device isn't found.
+func (fs *OSFilesystem) Resolve(source, deviceName string) (string, error) {
+	if _, err := fs.Stat(source); err == nil {
+		return source, nil
+	}
+
+	namespaces, err := afero.Glob(fs, NVMeDevices)
+	if err != nil {
+		return "", err
+	}
+
+	for _, dev := range namespaces {
+		if !nvmeNamespaceFormat.MatchString(filepath.Base(dev)) {
+			continue
+		}
+
+		args := fs.getNVMeIDArgs(dev)
+		output, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("nvme failed, arguments: %q: %s", args, err)
+		}
+
+		if parseNVMeDeviceName(output) == deviceName {
+			log15.Debug("nvme device resolved", "device-name", deviceName, "dev", dev)
+			return dev, nil
+		}
+	}
+
+	return "", nil
+}
+
+// Settle waits, up to timeout, for udev to process the queued events, like
+// the ones creating the links of a just attached device.
+func (fs *OSFilesystem) Settle(timeout time.Duration) error {
+	args := fs.getSettleArgs(timeout)
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(
+			"udevadm failed, arguments: %q\noutput: %s\n",
+			args, string(output),
+		)
+	}
+
+	return nil
+}
+
+func (fs *OSFilesystem) getSettleArgs(timeout time.Duration) []string {
+	seconds := int64(math.Ceil(timeout.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return fs.hostArgs("udevadm", "settle", fmt.Sprintf("--timeout=%d", seconds))
+}
+
+func (fs *OSFilesystem) getNVMeIDArgs(dev string) []string {
+	return fs.hostArgs("nvme", "id-ns", "-b", dev)
+}
+
+// parseNVMeDeviceName returns the device name from the binary namespace
+// identification printed by nvme id-ns, empty if it isn't a GCE disk.
+func parseNVMeDeviceName(id []byte) string {
+	if len(id) <= nvmeVendorOffset {
+		return ""
+	}
+
+	vendor := id[nvmeVendorOffset:]
+	if i := bytes.IndexByte(vendor, 0); i >= 0 {
+		vendor = vendor[:i]
+	}
+
+	var metadata struct {
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := json.Unmarshal(vendor, &metadata); err != nil {
+		return ""
+	}
+
+	return metadata.DeviceName
+}
+
+func (fs *OSFilesystem) Mounts() ([]*MountInfo, error) {
+	args := fs.hostArgs("cat", "/proc/mounts")
+
+	command := exec.Command(args[0], args[1:]...)
+	output, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading mounts, arguments: %q: %s", args, err)
+	}
+
+	return parseMounts(string(output)), nil
+}
+
+func parseMounts(content string) []*MountInfo {
+	var mounts []*MountInfo
+	for _, l := range strings.Split(content, "\n") {
+		p := strings.Fields(l)
+		if len(p) < 3 {
+			continue
+		}
+
+		mounts = append(mounts, &MountInfo{Source: p[0], Target: p[1], FSType: p[2]})
+	}
+
+	return mounts
+}
+
+// Check verifies that a mounted filesystem still responds, running a statfs
+// on the target and a direct read of the first block of the source. A stale
+// mount, usually left by a detach the kernel didn't notice, fails or hangs
+// on these, so both are bounded by HealthCheckTimeout. The command stuck
+// behind nsenter may outlive the killed nsenter and keep the output open,
+// the wait for it is bounded too.
+func (fs *OSFilesystem) Check(source string, target string) error {
+	checks := [][]string{
+		fs.hostArgs("stat", "-f", target),
+		fs.hostArgs("dd", "if="+source, "of=/dev/null", "bs=4096", "count=1", "iflag=direct"),
+	}
+
+	for _, args := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.WaitDelay = time.Second
+		output, err := cmd.CombinedOutput()
+		cancel()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("health check timed out, arguments: %q", args)
+		}
+
+		if err != nil {
+			return fmt.Errorf(
+				"health check failed, arguments: %q\noutput: %s\n",
+				args, string(output),
+			)
+		}
+	}
+
+	return nil
+}
+
+var ioErrorMessages = []string{"input/output error", "i/o error"}
+
+// IsIOError reports whether the error was caused by an I/O error on the
+// device, as reported by the kernel in the output of the failed command.
+func IsIOError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range ioErrorMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
 
+func (fs *OSFilesystem) hostArgs(args ...string) []string {
 	if fs.inContainer {
 		return append(nsenterArgs, args...)
 	}
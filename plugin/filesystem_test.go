@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type FilesystemSuite struct{}
+
+var _ = Suite(&FilesystemSuite{})
+
+func (s *FilesystemSuite) TestValidateMountOptions(c *C) {
+	fixtures := []struct {
+		fstype  string
+		options []string
+		err     string
+	}{
+		{"ext4", []string{"discard", "defaults"}, ""},
+		{"ext4", []string{"noatime", "data=writeback"}, ""},
+		{"xfs", []string{"pquota", "inode64", "logbsize=256k"}, ""},
+		{"btrfs", []string{"subvol=data", "compress=zstd"}, ""},
+		{"ext4", []string{"subvol=data"}, `invalid mount option "subvol=data", subvol is only valid for btrfs`},
+		{"ext4", []string{"pquota"}, `invalid mount option "pquota", pquota is only valid for xfs`},
+		{"xfs", []string{"data=journal"}, `invalid mount option "data=journal", data is only valid for ext4`},
+		{"xfs", []string{"nobarrier"}, `invalid mount option "nobarrier", nobarrier is only valid for btrfs, ext4`},
+		{"ext4", []string{"foo"}, `invalid mount option "foo", unknown option`},
+	}
+
+	for _, f := range fixtures {
+		err := ValidateMountOptions(f.fstype, f.options)
+		if f.err == "" {
+			c.Assert(err, IsNil, Commentf("%s %v", f.fstype, f.options))
+			continue
+		}
+
+		c.Assert(err, ErrorMatches, f.err, Commentf("%s %v", f.fstype, f.options))
+	}
+}
+
+func (s *FilesystemSuite) TestParseMounts(c *C) {
+	mounts := parseMounts("" +
+		"/dev/sda1 / ext4 rw,relatime 0 0\n" +
+		"/dev/sdb /mnt/foo ext4 rw,relatime,discard 0 0\n" +
+		"\n",
+	)
+
+	c.Assert(mounts, HasLen, 2)
+	c.Assert(mounts[1].Source, Equals, "/dev/sdb")
+	c.Assert(mounts[1].Target, Equals, "/mnt/foo")
+	c.Assert(mounts[1].FSType, Equals, "ext4")
+}
+
+func (s *FilesystemSuite) TestGetWipeArgs(c *C) {
+	fs := &OSFilesystem{}
+
+	args, err := fs.getWipeArgs("/dev/sdb", "discard")
+	c.Assert(err, IsNil)
+	c.Assert(args, DeepEquals, []string{"blkdiscard", "/dev/sdb"})
+
+	args, err = fs.getWipeArgs("/dev/sdb", "zero")
+	c.Assert(err, IsNil)
+	c.Assert(args, DeepEquals, []string{"shred", "-n", "0", "-z", "/dev/sdb"})
+
+	_, err = fs.getWipeArgs("/dev/sdb", "foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *FilesystemSuite) TestGetProbeArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getBlkidArgs("/dev/sdb"), DeepEquals, []string{"blkid", "-p", "-o", "value", "-s", "TYPE", "/dev/sdb"})
+	c.Assert(fs.getSignaturesArgs("/dev/sdb"), DeepEquals, []string{"wipefs", "--no-act", "--noheadings", "--output", "TYPE", "/dev/sdb"})
+}
+
+func (s *FilesystemSuite) TestIsIOError(c *C) {
+	c.Assert(IsIOError(fmt.Errorf("dd: error reading '/dev/sdb': Input/output error")), Equals, true)
+	c.Assert(IsIOError(fmt.Errorf("Buffer I/O error on dev sdb")), Equals, true)
+	c.Assert(IsIOError(fmt.Errorf("mount point does not exist")), Equals, false)
+	c.Assert(IsIOError(nil), Equals, false)
+}
+
+func (s *FilesystemSuite) TestGetRepairArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getRepairArgs("/dev/sdb", "ext4"), DeepEquals, []string{"e2fsck", "-p", "/dev/sdb"})
+	c.Assert(fs.getRepairArgs("/dev/sdb", "xfs"), DeepEquals, []string{"xfs_repair", "/dev/sdb"})
+}
+
+func (s *FilesystemSuite) TestParseDeviceNumbers(c *C) {
+	device, err := parseDeviceNumbers("8:10")
+	c.Assert(err, IsNil)
+	c.Assert(device, Equals, "8:16")
+
+	device, err = parseDeviceNumbers("103:0")
+	c.Assert(err, IsNil)
+	c.Assert(device, Equals, "259:0")
+
+	_, err = parseDeviceNumbers("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *FilesystemSuite) TestGetGrowArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getGrowArgs("/dev/sdb", "/mnt/foo", "ext4"), DeepEquals, []string{"resize2fs", "/dev/sdb"})
+	c.Assert(fs.getGrowArgs("/dev/sdb", "/mnt/foo", "xfs"), DeepEquals, []string{"xfs_growfs", "/mnt/foo"})
+}
+
+func (s *FilesystemSuite) TestGetMkfsArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getMkfsArgs("/dev/sdb", "ext4", false, nil), DeepEquals, []string{"mkfs.ext4", "/dev/sdb"})
+	c.Assert(fs.getMkfsArgs("/dev/sdb", "xfs", true, nil), DeepEquals, []string{"mkfs.xfs", "-f", "/dev/sdb"})
+	c.Assert(fs.getMkfsArgs("/dev/sdb", "btrfs", true, nil), DeepEquals, []string{"mkfs.btrfs", "-f", "/dev/sdb"})
+	c.Assert(fs.getMkfsArgs("/dev/sdb", "ext4", false, []string{"-m", "0"}), DeepEquals, []string{"mkfs.ext4", "-m", "0", "/dev/sdb"})
+}
+
+func (s *FilesystemSuite) TestGetFlushArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getFlushArgs("/dev/sdb"), DeepEquals, [][]string{
+		{"sync"},
+		{"blockdev", "--flushbufs", "/dev/sdb"},
+	})
+}
+
+func (s *FilesystemSuite) TestParseTrimmed(c *C) {
+	c.Assert(parseTrimmed("/mnt/foo: 1 GiB (1073741824 bytes) trimmed\n"), Equals, int64(1073741824))
+	c.Assert(parseTrimmed("/mnt/foo: 4096 bytes were trimmed\n"), Equals, int64(4096))
+	c.Assert(parseTrimmed(""), Equals, int64(0))
+}
+
+func (s *FilesystemSuite) TestDeviceTimeoutPath(c *C) {
+	c.Assert(deviceTimeoutPath("sdb"), Equals, "/sys/block/sdb/device/timeout")
+	c.Assert(deviceTimeoutPath("nvme0n2"), Equals, NVMeIOTimeout)
+}
+
+func (s *FilesystemSuite) TestGetChownRecursiveArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getChownRecursiveArgs("/mnt/foo", 999, 0), DeepEquals, []string{
+		"chown", "-R", "-P", "-h", "999:0", "/mnt/foo",
+	})
+}
+
+func (s *FilesystemSuite) TestReadAheadPath(c *C) {
+	c.Assert(readAheadPath("sdb"), Equals, "/sys/block/sdb/queue/read_ahead_kb")
+	c.Assert(readAheadPath("nvme0n2"), Equals, "/sys/block/nvme0n2/queue/read_ahead_kb")
+}
+
+func (s *FilesystemSuite) TestParseFilesystemSize(c *C) {
+	size, err := parseFilesystemSize("2621440 4096")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(10<<30))
+
+	_, err = parseFilesystemSize("foo")
+	c.Assert(err, NotNil)
+}
+
+func (s *FilesystemSuite) TestParseNVMeDeviceName(c *C) {
+	id := make([]byte, 4096)
+	copy(id[nvmeVendorOffset:], `{"device_name":"docker-volume-foo","disk_type":"PERSISTENT"}`)
+	c.Assert(parseNVMeDeviceName(id), Equals, "docker-volume-foo")
+
+	c.Assert(parseNVMeDeviceName(make([]byte, 4096)), Equals, "")
+	c.Assert(parseNVMeDeviceName(nil), Equals, "")
+
+	fs := &OSFilesystem{}
+	c.Assert(fs.getNVMeIDArgs("/dev/nvme0n2"), DeepEquals, []string{"nvme", "id-ns", "-b", "/dev/nvme0n2"})
+}
+
+func (s *FilesystemSuite) TestGetSettleArgs(c *C) {
+	fs := &OSFilesystem{}
+	c.Assert(fs.getSettleArgs(30*time.Second), DeepEquals, []string{"udevadm", "settle", "--timeout=30"})
+	c.Assert(fs.getSettleArgs(1500*time.Millisecond), DeepEquals, []string{"udevadm", "settle", "--timeout=2"})
+	c.Assert(fs.getSettleArgs(-time.Second), DeepEquals, []string{"udevadm", "settle", "--timeout=1"})
+}
+
+func (s *FilesystemSuite) TestParseFuserPids(c *C) {
+	c.Assert(parseFuserPids(" 4242c 4243  17m"), DeepEquals, []string{"4242", "4243", "17"})
+	c.Assert(parseFuserPids(""), HasLen, 0)
+}
+
+func (s *FilesystemSuite) TestIsBusyError(c *C) {
+	c.Assert(IsBusyError(fmt.Errorf("umount: /mnt/foo: target is busy.")), Equals, true)
+	c.Assert(IsBusyError(fmt.Errorf("umount: /mnt/foo: Device or resource busy")), Equals, true)
+	c.Assert(IsBusyError(fmt.Errorf("umount: /mnt/foo: not mounted.")), Equals, false)
+	c.Assert(IsBusyError(nil), Equals, false)
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"fmt"
+)
+
+// checkManagedLimit refuses to create or mount a disk not managed yet once
+// the plugin manages MaxManagedDisks, the disks it created or mounted and not
+// removed, so a misbehaving workload can't provision volumes without limit.
+// It's a soft limit, concurrent requests may exceed it.
+func (v *Volume) checkManagedLimit(name string) error {
+	if v.MaxManagedDisks <= 0 {
+		return nil
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.managed[name] || len(v.managed) < v.MaxManagedDisks {
+		return nil
+	}
+
+	return withCode(ErrorCodeLimitExceeded, fmt.Errorf(
+		"refusing to manage disk %q, the plugin reached its limit of %d managed disks",
+		name, v.MaxManagedDisks,
+	))
+}
+
+func (v *Volume) setManaged(name string, managed bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if managed {
+		v.managed[name] = true
+	} else {
+		delete(v.managed, name)
+	}
+
+	managedDisks.Set(float64(len(v.managed)))
+	managedDisksLimit.Set(float64(v.MaxManagedDisks))
+}
+
+func (v *Volume) isManaged(name string) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.managed[name]
+}
@@ -0,0 +1,189 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	MetricsNamespace         = "gce_docker"
+	DefaultMetricsDiskLabels = []string{"cost-center", "team", "env"}
+)
+
+var recoveredPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: MetricsNamespace,
+	Name:      "recovered_panics_total",
+	Help:      "Number of panics recovered while handling volume requests.",
+}, []string{"method"})
+
+const (
+	FormatOutcomeFormatted = "formatted"
+	FormatOutcomeSkipped   = "skipped"
+	FormatOutcomeForced    = "forced"
+	FormatOutcomeRefused   = "refused"
+)
+
+var formatDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: MetricsNamespace,
+	Name:      "format_decisions_total",
+	Help:      "Number of format decisions taken mounting a disk, by outcome.",
+}, []string{"outcome"})
+
+const (
+	IOErrorTransient  = "transient"
+	IOErrorPersistent = "persistent"
+)
+
+var ioErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: MetricsNamespace,
+	Name:      "io_errors_total",
+	Help:      "Number of I/O errors detected on the disks, by disk, stage and kind.",
+}, []string{"disk", "stage", "kind"})
+
+var managedDisks = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: MetricsNamespace,
+	Name:      "managed_disks",
+	Help:      "Number of disks created or mounted by the plugin and not removed.",
+})
+
+var managedDisksLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: MetricsNamespace,
+	Name:      "managed_disks_limit",
+	Help:      "Max. number of disks managed by the plugin, 0 if unlimited.",
+})
+
+var provisionedPerformance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: MetricsNamespace,
+	Name:      "disk_provisioned_performance",
+	Help:      "IOPS and throughput in MB/s provisioned on the mounted hyperdisks, by disk and kind.",
+}, []string{"disk", "kind"})
+
+var effectivePerformance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: MetricsNamespace,
+	Name:      "disk_effective_performance",
+	Help:      "Estimated IOPS and throughput in MB/s the instance gets from the mounted hyperdisks, by disk and kind.",
+}, []string{"disk", "kind"})
+
+const (
+	TrimOutcomeTrimmed = "trimmed"
+	TrimOutcomeFailed  = "failed"
+)
+
+var trims = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: MetricsNamespace,
+	Name:      "trims_total",
+	Help:      "Number of scheduled trims of the mounted volumes, by outcome.",
+}, []string{"outcome"})
+
+var trimmedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: MetricsNamespace,
+	Name:      "trimmed_bytes_total",
+	Help:      "Bytes discarded by the scheduled trims, by disk.",
+}, []string{"disk"})
+
+func init() {
+	prometheus.MustRegister(
+		recoveredPanics, formatDecisions, ioErrors, managedDisks, managedDisksLimit,
+		provisionedPerformance, effectivePerformance, trims, trimmedBytes,
+	)
+}
+
+var invalidMetricLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// DiskCollector exports a disk_info gauge per disk, labeled with its size,
+// type and the subset of GCE labels selected by the operator, and the number
+// of disks that can still be attached. The disks are listed on every scrape,
+// so the labels are always current.
+type DiskCollector struct {
+	Labels []string
+
+	p     providers.DiskProvider
+	desc  *prometheus.Desc
+	slots *prometheus.Desc
+}
+
+func NewDiskCollector(p providers.DiskProvider, labels []string) *DiskCollector {
+	names := []string{"disk", "type", "size_gb"}
+	for _, l := range labels {
+		names = append(names, MetricLabelName(l))
+	}
+
+	return &DiskCollector{
+		Labels: labels,
+		p:      p,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(MetricsNamespace, "", "disk_info"),
+			"Information about the disks, including the selected GCE labels.",
+			names, nil,
+		),
+		slots: prometheus.NewDesc(
+			prometheus.BuildFQName(MetricsNamespace, "", "attach_slots_remaining"),
+			"Number of disks that can still be attached to the instance.",
+			nil, nil,
+		),
+	}
+}
+
+func (v *Volume) DiskCollector(labels []string) *DiskCollector {
+	return NewDiskCollector(v.p, labels)
+}
+
+func (c *DiskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+	ch <- c.slots
+}
+
+func (c *DiskCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectSlots(ch)
+
+	disks, err := c.p.List()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for _, d := range disks {
+		values := []string{d.Name, providers.ResourceName(d.Type), strconv.FormatInt(d.SizeGb, 10)}
+		for _, l := range c.Labels {
+			values = append(values, d.Labels[l])
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, values...)
+	}
+}
+
+func (c *DiskCollector) collectSlots(ch chan<- prometheus.Metric) {
+	remaining, err := c.p.RemainingSlots()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.slots, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.slots, prometheus.GaugeValue, float64(remaining))
+}
+
+// MetricLabelName converts a GCE label key into a valid Prometheus label name.
+func MetricLabelName(key string) string {
+	return "label_" + invalidMetricLabelChars.ReplaceAllString(key, "_")
+}
+
+// CheckMetricsDiskLabels refuses the GCE labels exported with the same
+// metric label name, e.g. cost-center and cost_center, a metric can't have
+// the same label twice.
+func CheckMetricsDiskLabels(labels []string) error {
+	keys := make(map[string]string, len(labels))
+	for _, l := range labels {
+		name := MetricLabelName(l)
+		if other, ok := keys[name]; ok {
+			return fmt.Errorf("invalid metrics disk labels, %q and %q are both exported as %s", other, l, name)
+		}
+
+		keys[name] = l
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+)
+
+type MetricsSuite struct {
+	p *DiskProviderFixture
+}
+
+var _ = Suite(&MetricsSuite{})
+
+func (s *MetricsSuite) SetUpTest(c *C) {
+	s.p = NewDiskProviderFixture()
+}
+
+func (s *MetricsSuite) TestDiskCollector(c *C) {
+	s.p.disks["foo"] = true
+	s.p.labels["foo"] = map[string]string{"cost-center": "42", "team": "infra", "owner": "qux"}
+
+	collector := NewDiskCollector(s.p, []string{"cost-center", "team"})
+	c.Assert(testutil.CollectAndCount(collector), Equals, 3)
+
+	expected := `
+		# HELP gce_docker_attach_slots_remaining Number of disks that can still be attached to the instance.
+		# TYPE gce_docker_attach_slots_remaining gauge
+		gce_docker_attach_slots_remaining 16
+		# HELP gce_docker_disk_info Information about the disks, including the selected GCE labels.
+		# TYPE gce_docker_disk_info gauge
+		gce_docker_disk_info{disk="foo",label_cost_center="42",label_team="infra",size_gb="0",type=""} 1
+		gce_docker_disk_info{disk="no-ready",label_cost_center="",label_team="",size_gb="0",type=""} 1
+	`
+
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	c.Assert(err, IsNil)
+}
+
+func (s *MetricsSuite) TestMetricLabelName(c *C) {
+	c.Assert(MetricLabelName("cost-center"), Equals, "label_cost_center")
+	c.Assert(MetricLabelName("env"), Equals, "label_env")
+}
+
+func (s *MetricsSuite) TestCheckMetricsDiskLabels(c *C) {
+	c.Assert(CheckMetricsDiskLabels(DefaultMetricsDiskLabels), IsNil)
+	c.Assert(CheckMetricsDiskLabels([]string{"cost-center", "team", "cost_center"}), ErrorMatches,
+		`invalid metrics disk labels, "cost-center" and "cost_center" are both exported as label_cost_center`,
+	)
+	c.Assert(CheckMetricsDiskLabels([]string{"env", "env"}), NotNil)
+}
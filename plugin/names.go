@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bloomapi/gce-docker/providers"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// LabelVolumeName is the label holding the Docker name of the volumes whose
+// disk name differs from it, the names longer than a label value continue
+// in volume-name-1, volume-name-2...
+var LabelVolumeName = "volume-name"
+
+// MaxDiskNameLength is the max. length of the GCE disk names.
+const MaxDiskNameLength = 63
+
+// diskNameHashLength is the length of the hash appended to the sanitized
+// names, with its separator.
+const diskNameHashLength = 9
+
+var diskNameFormat = regexp.MustCompile("^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$")
+
+var invalidDiskNameChars = regexp.MustCompile("[^-a-z0-9]+")
+
+// DiskName maps a Docker volume name to a valid GCE disk name, made of
+// lowercase letters, numbers and -, starting with a letter and up to 63
+// characters. The valid names are kept, the rest are sanitized, truncated
+// and get a hash of the volume name appended, so two volumes can't map to
+// the same disk, e.g. myapp_data_1 and myapp-data-1.
+func DiskName(name string) string {
+	if diskNameFormat.MatchString(name) {
+		return name
+	}
+
+	base := strings.Trim(invalidDiskNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if base == "" || base[0] < 'a' || base[0] > 'z' {
+		base = "v-" + base
+	}
+
+	if max := MaxDiskNameLength - diskNameHashLength; len(base) > max {
+		base = base[:max]
+	}
+
+	base = strings.TrimRight(base, "-")
+
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("%s-%x", base, sum[:4])
+}
+
+// setVolumeName records the Docker name of a disk named differently, an
+// empty name forgets it.
+func (v *Volume) setVolumeName(disk, name string) {
+	v.Lock()
+	defer v.Unlock()
+
+	if name == "" || name == disk {
+		delete(v.names, disk)
+		return
+	}
+
+	v.names[disk] = name
+}
+
+// volumeName returns the Docker name of the disk, from its volume-name labels
+// or, for the names that can't be a label value, the one recorded on create.
+func (v *Volume) volumeName(d *compute.Disk) string {
+	if name := d.Labels[LabelVolumeName]; name != "" {
+		for i := 1; d.Labels[volumeNameLabel(i)] != ""; i++ {
+			name += d.Labels[volumeNameLabel(i)]
+		}
+
+		return name
+	}
+
+	return v.diskVolumeName(d.Name)
+}
+
+// diskVolumeName returns the Docker name recorded on create for the disk, or
+// the disk name, so the requests made by the plugin itself for a disk find
+// the options of its volume.
+func (v *Volume) diskVolumeName(disk string) string {
+	v.Lock()
+	defer v.Unlock()
+
+	if name, ok := v.names[disk]; ok {
+		return name
+	}
+
+	return disk
+}
+
+// labelVolumeName labels the disk with the Docker name of the volume, when
+// the disk is named differently and the name only has valid label characters.
+func labelVolumeName(c *providers.DiskConfig, name string) {
+	if c.Name == name {
+		return
+	}
+
+	var chunks []string
+	for rest := name; rest != ""; {
+		n := len(rest)
+		if n > providers.MaxLabelLength {
+			n = providers.MaxLabelLength
+		}
+
+		chunks, rest = append(chunks, rest[:n]), rest[n:]
+	}
+
+	for _, chunk := range chunks {
+		if providers.LabelValue(chunk) != chunk {
+			return
+		}
+	}
+
+	for i, chunk := range chunks {
+		c.Labels[volumeNameLabel(i)] = chunk
+	}
+}
+
+func volumeNameLabel(i int) string {
+	if i == 0 {
+		return LabelVolumeName
+	}
+
+	return fmt.Sprintf("%s-%d", LabelVolumeName, i)
+}
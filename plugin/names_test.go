@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+type NamesSuite struct {
+	p *DiskProviderFixture
+	v *Volume
+}
+
+var _ = Suite(&NamesSuite{})
+
+func (s *NamesSuite) SetUpTest(c *C) {
+	s.p = NewDiskProviderFixture()
+	s.v = newVolume(s.p, NewMemFilesystem())
+}
+
+func (s *NamesSuite) TestDiskName(c *C) {
+	c.Assert(DiskName("foo"), Equals, "foo")
+	c.Assert(DiskName("my-app-1"), Equals, "my-app-1")
+	c.Assert(DiskName("myapp_data_1"), Matches, "myapp-data-1-[0-9a-f]{8}")
+	c.Assert(DiskName("MyApp.Data"), Matches, "myapp-data-[0-9a-f]{8}")
+	c.Assert(DiskName("1data"), Matches, "v-1data-[0-9a-f]{8}")
+	c.Assert(DiskName("___"), Matches, "v-[0-9a-f]{8}")
+	c.Assert(DiskName("myapp_data_1"), Equals, DiskName("myapp_data_1"))
+	c.Assert(DiskName("myapp_data_1"), Not(Equals), DiskName("myapp-data_1"))
+
+	for _, name := range []string{"myapp_data_1", "MyApp.Data", "1data", "___"} {
+		c.Assert(diskNameFormat.MatchString(DiskName(name)), Equals, true, Commentf(name))
+	}
+}
+
+func (s *NamesSuite) TestCreate(c *C) {
+	disk := DiskName("myapp_data_1")
+
+	r := s.v.Create(volume.Request{Name: "myapp_data_1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks[disk], Equals, true)
+	c.Assert(s.p.labels[disk][LabelVolumeName], Equals, "myapp_data_1")
+
+	r = s.v.Get(volume.Request{Name: "myapp_data_1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Volume.Name, Equals, "myapp_data_1")
+	c.Assert(r.Volume.Mountpoint, Equals, "/mnt/"+disk)
+
+	r = s.v.List(volume.Request{})
+	c.Assert(r.Volumes, HasLen, 1)
+	c.Assert(r.Volumes[0].Name, Equals, "myapp_data_1")
+
+	r = s.v.Remove(volume.Request{Name: "myapp_data_1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks, HasLen, 0)
+}
+
+func (s *NamesSuite) TestDiskNameLong(c *C) {
+	long := strings.Repeat("myapp_", 20) + "data"
+	c.Assert(DiskName(long), Matches, "(myapp-){8}myapp-[0-9a-f]{8}")
+	c.Assert(diskNameFormat.MatchString(DiskName(long)), Equals, true)
+	c.Assert(DiskName(long), Not(Equals), DiskName(long+"2"))
+
+	valid := strings.Repeat("a", 70)
+	c.Assert(DiskName(valid), Matches, strings.Repeat("a", 54)+"-[0-9a-f]{8}")
+}
+
+func (s *NamesSuite) TestCreateLong(c *C) {
+	long := strings.Repeat("myapp_", 20) + "data"
+	disk := DiskName(long)
+
+	r := s.v.Create(volume.Request{Name: long})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels[disk][LabelVolumeName], Equals, long[:63])
+	c.Assert(s.p.labels[disk]["volume-name-1"], Equals, long[63:])
+
+	s.v = newVolume(s.p, NewMemFilesystem())
+	r = s.v.List(volume.Request{})
+	c.Assert(r.Volumes, HasLen, 1)
+	c.Assert(r.Volumes[0].Name, Equals, long)
+
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Label.volume-name-1": "foo"}})
+	c.Assert(r.Err, Equals, `invalid label "volume-name-1", it's managed by the plugin`)
+}
+
+func (s *NamesSuite) TestCreateUppercase(c *C) {
+	disk := DiskName("MyApp_Data")
+
+	r := s.v.Create(volume.Request{Name: "MyApp_Data"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels[disk][LabelVolumeName], Equals, "")
+
+	r = s.v.List(volume.Request{})
+	c.Assert(r.Volumes[0].Name, Equals, "MyApp_Data")
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OptionNames are the options of the volumes, matched ignoring the case.
+var OptionNames = []string{
+	"Name", "Project", "Type", "SizeGb", "Size", "Regional",
+	"ReplicaZones", "SourceSnapshot", "SourceSnapshotLabels",
+	"SourceImage", "SourceDisk", "Labels", "Description", "DeviceName",
+	"Licenses", "ResourcePolicies", "SnapshotSchedule", "KmsKeyName",
+	"KmsKey", "CsekKey", "Wipe", "SizePolicy", "FSType", "JournalMode",
+	"FormatPolicy", "ForceFormat", "ReadIopsLimit", "WriteIopsLimit",
+	"ReadBpsLimit", "WriteBpsLimit", "DeviceTimeout", "ReadAheadKb", "ProvisionedIops",
+	"ProvisionedThroughput", "StoragePool", "Consumer", "ForceOwnership",
+	"WaitFor", "SnapshotOnRemove", "ReclaimPolicy", "Exists", "NoCreate",
+	"AllowTypeChange", "Mode", "Uid", "Gid", "MultiWriter", "DryRun",
+	"MountOptions", "MkfsOptions", "Fsck", "ChownOnCreate", "Subpath", "SecurityContext", ProfileOption,
+}
+
+// SecretOptions are the options never saved in the state, the plugin keeps
+// them in memory only.
+var SecretOptions = []string{"CsekKey"}
+
+// OptionAliases are alternative names of the options, in lowercase.
+var OptionAliases = map[string]string{
+	"disk-type": "Type",
+	"snapshot":  "SourceSnapshot",
+	"image":     "SourceImage",
+}
+
+// canonicalOption returns the name of the option given with any case or by
+// an alias, unknown options are returned unchanged.
+func canonicalOption(key string) string {
+	lower := strings.ToLower(key)
+	if name, ok := OptionAliases[lower]; ok {
+		return name
+	}
+
+	for _, name := range OptionNames {
+		if strings.ToLower(name) == lower {
+			return name
+		}
+	}
+
+	prefix := strings.ToLower(LabelOptionPrefix)
+	if strings.HasPrefix(lower, prefix) {
+		return LabelOptionPrefix + key[len(prefix):]
+	}
+
+	return key
+}
+
+// canonicalOptions renames the options to their canonical names, refusing
+// the same option given twice with different names.
+func canonicalOptions(options map[string]string) (map[string]string, error) {
+	given := make(map[string]string, len(options))
+	canonical := make(map[string]string, len(options))
+	for key, value := range options {
+		name := canonicalOption(key)
+		if other, ok := given[name]; ok {
+			return nil, fmt.Errorf("invalid options, %q and %q are the same option", other, key)
+		}
+
+		given[name] = key
+		canonical[name] = value
+	}
+
+	return canonical, nil
+}
+
+// isSecretOption returns whether the option, given with any case or by an
+// alias, is one of SecretOptions.
+func isSecretOption(key string) bool {
+	name := canonicalOption(key)
+	for _, secret := range SecretOptions {
+		if name == secret {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withoutSecrets returns a copy of the options without the SecretOptions.
+func withoutSecrets(options map[string]string) map[string]string {
+	public := make(map[string]string, len(options))
+	for key, value := range options {
+		if !isSecretOption(key) {
+			public[key] = value
+		}
+	}
+
+	return public
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+type OptionsSuite struct{}
+
+var _ = Suite(&OptionsSuite{})
+
+func (s *OptionsSuite) TestCanonicalOption(c *C) {
+	c.Assert(canonicalOption("SizeGb"), Equals, "SizeGb")
+	c.Assert(canonicalOption("sizegb"), Equals, "SizeGb")
+	c.Assert(canonicalOption("type"), Equals, "Type")
+	c.Assert(canonicalOption("disk-type"), Equals, "Type")
+	c.Assert(canonicalOption("Snapshot"), Equals, "SourceSnapshot")
+	c.Assert(canonicalOption("image"), Equals, "SourceImage")
+	c.Assert(canonicalOption("profile"), Equals, ProfileOption)
+	c.Assert(canonicalOption("label.team"), Equals, "Label.team")
+	c.Assert(canonicalOption("Foo"), Equals, "Foo")
+}
+
+func (s *OptionsSuite) TestCanonicalOptions(c *C) {
+	options, err := canonicalOptions(map[string]string{"sizegb": "10", "disk-type": "pd-ssd"})
+	c.Assert(err, IsNil)
+	c.Assert(options, DeepEquals, map[string]string{"SizeGb": "10", "Type": "pd-ssd"})
+
+	_, err = canonicalOptions(map[string]string{"type": "pd-ssd", "disk-type": "pd-standard"})
+	c.Assert(err, ErrorMatches, `invalid options, "(type|disk-type)" and "(type|disk-type)" are the same option`)
+}
+
+func (s *OptionsSuite) TestCreateDiskConfig(c *C) {
+	v := newVolume(NewDiskProviderFixture(), NewMemFilesystem())
+	config, err := v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{
+		"sizegb": "10", "type": "pd-ssd", "image": "debian-12", "FSTYPE": "xfs",
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(config.SizeGb, Equals, int64(10))
+	c.Assert(config.Type, Equals, "pd-ssd")
+	c.Assert(config.SourceImage, Equals, "debian-12")
+	c.Assert(config.FSType, Equals, "xfs")
+
+	_, err = v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{"sizegbs": "10"}})
+	c.Assert(err, ErrorMatches, `unknown option "sizegbs"`)
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// ProfileOption is the option selecting a profile, its options are the
+// defaults of the volume, overridden by the ones given to the volume.
+var ProfileOption = "Profile"
+
+// Profiles are named sets of volume options, e.g. a fast profile with
+// Type=pd-ssd, selected with -o profile=fast.
+type Profiles map[string]map[string]string
+
+// LoadProfiles reads the profiles from a JSON object keyed by profile name,
+// each of them an object with the options as strings.
+func LoadProfiles(r io.Reader) (Profiles, error) {
+	var p Profiles
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, fmt.Errorf("error decoding profiles: %s", err)
+	}
+
+	return p, nil
+}
+
+// CheckProfiles verifies that the options of every profile are valid,
+// refusing the profiles selecting another profile.
+func (v *Volume) CheckProfiles() error {
+	for name, options := range v.Profiles {
+		for key := range options {
+			if canonicalOption(key) == ProfileOption {
+				return fmt.Errorf("invalid profile %q, it can't select another profile", name)
+			}
+		}
+
+		if _, err := v.parseDiskConfig(volume.Request{Name: name, Options: options}); err != nil {
+			return fmt.Errorf("invalid profile %q: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// profileOptions returns the options of the selected profile, if any,
+// merged with the given canonical options, which take precedence.
+func (v *Volume) profileOptions(options map[string]string) (map[string]string, error) {
+	name := options[ProfileOption]
+	delete(options, ProfileOption)
+	if name == "" {
+		return options, nil
+	}
+
+	if _, ok := v.Profiles[name]; !ok {
+		return nil, fmt.Errorf("unknown profile %q, the profiles available are: %s", name, v.profileNames())
+	}
+
+	profile, err := canonicalOptions(v.Profiles[name])
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %q: %s", name, err)
+	}
+
+	merged := make(map[string]string, len(profile)+len(options))
+	for key, value := range profile {
+		merged[key] = value
+	}
+
+	for key, value := range options {
+		merged[key] = value
+	}
+
+	return merged, nil
+}
+
+func (v *Volume) profileNames() string {
+	if len(v.Profiles) == 0 {
+		return "none"
+	}
+
+	var names []string
+	for name := range v.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+type ProfileSuite struct {
+	p *DiskProviderFixture
+	v *Volume
+}
+
+var _ = Suite(&ProfileSuite{})
+
+func (s *ProfileSuite) SetUpTest(c *C) {
+	s.p = NewDiskProviderFixture()
+	s.v = newVolume(s.p, NewMemFilesystem())
+
+	var err error
+	s.v.Profiles, err = LoadProfiles(strings.NewReader(`{
+		"fast": {"Type": "pd-ssd", "SizeGb": "100", "FSType": "xfs"},
+		"bulk": {"Type": "pd-standard", "SizeGb": "1000"}
+	}`))
+	c.Assert(err, IsNil)
+}
+
+func (s *ProfileSuite) TestLoadProfiles(c *C) {
+	_, err := LoadProfiles(strings.NewReader(`{"fast": {"SizeGb": 100}}`))
+	c.Assert(err, ErrorMatches, "error decoding profiles: .*")
+}
+
+func (s *ProfileSuite) TestCheckProfiles(c *C) {
+	c.Assert(s.v.CheckProfiles(), IsNil)
+
+	s.v.Profiles["broken"] = map[string]string{"SizeGb": "foo"}
+	c.Assert(s.v.CheckProfiles(), ErrorMatches, `invalid profile "broken": .*`)
+
+	s.v.Profiles["broken"] = map[string]string{"profile": "fast"}
+	c.Assert(s.v.CheckProfiles(), ErrorMatches, `invalid profile "broken", it can't select another profile`)
+}
+
+func (s *ProfileSuite) TestCreateDiskConfig(c *C) {
+	config, err := s.v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{
+		"profile": "fast", "SizeGb": "200",
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(config.Type, Equals, "pd-ssd")
+	c.Assert(config.SizeGb, Equals, int64(200))
+	c.Assert(config.FSType, Equals, "xfs")
+
+	config, err = s.v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{"Profile": "bulk"}})
+	c.Assert(err, IsNil)
+	c.Assert(config.SizeGb, Equals, int64(1000))
+
+	_, err = s.v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{"profile": "slow"}})
+	c.Assert(err, ErrorMatches, `unknown profile "slow", the profiles available are: bulk, fast`)
+}
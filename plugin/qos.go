@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"github.com/spf13/afero"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// reportPerformance logs and exports the performance provisioned on a
+// hyperdisk and the one the instance gets from it, warning when the instance
+// limits throttle it. It's informative, a failure is only logged.
+func (v *Volume) reportPerformance(c *providers.DiskConfig) {
+	p, err := v.p.EffectivePerformance(c)
+	if err != nil {
+		log15.Warn("error retrieving effective disk performance", "disk", c.Name, "error", err)
+		return
+	}
+
+	if p == nil {
+		return
+	}
+
+	provisionedPerformance.WithLabelValues(c.Name, "iops").Set(float64(p.ProvisionedIops))
+	provisionedPerformance.WithLabelValues(c.Name, "throughput").Set(float64(p.ProvisionedThroughput))
+	effectivePerformance.WithLabelValues(c.Name, "iops").Set(float64(p.Iops))
+	effectivePerformance.WithLabelValues(c.Name, "throughput").Set(float64(p.Throughput))
+
+	ctx := []interface{}{
+		"disk", c.Name, "type", p.Type,
+		"provisioned-iops", p.ProvisionedIops, "provisioned-throughput", p.ProvisionedThroughput,
+		"iops", p.Iops, "throughput", p.Throughput,
+	}
+
+	if p.Capped() {
+		log15.Warn("hyperdisk performance capped by the instance limits", ctx...)
+		return
+	}
+
+	log15.Info("hyperdisk performance", ctx...)
+}
+
+func clearPerformance(c *providers.DiskConfig) {
+	for _, kind := range []string{"iops", "throughput"} {
+		provisionedPerformance.DeleteLabelValues(c.Name, kind)
+		effectivePerformance.DeleteLabelValues(c.Name, kind)
+	}
+}
+
+// BlkioCgroup is the cgroup the I/O limits of the volumes are set on, the
+// parent cgroup of the containers, so the limit is shared by all of them.
+var BlkioCgroup = "/sys/fs/cgroup/blkio/docker"
+
+// cgroup v1 throttle files, by limit.
+var blkioThrottleFiles = map[string]string{
+	"riops": "blkio.throttle.read_iops_device",
+	"wiops": "blkio.throttle.write_iops_device",
+	"rbps":  "blkio.throttle.read_bps_device",
+	"wbps":  "blkio.throttle.write_bps_device",
+}
+
+var ioLimitKeys = []string{"rbps", "wbps", "riops", "wiops"}
+
+func ioLimits(c *providers.DiskConfig) map[string]int64 {
+	limits := map[string]int64{
+		"rbps":  c.ReadBpsLimit,
+		"wbps":  c.WriteBpsLimit,
+		"riops": c.ReadIopsLimit,
+		"wiops": c.WriteIopsLimit,
+	}
+
+	for k, l := range limits {
+		if l == 0 {
+			delete(limits, k)
+		}
+	}
+
+	return limits
+}
+
+// applyIOLimits throttles the I/O of the disk device in BlkioCgroup, using
+// io.max on cgroup v2 or the blkio throttle files on v1.
+func (v *Volume) applyIOLimits(c *providers.DiskConfig) error {
+	limits := ioLimits(c)
+	if len(limits) == 0 {
+		return nil
+	}
+
+	if err := v.writeIOLimits(c, limits); err != nil {
+		return fmt.Errorf("error setting I/O limits of disk %q: %s", c.Name, err)
+	}
+
+	log15.Info("I/O limits set", "disk", c.Name, "cgroup", BlkioCgroup, "limits", limits)
+	return nil
+}
+
+// clearIOLimits removes the limits set by applyIOLimits, before the device
+// goes away with the detach.
+func (v *Volume) clearIOLimits(c *providers.DiskConfig) {
+	limits := ioLimits(c)
+	if len(limits) == 0 {
+		return
+	}
+
+	for k := range limits {
+		limits[k] = 0
+	}
+
+	if err := v.writeIOLimits(c, limits); err != nil {
+		log15.Warn("error clearing I/O limits", "disk", c.Name, "error", err)
+	}
+}
+
+func (v *Volume) writeIOLimits(c *providers.DiskConfig, limits map[string]int64) error {
+	device, err := v.fs.Device(c.Dev())
+	if err != nil {
+		return err
+	}
+
+	ioMax := filepath.Join(BlkioCgroup, "io.max")
+	if ok, _ := afero.Exists(v.fs, ioMax); ok {
+		var fields []string
+		for _, k := range ioLimitKeys {
+			if l, ok := limits[k]; ok {
+				fields = append(fields, fmt.Sprintf("%s=%s", k, ioMaxValue(l)))
+			}
+		}
+
+		line := fmt.Sprintf("%s %s\n", device, strings.Join(fields, " "))
+		return afero.WriteFile(v.fs, ioMax, []byte(line), 0644)
+	}
+
+	for _, k := range ioLimitKeys {
+		l, ok := limits[k]
+		if !ok {
+			continue
+		}
+
+		file := filepath.Join(BlkioCgroup, blkioThrottleFiles[k])
+		line := fmt.Sprintf("%s %d\n", device, l)
+		if err := afero.WriteFile(v.fs, file, []byte(line), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ioMaxValue formats a limit for io.max, where zero means no limit.
+func ioMaxValue(l int64) string {
+	if l == 0 {
+		return "max"
+	}
+
+	return fmt.Sprintf("%d", l)
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// acquireMount adds a reference by the caller id, the container, to a disk
+// already mounted and healthy, returning false if it must be mounted. The
+// requests without id, from the plugin itself or Docker before 1.12, aren't
+// counted and always mount the disk.
+func (v *Volume) acquireMount(name, id string) (int, bool) {
+	if id == "" {
+		return 0, false
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	s, ok := v.mounts[name]
+	if !ok || !s.Healthy || s.Detached {
+		return 0, false
+	}
+
+	v.addMountRef(name, id)
+	return len(v.refs[name]), true
+}
+
+// setMountRef adds a reference by the caller id to a just mounted disk.
+func (v *Volume) setMountRef(name, id string) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.addMountRef(name, id)
+}
+
+func (v *Volume) addMountRef(name, id string) {
+	if id == "" {
+		return
+	}
+
+	if v.refs[name] == nil {
+		v.refs[name] = make(map[string]bool, 0)
+	}
+
+	v.refs[name][id] = true
+}
+
+// releaseMount removes the reference by the caller id, returning the ones
+// left, the disk is only unmounted once none is. A request without id
+// releases all of them.
+func (v *Volume) releaseMount(name, id string) int {
+	v.Lock()
+	defer v.Unlock()
+
+	if id == "" {
+		delete(v.refs, name)
+		return 0
+	}
+
+	delete(v.refs[name], id)
+	if len(v.refs[name]) == 0 {
+		delete(v.refs, name)
+	}
+
+	return len(v.refs[name])
+}
+
+// hasMountRef reports whether the caller id holds a reference to the disk.
+func (v *Volume) hasMountRef(name, id string) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.refs[name][id]
+}
+
+// mountRefs returns the number of references to the disk.
+func (v *Volume) mountRefs(name string) int {
+	v.Lock()
+	defer v.Unlock()
+
+	return len(v.refs[name])
+}
+
+// dropMountRefs forgets the references, restored from the state, to the
+// disks not mounted anymore, e.g. unmounted while the plugin was stopped.
+func (v *Volume) dropMountRefs(mounted map[string]bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	for name := range v.refs {
+		if !mounted[name] {
+			log15.Warn("disk not mounted anymore, dropping its references", "disk", name, "references", len(v.refs[name]))
+			delete(v.refs, name)
+		}
+	}
+}
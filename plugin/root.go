@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// UnsuitableRootFilesystems are the filesystems the mount root can't be on:
+// the mounts under an overlay, usually the root of the plugin container,
+// aren't seen by Docker, and the network filesystems are shared by hosts.
+var UnsuitableRootFilesystems = []string{"overlay", "aufs", "nfs", "nfs4", "cifs", "smb3", "9p"}
+
+// CheckRoot verifies that Root, where the disks are mounted, is an absolute
+// path on a filesystem suitable for mounts, creating it if missing.
+func (v *Volume) CheckRoot() error {
+	if !filepath.IsAbs(v.Root) {
+		return fmt.Errorf("invalid root %q, it must be an absolute path", v.Root)
+	}
+
+	if err := v.fs.MkdirAll(v.Root, 0755); err != nil {
+		return fmt.Errorf("error creating root %q: %s", v.Root, err)
+	}
+
+	mounts, err := v.fs.Mounts()
+	if err != nil {
+		return err
+	}
+
+	fstype := rootFilesystem(filepath.Clean(v.Root), mounts)
+	if containsString(UnsuitableRootFilesystems, fstype) || strings.HasPrefix(fstype, "fuse") {
+		return fmt.Errorf("invalid root %q, it's on a %s filesystem, where the mounts can't be shared with Docker", v.Root, fstype)
+	}
+
+	return nil
+}
+
+// rootFilesystem returns the type of the filesystem the path is on, the one
+// of the closest mount containing it.
+func rootFilesystem(path string, mounts []*MountInfo) string {
+	var fstype, target string
+	for _, m := range mounts {
+		rel, err := filepath.Rel(m.Target, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+
+		if len(m.Target) >= len(target) {
+			fstype, target = m.FSType, m.Target
+		}
+	}
+
+	return fstype
+}
@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestCheckRoot(c *C) {
+	s.v.Root = "/var/lib/gce-docker"
+	c.Assert(s.v.CheckRoot(), IsNil)
+
+	ok, err := afero.DirExists(s.fs, s.v.Root)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	s.v.Root = "mnt"
+	c.Assert(s.v.CheckRoot(), ErrorMatches, `invalid root "mnt", it must be an absolute path`)
+
+	s.v.Root = "/shared/gce"
+	s.fs.Mounted["/shared"] = "nfs.example.com:/export"
+	s.fs.FSTypes["/shared"] = "nfs4"
+	c.Assert(s.v.CheckRoot(), ErrorMatches, `invalid root "/shared/gce", it's on a nfs4 filesystem, .*`)
+}
+
+func (s *VolumeSuite) TestRootFilesystem(c *C) {
+	mounts := []*MountInfo{
+		{Target: "/", FSType: "overlay"},
+		{Target: "/mnt", FSType: "ext4"},
+		{Target: "/mnt/foo", FSType: "xfs"},
+		{Target: "/mntx", FSType: "tmpfs"},
+	}
+
+	c.Assert(rootFilesystem("/mnt", mounts), Equals, "ext4")
+	c.Assert(rootFilesystem("/mnt/bar", mounts), Equals, "ext4")
+	c.Assert(rootFilesystem("/var/lib", mounts), Equals, "overlay")
+	c.Assert(rootFilesystem("/mntx/foo", mounts), Equals, "tmpfs")
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/bloomapi/gce-docker/providers"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// SnapshotHooks make the snapshots of a mounted disk application consistent,
+// PreSnapshot and PostSnapshot are shell commands run on the host before and
+// after the snapshot, e.g. to flush and pause a database, and with Freeze the
+// filesystem is frozen while the snapshot is taken.
+type SnapshotHooks struct {
+	Root         string
+	PreSnapshot  string
+	PostSnapshot string
+	Freeze       bool
+
+	fs Filesystem
+}
+
+func NewSnapshotHooks(fs Filesystem) *SnapshotHooks {
+	return &SnapshotHooks{Root: DefaultRoot, fs: fs}
+}
+
+// Run calls snapshot between the hooks. Once the pre-snapshot hook ran, the
+// filesystem is always unfrozen and the post-snapshot hook always runs, even
+// if the snapshot fails. snapshot calls taken as soon as the content of the
+// disk is captured, which unfreezes the filesystem without waiting for the
+// upload. The hooks get the disk and its mountpoint in the GCE_DOCKER_DISK
+// and GCE_DOCKER_MOUNTPOINT variables.
+func (h *SnapshotHooks) Run(c *providers.DiskConfig, snapshot func(taken func()) error) (err error) {
+	target := c.MountPoint(h.Root)
+	env := []string{"GCE_DOCKER_DISK=" + c.Name, "GCE_DOCKER_MOUNTPOINT=" + target}
+
+	if h.PostSnapshot != "" {
+		defer func() {
+			if herr := h.fs.Hook(h.PostSnapshot, env); herr != nil && err == nil {
+				err = fmt.Errorf("error running post-snapshot hook of disk %q: %s", c.Name, herr)
+			}
+		}()
+	}
+
+	if h.PreSnapshot != "" {
+		if err := h.fs.Hook(h.PreSnapshot, env); err != nil {
+			return fmt.Errorf("error running pre-snapshot hook of disk %q: %s", c.Name, err)
+		}
+	}
+
+	var frozen bool
+	if h.Freeze {
+		var ferr error
+		if frozen, ferr = h.freeze(c, target); ferr != nil {
+			return ferr
+		}
+	}
+
+	var unfreezeErr error
+	taken := func() {
+		if !frozen {
+			return
+		}
+
+		frozen = false
+		if ferr := h.fs.Freeze(target, false); ferr != nil {
+			log15.Error("error unfreezing filesystem", "disk", c.Name, "target", target, "error", ferr)
+			unfreezeErr = fmt.Errorf("error unfreezing filesystem of disk %q: %s", c.Name, ferr)
+			return
+		}
+
+		log15.Info("filesystem unfrozen", "disk", c.Name, "target", target)
+	}
+
+	defer func() {
+		taken()
+		if err == nil {
+			err = unfreezeErr
+		}
+	}()
+
+	return snapshot(taken)
+}
+
+// freeze freezes the filesystem of the disk, if it's mounted.
+func (h *SnapshotHooks) freeze(c *providers.DiskConfig, target string) (bool, error) {
+	mounts, err := h.fs.Mounts()
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range mounts {
+		if m.Target != target {
+			continue
+		}
+
+		if err := h.fs.Freeze(target, true); err != nil {
+			return false, fmt.Errorf("error freezing filesystem of disk %q: %s", c.Name, err)
+		}
+
+		log15.Info("filesystem frozen", "disk", c.Name, "target", target)
+		return true, nil
+	}
+
+	log15.Warn("disk not mounted, snapshot taken without freezing", "disk", c.Name, "target", target)
+	return false, nil
+}
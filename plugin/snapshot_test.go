@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/bloomapi/gce-docker/providers"
+	. "gopkg.in/check.v1"
+)
+
+type SnapshotSuite struct {
+	fs *MemFilesystem
+	h  *SnapshotHooks
+	c  *providers.DiskConfig
+}
+
+var _ = Suite(&SnapshotSuite{})
+
+func (s *SnapshotSuite) SetUpTest(c *C) {
+	s.fs = NewMemFilesystem()
+	s.fs.Mounted["/mnt/foo"] = "/dev/disk/by-id/google-docker-volume-foo"
+	s.h = NewSnapshotHooks(s.fs)
+	s.h.PreSnapshot, s.h.PostSnapshot, s.h.Freeze = "pre", "post", true
+	s.c = &providers.DiskConfig{Name: "foo"}
+}
+
+func (s *SnapshotSuite) snapshot(err error) func(func()) error {
+	return func(taken func()) error {
+		s.fs.Events = append(s.fs.Events, "snapshot")
+		return err
+	}
+}
+
+func (s *SnapshotSuite) TestRun(c *C) {
+	err := s.h.Run(s.c, s.snapshot(nil))
+	c.Assert(err, IsNil)
+	c.Assert(s.fs.Events, DeepEquals, []string{
+		"pre GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo",
+		"freeze /mnt/foo",
+		"snapshot",
+		"unfreeze /mnt/foo",
+		"post GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo",
+	})
+}
+
+func (s *SnapshotSuite) TestRunTaken(c *C) {
+	err := s.h.Run(s.c, func(taken func()) error {
+		s.fs.Events = append(s.fs.Events, "snapshot")
+		taken()
+		s.fs.Events = append(s.fs.Events, "upload")
+		return nil
+	})
+
+	c.Assert(err, IsNil)
+	c.Assert(s.fs.Events[1:], DeepEquals, []string{
+		"freeze /mnt/foo",
+		"snapshot",
+		"unfreeze /mnt/foo",
+		"upload",
+		"post GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo",
+	})
+}
+
+func (s *SnapshotSuite) TestRunSnapshotFailed(c *C) {
+	err := s.h.Run(s.c, s.snapshot(fmt.Errorf("foo")))
+	c.Assert(err, ErrorMatches, "foo")
+	c.Assert(s.fs.Events[2:], DeepEquals, []string{
+		"snapshot",
+		"unfreeze /mnt/foo",
+		"post GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo",
+	})
+}
+
+func (s *SnapshotSuite) TestRunPreSnapshotFailed(c *C) {
+	s.fs.Failures["pre"] = []error{fmt.Errorf("foo")}
+
+	err := s.h.Run(s.c, s.snapshot(nil))
+	c.Assert(err, ErrorMatches, `error running pre-snapshot hook of disk "foo": foo`)
+	c.Assert(s.fs.Events, DeepEquals, []string{
+		"pre GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo",
+		"post GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo",
+	})
+}
+
+func (s *SnapshotSuite) TestRunUnfreezeFailed(c *C) {
+	s.fs.Failures["/mnt/foo"] = []error{nil, fmt.Errorf("foo")}
+
+	err := s.h.Run(s.c, s.snapshot(nil))
+	c.Assert(err, ErrorMatches, `error unfreezing filesystem of disk "foo": foo`)
+	c.Assert(s.fs.Events[len(s.fs.Events)-1], Equals, "post GCE_DOCKER_DISK=foo GCE_DOCKER_MOUNTPOINT=/mnt/foo")
+}
+
+func (s *SnapshotSuite) TestRunNotMounted(c *C) {
+	delete(s.fs.Mounted, "/mnt/foo")
+
+	err := s.h.Run(s.c, s.snapshot(nil))
+	c.Assert(err, IsNil)
+	c.Assert(s.fs.Events, HasLen, 3)
+	c.Assert(s.fs.Events[1], Equals, "snapshot")
+}
@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/spf13/afero"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// StateFilename is the file, under Root, the state of the plugin is saved
+// to, so a restarted plugin keeps track of the volumes it created and
+// mounted.
+var StateFilename = ".gce-docker-state.json"
+
+// State is the state of the plugin saved across restarts: the options and
+// names of the created volumes, the managed and chowned disks, the volumes
+// created with DryRun, the mounts with their references and the operations
+// in progress. The SecretOptions aren't saved.
+type State struct {
+	Options    map[string]map[string]string `json:"options,omitempty"`
+	Names      map[string]string            `json:"names,omitempty"`
+	Managed    []string                     `json:"managed,omitempty"`
+	Chowned    []string                     `json:"chowned,omitempty"`
+	DryRuns    []string                     `json:"dry_runs,omitempty"`
+	Mounts     []*MountStatus               `json:"mounts,omitempty"`
+	References map[string][]string          `json:"references,omitempty"`
+	Operations map[string]time.Time         `json:"operations,omitempty"`
+}
+
+func (v *Volume) statePath() string {
+	return filepath.Join(v.Root, StateFilename)
+}
+
+// LoadState restores the state saved by a previous run of the plugin, if
+// any. The operations it was running are logged as interrupted, the mounts
+// are rediscovered, and restored if lost, by Reconcile.
+func (v *Volume) LoadState() error {
+	content, err := afero.ReadFile(v.fs, v.statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading state: %s", err)
+	}
+
+	var s State
+	if err := json.Unmarshal(content, &s); err != nil {
+		return fmt.Errorf("error decoding state %q: %s", v.statePath(), err)
+	}
+
+	for key, started := range s.Operations {
+		log15.Warn("operation interrupted by a restart", "operation", key, "started", started)
+	}
+
+	v.Lock()
+	for name, options := range s.Options {
+		v.options[name] = options
+	}
+
+	for disk, name := range s.Names {
+		v.names[disk] = name
+	}
+
+	for name, ids := range s.References {
+		for _, id := range ids {
+			v.addMountRef(name, id)
+		}
+	}
+
+	for _, name := range s.Chowned {
+		v.chowned[name] = true
+	}
+
+	for _, name := range s.DryRuns {
+		v.dryRuns[name] = true
+	}
+
+	v.saved = s.Mounts
+	v.Unlock()
+
+	for _, name := range s.Managed {
+		v.setManaged(name, true)
+	}
+
+	log15.Info("state loaded", "file", v.statePath(), "volumes", len(s.Options), "managed", len(s.Managed))
+	return nil
+}
+
+// saveState writes the state, replacing the previous one at once so a crash
+// can't leave it half written. A failure is only logged, the state is lost
+// on restart but the requests keep working.
+func (v *Volume) saveState() {
+	v.stateLock.Lock()
+	defer v.stateLock.Unlock()
+
+	content, err := v.encodeState()
+	if err != nil {
+		log15.Error("error encoding state", "error", err)
+		return
+	}
+
+	path := v.statePath()
+	if err := v.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log15.Error("error saving state", "file", path, "error", err)
+		return
+	}
+
+	if err := afero.WriteFile(v.fs, path+".tmp", content, 0600); err != nil {
+		log15.Error("error saving state", "file", path, "error", err)
+		return
+	}
+
+	if err := v.fs.Rename(path+".tmp", path); err != nil {
+		log15.Error("error saving state", "file", path, "error", err)
+	}
+}
+
+// encodeState encodes the state holding the lock, the maps and mounts it
+// refers to change while the requests run.
+func (v *Volume) encodeState() ([]byte, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	s := &State{
+		Options:    make(map[string]map[string]string, len(v.options)),
+		Names:      v.names,
+		References: make(map[string][]string, 0),
+		Operations: v.operations,
+	}
+
+	for name, options := range v.options {
+		s.Options[name] = withoutSecrets(options)
+	}
+
+	for name := range v.managed {
+		s.Managed = append(s.Managed, name)
+	}
+
+	for name := range v.chowned {
+		s.Chowned = append(s.Chowned, name)
+	}
+
+	for name := range v.dryRuns {
+		s.DryRuns = append(s.DryRuns, name)
+	}
+
+	for _, m := range v.mounts {
+		s.Mounts = append(s.Mounts, m)
+	}
+
+	for name, refs := range v.refs {
+		for id := range refs {
+			s.References[name] = append(s.References[name], id)
+		}
+
+		sort.Strings(s.References[name])
+	}
+
+	sort.Strings(s.Managed)
+	sort.Strings(s.Chowned)
+	sort.Strings(s.DryRuns)
+	sort.Slice(s.Mounts, func(i, j int) bool {
+		return s.Mounts[i].Name < s.Mounts[j].Name
+	})
+
+	return json.Marshal(s)
+}
+
+// tracked records the operation in the state while it runs, so the ones
+// interrupted by a restart are known, and saves the state it leaves.
+func (v *Volume) tracked(method string, h func(volume.Request) volume.Response) func(volume.Request) volume.Response {
+	return func(r volume.Request) volume.Response {
+		key := operationKey(method, r)
+		v.setOperation(key, true)
+		v.saveState()
+
+		defer func() {
+			v.setOperation(key, false)
+			v.saveState()
+		}()
+
+		return h(r)
+	}
+}
+
+func (v *Volume) setOperation(key string, running bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if running {
+		v.operations[key] = time.Now()
+	} else {
+		delete(v.operations, key)
+	}
+}
+
+// restoreMounts mounts again the disks mounted by the previous run, still
+// attached and used by containers, whose mount is gone, e.g. the plugin
+// container was restarted with its own mount namespace. Without it the
+// containers would keep running on an empty mountpoint. The managed disks
+// attached but not mounted, left by an interrupted operation, are logged.
+func (v *Volume) restoreMounts(mounted, attached map[string]bool) {
+	v.Lock()
+	saved := v.saved
+	v.saved = nil
+	v.Unlock()
+
+	if attached == nil {
+		return
+	}
+
+	for _, s := range saved {
+		if s.Lazy {
+			if attached[s.Name] && !mounted[s.Name] {
+				v.setMountStatus(s)
+			}
+
+			continue
+		}
+
+		v.Lock()
+		refs := len(v.refs[s.Name])
+		v.Unlock()
+
+		if mounted[s.Name] || !attached[s.Name] || refs == 0 {
+			continue
+		}
+
+		log15.Warn("mount lost, mounting disk again", "disk", s.Name, "mnt", s.Mountpoint, "source", s.Source, "references", refs)
+		status := &MountStatus{Name: s.Name, Source: s.Source, Mountpoint: s.Mountpoint, Healthy: true}
+		if err := v.restoreMount(status); err != nil {
+			log15.Error("error mounting disk again", "disk", s.Name, "error", err)
+			status.Healthy = false
+			status.Error = fmt.Sprintf("mount lost on restart, mounting it again failed: %s", err)
+		}
+
+		mounted[s.Name] = true
+		v.setMountStatus(status)
+	}
+
+	for name := range attached {
+		if v.isManaged(name) && !mounted[name] {
+			log15.Warn("managed disk attached but not mounted", "disk", name)
+		}
+	}
+}
+
+// restoreMount mounts the attached disk on its previous mountpoint, from
+// the device it was mounted from.
+func (v *Volume) restoreMount(s *MountStatus) error {
+	config, err := v.createDiskConfig(volume.Request{Name: v.diskVolumeName(s.Name)})
+	if err != nil {
+		return err
+	}
+
+	config.DevicePath = s.Source
+	if err := v.createMountPoint(config); err != nil {
+		return err
+	}
+
+	fstype, err := v.fs.Probe(config.Dev())
+	if err != nil {
+		return err
+	}
+
+	if fstype == "" {
+		return fmt.Errorf("no filesystem found on %s", config.Dev())
+	}
+
+	return v.mountDevice(config, fstype)
+}
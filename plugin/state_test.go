@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestLoadState(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"FSType": "xfs"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "My_Data"})
+	c.Assert(r.Err, HasLen, 0)
+
+	for _, id := range []string{"a1", "b2"} {
+		r = s.v.Mount(volume.Request{Name: "foo", ID: id})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+	c.Assert(v.options["foo"], DeepEquals, map[string]string{"FSType": "xfs"})
+	c.Assert(v.names[DiskName("My_Data")], Equals, "My_Data")
+	c.Assert(v.managed, DeepEquals, map[string]bool{"foo": true, DiskName("My_Data"): true})
+	c.Assert(v.refs["foo"], DeepEquals, map[string]bool{"a1": true, "b2": true})
+	c.Assert(v.operations, HasLen, 0)
+
+	c.Assert(v.Reconcile(), IsNil)
+	c.Assert(v.refs["foo"], HasLen, 2)
+
+	r = v.Unmount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Not(Equals), "")
+}
+
+func (s *VolumeSuite) TestLoadStateUnmounted(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Mounted["/mnt/foo"] = ""
+	delete(s.p.attached, "foo")
+
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+	c.Assert(v.Reconcile(), IsNil)
+	c.Assert(v.refs, HasLen, 0)
+	c.Assert(v.Status().Mounts, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestLoadStateRestoreMounts(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Mounted["/mnt/foo"] = ""
+	s.fs.Mounted["/mnt/bar"] = ""
+
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+	c.Assert(v.Reconcile(), IsNil)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, dev)
+	c.Assert(s.fs.Mounted["/mnt/bar"], Equals, "")
+	c.Assert(v.refs["foo"], HasLen, 1)
+
+	mounts := v.Status().Mounts
+	c.Assert(mounts, HasLen, 1)
+	c.Assert(mounts[0].Name, Equals, "foo")
+	c.Assert(mounts[0].Healthy, Equals, true)
+
+	s.fs.Failures["/mnt/bar"] = []error{fmt.Errorf("umount: /mnt/bar: not mounted.")}
+	r = v.Unmount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached["bar"], Equals, false)
+}
+
+func (s *VolumeSuite) TestLoadStateRestoreMountsVolumeName(c *C) {
+	disk := DiskName("My_Data")
+	r := s.v.Create(volume.Request{Name: "My_Data", Options: map[string]string{"MountOptions": "noatime"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "My_Data", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Mounted["/mnt/"+disk] = ""
+
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+	c.Assert(v.Reconcile(), IsNil)
+	c.Assert(s.fs.Mounted["/mnt/"+disk], Equals, "/dev/disk/by-id/google-docker-volume-"+disk)
+	c.Assert(s.fs.Options["/mnt/"+disk], DeepEquals, []string{"discard", "defaults", "noatime"})
+}
+
+func (s *VolumeSuite) TestLoadStateMissing(c *C) {
+	c.Assert(s.v.LoadState(), IsNil)
+
+	path := filepath.Join(s.v.Root, StateFilename)
+	c.Assert(afero.WriteFile(s.fs, path, []byte("{"), 0600), IsNil)
+	c.Assert(s.v.LoadState(), ErrorMatches, "error decoding state .*")
+}
+
+func (s *VolumeSuite) TestSaveStateWithoutSecrets(c *C) {
+	key := "SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0="
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"FSType": "xfs", "csekkey": key}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.v.options["foo"]["csekkey"], Equals, key)
+
+	content, err := afero.ReadFile(s.fs, filepath.Join(s.v.Root, StateFilename))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(string(content), key), Equals, false)
+
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+	c.Assert(v.options["foo"], DeepEquals, map[string]string{"FSType": "xfs"})
+}
+
+func (s *VolumeSuite) TestLoadStateDryRun(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"DryRun": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+	c.Assert(v.dryRuns, DeepEquals, map[string]bool{"foo": true})
+
+	r = v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["foo"], Equals, true)
+}
@@ -0,0 +1,335 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bloomapi/gce-docker/providers"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type Status struct {
+	Draining       bool           `json:"draining"`
+	LabelsDisabled bool           `json:"labels_disabled"`
+	Mounts         []*MountStatus `json:"mounts"`
+}
+
+type MountStatus struct {
+	Name       string    `json:"name"`
+	Source     string    `json:"source"`
+	Mountpoint string    `json:"mountpoint"`
+	Healthy    bool      `json:"healthy"`
+	Detached   bool      `json:"detached,omitempty"`
+	Lazy       bool      `json:"lazy,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at,omitempty"`
+}
+
+// Reconcile discovers the volumes already mounted under Root, usually left
+// by a previous run of the plugin, and when CheckMounts is enabled verifies
+// that each of them is still healthy, using up to ReconcileWorkers at once.
+// With RepairDirtyMounts the disks left mounted by a crash are marked to be
+// repaired on their next mount. The mounts of disks not attached to the
+// instance anymore are handled following the DetachedPolicy, the lost mounts
+// of disks still attached and used, found in the state, are restored. Once
+// the volume is closed the mounts not reconciled yet are skipped.
+func (v *Volume) Reconcile() error {
+	v.Lock()
+	started := v.addBackground()
+	v.Unlock()
+	if !started {
+		return ErrClosed
+	}
+
+	defer v.background.Done()
+	start := time.Now()
+	mounts, err := v.fs.Mounts()
+	if err != nil {
+		return err
+	}
+
+	mounted := make(map[string]bool, 0)
+	for _, m := range mounts {
+		if name, ok := v.mountName(m.Target); ok {
+			mounted[name] = true
+			v.setManaged(name, true)
+		}
+	}
+
+	attached, err := v.p.AttachedDisks()
+	if err != nil {
+		log15.Error("error listing attached disks, skipping detached disks check", "error", err)
+	}
+
+	v.restoreMounts(mounted, attached)
+	v.dropMountRefs(mounted)
+
+	if v.RepairDirtyMounts {
+		if err := v.findDirtyDisks(mounted); err != nil {
+			log15.Error("error looking for dirty disks", "error", err)
+		}
+	}
+
+	pending := make(chan *MountStatus, 0)
+	go func() {
+		defer close(pending)
+		for _, m := range mounts {
+			if name, ok := v.mountName(m.Target); ok {
+				select {
+				case pending <- &MountStatus{Name: name, Source: m.Source, Mountpoint: m.Target, Healthy: true}:
+				case <-v.done:
+					log15.Warn("reconcile stopped, plugin shutting down")
+					return
+				}
+			}
+		}
+	}()
+
+	workers := v.ReconcileWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reconciled, unhealthy int
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range pending {
+				v.reconcileMount(s, attached)
+
+				mu.Lock()
+				reconciled++
+				if !s.Healthy {
+					unhealthy++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	log15.Info("mounts reconciled",
+		"reconciled", reconciled, "healthy", reconciled-unhealthy, "unhealthy", unhealthy,
+		"elapsed", time.Since(start),
+	)
+
+	v.saveState()
+
+	return nil
+}
+
+// findDirtyDisks looks for the disks still labeled as mounted by this
+// instance but not mounted anymore, usually after a crash of the node, their
+// filesystem is repaired before mounting them again. A disk labeled by
+// another instance is only dirty if nothing uses it, otherwise it's mounted
+// there right now.
+func (v *Volume) findDirtyDisks(mounted map[string]bool) error {
+	disks, err := v.p.List()
+	if err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	for _, d := range disks {
+		label := d.Labels[LabelDirtyMount]
+		if label == "" || mounted[d.Name] {
+			continue
+		}
+
+		if label != providers.LabelValue(v.instance) && len(d.Users) != 0 {
+			continue
+		}
+
+		log15.Warn("disk wasn't cleanly unmounted, it will be repaired on mount", "disk", d.Name)
+		v.dirty[d.Name] = true
+	}
+
+	return nil
+}
+
+func (v *Volume) reconcileMount(s *MountStatus, attached map[string]bool) {
+	if attached != nil && !attached[s.Name] {
+		v.reconcileDetached(s)
+		return
+	}
+
+	if v.CheckMounts {
+		v.checkMount(s)
+	}
+
+	log15.Debug("mount reconciled", "disk", s.Name, "mnt", s.Mountpoint, "healthy", s.Healthy)
+	v.setMountStatus(s)
+}
+
+// reconcileDetached handles a mount whose disk was detached by someone else,
+// or attached to another instance, while the plugin wasn't running.
+func (v *Volume) reconcileDetached(s *MountStatus) {
+	v.logDetached(s)
+
+	switch v.DetachedPolicy {
+	case DetachedDrop:
+		if err := v.fs.Unmount(s.Mountpoint); err != nil {
+			log15.Error("error unmounting detached disk", "disk", s.Name, "mnt", s.Mountpoint, "error", err)
+		}
+
+		v.setManaged(s.Name, false)
+		log15.Warn("stopped tracking detached disk", "disk", s.Name, "mnt", s.Mountpoint)
+		return
+	case DetachedRemount:
+		err := v.remount(s)
+		if err == nil {
+			log15.Info("detached disk mounted again", "disk", s.Name, "mnt", s.Mountpoint)
+			return
+		}
+
+		log15.Error("error mounting detached disk again", "disk", s.Name, "error", err)
+		s.Error = fmt.Sprintf("disk is not attached to the instance, mounting it again failed: %s", err)
+	default:
+		s.Error = "disk is not attached to the instance"
+	}
+
+	s.Healthy = false
+	s.Detached = true
+	v.setMountStatus(s)
+}
+
+// logDetached logs the status and users of the detached disk, looked up with
+// the saved options of the volume, as remount does, so the disk of another
+// project or a regional one is found.
+func (v *Volume) logDetached(s *MountStatus) {
+	config, err := v.createDiskConfig(volume.Request{Name: v.diskVolumeName(s.Name)})
+	var d *compute.Disk
+	if err == nil {
+		d, err = v.p.Get(config)
+	}
+
+	if err != nil {
+		log15.Warn("mounted disk is not attached to the instance",
+			"disk", s.Name, "mnt", s.Mountpoint, "source", s.Source, "policy", v.DetachedPolicy, "error", err,
+		)
+
+		return
+	}
+
+	log15.Warn("mounted disk is not attached to the instance",
+		"disk", s.Name, "mnt", s.Mountpoint, "source", s.Source, "policy", v.DetachedPolicy,
+		"status", d.Status, "users", d.Users,
+	)
+}
+
+// remount replaces the stale mount of a detached disk by a new one.
+func (v *Volume) remount(s *MountStatus) error {
+	if err := v.fs.Unmount(s.Mountpoint); err != nil {
+		return err
+	}
+
+	r := v.mount(volume.Request{Name: v.diskVolumeName(s.Name)})
+	if r.Err != "" {
+		return errors.New(r.Err)
+	}
+
+	return nil
+}
+
+// checkMount runs a health check of the mount, an I/O error is checked again
+// to tell transient errors from persistent ones.
+func (v *Volume) checkMount(s *MountStatus) {
+	s.CheckedAt = time.Now()
+	err := v.fs.Check(s.Source, s.Mountpoint)
+	if IsIOError(err) {
+		kind := IOErrorTransient
+		if rerr := v.fs.Check(s.Source, s.Mountpoint); rerr != nil {
+			kind, err = IOErrorPersistent, rerr
+		}
+
+		v.reportIOError(s.Name, "health", kind, err)
+		if kind == IOErrorTransient {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		s.Healthy = false
+		s.Error = err.Error()
+		log15.Error("unhealthy mount detected", "disk", s.Name, "mnt", s.Mountpoint, "error", err)
+	}
+}
+
+func (v *Volume) mountName(target string) (string, bool) {
+	rel, err := filepath.Rel(v.Root, target)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") || strings.Contains(rel, "/") {
+		return "", false
+	}
+
+	return rel, true
+}
+
+func (v *Volume) setMountStatus(s *MountStatus) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.mounts[s.Name] = s
+}
+
+// mountSource returns the device the disk is mounted from, the one of the
+// config if it isn't mounted.
+func (v *Volume) mountSource(c *providers.DiskConfig) string {
+	v.Lock()
+	defer v.Unlock()
+
+	if s, ok := v.mounts[c.Name]; ok && s.Source != "" {
+		return s.Source
+	}
+
+	return c.Dev()
+}
+
+// lazySource returns the device of the disk unmounted lazily and kept
+// attached, if it's the case.
+func (v *Volume) lazySource(name string) (string, bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if s, ok := v.mounts[name]; ok && s.Lazy {
+		return s.Source, true
+	}
+
+	return "", false
+}
+
+func (v *Volume) deleteMountStatus(name string) {
+	v.Lock()
+	defer v.Unlock()
+
+	delete(v.mounts, name)
+}
+
+func (v *Volume) Status() *Status {
+	v.Lock()
+	defer v.Unlock()
+
+	s := &Status{Draining: v.draining, LabelsDisabled: v.nolabels, Mounts: make([]*MountStatus, 0)}
+	for _, m := range v.mounts {
+		s.Mounts = append(s.Mounts, m)
+	}
+
+	sort.Slice(s.Mounts, func(i, j int) bool {
+		return s.Mounts[i].Name < s.Mounts[j].Name
+	})
+
+	return s
+}
@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+)
+
+// mounted simulates a disk attached and mounted by a previous run.
+func (s *VolumeSuite) mounted(name, source string) {
+	s.fs.Mounted["/mnt/"+name] = source
+	s.p.attached[name] = true
+}
+
+func (s *VolumeSuite) TestReconcile(c *C) {
+	s.mounted("foo", "/dev/disk/by-id/google-docker-volume-foo")
+	s.mounted("bar", "/dev/disk/by-id/google-docker-volume-bar")
+	s.fs.Mounted["/mnt/foo/nested"] = "/dev/sdc"
+	s.fs.Mounted["/var/lib/docker"] = "/dev/sda1"
+	s.fs.Unhealthy["/mnt/bar"] = fmt.Errorf("input/output error")
+
+	err := s.v.Reconcile()
+	c.Assert(err, IsNil)
+
+	status := s.v.Status()
+	c.Assert(status.Mounts, HasLen, 2)
+	c.Assert(status.Mounts[0].Name, Equals, "bar")
+	c.Assert(status.Mounts[0].Healthy, Equals, true)
+	c.Assert(status.Mounts[1].Name, Equals, "foo")
+	c.Assert(status.Mounts[1].Healthy, Equals, true)
+}
+
+func (s *VolumeSuite) TestReconcileWithCheckMounts(c *C) {
+	s.mounted("foo", "/dev/disk/by-id/google-docker-volume-foo")
+	s.mounted("bar", "/dev/disk/by-id/google-docker-volume-bar")
+	s.fs.Unhealthy["/mnt/bar"] = fmt.Errorf("input/output error")
+	s.v.CheckMounts = true
+
+	err := s.v.Reconcile()
+	c.Assert(err, IsNil)
+
+	status := s.v.Status()
+	c.Assert(status.Mounts, HasLen, 2)
+	c.Assert(status.Mounts[0].Name, Equals, "bar")
+	c.Assert(status.Mounts[0].Healthy, Equals, false)
+	c.Assert(status.Mounts[0].Error, Equals, "input/output error")
+	c.Assert(status.Mounts[1].Name, Equals, "foo")
+	c.Assert(status.Mounts[1].Healthy, Equals, true)
+}
+
+func (s *VolumeSuite) TestReconcileIOErrors(c *C) {
+	s.mounted("foo", "/dev/disk/by-id/google-docker-volume-foo")
+	s.fs.Failures["/mnt/foo"] = []error{fmt.Errorf("input/output error")}
+	s.v.CheckMounts = true
+
+	transient := testutil.ToFloat64(ioErrors.WithLabelValues("foo", "health", IOErrorTransient))
+	c.Assert(s.v.Reconcile(), IsNil)
+	c.Assert(s.v.Status().Mounts[0].Healthy, Equals, true)
+	c.Assert(testutil.ToFloat64(ioErrors.WithLabelValues("foo", "health", IOErrorTransient)), Equals, transient+1)
+
+	persistent := testutil.ToFloat64(ioErrors.WithLabelValues("foo", "health", IOErrorPersistent))
+	s.fs.Unhealthy["/mnt/foo"] = fmt.Errorf("input/output error")
+	c.Assert(s.v.Reconcile(), IsNil)
+	c.Assert(s.v.Status().Mounts[0].Healthy, Equals, false)
+	c.Assert(testutil.ToFloat64(ioErrors.WithLabelValues("foo", "health", IOErrorPersistent)), Equals, persistent+1)
+}
+
+func (s *VolumeSuite) TestReconcileWorkers(c *C) {
+	for i := 0; i < 20; i++ {
+		s.mounted(fmt.Sprintf("foo-%d", i), fmt.Sprintf("/dev/sd%d", i))
+	}
+
+	for _, workers := range []int{0, 1, 8} {
+		s.v.ReconcileWorkers = workers
+		s.v.CheckMounts = true
+
+		err := s.v.Reconcile()
+		c.Assert(err, IsNil)
+		c.Assert(s.v.Status().Mounts, HasLen, 20)
+	}
+}
+
+func (s *VolumeSuite) TestStatusMountAndUnmount(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.v.Status().Mounts, HasLen, 1)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.v.Status().Mounts, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestReconcileDirtyDisks(c *C) {
+	s.v.RepairDirtyMounts = true
+	s.v.instance = "instance"
+	s.p.disks["foo"], s.p.disks["bar"], s.p.disks["qux"] = true, true, true
+	s.p.disks["shared"], s.p.disks["crashed"] = true, true
+	s.p.labels["foo"] = map[string]string{LabelDirtyMount: "instance"}
+	s.p.labels["bar"] = map[string]string{LabelDirtyMount: "instance"}
+	s.p.labels["shared"] = map[string]string{LabelDirtyMount: "other"}
+	s.p.users["shared"] = []string{"other"}
+	s.p.labels["crashed"] = map[string]string{LabelDirtyMount: "other"}
+	s.mounted("bar", "/dev/disk/by-id/google-docker-volume-bar")
+	s.fs.Formatted["/dev/disk/by-id/google-docker-volume-foo"] = "ext4"
+	s.fs.Formatted["/dev/disk/by-id/google-docker-volume-qux"] = "ext4"
+
+	c.Assert(s.v.Reconcile(), IsNil)
+	c.Assert(s.v.dirty, DeepEquals, map[string]bool{"foo": true, "crashed": true})
+
+	r := s.v.Mount(volume.Request{Name: "qux"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Repaired, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Repaired["/dev/disk/by-id/google-docker-volume-foo"], Equals, "ext4")
+	c.Assert(s.v.dirty, DeepEquals, map[string]bool{"crashed": true})
+
+	r = s.v.Unmount(volume.Request{Name: "qux"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.background.Wait()
+	c.Assert(s.p.labels["foo"][LabelDirtyMount], Equals, "instance")
+	c.Assert(s.p.labels["qux"], HasLen, 0)
+}
+
+func (s *VolumeSuite) TestReconcileDetachedMarkFailed(c *C) {
+	s.p.disks["foo"] = true
+	s.mounted("bar", "/dev/disk/by-id/google-docker-volume-bar")
+	s.fs.Mounted["/mnt/foo"] = "/dev/disk/by-id/google-docker-volume-foo"
+
+	c.Assert(s.v.Reconcile(), IsNil)
+
+	status := s.v.Status()
+	c.Assert(status.Mounts, HasLen, 2)
+	c.Assert(status.Mounts[0].Healthy, Equals, true)
+	c.Assert(status.Mounts[0].Detached, Equals, false)
+	c.Assert(status.Mounts[1].Name, Equals, "foo")
+	c.Assert(status.Mounts[1].Healthy, Equals, false)
+	c.Assert(status.Mounts[1].Detached, Equals, true)
+	c.Assert(status.Mounts[1].Error, Equals, "disk is not attached to the instance")
+	c.Assert(s.fs.Mounted["/mnt/foo"], Not(Equals), "")
+}
+
+func (s *VolumeSuite) TestReconcileDetachedSavedOptions(c *C) {
+	s.p.disks["foo"] = true
+	s.v.options["foo"] = map[string]string{"Project": "other"}
+	s.fs.Mounted["/mnt/foo"] = "/dev/disk/by-id/google-docker-volume-foo"
+
+	c.Assert(s.v.Reconcile(), IsNil)
+	c.Assert(s.p.lastGet.Name, Equals, "foo")
+	c.Assert(s.p.lastGet.Project, Equals, "other")
+
+	status := s.v.Status()
+	c.Assert(status.Mounts, HasLen, 1)
+	c.Assert(status.Mounts[0].Detached, Equals, true)
+}
+
+func (s *VolumeSuite) TestReconcileDetachedDrop(c *C) {
+	s.v.DetachedPolicy = DetachedDrop
+	s.fs.Mounted["/mnt/foo"] = "/dev/disk/by-id/google-docker-volume-foo"
+
+	c.Assert(s.v.Reconcile(), IsNil)
+	c.Assert(s.v.Status().Mounts, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+}
+
+func (s *VolumeSuite) TestReconcileDetachedRemount(c *C) {
+	s.v.DetachedPolicy = DetachedRemount
+	s.p.disks["foo"] = true
+	s.fs.Mounted["/mnt/foo"] = "/dev/sdb"
+	s.fs.Mounted["/mnt/bar"] = "/dev/sdc"
+
+	c.Assert(s.v.Reconcile(), IsNil)
+	c.Assert(s.p.attached["foo"], Equals, true)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/disk/by-id/google-docker-volume-foo")
+
+	status := s.v.Status()
+	c.Assert(status.Mounts, HasLen, 2)
+	c.Assert(status.Mounts[0].Name, Equals, "bar")
+	c.Assert(status.Mounts[0].Detached, Equals, true)
+	c.Assert(status.Mounts[0].Error, Matches, "disk is not attached to the instance, mounting it again failed: .*unable to find disk bar")
+	c.Assert(status.Mounts[1].Name, Equals, "foo")
+	c.Assert(status.Mounts[1].Healthy, Equals, true)
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// StepError is returned by the operations made of several steps, like mount,
+// naming the step that failed, the ones completed before it and the cleanup
+// performed, so the state the disk was left in is known.
+type StepError struct {
+	Operation string
+	Step      string
+	Done      []string
+	Cleanup   []string
+	Err       error
+}
+
+func (e *StepError) Error() string {
+	msg := fmt.Sprintf("%s failed at %s", e.Operation, e.Step)
+	if len(e.Done) != 0 {
+		msg += fmt.Sprintf(" after successful %s", strings.Join(e.Done, ", "))
+	}
+
+	if len(e.Cleanup) != 0 {
+		msg += fmt.Sprintf("; %s", strings.Join(e.Cleanup, ", "))
+	}
+
+	return fmt.Sprintf("%s: %s", msg, e.Err)
+}
+
+type undoStep struct {
+	name string
+	done string
+	f    func() error
+}
+
+// steps tracks the completed steps of an operation and how to undo them.
+type steps struct {
+	operation string
+	disk      string
+	done      []string
+	undo      []undoStep
+}
+
+func newSteps(operation, disk string) *steps {
+	return &steps{operation: operation, disk: disk}
+}
+
+// completed records a step, undo reverts it if a later step fails and done
+// describes the cleanup, e.g. "disk was detached". undo may be nil.
+func (s *steps) completed(step, done string, undo func() error) {
+	s.done = append(s.done, step)
+	if undo != nil {
+		s.undo = append(s.undo, undoStep{name: step, done: done, f: undo})
+	}
+}
+
+// fail undoes the completed steps, in reverse order, and returns a StepError
+// describing the failure and the cleanup.
+func (s *steps) fail(step string, err error) error {
+	e := &StepError{Operation: s.operation, Step: step, Done: s.done, Err: err}
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		u := s.undo[i]
+		if uerr := u.f(); uerr != nil {
+			log15.Error("error cleaning up failed operation",
+				"disk", s.disk, "operation", s.operation, "step", u.name, "error", uerr,
+			)
+
+			e.Cleanup = append(e.Cleanup, fmt.Sprintf("undoing %s failed (%s)", u.name, uerr))
+			continue
+		}
+
+		e.Cleanup = append(e.Cleanup, u.done)
+	}
+
+	log15.Warn("operation failed",
+		"disk", s.disk, "operation", s.operation, "step", step, "done", s.done, "cleanup", e.Cleanup,
+	)
+
+	return e
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/bloomapi/gce-docker/providers"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// RunTrim runs TrimMounts every interval, until the volume is closed. The
+// volumes mounted with nodiscard only reclaim the space of the files
+// deleted, and keep the SSDs fast, once trimmed.
+func (v *Volume) RunTrim(interval time.Duration) {
+	v.Lock()
+	started := v.addBackground()
+	v.Unlock()
+	if !started {
+		return
+	}
+
+	defer v.background.Done()
+	log15.Info("trimming mounted volumes periodically", "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.TrimMounts()
+		case <-v.done:
+			log15.Debug("periodic trim stopped")
+			return
+		}
+	}
+}
+
+// TrimMounts runs fstrim on the healthy mounts under Root, a failure is only
+// logged and the mount is trimmed again on the next run.
+func (v *Volume) TrimMounts() {
+	start := time.Now()
+	var trimmed, failed int
+	for _, s := range v.trimmableMounts() {
+		bytes, err := v.fs.Trim(s.Mountpoint)
+		if err != nil {
+			log15.Warn("error trimming volume", "disk", s.Name, "mnt", s.Mountpoint, "error", err)
+			trims.WithLabelValues(TrimOutcomeFailed).Inc()
+			failed++
+			continue
+		}
+
+		log15.Debug("volume trimmed", "disk", s.Name, "mnt", s.Mountpoint, "bytes", bytes)
+		trims.WithLabelValues(TrimOutcomeTrimmed).Inc()
+		trimmedBytes.WithLabelValues(s.Name).Add(float64(bytes))
+		trimmed++
+	}
+
+	log15.Info("volumes trimmed", "trimmed", trimmed, "failed", failed, "elapsed", time.Since(start))
+}
+
+// clearTrimmed deletes the trimmed bytes of a removed volume.
+func clearTrimmed(c *providers.DiskConfig) {
+	trimmedBytes.DeleteLabelValues(c.Name)
+}
+
+// trimmableMounts returns the mounts healthy and attached, the others would
+// fail.
+func (v *Volume) trimmableMounts() []*MountStatus {
+	v.Lock()
+	defer v.Unlock()
+
+	var mounts []*MountStatus
+	for _, s := range v.mounts {
+		if s.Healthy && !s.Detached {
+			mounts = append(mounts, &MountStatus{Name: s.Name, Mountpoint: s.Mountpoint})
+		}
+	}
+
+	return mounts
+}
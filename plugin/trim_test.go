@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestTrimMounts(c *C) {
+	for _, name := range []string{"foo", "bar"} {
+		r := s.v.Create(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+
+		r = s.v.Mount(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	s.fs.Events = nil
+	s.fs.Failures["/mnt/bar"] = []error{fmt.Errorf("fstrim: /mnt/bar: the discard operation is not supported")}
+	s.v.TrimMounts()
+	c.Assert(s.fs.Events, DeepEquals, []string{"trim /mnt/foo"})
+
+	s.fs.Events = nil
+	s.v.setMountStatus(&MountStatus{Name: "foo", Mountpoint: "/mnt/foo", Healthy: true, Detached: true})
+	s.v.TrimMounts()
+	c.Assert(s.fs.Events, DeepEquals, []string{"trim /mnt/bar"})
+}
+
+func (s *VolumeSuite) TestTrimMountsRemoved(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.TrimMounts()
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(trimmedBytes.DeleteLabelValues("foo"), Equals, false)
+}
+
+func (s *VolumeSuite) TestRunTrimClose(c *C) {
+	stopped := make(chan struct{})
+	go func() {
+		s.v.RunTrim(time.Hour)
+		close(stopped)
+	}()
+
+	c.Assert(s.v.Close(), IsNil)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		c.Fatal("periodic trim not stopped on close")
+	}
+}
@@ -1,24 +1,112 @@
 package plugin
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bloomapi/gce-docker/providers"
 
 	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/spf13/afero"
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-var WaitStatusTimeout = 100 * time.Second
+var (
+	DefaultRoot             = "/mnt/"
+	WaitStatusTimeout       = 100 * time.Second
+	WaitStatusInterval      = 1 * time.Second
+	WaitDeviceTimeout       = 30 * time.Second
+	GrowthTolerance         = 0.9
+	DefaultReconcileWorkers = 4
+	DefaultUnmountRetries   = 3
+	DefaultZoneRefresh      = 5 * time.Minute
+	UnmountRetryInterval    = 1 * time.Second
+	LabelConsumer           = "used-by"
+	LabelDirtyMount         = "dirty-mount"
+	LabelOwnerToken         = "owner-token"
+	LabelReclaimPolicy      = "reclaim-policy"
+	LabelOptionPrefix       = "Label."
+)
+
+// WaitDeviceBackoff is the delay between the checks for the device of a just
+// attached disk.
+var WaitDeviceBackoff = providers.Backoff{
+	Strategy:   providers.BackoffExponential,
+	Initial:    250 * time.Millisecond,
+	Max:        4 * time.Second,
+	Multiplier: 2,
+}
+
+const (
+	ScopeLocal  = "local"
+	ScopeGlobal = "global"
+)
+
+const (
+	DetachedMarkFailed = "mark-failed"
+	DetachedRemount    = "remount"
+	DetachedDrop       = "drop"
+)
 
 type Volume struct {
-	Root string
-	p    providers.DiskProvider
-	fs   Filesystem
+	Root              string
+	CheckMounts       bool
+	ReconcileWorkers  int
+	ResponseTimeout   time.Duration
+	DefaultKmsKeyName string
+	DefaultSizeGb     int64
+	DefaultType       string
+	MkfsOptions       map[string]string
+	IgnoreDrift       bool
+	Fsck              bool
+	UIDOffset         int
+	GIDOffset         int
+	RepairDirtyMounts bool
+	Scope             string
+	SourceProjects    []string
+	Projects          []string
+	DetachedPolicy    string
+	OwnerToken        string
+	MaxManagedDisks   int
+	ResourcePolicies  []string
+	FlushOnUnmount    bool
+	UnmountRetries    int
+	LazyUnmount       bool
+	SnapshotOnRemove  bool
+	Profiles          Profiles
+
+	p          providers.DiskProvider
+	fs         Filesystem
+	instance   string
+	mounts     map[string]*MountStatus
+	saved      []*MountStatus
+	refs       map[string]map[string]bool
+	options    map[string]map[string]string
+	names      map[string]string
+	pending    map[string]*pendingOperation
+	operations map[string]time.Time
+	dirty      map[string]bool
+	labeling   map[string]chan struct{}
+	managed    map[string]bool
+	chowned    map[string]bool
+	dryRuns    map[string]bool
+	draining   bool
+	nolabels   bool
+	closed     bool
+	done       chan struct{}
+	background sync.WaitGroup
+	stateLock  sync.Mutex
+	sync.Mutex
 }
 
 func NewVolume(c *http.Client, project, zone, instance string) (*Volume, error) {
@@ -27,14 +115,78 @@ func NewVolume(c *http.Client, project, zone, instance string) (*Volume, error)
 		return nil, err
 	}
 
+	v := newVolume(p, NewFilesystem())
+	v.instance = instance
+	return v, nil
+}
+
+func newVolume(p providers.DiskProvider, fs Filesystem) *Volume {
 	return &Volume{
-		Root: "/mnt/",
-		p:    p,
-		fs:   NewFilesystem(),
-	}, nil
+		Root:             DefaultRoot,
+		ReconcileWorkers: DefaultReconcileWorkers,
+		UnmountRetries:   DefaultUnmountRetries,
+		Scope:            ScopeLocal,
+		DetachedPolicy:   DetachedMarkFailed,
+		p:                p,
+		fs:               fs,
+		mounts:           make(map[string]*MountStatus, 0),
+		refs:             make(map[string]map[string]bool, 0),
+		options:          make(map[string]map[string]string, 0),
+		names:            make(map[string]string, 0),
+		pending:          make(map[string]*pendingOperation, 0),
+		operations:       make(map[string]time.Time, 0),
+		dirty:            make(map[string]bool, 0),
+		labeling:         make(map[string]chan struct{}, 0),
+		managed:          make(map[string]bool, 0),
+		chowned:          make(map[string]bool, 0),
+		dryRuns:          make(map[string]bool, 0),
+		done:             make(chan struct{}),
+	}
+}
+
+// ErrClosed is returned to the requests received while the plugin stops.
+var ErrClosed = errors.New("plugin shutting down, retry once it's restarted")
+
+// Close stops accepting requests and the background loops, like the trim and
+// the reconcile, waits for the work running in the background, like the
+// requests answered after ResponseTimeout, saves the state and releases the
+// provider.
+func (v *Volume) Close() error {
+	v.Lock()
+	if !v.closed {
+		v.closed = true
+		close(v.done)
+	}
+	v.Unlock()
+
+	v.background.Wait()
+	v.saveState()
+	return v.p.Close()
+}
+
+func (v *Volume) isClosed() bool {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.closed
+}
+
+// addBackground registers work Close waits for, false once closed, then the
+// work must not start. Holding the lock.
+func (v *Volume) addBackground() bool {
+	if v.closed {
+		return false
+	}
+
+	v.background.Add(1)
+	return true
 }
 
 func (v *Volume) Create(r volume.Request) volume.Response {
+	return v.withDeadline("create", r, recovered("create", v.create))
+}
+
+func (v *Volume) create(r volume.Request) volume.Response {
 	log15.Debug("create request received", "name", r.Name)
 	start := time.Now()
 	config, err := v.createDiskConfig(r)
@@ -42,15 +194,160 @@ func (v *Volume) Create(r volume.Request) volume.Response {
 		return buildReponseError(err)
 	}
 
-	if err := v.p.Create(config); err != nil {
+	if v.Draining() {
+		return buildReponseError(ErrDraining)
+	}
+
+	if err := v.checkManagedLimit(config.Name); err != nil {
+		return buildReponseError(err)
+	}
+
+	if v.OwnerToken != "" {
+		config.Labels[LabelOwnerToken] = v.OwnerToken
+	}
+
+	if config.ReclaimPolicy == providers.ReclaimPolicyRetain {
+		config.Labels[LabelReclaimPolicy] = string(providers.ReclaimPolicyRetain)
+	}
+
+	labelVolumeName(config, r.Name)
+
+	p, err := v.provider(config)
+	if err != nil {
+		return buildReponseError(err)
+	}
+
+	if config.Exists {
+		err = v.adopt(p, config)
+	} else {
+		err = p.Create(config)
+	}
+
+	if err != nil {
 		return buildReponseError(err)
 	}
 
-	log15.Info("disk created", "disk", r.Name, "elapsed", time.Since(start))
+	v.setDryRun(r.Name, config.DryRun)
+	if config.DryRun {
+		log15.Info("dry run, disk not created", "disk", r.Name, "elapsed", time.Since(start))
+		return volume.Response{}
+	}
+
+	status := "unknown"
+	if config.WaitFor != providers.WaitForOperation {
+		status, err = v.waitStatus(config, "READY")
+		if err != nil {
+			return buildReponseError(err)
+		}
+	}
+
+	if config.SnapshotSchedule != "" {
+		if err := v.p.AddSnapshotSchedule(config); err != nil {
+			return buildReponseError(fmt.Errorf("disk %q created, but unable to attach its snapshot schedule: %s", config.Name, err))
+		}
+
+		log15.Info("snapshot schedule attached", "disk", config.Name, "policy", config.SnapshotSchedule)
+	}
+
+	if err := v.growMounted(config); err != nil {
+		return buildReponseError(fmt.Errorf("disk %q created, but unable to grow its mounted filesystem: %s", config.Name, err))
+	}
+
+	v.setOptions(r.Name, r.Options)
+	v.setVolumeName(config.Name, r.Name)
+	v.setManaged(config.Name, true)
+
+	log15.Info("disk created",
+		"disk", r.Name, "status", status, "kms-key", config.KmsKeyName, "elapsed", time.Since(start),
+	)
 	return volume.Response{}
 }
 
-func (v *Volume) List(volume.Request) volume.Response {
+// adopt registers a disk that must already exist, created outside of the
+// plugin, instead of creating it.
+func (v *Volume) adopt(p providers.DiskProvider, c *providers.DiskConfig) error {
+	d, err := v.p.Get(c)
+	if providers.IsNotFoundError(err) {
+		return withCode(ErrorCodeNotFound, fmt.Errorf("unable to adopt disk %q, it doesn't exist", c.Name))
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to adopt disk %q: %w", c.Name, err)
+	}
+
+	log15.Info("adopting existing disk", "disk", c.Name, "type", providers.ResourceName(d.Type), "size", d.SizeGb)
+	if c.ReclaimPolicy == providers.ReclaimPolicyRetain && d.Labels[LabelReclaimPolicy] != string(c.ReclaimPolicy) {
+		return p.UpdateLabels(c, map[string]string{LabelReclaimPolicy: string(c.ReclaimPolicy)})
+	}
+
+	return nil
+}
+
+// setDryRun records whether the last create of the volume was a dry run.
+func (v *Volume) setDryRun(name string, dryRun bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if dryRun {
+		v.dryRuns[name] = true
+	} else {
+		delete(v.dryRuns, name)
+	}
+}
+
+func (v *Volume) dryRun(name string) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.dryRuns[name]
+}
+
+// provider returns the provider changing the disk of the volume, one only
+// logging the changes with DryRun.
+func (v *Volume) provider(c *providers.DiskConfig) (providers.DiskProvider, error) {
+	if !c.DryRun {
+		return v.p, nil
+	}
+
+	p, err := v.p.DryRun()
+	if err != nil {
+		return nil, fmt.Errorf("error creating dry-run provider: %s", err)
+	}
+
+	return p, nil
+}
+
+// waitStatus waits until the disk reaches the given status, failing if it
+// doesn't within WaitStatusTimeout or the disk creation failed.
+func (v *Volume) waitStatus(c *providers.DiskConfig, status string) (string, error) {
+	start := time.Now()
+	for {
+		d, err := v.p.Get(c)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case d.Status == status:
+			return d.Status, nil
+		case d.Status == "FAILED":
+			return d.Status, fmt.Errorf("disk %q creation failed", c.Name)
+		case time.Since(start) > WaitStatusTimeout:
+			return d.Status, fmt.Errorf(
+				"max. time reached waiting for disk %q to be %s, current status %s",
+				c.Name, status, d.Status,
+			)
+		}
+
+		time.Sleep(WaitStatusInterval)
+	}
+}
+
+func (v *Volume) List(r volume.Request) volume.Response {
+	return recovered("list", v.list)(r)
+}
+
+func (v *Volume) list(volume.Request) volume.Response {
 	log15.Debug("list request received")
 	disks, err := v.p.List()
 	if err != nil {
@@ -64,48 +361,57 @@ func (v *Volume) List(volume.Request) volume.Response {
 		}
 
 		r.Volumes = append(r.Volumes, &volume.Volume{
-			Name: d.Name,
+			Name: v.volumeName(d),
 		})
 	}
 
 	return r
 }
 
-func (v *Volume) Capabilities(volume.Request) volume.Response {
+func (v *Volume) Capabilities(r volume.Request) volume.Response {
+	return recovered("capabilities", v.capabilities)(r)
+}
+
+func (v *Volume) capabilities(volume.Request) volume.Response {
 	log15.Debug("capabilities request received")
 	return volume.Response{
-		Capabilities: volume.Capability{Scope: "local"},
+		Capabilities: volume.Capability{Scope: v.Scope},
 	}
 }
 
 func (v *Volume) Get(r volume.Request) volume.Response {
+	return recovered("get", v.get)(r)
+}
+
+func (v *Volume) get(r volume.Request) volume.Response {
 	log15.Debug("get request received")
-	disks, err := v.p.List()
+	config, err := v.createDiskConfig(r)
 	if err != nil {
 		return buildReponseError(err)
 	}
 
-	resp := volume.Response{}
-	for _, d := range disks {
-		if d.Name != r.Name {
-			continue
-		}
-
-		config, err := v.createDiskConfig(r)
-		if err != nil {
-			return buildReponseError(err)
-		}
+	_, err = v.p.Get(config)
+	if providers.IsNotFoundError(err) {
+		return volume.Response{}
+	}
 
-		resp.Volume = &volume.Volume{
-			Name:       d.Name,
-			Mountpoint: config.MountPoint(v.Root),
-		}
+	if err != nil {
+		return buildReponseError(err)
 	}
 
-	return resp
+	return volume.Response{
+		Volume: &volume.Volume{
+			Name:       r.Name,
+			Mountpoint: config.Path(v.Root),
+		},
+	}
 }
 
 func (v *Volume) Remove(r volume.Request) volume.Response {
+	return v.withDeadline("remove", r, recovered("remove", v.remove))
+}
+
+func (v *Volume) remove(r volume.Request) volume.Response {
 	log15.Debug("remove request received", "name", r.Name)
 	start := time.Now()
 
@@ -114,21 +420,84 @@ func (v *Volume) Remove(r volume.Request) volume.Response {
 		return buildReponseError(err)
 	}
 
-	if err := v.p.Delete(config); err != nil {
+	if err := v.checkOwnership(config, "remove"); err != nil {
+		return buildReponseError(err)
+	}
+
+	// Docker doesn't send the options on remove, a volume created with DryRun
+	// is removed with it too
+	config.DryRun = config.DryRun || v.dryRun(r.Name)
+	p, err := v.provider(config)
+	if err != nil {
 		return buildReponseError(err)
 	}
 
+	retain := v.retained(config)
+	if !retain && config.SnapshotOnRemove {
+		s, err := p.Snapshot(config)
+		if err != nil {
+			return buildReponseError(fmt.Errorf("error taking snapshot of disk %q before removing it, the disk was kept: %s", r.Name, err))
+		}
+
+		log15.Info("snapshot taken before removal", "disk", r.Name, "snapshot", s.Name)
+	}
+
+	if !retain {
+		if err := p.Delete(config); err != nil {
+			return buildReponseError(err)
+		}
+	}
+
+	if config.DryRun {
+		v.setDryRun(r.Name, false)
+		log15.Info("dry run, disk not removed", "disk", r.Name, "elapsed", time.Since(start))
+		return volume.Response{}
+	}
+
+	v.setOptions(r.Name, nil)
+
+	v.setVolumeName(config.Name, "")
+	v.setManaged(config.Name, false)
+	v.setChowned(config.Name, false)
+	clearTrimmed(config)
+
+	if retain {
+		log15.Info("volume removed, disk retained", "disk", r.Name, "elapsed", time.Since(start))
+		return volume.Response{}
+	}
+
 	log15.Info("disk removed", "disk", r.Name, "elapsed", time.Since(start))
 	return volume.Response{}
 }
 
+// retained reports whether the disk must be kept when the volume is removed,
+// by its ReclaimPolicy or, as the options are lost when the plugin restarts,
+// by the reclaim-policy label set when the volume was created.
+func (v *Volume) retained(c *providers.DiskConfig) bool {
+	if c.ReclaimPolicy != "" {
+		return c.ReclaimPolicy == providers.ReclaimPolicyRetain
+	}
+
+	// a missing disk is left to Delete to report
+	d, err := v.p.Get(c)
+	if err != nil {
+		return false
+	}
+
+	return d.Labels[LabelReclaimPolicy] == string(providers.ReclaimPolicyRetain)
+}
+
 func (v *Volume) Path(r volume.Request) volume.Response {
+	return recovered("path", v.path)(r)
+}
+
+func (v *Volume) path(r volume.Request) volume.Response {
 	config, err := v.createDiskConfig(r)
 	if err != nil {
 		return buildReponseError(err)
 	}
 
-	mnt := config.MountPoint(v.Root)
+	mnt := config.Path(v.Root)
 	log15.Debug("path request received", "name", r.Name, "mnt", mnt)
 
 	if err := v.createMountPoint(config); err != nil {
@@ -139,6 +508,10 @@ func (v *Volume) Path(r volume.Request) volume.Response {
 }
 
 func (v *Volume) Mount(r volume.Request) volume.Response {
+	return v.withDeadline("mount", r, recovered("mount", v.mount))
+}
+
+func (v *Volume) mount(r volume.Request) volume.Response {
 	log15.Debug("mount request received", "name", r.Name)
 	start := time.Now()
 
@@ -147,94 +520,1415 @@ func (v *Volume) Mount(r volume.Request) volume.Response {
 		return buildReponseError(err)
 	}
 
+	if v.Draining() {
+		return buildReponseError(ErrDraining)
+	}
+
+	if refs, ok := v.acquireMount(config.Name, r.ID); ok {
+		log15.Info("disk already mounted, reference added", "disk", r.Name, "id", r.ID, "references", refs)
+		if config.Subpath != "" {
+			err := v.createSubpath(config)
+			if err == nil {
+				err = v.setPermissions(config)
+			}
+
+			if err != nil {
+				v.releaseMount(config.Name, r.ID)
+				return buildReponseError(err)
+			}
+		}
+
+		return volume.Response{Mountpoint: config.Path(v.Root)}
+	}
+
+	op := newSteps("mount", config.Name)
+	if err := v.checkManagedLimit(config.Name); err != nil {
+		return buildReponseError(op.fail("check managed disks limit", err))
+	}
+
 	if err := v.createMountPoint(config); err != nil {
-		return buildReponseError(err)
+		return buildReponseError(op.fail("create mountpoint", err))
 	}
 
-	if err := v.p.Attach(config); err != nil {
-		return buildReponseError(err)
+	if err := v.checkOwnership(config, "attach"); err != nil {
+		return buildReponseError(op.fail("verify ownership", err))
 	}
 
-	if err := v.fs.Format(config.Dev()); err != nil {
-		return buildReponseError(err)
+	source, lazy := v.lazySource(config.Name)
+	if lazy {
+		log15.Warn("disk unmounted lazily still attached, reusing it", "disk", config.Name, "source", source)
+		config.DevicePath = source
+	} else {
+		if err := v.p.Attach(config); err != nil {
+			return buildReponseError(op.fail("attach", err))
+		}
+
+		op.completed("attach", "disk was detached", func() error { return v.p.Detach(config) })
 	}
 
-	if err := v.fs.Mount(config.Dev(), config.MountPoint(v.Root)); err != nil {
-		return buildReponseError(err)
+	if err := v.resolveDevice(config); err != nil {
+		return buildReponseError(op.fail("resolve device", err))
+	}
+
+	log15.Debug("disk attached", "disk", config.Name, "device-name", config.DeviceName(), "dev", config.Dev())
+	v.reportPerformance(config)
+
+	if config.DeviceTimeout != 0 {
+		if err := v.fs.SetDeviceTimeout(config.Dev(), config.DeviceTimeout); err != nil {
+			return buildReponseError(op.fail("set device timeout", err))
+		}
+	}
+
+	if config.ReadAheadKb != 0 {
+		if err := v.fs.SetReadAhead(config.Dev(), config.ReadAheadKb); err != nil {
+			return buildReponseError(op.fail("set read-ahead", err))
+		}
+	}
+
+	fstype, formatted, err := v.format(config)
+	if err != nil {
+		return buildReponseError(op.fail("format", err))
+	}
+
+	if !formatted && !config.ReadOnly && !config.MultiWriter && !lazy {
+		if err := v.repair(config, fstype); err != nil {
+			return buildReponseError(op.fail("repair", err))
+		}
+	}
+
+	if err := v.mountDevice(config, fstype); err != nil {
+		return buildReponseError(op.fail("mount", err))
+	}
+
+	op.completed("mount", "filesystem was unmounted", func() error {
+		return v.fs.Unmount(config.MountPoint(v.Root))
+	})
+
+	if !formatted && !config.ReadOnly && !config.MultiWriter {
+		if err := v.growFilesystem(config, fstype); err != nil {
+			return buildReponseError(op.fail("grow filesystem", err))
+		}
+	}
+
+	if err := v.chownOnCreate(config, formatted); err != nil {
+		return buildReponseError(op.fail("chown", err))
+	}
+
+	if err := v.createSubpath(config); err != nil {
+		return buildReponseError(op.fail("create subpath", err))
+	}
+
+	if err := v.remapOwner(config); err != nil {
+		return buildReponseError(op.fail("set owner", err))
+	}
+
+	if err := v.setPermissions(config); err != nil {
+		return buildReponseError(op.fail("set permissions", err))
+	}
+
+	if err := v.applyIOLimits(config); err != nil {
+		return buildReponseError(op.fail("set I/O limits", err))
+	}
+
+	status := &MountStatus{
+		Name:       config.Name,
+		Source:     config.Dev(),
+		Mountpoint: config.MountPoint(v.Root),
+		Healthy:    true,
 	}
 
+	v.checkMount(status)
+	v.setMountStatus(status)
+	v.setMountRef(config.Name, r.ID)
+	v.setManaged(config.Name, true)
+
+	v.updateLabels(config, v.mountLabels(config, true))
+
 	log15.Info("disk mounted", "disk", r.Name, "elapsed", time.Since(start))
 	return volume.Response{
-		Mountpoint: config.MountPoint(v.Root),
+		Mountpoint: config.Path(v.Root),
 	}
 }
 
-func (v *Volume) createMountPoint(c *providers.DiskConfig) error {
-	target := c.MountPoint(v.Root)
-	fi, err := v.fs.Stat(target)
-	if os.IsNotExist(err) {
-		return v.fs.MkdirAll(target, 0755)
+// resolveDevice waits for the device of the attached disk and sets its
+// DevicePath, the google-* link or, on the machine types attaching the disks
+// through NVMe, its namespace. The attach returns before the kernel and udev
+// create the device, which can take seconds on slower instances, so it's
+// polled with a backoff up to WaitDeviceTimeout.
+func (v *Volume) resolveDevice(c *providers.DiskConfig) error {
+	start := time.Now()
+	for retry := 0; ; retry++ {
+		if err := v.fs.Settle(WaitDeviceTimeout - time.Since(start)); err != nil {
+			log15.Warn("error waiting for udev events", "disk", c.Name, "error", err)
+		}
+
+		dev, err := v.fs.Resolve(c.Dev(), c.DeviceName())
+		if err != nil {
+			return err
+		}
+
+		if dev != "" {
+			if retry > 0 {
+				log15.Info("disk device appeared", "disk", c.Name, "dev", dev, "elapsed", time.Since(start))
+			}
+
+			c.DevicePath = dev
+			return nil
+		}
+
+		if time.Since(start) > WaitDeviceTimeout {
+			return fmt.Errorf(
+				"device of disk %q not found at %s or among the NVMe namespaces after %s",
+				c.Name, c.Dev(), WaitDeviceTimeout,
+			)
+		}
+
+		log15.Debug("waiting for disk device", "disk", c.Name, "dev", c.Dev(), "retry", retry)
+		time.Sleep(WaitDeviceBackoff.Delay(retry))
 	}
+}
 
-	if err != nil {
+// mountDevice mounts the disk, retrying once on I/O errors: if the retry
+// succeeds the error is reported as transient, otherwise as persistent.
+func (v *Volume) mountDevice(c *providers.DiskConfig, fstype string) error {
+	options := mountOptions(c, fstype)
+	err := v.fs.Mount(c.Dev(), c.MountPoint(v.Root), fstype, options)
+	if !IsIOError(err) {
 		return err
 	}
 
-	if !fi.IsDir() {
-		return fmt.Errorf("error the mountpoint %q already exists", target)
+	log15.Warn("I/O error mounting disk, retrying", "disk", c.Name, "error", err)
+	if rerr := v.fs.Mount(c.Dev(), c.MountPoint(v.Root), fstype, options); rerr != nil {
+		v.reportIOError(c.Name, "mount", IOErrorPersistent, rerr)
+		return rerr
 	}
 
+	v.reportIOError(c.Name, "mount", IOErrorTransient, err)
 	return nil
 }
 
-func (v *Volume) Unmount(r volume.Request) volume.Response {
-	log15.Debug("unmount request received", "name", r.Name)
-	start := time.Now()
-	config, err := v.createDiskConfig(r)
-	if err != nil {
-		return buildReponseError(err)
+// ContainerSecurityContext is the SELinux context of the files the containers
+// can read and write, used by SecurityContext=container.
+var ContainerSecurityContext = "system_u:object_r:container_file_t:s0"
+
+// selinuxMountOptions are the mount options setting the SELinux context.
+var selinuxMountOptions = []string{"context", "fscontext", "defcontext", "rootcontext"}
+
+// readOnlyMountOptions skip the journal replay, which would write to the
+// device, when mounting a read-only disk.
+var readOnlyMountOptions = map[string]string{
+	"ext4": "noload",
+	"xfs":  "norecovery",
+}
+
+// checkMkfsOptions refuses the paths among the mkfs arguments, only the disk
+// of the volume can be formatted.
+func checkMkfsOptions(options []string) error {
+	for _, o := range options {
+		if strings.HasPrefix(o, "/") {
+			return fmt.Errorf("invalid mkfs option %q, paths aren't allowed", o)
+		}
 	}
 
-	if err := v.fs.Unmount(config.MountPoint(v.Root)); err != nil {
-		return buildReponseError(err)
+	return nil
+}
+
+// checkMountOptions validates the MountOptions, against FSType if given,
+// otherwise once the filesystem is known, on mount. The ro option makes the
+// disk read-only.
+func checkMountOptions(c *providers.DiskConfig) error {
+	for _, o := range c.MountOptions {
+		name := strings.SplitN(o, "=", 2)[0]
+		switch {
+		case name == "ro":
+			c.ReadOnly = true
+		case name == "data" && c.JournalMode != "":
+			return fmt.Errorf("invalid mount option %q, the journal mode is set by JournalMode", o)
+		case containsString(selinuxMountOptions, name) && c.SecurityContext != "":
+			return fmt.Errorf("invalid mount option %q, the SELinux context is set by SecurityContext", o)
+		}
 	}
 
-	if err := v.p.Detach(config); err != nil {
-		return buildReponseError(err)
+	if c.ReadOnly && containsString(c.MountOptions, "rw") {
+		return fmt.Errorf("invalid mount option \"rw\", the disk is read-only")
 	}
 
-	log15.Info("disk unmounted", "disk", r.Name, "elapsed", time.Since(start))
-	return volume.Response{}
+	if c.FSType == "" {
+		return nil
+	}
+
+	return ValidateMountOptions(c.FSType, c.MountOptions)
 }
 
-func (v *Volume) createDiskConfig(r volume.Request) (*providers.DiskConfig, error) {
-	config := &providers.DiskConfig{Name: r.Name}
+// mountOptions returns the DefaultMountOptions plus the ones requested by the
+// volume.
+func mountOptions(c *providers.DiskConfig, fstype string) []string {
+	options := append([]string{}, DefaultMountOptions...)
+	options = append(options, c.MountOptions...)
+	if c.ReadOnly {
+		if !containsString(c.MountOptions, "ro") {
+			options = append(options, "ro")
+		}
 
-	for key, value := range r.Options {
-		switch key {
-		case "Name":
-			config.Name = value
-		case "Type":
-			config.Type = value
-		case "SizeGb":
-			var err error
-			config.SizeGb, err = strconv.ParseInt(value, 10, 64)
-			if err != nil {
-				return nil, err
-			}
-		case "SourceSnapshot":
-			config.SourceSnapshot = value
-		case "SourceImage":
-			config.SourceImage = value
-		default:
-			return nil, fmt.Errorf("unknown option %q", key)
+		if o, ok := readOnlyMountOptions[fstype]; ok {
+			options = append(options, o)
 		}
 	}
 
+	if c.JournalMode != "" {
+		options = append(options, "data="+string(c.JournalMode))
+	}
+
+	if c.SecurityContext != "" {
+		options = append(options, fmt.Sprintf("context=%q", c.SecurityContext))
+	}
+
+	return options
+}
+
+func (v *Volume) reportIOError(name, stage, kind string, err error) {
+	ioErrors.WithLabelValues(name, stage, kind).Inc()
+	log15.Error("I/O error detected", "disk", name, "stage", stage, "kind", kind, "error", err)
+}
+
+// format formats the disk if it's blank, returning the filesystem to mount
+// and whether it was formatted. When the disk already contains a filesystem
+// other than the requested one, FSType or DefaultFStype, the FormatPolicy
+// decides whether it's used, reformatted or refused.
+func (v *Volume) format(c *providers.DiskConfig) (string, bool, error) {
+	requested := DefaultFStype
+	if c.FSType != "" {
+		requested = c.FSType
+	}
+
+	existing, err := v.fs.Probe(c.Dev())
+	if err != nil {
+		return "", false, err
+	}
+
+	if c.ReadOnly {
+		if existing == "" {
+			return "", false, fmt.Errorf("disk %q is blank, it can't be mounted read-only", c.Name)
+		}
+
+		return existing, false, nil
+	}
+
+	if c.MultiWriter {
+		return multiWriterFilesystem(c, existing)
+	}
+
+	switch existing {
+	case "":
+		signatures, err := v.fs.Signatures(c.Dev())
+		if err != nil {
+			return "", false, err
+		}
+
+		if len(signatures) == 0 {
+			formatDecisions.WithLabelValues(FormatOutcomeFormatted).Inc()
+			log15.Info("formatting blank disk", "disk", c.Name, "fstype", requested)
+			return requested, true, v.formatDevice(c, requested, false)
+		}
+
+		if c.FormatPolicy != providers.FormatPolicyReformat {
+			formatDecisions.WithLabelValues(FormatOutcomeRefused).Inc()
+			return "", false, fmt.Errorf(
+				"disk %q has no filesystem but isn't blank, it contains %s signatures, use FormatPolicy to reformat it",
+				c.Name, strings.Join(signatures, ", "),
+			)
+		}
+
+		formatDecisions.WithLabelValues(FormatOutcomeForced).Inc()
+		log15.Warn("reformatting disk", "disk", c.Name, "fstype", requested, "signatures", strings.Join(signatures, ","))
+		return requested, true, v.formatDevice(c, requested, true)
+	case requested:
+		formatDecisions.WithLabelValues(FormatOutcomeSkipped).Inc()
+		log15.Info("disk already formatted, skipping format", "disk", c.Name, "fstype", existing)
+		return requested, false, nil
+	}
+
+	switch c.FormatPolicy {
+	case providers.FormatPolicyUseExisting:
+		formatDecisions.WithLabelValues(FormatOutcomeSkipped).Inc()
+		log15.Warn("using existing filesystem", "disk", c.Name, "fstype", existing, "requested", requested)
+		return existing, false, nil
+	case providers.FormatPolicyReformat:
+		formatDecisions.WithLabelValues(FormatOutcomeForced).Inc()
+		log15.Warn("reformatting disk", "disk", c.Name, "fstype", requested, "existing", existing)
+		return requested, true, v.formatDevice(c, requested, true)
+	}
+
+	formatDecisions.WithLabelValues(FormatOutcomeRefused).Inc()
+	return "", false, fmt.Errorf(
+		"disk %q contains a %s filesystem but %s was requested, use FormatPolicy to mount or reformat it",
+		c.Name, existing, requested,
+	)
+}
+
+// ClusteredFSTypes are the filesystems a multi-writer disk can be mounted
+// with, the ones coordinating the writes of several instances.
+var ClusteredFSTypes = []string{"gfs2", "ocfs2"}
+
+// multiWriterFilesystem returns the filesystem of a multi-writer disk, which
+// is never formatted, refusing any that isn't clustered: mounting ext4 or xfs
+// read-write from several instances corrupts it.
+func multiWriterFilesystem(c *providers.DiskConfig, existing string) (string, bool, error) {
+	if existing == "" {
+		return "", false, fmt.Errorf(
+			"disk %q is blank, a multi-writer disk is never formatted, format it with a clustered filesystem (%s) first",
+			c.Name, strings.Join(ClusteredFSTypes, ", "),
+		)
+	}
+
+	if !containsString(ClusteredFSTypes, existing) {
+		return "", false, fmt.Errorf(
+			"disk %q contains a %s filesystem, a multi-writer disk can only be mounted with a clustered filesystem (%s)",
+			c.Name, existing, strings.Join(ClusteredFSTypes, ", "),
+		)
+	}
+
+	return existing, false, nil
+}
+
+// formatDevice formats the disk, wiping it first if requested. Wiping goes
+// through the whole device, so it can take long on big disks.
+func (v *Volume) formatDevice(c *providers.DiskConfig, fstype string, force bool) error {
+	if c.Wipe != "" {
+		start := time.Now()
+		log15.Info("wiping disk", "disk", c.Name, "method", c.Wipe)
+		if err := v.fs.Wipe(c.Dev(), string(c.Wipe)); err != nil {
+			return fmt.Errorf("error wiping disk %q: %s", c.Name, err)
+		}
+
+		log15.Info("disk wiped", "disk", c.Name, "method", c.Wipe, "elapsed", time.Since(start))
+	}
+
+	options := c.MkfsOptions
+	if len(options) == 0 {
+		options = strings.Fields(v.MkfsOptions[fstype])
+	}
+
+	if !hasLabelOption(options) {
+		options = append([]string{"-L", filesystemLabel(fstype, c.Name)}, options...)
+	}
+
+	if len(options) != 0 {
+		log15.Debug("formatting with mkfs options", "disk", c.Name, "fstype", fstype, "options", strings.Join(options, " "))
+	}
+
+	return v.fs.Format(c.Dev(), fstype, force, options)
+}
+
+// maxLabelLengths are the max. lengths of the filesystem labels by fstype.
+var maxLabelLengths = map[string]int{
+	"ext4":  16,
+	"xfs":   12,
+	"btrfs": 255,
+}
+
+// filesystemLabel returns the label of a new filesystem, the volume name
+// truncated to the max. length of the fstype, so the disk can be found by
+// label on the host with lsblk or blkid.
+func filesystemLabel(fstype, name string) string {
+	if max, ok := maxLabelLengths[fstype]; ok && len(name) > max {
+		return name[:max]
+	}
+
+	return name
+}
+
+// hasLabelOption reports whether the mkfs options already set a label.
+func hasLabelOption(options []string) bool {
+	for _, o := range options {
+		if o == "-L" || o == "--label" || strings.HasPrefix(o, "--label=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repair checks and repairs the filesystem of a disk that wasn't cleanly
+// unmounted, as found by Reconcile, or of any disk with Fsck.
+func (v *Volume) repair(c *providers.DiskConfig, fstype string) error {
+	v.Lock()
+	dirty := v.dirty[c.Name]
+	v.Unlock()
+
+	if !dirty && !c.Fsck {
+		return nil
+	}
+
+	start := time.Now()
+	if !dirty {
+		log15.Info("checking filesystem", "disk", c.Name, "fstype", fstype)
+		if err := v.fs.Repair(c.Dev(), fstype); err != nil {
+			return fmt.Errorf("error checking filesystem of disk %q: %s", c.Name, err)
+		}
+
+		log15.Info("filesystem checked", "disk", c.Name, "fstype", fstype, "elapsed", time.Since(start))
+		return nil
+	}
+
+	log15.Warn("repairing filesystem of dirty disk", "disk", c.Name, "fstype", fstype)
+	if err := v.fs.Repair(c.Dev(), fstype); err != nil {
+		return fmt.Errorf("error repairing filesystem of disk %q, it wasn't cleanly unmounted: %s", c.Name, err)
+	}
+
+	log15.Info("filesystem repaired", "disk", c.Name, "fstype", fstype, "elapsed", time.Since(start))
+
+	v.Lock()
+	delete(v.dirty, c.Name)
+	v.Unlock()
+	return nil
+}
+
+// growFilesystem grows an existing filesystem smaller than the disk, usually
+// resized while detached, verifying that it reached the disk size. If it
+// didn't, the kernel may still see the previous size of the device, so the
+// device is rescanned and the filesystem grown again.
+func (v *Volume) growFilesystem(c *providers.DiskConfig, fstype string) error {
+	d, err := v.p.Get(c)
+	if err != nil {
+		return err
+	}
+
+	expected := d.SizeGb << 30
+	size, err := v.fs.Size(c.MountPoint(v.Root))
+	if err != nil || grown(size, expected) {
+		return err
+	}
+
+	log15.Info("growing filesystem", "disk", c.Name, "fstype", fstype, "size", size, "disk-size", expected)
+	for _, rescan := range []bool{false, true} {
+		if rescan {
+			log15.Warn("filesystem didn't grow, rescanning device", "disk", c.Name, "size", size, "disk-size", expected)
+			if err := v.fs.Rescan(c.Dev()); err != nil {
+				return fmt.Errorf("error rescanning device of disk %q: %s", c.Name, err)
+			}
+		}
+
+		if err := v.fs.Grow(c.Dev(), c.MountPoint(v.Root), fstype); err != nil {
+			return fmt.Errorf("error growing filesystem of disk %q: %s", c.Name, err)
+		}
+
+		if size, err = v.fs.Size(c.MountPoint(v.Root)); err != nil {
+			return err
+		}
+
+		if grown(size, expected) {
+			log15.Info("filesystem grown", "disk", c.Name, "size", size)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("filesystem of disk %q didn't grow, size %d bytes, disk %d bytes", c.Name, size, expected)
+}
+
+// growMounted grows the filesystem of a disk mounted on this host, usually
+// just resized by a create with a bigger SizeGb, without waiting for its next
+// mount. The filesystems can grow while mounted.
+func (v *Volume) growMounted(c *providers.DiskConfig) error {
+	v.Lock()
+	_, mounted := v.mounts[c.Name]
+	v.Unlock()
+
+	if !mounted || c.ReadOnly || c.MultiWriter {
+		return nil
+	}
+
+	c.DevicePath = v.mountSource(c)
+	fstype, err := v.fs.Probe(c.Dev())
+	if err != nil {
+		return err
+	}
+
+	return v.growFilesystem(c, fstype)
+}
+
+// grown reports whether the filesystem uses the disk, the filesystem metadata
+// isn't counted in its size.
+func grown(size, expected int64) bool {
+	return float64(size) >= float64(expected)*GrowthTolerance
+}
+
+// remapOwner maps the mountpoint, the root of the filesystem, and the
+// Subpath directories, still owned by the host root, into the remapped user
+// namespace, giving them to its root user, so rootless and userns-remap
+// containers can write to new and existing filesystems. It runs on every
+// mount, the directories owned by someone else are kept.
+func (v *Volume) remapOwner(c *providers.DiskConfig) error {
+	if (v.UIDOffset == 0 && v.GIDOffset == 0) || c.ReadOnly {
+		return nil
+	}
+
+	uid, gid := v.UIDOffset, v.GIDOffset
+	dirs := []string{c.MountPoint(v.Root)}
+	if c.Subpath != "" {
+		for _, part := range strings.Split(c.Subpath, "/") {
+			dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], part))
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := v.fs.RemapOwner(dir, uid, gid); err != nil {
+			return fmt.Errorf("error remapping owner of disk %q: %s", c.Name, err)
+		}
+	}
+
+	log15.Debug("volume owner remapped", "disk", c.Name, "uid", uid, "gid", gid)
+	return nil
+}
+
+// chownOnCreate gives all the files of the filesystem to the owner set by
+// ChownOnCreate, on the first mount of the disk, e.g. restored from a snapshot
+// taken with other ids, and after formatting it. The chowned disks are saved
+// in the state, so the walk isn't repeated on every mount. The ids are the
+// ones of the containers, shifted by the offsets of the remapped user
+// namespace.
+func (v *Volume) chownOnCreate(c *providers.DiskConfig, formatted bool) error {
+	if c.ChownUID == nil || (v.isChowned(c.Name) && !formatted) {
+		return nil
+	}
+
+	start := time.Now()
+	uid, gid := *c.ChownUID+v.UIDOffset, *c.ChownGID+v.GIDOffset
+	if err := v.fs.SetOwnerRecursive(c.MountPoint(v.Root), uid, gid); err != nil {
+		return fmt.Errorf("error changing owner of the files of disk %q: %s", c.Name, err)
+	}
+
+	log15.Info("volume files owner changed", "disk", c.Name, "uid", uid, "gid", gid, "elapsed", time.Since(start))
+	v.setChowned(c.Name, true)
+	return nil
+}
+
+func (v *Volume) setChowned(name string, chowned bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	if chowned {
+		v.chowned[name] = true
+	} else {
+		delete(v.chowned, name)
+	}
+}
+
+func (v *Volume) isChowned(name string) bool {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.chowned[name]
+}
+
+// setPermissions sets the owner and the mode of the root of the filesystem,
+// or of the Subpath, given by the Uid, Gid and Mode options, on every mount,
+// so non-root containers can write to it. The ids are the ones of the
+// containers, shifted by the offsets of the remapped user namespace.
+func (v *Volume) setPermissions(c *providers.DiskConfig) error {
+	target := c.Path(v.Root)
+	if c.UID != nil || c.GID != nil {
+		uid, gid := -1, -1
+		if c.UID != nil {
+			uid = *c.UID + v.UIDOffset
+		}
+
+		if c.GID != nil {
+			gid = *c.GID + v.GIDOffset
+		}
+
+		log15.Info("setting volume owner", "disk", c.Name, "uid", uid, "gid", gid)
+		if err := v.fs.SetOwner(target, uid, gid); err != nil {
+			return fmt.Errorf("error setting owner of disk %q: %s", c.Name, err)
+		}
+	}
+
+	if c.FileMode != 0 {
+		log15.Info("setting volume mode", "disk", c.Name, "mode", fmt.Sprintf("%04o", c.FileMode))
+		if err := v.fs.SetMode(target, c.FileMode); err != nil {
+			return fmt.Errorf("error setting mode of disk %q: %s", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// createSubpath creates the Subpath directories within the mounted
+// filesystem, refusing the symlinks, a container could otherwise point the
+// Subpath of the next mount anywhere on the host.
+func (v *Volume) createSubpath(c *providers.DiskConfig) error {
+	if c.Subpath == "" {
+		return nil
+	}
+
+	dir := c.MountPoint(v.Root)
+	for _, part := range strings.Split(c.Subpath, "/") {
+		dir = filepath.Join(dir, part)
+		fi, err := lstat(v.fs, dir)
+		if os.IsNotExist(err) {
+			if err := v.fs.Mkdir(dir, 0755); err != nil {
+				return fmt.Errorf("error creating subpath %q of disk %q: %s", c.Subpath, c.Name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid subpath %q of disk %q, %s is a symlink", c.Subpath, c.Name, dir)
+		}
+
+		if !fi.IsDir() {
+			return fmt.Errorf("invalid subpath %q of disk %q, %s isn't a directory", c.Subpath, c.Name, dir)
+		}
+	}
+
+	return nil
+}
+
+func lstat(fs afero.Fs, name string) (os.FileInfo, error) {
+	if l, ok := fs.(afero.Lstater); ok {
+		fi, _, err := l.LstatIfPossible(name)
+		return fi, err
+	}
+
+	return fs.Stat(name)
+}
+
+func (v *Volume) createMountPoint(c *providers.DiskConfig) error {
+	target := c.MountPoint(v.Root)
+	fi, err := v.fs.Stat(target)
+	if os.IsNotExist(err) {
+		return v.fs.MkdirAll(target, 0755)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("error the mountpoint %q already exists", target)
+	}
+
+	return nil
+}
+
+func (v *Volume) Unmount(r volume.Request) volume.Response {
+	return v.withDeadline("unmount", r, recovered("unmount", v.unmount))
+}
+
+func (v *Volume) unmount(r volume.Request) volume.Response {
+	return v.unmountVolume(r, v.LazyUnmount)
+}
+
+// unmountVolume unmounts and detaches the disk once its last reference is
+// released, with lazy a mountpoint still busy is unmounted lazily.
+func (v *Volume) unmountVolume(r volume.Request, lazy bool) volume.Response {
+	log15.Debug("unmount request received", "name", r.Name)
+	start := time.Now()
+	config, err := v.createDiskConfig(r)
+	if err != nil {
+		return buildReponseError(err)
+	}
+
+	if refs := v.releaseMount(config.Name, r.ID); refs > 0 {
+		log15.Info("disk still used, keeping it mounted", "disk", r.Name, "id", r.ID, "references", refs)
+		return volume.Response{}
+	}
+
+	if _, ok := v.lazySource(config.Name); ok {
+		log15.Warn("disk unmounted lazily, kept attached", "disk", r.Name)
+		return volume.Response{}
+	}
+
+	op := newSteps("unmount", config.Name)
+	lazily, err := v.unmountDevice(config, lazy)
+	if err != nil {
+		return buildReponseError(op.fail("unmount", err))
+	}
+
+	op.completed("unmount", "", nil)
+	dev := v.mountSource(config)
+	if lazily {
+		v.setMountStatus(&MountStatus{
+			Name:       config.Name,
+			Source:     dev,
+			Mountpoint: config.MountPoint(v.Root),
+			Lazy:       true,
+			Error:      "unmounted lazily while busy, kept attached until the processes holding it exit",
+		})
+
+		log15.Warn("disk unmounted lazily, kept attached", "disk", r.Name, "elapsed", time.Since(start))
+		return volume.Response{}
+	}
+
+	v.deleteMountStatus(config.Name)
+	v.clearIOLimits(config)
+	clearPerformance(config)
+	if v.FlushOnUnmount {
+		if err := v.fs.Flush(dev); err != nil {
+			return buildReponseError(op.fail("flush", err))
+		}
+
+		op.completed("flush", "", nil)
+	}
+
+	if err := v.p.Detach(config); err != nil {
+		return buildReponseError(op.fail("detach", err))
+	}
+
+	v.updateLabels(config, v.mountLabels(config, false))
+
+	log15.Info("disk unmounted", "disk", r.Name, "elapsed", time.Since(start))
+	return volume.Response{}
+}
+
+// unmountDevice unmounts the disk, retrying up to UnmountRetries times while
+// the mountpoint is busy, usually by a process of the stopped container still
+// exiting. If it's still busy the error names the processes holding it, or
+// with lazy the mount is detached lazily, the kernel finishing it once they
+// exit, returning true: the disk can't be flushed nor detached while they
+// still write to it.
+func (v *Volume) unmountDevice(c *providers.DiskConfig, lazy bool) (bool, error) {
+	target := c.MountPoint(v.Root)
+	err := v.fs.Unmount(target)
+	if IsNotMountedError(err) {
+		log15.Warn("disk not mounted, skipping unmount", "disk", c.Name, "mnt", target)
+		return false, nil
+	}
+
+	for retry := 0; IsBusyError(err) && retry < v.UnmountRetries; retry++ {
+		log15.Warn("mountpoint busy, retrying unmount", "disk", c.Name, "mnt", target, "retry", retry)
+		time.Sleep(UnmountRetryInterval)
+		err = v.fs.Unmount(target)
+	}
+
+	if !IsBusyError(err) {
+		return false, err
+	}
+
+	holders, herr := v.fs.Holders(target)
+	if herr != nil {
+		log15.Warn("error listing the processes using the mountpoint", "disk", c.Name, "mnt", target, "error", herr)
+	}
+
+	if !lazy {
+		if len(holders) == 0 {
+			return false, err
+		}
+
+		return false, fmt.Errorf("%s, used by %s", strings.TrimSpace(err.Error()), strings.Join(holders, ", "))
+	}
+
+	log15.Warn("lazily unmounting busy mountpoint", "disk", c.Name, "mnt", target, "holders", strings.Join(holders, ", "))
+	if err := v.fs.LazyUnmount(target); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// disableLabels stops updating the labels, warning once, when the service
+// account lacks the compute.disks.setLabels permission.
+func (v *Volume) disableLabels(c *providers.DiskConfig, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if v.nolabels {
+		return
+	}
+
+	v.nolabels = true
+	log15.Warn("disk label updates disabled, the service account lacks the compute.disks.setLabels permission",
+		"disk", c.Name, "error", err,
+	)
+}
+
+// mountLabels returns the labels set on the disk while it's mounted, or the
+// ones removing them once unmounted.
+func (v *Volume) mountLabels(c *providers.DiskConfig, mounted bool) map[string]string {
+	labels := make(map[string]string, 0)
+	if c.Consumer != "" {
+		labels[LabelConsumer] = ""
+		if mounted {
+			labels[LabelConsumer] = providers.LabelValue(c.Consumer)
+		}
+	}
+
+	if v.RepairDirtyMounts {
+		labels[LabelDirtyMount] = ""
+		if mounted {
+			labels[LabelDirtyMount] = providers.LabelValue(v.instance)
+		}
+	}
+
+	return labels
+}
+
+// updateLabels updates the disk labels in the background, the labels are
+// informative so a failure is only logged. The updates of a disk are applied
+// in order, so the labels of an unmount aren't overwritten by its mount.
+// Once an update fails for lack of permissions the updates are disabled.
+func (v *Volume) updateLabels(c *providers.DiskConfig, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	v.Lock()
+	if v.nolabels || !v.addBackground() {
+		v.Unlock()
+		return
+	}
+
+	previous := v.labeling[c.Name]
+	done := make(chan struct{})
+	v.labeling[c.Name] = done
+	v.Unlock()
+
+	go func() {
+		defer v.background.Done()
+		defer close(done)
+
+		if previous != nil {
+			<-previous
+		}
+
+		if err := v.p.UpdateLabels(c, labels); err != nil {
+			if providers.IsPermissionError(err) {
+				v.disableLabels(c, err)
+			} else {
+				log15.Warn("error updating disk labels", "disk", c.Name, "labels", labels, "error", err)
+			}
+		}
+
+		v.Lock()
+		if v.labeling[c.Name] == done {
+			delete(v.labeling, c.Name)
+		}
+		v.Unlock()
+	}()
+}
+
+// setOptions records the options a volume was created with, Docker only
+// sends them on create but many of them are needed to mount the volume.
+func (v *Volume) setOptions(name string, options map[string]string) {
+	v.Lock()
+	defer v.Unlock()
+
+	if len(options) == 0 {
+		delete(v.options, name)
+		return
+	}
+
+	v.options[name] = options
+}
+
+func (v *Volume) requestOptions(r volume.Request) map[string]string {
+	v.Lock()
+	defer v.Unlock()
+
+	options := make(map[string]string, 0)
+	for key, value := range v.options[r.Name] {
+		options[key] = value
+	}
+
+	for key, value := range r.Options {
+		options[key] = value
+	}
+
+	return options
+}
+
+func (v *Volume) createDiskConfig(r volume.Request) (*providers.DiskConfig, error) {
+	config, err := v.parseDiskConfig(r)
+	if err != nil {
+		return nil, withCode(ErrorCodeInvalidArgument, err)
+	}
+
+	if err := v.checkSourceProjects(config); err != nil {
+		return nil, err
+	}
+
+	if err := v.checkProject(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (v *Volume) parseDiskConfig(r volume.Request) (*providers.DiskConfig, error) {
+	config := &providers.DiskConfig{
+		Name:             DiskName(r.Name),
+		KmsKeyName:       v.DefaultKmsKeyName,
+		DefaultType:      v.DefaultType,
+		DefaultSizeGb:    v.DefaultSizeGb,
+		IgnoreDrift:      v.IgnoreDrift,
+		SnapshotOnRemove: v.SnapshotOnRemove,
+		Fsck:             v.Fsck,
+		Labels:           make(map[string]string, 0),
+	}
+	config.ResourcePolicies = append(config.ResourcePolicies, v.ResourcePolicies...)
+
+	options, err := canonicalOptions(v.requestOptions(r))
+	if err != nil {
+		return nil, err
+	}
+
+	options, err = v.profileOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range options {
+		switch key {
+		case "Name":
+			config.Name = value
+		case "Project":
+			config.Project = value
+		case "Type":
+			config.Type = value
+		case "SizeGb", "Size":
+			if config.SizeGb != 0 {
+				return nil, fmt.Errorf("invalid options, SizeGb and Size can't be used at the same time")
+			}
+
+			var err error
+			config.SizeGb, err = ParseSize(value)
+			if err != nil {
+				return nil, err
+			}
+		case "Regional":
+			var err error
+			config.Regional, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "ReplicaZones":
+			config.ReplicaZones = strings.Split(value, ",")
+		case "SourceSnapshot":
+			config.SourceSnapshot = value
+		case "SourceSnapshotLabels":
+			var err error
+			config.SourceSnapshotLabels, err = parseLabels(value)
+			if err != nil {
+				return nil, err
+			}
+		case "Labels":
+			labels, err := parseLabels(value)
+			if err != nil {
+				return nil, err
+			}
+
+			for k, l := range labels {
+				config.Labels[k] = l
+			}
+		case "SourceImage":
+			config.SourceImage = value
+		case "SourceDisk":
+			config.SourceDisk = value
+		case "Description":
+			config.Description = value
+		case "DeviceName":
+			config.CustomDeviceName = value
+		case "Licenses":
+			config.Licenses = strings.Split(value, ",")
+		case "ResourcePolicies":
+			config.ResourcePolicies = mergeResourcePolicies(v.ResourcePolicies, value)
+		case "SnapshotSchedule":
+			config.SnapshotSchedule = value
+		case "DryRun":
+			config.DryRun, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "KmsKeyName", "KmsKey":
+			config.KmsKeyName = value
+		case "CsekKey":
+			config.CsekKey = value
+		case "Wipe":
+			config.Wipe = providers.WipeMethod(value)
+		case "SizePolicy":
+			config.SizePolicy = providers.SizePolicy(value)
+		case "MultiWriter":
+			var err error
+			config.MultiWriter, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "Mode":
+			switch value {
+			case "rw":
+			case "ro":
+				config.ReadOnly = true
+			default:
+				mode, err := strconv.ParseUint(value, 8, 32)
+				if err != nil || mode == 0 {
+					return nil, fmt.Errorf("invalid mode %q, must be rw, ro or an octal permission like 0770", value)
+				}
+
+				config.FileMode = uint32(mode)
+			}
+		case "Uid", "Gid":
+			id, err := strconv.Atoi(value)
+			if err != nil || id < 0 {
+				return nil, fmt.Errorf("invalid %s %q, must be a number", key, value)
+			}
+
+			if key == "Uid" {
+				config.UID = &id
+			} else {
+				config.GID = &id
+			}
+		case "ChownOnCreate":
+			ids := strings.SplitN(value, ":", 2)
+			if len(ids) != 2 {
+				return nil, fmt.Errorf("invalid ChownOnCreate %q, must be uid:gid, like 999:999", value)
+			}
+
+			uid, uerr := strconv.Atoi(ids[0])
+			gid, gerr := strconv.Atoi(ids[1])
+			if uerr != nil || gerr != nil || uid < 0 || gid < 0 {
+				return nil, fmt.Errorf("invalid ChownOnCreate %q, must be uid:gid, like 999:999", value)
+			}
+
+			config.ChownUID, config.ChownGID = &uid, &gid
+		case "FSType":
+			config.FSType = value
+		case "JournalMode":
+			config.JournalMode = providers.JournalMode(value)
+		case "MkfsOptions":
+			config.MkfsOptions = strings.Fields(value)
+			if err := checkMkfsOptions(config.MkfsOptions); err != nil {
+				return nil, err
+			}
+		case "MountOptions":
+			for _, o := range strings.Split(value, ",") {
+				if o = strings.TrimSpace(o); o != "" {
+					config.MountOptions = append(config.MountOptions, o)
+				}
+			}
+		case "SecurityContext":
+			config.SecurityContext = value
+			if value == "container" {
+				config.SecurityContext = ContainerSecurityContext
+			}
+		case "FormatPolicy":
+			config.FormatPolicy = providers.FormatPolicy(value)
+		case "ForceFormat":
+			var err error
+			config.ForceFormat, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "ReadIopsLimit", "WriteIopsLimit", "ReadBpsLimit", "WriteBpsLimit":
+			if err := parseIOLimit(config, key, value); err != nil {
+				return nil, err
+			}
+		case "DeviceTimeout":
+			var err error
+			config.DeviceTimeout, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DeviceTimeout %q: %s", value, err)
+			}
+		case "ReadAheadKb":
+			var err error
+			config.ReadAheadKb, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ReadAheadKb %q: %s", value, err)
+			}
+		case "ProvisionedIops":
+			var err error
+			config.ProvisionedIops, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ProvisionedIops %q: %s", value, err)
+			}
+		case "ProvisionedThroughput":
+			var err error
+			config.ProvisionedThroughput, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ProvisionedThroughput %q: %s", value, err)
+			}
+		case "StoragePool":
+			config.StoragePool = value
+		case "Consumer":
+			config.Consumer = value
+		case "ForceOwnership":
+			var err error
+			config.ForceOwnership, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "WaitFor":
+			config.WaitFor = providers.WaitFor(value)
+		case "SnapshotOnRemove":
+			var err error
+			config.SnapshotOnRemove, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "Subpath":
+			config.Subpath = value
+		case "Fsck":
+			var err error
+			config.Fsck, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "ReclaimPolicy":
+			config.ReclaimPolicy = providers.ReclaimPolicy(value)
+		case "Exists", "NoCreate":
+			var err error
+			config.Exists, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		case "AllowTypeChange":
+			var err error
+			config.AllowTypeChange, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if !strings.HasPrefix(key, LabelOptionPrefix) {
+				return nil, fmt.Errorf("unknown option %q", key)
+			}
+
+			config.Labels[strings.TrimPrefix(key, LabelOptionPrefix)] = value
+		}
+	}
+
+	for _, k := range []string{
+		LabelConsumer, LabelDirtyMount, LabelOwnerToken, LabelReclaimPolicy, LabelVolumeName,
+		providers.LabelCreatedBy, providers.LabelInstance, providers.LabelInstanceProject,
+	} {
+		if _, ok := config.Labels[k]; ok {
+			return nil, fmt.Errorf("invalid label %q, it's managed by the plugin", k)
+		}
+	}
+
+	for k := range config.Labels {
+		if strings.HasPrefix(k, LabelVolumeName+"-") {
+			return nil, fmt.Errorf("invalid label %q, it's managed by the plugin", k)
+		}
+	}
+
+	// a customer-supplied key replaces the default KMS key
+	if config.CsekKey != "" && config.KmsKeyName == v.DefaultKmsKeyName {
+		config.KmsKeyName = ""
+	}
+
+	if err := checkMountOptions(config); err != nil {
+		return nil, err
+	}
+
 	return config, config.Validate()
 }
 
+// checkOwnership verifies that the disk carries the OwnerToken of this
+// plugin, set when it created the disk, so a disk with the same name created
+// by another daemon is never attached or removed, unless ForceOwnership is
+// set.
+func (v *Volume) checkOwnership(c *providers.DiskConfig, operation string) error {
+	if v.OwnerToken == "" {
+		return nil
+	}
+
+	d, err := v.p.Get(c)
+	if err != nil {
+		return fmt.Errorf("error verifying ownership of disk %q: %s", c.Name, err)
+	}
+
+	token := d.Labels[LabelOwnerToken]
+	if token == v.OwnerToken {
+		return nil
+	}
+
+	if c.ForceOwnership {
+		log15.Warn("ownership token mismatch, forced", "disk", c.Name, "operation", operation, "token", token)
+		return nil
+	}
+
+	return withCode(ErrorCodeConflict, fmt.Errorf(
+		"refusing to %s disk %q, its ownership token %q doesn't match the one of this plugin, use ForceOwnership to override",
+		operation, c.Name, token,
+	))
+}
+
+// checkSourceProjects verifies that the image or snapshot the disk is created
+// from belongs to one of the SourceProjects, if any. The sources without a
+// project are resolved in the project of the disk, always allowed when it's
+// the local one.
+func (v *Volume) checkSourceProjects(c *providers.DiskConfig) error {
+	if len(v.SourceProjects) == 0 {
+		return nil
+	}
+
+	projects := make(map[string]string, 0)
+	if c.SourceImage != "" {
+		projects["SourceImage"] = providers.ResourceProject(c.SourceImage, c.Project)
+	}
+
+	if c.SourceSnapshot != "" {
+		projects["SourceSnapshot"] = providers.ResourceProject(c.SourceSnapshot, c.Project)
+	}
+
+	if c.SourceDisk != "" {
+		projects["SourceDisk"] = providers.ResourceProject(c.SourceDisk, c.Project)
+	}
+
+	if len(c.SourceSnapshotLabels) != 0 {
+		projects["SourceSnapshotLabels"] = c.Project
+	}
+
+	for key, project := range projects {
+		if project == "" || containsString(v.SourceProjects, project) {
+			continue
+		}
+
+		return withCode(ErrorCodePermissionDenied, fmt.Errorf(
+			"%s of project %q not allowed, the allowed source projects are: %s",
+			key, project, strings.Join(v.SourceProjects, ", "),
+		))
+	}
+
+	return nil
+}
+
+// checkProject verifies that the Project of the disk is one of the Projects,
+// if any, the disks without a Project are in the instance project.
+func (v *Volume) checkProject(c *providers.DiskConfig) error {
+	if len(v.Projects) == 0 || c.Project == "" || containsString(v.Projects, c.Project) {
+		return nil
+	}
+
+	return withCode(ErrorCodePermissionDenied, fmt.Errorf(
+		"project %q not allowed, the allowed projects are: %s", c.Project, strings.Join(v.Projects, ", "),
+	))
+}
+
+// mergeResourcePolicies adds the comma separated policies to the default ones,
+// none removes the defaults.
+func mergeResourcePolicies(defaults []string, value string) []string {
+	if value == "none" {
+		return nil
+	}
+
+	policies := append([]string{}, defaults...)
+	for _, p := range strings.Split(value, ",") {
+		if !containsString(policies, p) {
+			policies = append(policies, p)
+		}
+	}
+
+	return policies
+}
+
+// CheckResourcePolicies verifies that the default ResourcePolicies exist.
+func (v *Volume) CheckResourcePolicies() error {
+	return v.p.CheckResourcePolicies(v.ResourcePolicies)
+}
+
+func parseIOLimit(c *providers.DiskConfig, key, value string) error {
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %s", key, value, err)
+	}
+
+	switch key {
+	case "ReadIopsLimit":
+		c.ReadIopsLimit = limit
+	case "WriteIopsLimit":
+		c.WriteIopsLimit = limit
+	case "ReadBpsLimit":
+		c.ReadBpsLimit = limit
+	case "WriteBpsLimit":
+		c.WriteBpsLimit = limit
+	}
+
+	return nil
+}
+
+// parseLabels parses a comma separated list of key=value pairs.
+func parseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string, 0)
+	for _, pair := range strings.Split(value, ",") {
+		p := strings.SplitN(pair, "=", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+
+		labels[p[0]] = p[1]
+	}
+
+	return labels, nil
+}
+
+var sizeFormat = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?) ?([a-zA-Z]*)$`)
+
+// sizeUnits are the size units in GB. GCE sizes are binary, a GB is 2^30
+// bytes and a TB 1024 GB, so the decimal and binary units are equivalent.
+var sizeUnits = map[string]float64{
+	"": 1, "g": 1, "gb": 1, "gib": 1,
+	"t": 1024, "tb": 1024, "tib": 1024,
+	"m": 1.0 / 1024, "mb": 1.0 / 1024, "mib": 1.0 / 1024,
+}
+
+// ParseSize parses a size in GB, a bare number, or with a unit like 100G, 1T
+// or 500GiB, returning it in GB. Sizes that aren't a whole number of GB are
+// refused, GCE can't create them.
+func ParseSize(value string) (int64, error) {
+	m := sizeFormat.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q, expected a number of GB or a size like 100G, 1T or 500GiB", value)
+	}
+
+	unit, ok := sizeUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q, unknown unit %q, valid ones are M, G and T", value, m[2])
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %s", value, err)
+	}
+
+	gb := n * unit
+	if gb < 1 {
+		return 0, fmt.Errorf("invalid size %q, the min. size is 1 GB", value)
+	}
+
+	if gb != math.Trunc(gb) {
+		return 0, fmt.Errorf("invalid size %q, must be a whole number of GB, %g GB isn't", value, gb)
+	}
+
+	return int64(gb), nil
+}
+
+// recovered wraps a handler converting any panic into an error response, so
+// a bug handling one volume doesn't take down the whole plugin.
+func recovered(method string, h func(volume.Request) volume.Response) func(volume.Request) volume.Response {
+	return func(r volume.Request) (resp volume.Response) {
+		defer func() {
+			if p := recover(); p != nil {
+				recoveredPanics.WithLabelValues(method).Inc()
+				log15.Error("panic handling request",
+					"method", method, "name", r.Name, "panic", p, "stack", string(debug.Stack()),
+				)
+
+				resp = buildReponseError(withCode(ErrorCodeInternal,
+					fmt.Errorf("internal error handling %s of %q: %v", method, r.Name, p),
+				))
+			}
+		}()
+
+		return h(r)
+	}
+}
+
 func buildReponseError(err error) volume.Response {
-	log15.Error("request failed", "error", err.Error())
-	return volume.Response{Err: err.Error()}
+	log15.Error("request failed", "error", err.Error(), "code", ErrorCode(err))
+	return errorResponse(err)
 }
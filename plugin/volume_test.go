@@ -2,16 +2,25 @@ package plugin
 
 import (
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/bloomapi/gce-docker/providers"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/afero"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 	. "gopkg.in/check.v1"
 )
 
-const TimeoutAfterUnmount = 15 * time.Second
+const (
+	TimeoutAfterUnmount = 15 * time.Second
+	MaxFixtureSlots     = 16
+)
 
 type VolumeSuite struct {
 	v  *Volume
@@ -24,7 +33,7 @@ var _ = Suite(&VolumeSuite{})
 func (s *VolumeSuite) SetUpTest(c *C) {
 	s.fs = NewMemFilesystem()
 	s.p = NewDiskProviderFixture()
-	s.v = &Volume{p: s.p, fs: s.fs, Root: "/mnt/"}
+	s.v = newVolume(s.p, s.fs)
 }
 
 func (s *VolumeSuite) TestCreateDiskConfig(c *C) {
@@ -39,6 +48,19 @@ func (s *VolumeSuite) TestCreateDiskConfig(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(config.SizeGb, Equals, int64(42))
 
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Size": "1T"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.SizeGb, Equals, int64(1024))
+
+	_, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Size": "1T", "SizeGb": "10"},
+	})
+	c.Assert(err, ErrorMatches, "invalid options, SizeGb and Size can't be used at the same time")
+
 	config, err = s.v.createDiskConfig(volume.Request{
 		Name:    "foo",
 		Options: map[string]string{"Type": "foo"},
@@ -59,6 +81,148 @@ func (s *VolumeSuite) TestCreateDiskConfig(c *C) {
 	})
 	c.Assert(err, IsNil)
 	c.Assert(config.SourceImage, Equals, "foo")
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Licenses": "projects/foo/global/licenses/bar,projects/foo/global/licenses/qux"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.Licenses, DeepEquals, []string{"projects/foo/global/licenses/bar", "projects/foo/global/licenses/qux"})
+
+	_, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Licenses": "bar"},
+	})
+	c.Assert(err, NotNil)
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"SourceSnapshotLabels": "app=foo,env=prod"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.SourceSnapshotLabels, DeepEquals, map[string]string{"app": "foo", "env": "prod"})
+
+	_, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"SourceSnapshotLabels": "app"},
+	})
+	c.Assert(err, NotNil)
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"AllowTypeChange": "true"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.AllowTypeChange, Equals, true)
+
+	_, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"AllowTypeChange": "foo"},
+	})
+	c.Assert(err, NotNil)
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Type": "hyperdisk-balanced", "ProvisionedIops": "5000", "ProvisionedThroughput": "200"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.ProvisionedIops, Equals, int64(5000))
+	c.Assert(config.ProvisionedThroughput, Equals, int64(200))
+
+	_, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"ProvisionedIops": "fast"},
+	})
+	c.Assert(err, ErrorMatches, `invalid ProvisionedIops "fast": .*`)
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Regional": "true", "ReplicaZones": "us-central1-a,us-central1-b"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.Regional, Equals, true)
+	c.Assert(config.ReplicaZones, DeepEquals, []string{"us-central1-a", "us-central1-b"})
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"KmsKey": "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.KmsKeyName, Equals, "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux")
+}
+
+func (s *VolumeSuite) TestCreateDiskConfigResourcePolicies(c *C) {
+	config, err := s.v.createDiskConfig(volume.Request{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(config.ResourcePolicies, HasLen, 0)
+
+	s.v.ResourcePolicies = []string{"daily"}
+	config, err = s.v.createDiskConfig(volume.Request{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(config.ResourcePolicies, DeepEquals, []string{"daily"})
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"ResourcePolicies": "weekly,daily"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.ResourcePolicies, DeepEquals, []string{"daily", "weekly"})
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"ResourcePolicies": "none"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.ResourcePolicies, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestCreateDiskConfigDefaults(c *C) {
+	s.v.DefaultSizeGb = 100
+	s.v.DefaultType = "pd-balanced"
+
+	config, err := s.v.createDiskConfig(volume.Request{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(config.DefaultSizeGb, Equals, int64(100))
+	c.Assert(config.DefaultType, Equals, "pd-balanced")
+	c.Assert(config.Type, Equals, "")
+
+	config, err = s.v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{"Type": "pd-ssd"}})
+	c.Assert(err, IsNil)
+	c.Assert(config.Type, Equals, "pd-ssd")
+
+	config, err = s.v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{"Exists": "true"}})
+	c.Assert(err, IsNil)
+	c.Assert(config.Type, Equals, "")
+}
+
+func (s *VolumeSuite) TestCreateDiskConfigDefaultKmsKey(c *C) {
+	s.v.DefaultKmsKeyName = "projects/foo/locations/global/keyRings/bar/cryptoKeys/default"
+
+	config, err := s.v.createDiskConfig(volume.Request{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(config.KmsKeyName, Equals, s.v.DefaultKmsKeyName)
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"KmsKeyName": "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.KmsKeyName, Equals, "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux")
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"Description": "postgres data"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.Description, Equals, "postgres data")
+
+	config, err = s.v.createDiskConfig(volume.Request{
+		Name:    "foo",
+		Options: map[string]string{"CsekKey": "SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0="},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(config.KmsKeyName, Equals, "")
+	c.Assert(config.CsekKey, Equals, "SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0=")
 }
 
 func (s *VolumeSuite) TestCreate(c *C) {
@@ -69,6 +233,92 @@ func (s *VolumeSuite) TestCreate(c *C) {
 	c.Assert(s.p.disks["foo"], Equals, true)
 }
 
+func (s *VolumeSuite) TestCreateExists(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Exists": "true"}})
+	c.Assert(r.Err, Matches, `unable to adopt disk "foo": .*`)
+	c.Assert(s.p.disks, HasLen, 0)
+
+	s.p.disks["foo"] = true
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"NoCreate": "true", "SizeGb": "42"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.sizes["foo"], Equals, int64(0))
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Exists": "true", "SourceImage": "foo"}})
+	c.Assert(r.Err, Equals, "invalid disk config, an existing disk can't be created from a source")
+}
+
+func (s *VolumeSuite) TestCreateLabels(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{
+		"Labels": "team=infra,env=prod", "Label.cost-center": "42",
+	}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["foo"], DeepEquals, map[string]string{"team": "infra", "env": "prod", "cost-center": "42"})
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Labels": "Team=infra"}})
+	c.Assert(r.Err, Matches, `invalid disk config, invalid label key "Team", .*`)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Label.used-by": "foo"}})
+	c.Assert(r.Err, Equals, `invalid label "used-by", it's managed by the plugin`)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Label.created-by": "foo"}})
+	c.Assert(r.Err, Equals, `invalid label "created-by", it's managed by the plugin`)
+}
+
+func (s *VolumeSuite) TestCreateWaitFor(c *C) {
+	WaitStatusInterval = time.Millisecond
+	s.p.status["foo"] = []string{"CREATING", "CREATING"}
+
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.status["foo"], HasLen, 0)
+
+	s.p.status["bar"] = []string{"CREATING", "CREATING"}
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"WaitFor": "operation"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.status["bar"], HasLen, 2)
+
+	s.p.status["qux"] = []string{"CREATING", "FAILED"}
+	r = s.v.Create(volume.Request{Name: "qux"})
+	c.Assert(r.Err, Equals, `disk "qux" creation failed`)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"WaitFor": "foo"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+}
+
+func (s *VolumeSuite) TestCreatePanic(c *C) {
+	s.p.panic = true
+
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, `internal error handling create of "foo": unexpected failure`)
+	c.Assert(testutil.ToFloat64(recoveredPanics.WithLabelValues("create")) > 0, Equals, true)
+
+	s.p.panic = false
+	r = s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestGet(c *C) {
+	s.p.disks["foo"] = true
+
+	r := s.v.Get(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Volume.Name, Equals, "foo")
+	c.Assert(r.Volume.Mountpoint, Equals, "/mnt/foo")
+
+	s.p.getErr = &googleapi.Error{Code: 404, Message: "The resource 'foo' was not found"}
+	r = s.v.Get(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Volume, IsNil)
+
+	s.p.getErr = &googleapi.Error{Code: 403, Message: "Required 'compute.disks.get' permission"}
+	r = s.v.Get(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, ".*compute.disks.get.*")
+	c.Assert(r.Volume, IsNil)
+}
+
 func (s *VolumeSuite) TestList(c *C) {
 	r := s.v.Create(volume.Request{Name: "foo"})
 	c.Assert(r.Err, HasLen, 0)
@@ -89,6 +339,110 @@ func (s *VolumeSuite) TestRemove(c *C) {
 	c.Assert(s.p.disks, HasLen, 0)
 }
 
+func (s *VolumeSuite) TestRemoveRetain(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"ReclaimPolicy": "retain"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["foo"][LabelReclaimPolicy], Equals, "retain")
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["foo"], Equals, true)
+
+	r = s.v.List(volume.Request{})
+	c.Assert(r.Volumes, HasLen, 1)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"ReclaimPolicy": "keep"}})
+	c.Assert(r.Err, Equals, `invalid disk config, unknown reclaim policy "keep"`)
+}
+
+func (s *VolumeSuite) TestRemoveRetainAfterRestart(c *C) {
+	s.p.disks["foo"] = true
+	s.p.labels["foo"] = map[string]string{LabelReclaimPolicy: "retain"}
+
+	r := s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["foo"], Equals, true)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Exists": "true", "ReclaimPolicy": "retain"}})
+	c.Assert(r.Err, Matches, `unable to adopt disk "bar": .*`)
+
+	s.p.disks["bar"] = true
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Exists": "true", "ReclaimPolicy": "retain"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["bar"][LabelReclaimPolicy], Equals, "retain")
+}
+
+func (s *VolumeSuite) TestRemoveSnapshotOnRemove(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"SnapshotOnRemove": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.p.snapshotErr = fmt.Errorf("quota exceeded")
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, `error taking snapshot of disk "foo" before removing it, the disk was kept: quota exceeded`)
+	c.Assert(s.p.disks["foo"], Equals, true)
+
+	s.p.snapshotErr = nil
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["foo"], Equals, false)
+	c.Assert(s.p.snapshots, DeepEquals, []string{"foo"})
+
+	s.v.SnapshotOnRemove = true
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"SnapshotOnRemove": "false"}})
+	c.Assert(r.Err, HasLen, 0)
+	r = s.v.Create(volume.Request{Name: "qux", Options: map[string]string{"ReclaimPolicy": "retain"}})
+	c.Assert(r.Err, HasLen, 0)
+	s.p.disks["baz"] = true
+
+	for _, name := range []string{"bar", "qux", "baz"} {
+		r = s.v.Remove(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	c.Assert(s.p.snapshots, DeepEquals, []string{"foo", "baz"})
+}
+
+func (s *VolumeSuite) TestCreateSnapshotSchedule(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"SnapshotSchedule": "daily"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.schedules["foo"], Equals, "daily")
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.schedules, HasLen, 1)
+
+	s.p.scheduleErr = fmt.Errorf("policy not found")
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"SnapshotSchedule": "weekly"}})
+	c.Assert(r.Err, Equals, `disk "baz" created, but unable to attach its snapshot schedule: policy not found`)
+}
+
+func (s *VolumeSuite) TestCreateDryRun(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"dryrun": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["foo"], Equals, false)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"DryRun": "true", "SnapshotOnRemove": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["bar"], Equals, true)
+	c.Assert(s.p.snapshots, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.disks["bar"], Equals, false)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"DryRun": "maybe"}})
+	c.Assert(r.Err, Matches, `.*invalid syntax`)
+}
+
 func (s *VolumeSuite) TestPath(c *C) {
 	r := s.v.Path(volume.Request{Name: "foo"})
 	c.Assert(r.Err, HasLen, 0)
@@ -116,93 +470,1590 @@ func (s *VolumeSuite) TestMount(c *C) {
 	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/disk/by-id/google-docker-volume-foo")
 }
 
-func (s *VolumeSuite) TestUnmount(c *C) {
+func (s *VolumeSuite) TestMountFormat(c *C) {
 	r := s.v.Create(volume.Request{Name: "foo"})
 	c.Assert(r.Err, HasLen, 0)
 
 	r = s.v.Mount(volume.Request{Name: "foo"})
 	c.Assert(r.Err, HasLen, 0)
-	c.Assert(r.Mountpoint, Equals, "/mnt/foo")
+	c.Assert(s.fs.Formatted["/dev/disk/by-id/google-docker-volume-foo"], Equals, "ext4")
 
 	r = s.v.Unmount(volume.Request{Name: "foo"})
 	c.Assert(r.Err, HasLen, 0)
 
-	c.Assert(s.p.attached, HasLen, 0)
-	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
 }
 
-type DiskProviderFixture struct {
-	disks    map[string]bool
-	attached map[string]bool
-}
+func (s *VolumeSuite) TestMountIOErrors(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
 
-func NewDiskProviderFixture() *DiskProviderFixture {
-	return &DiskProviderFixture{
-		disks:    make(map[string]bool, 0),
-		attached: make(map[string]bool, 0),
-	}
-}
+	transient := testutil.ToFloat64(ioErrors.WithLabelValues("foo", "mount", IOErrorTransient))
+	s.fs.Failures["/mnt/foo"] = []error{fmt.Errorf("mount: /mnt/foo: can't read superblock: Input/output error")}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(testutil.ToFloat64(ioErrors.WithLabelValues("foo", "mount", IOErrorTransient)), Equals, transient+1)
 
-func (d *DiskProviderFixture) Create(c *providers.DiskConfig) error {
-	d.disks[c.Name] = true
-	return nil
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	persistent := testutil.ToFloat64(ioErrors.WithLabelValues("foo", "mount", IOErrorPersistent))
+	err := fmt.Errorf("mount: /mnt/foo: can't read superblock: Input/output error")
+	s.fs.Failures["/mnt/foo"] = []error{err, err}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, ".*Input/output error")
+	c.Assert(testutil.ToFloat64(ioErrors.WithLabelValues("foo", "mount", IOErrorPersistent)), Equals, persistent+1)
 }
 
-func (d *DiskProviderFixture) Attach(c *providers.DiskConfig) error {
-	if _, ok := d.disks[c.Name]; !ok {
-		return fmt.Errorf("unable to find disk %s", c.Name)
-	}
+func (s *VolumeSuite) TestMountStepError(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
 
-	d.attached[c.Name] = true
-	return nil
-}
+	s.fs.Failures["/mnt/foo"] = []error{fmt.Errorf("wrong fs type")}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, "mount failed at mount after successful attach; disk was detached: wrong fs type")
+	c.Assert(s.p.attached["foo"], Equals, false)
+	c.Assert(s.v.Status().Mounts, HasLen, 0)
 
-func (d *DiskProviderFixture) Detach(c *providers.DiskConfig) error {
-	delete(d.attached, c.Name)
-	return nil
+	s.p.detachErr = fmt.Errorf("timeout")
+	s.fs.Failures["/mnt/foo"] = []error{fmt.Errorf("wrong fs type")}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, "mount failed at mount after successful attach; undoing attach failed (timeout): wrong fs type")
+	c.Assert(s.p.attached["foo"], Equals, true)
 }
 
-func (d *DiskProviderFixture) Delete(c *providers.DiskConfig) error {
-	delete(d.disks, c.Name)
-	return nil
-}
+func (s *VolumeSuite) TestUnmountStepError(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
 
-func (d *DiskProviderFixture) List() ([]*compute.Disk, error) {
-	var l []*compute.Disk
-	for name, _ := range d.disks {
-		l = append(l, &compute.Disk{Name: name, Status: "READY"})
-	}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
 
-	l = append(l, &compute.Disk{Name: "no-ready", Status: "PENDING"})
-	return l, nil
+	s.p.detachErr = fmt.Errorf("timeout")
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, "unmount failed at detach after successful unmount: timeout")
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
 }
 
-type MemFilesystem struct {
-	Mounted   map[string]string
-	Formatted map[string]string
-	afero.Fs
-}
+func (s *VolumeSuite) TestOwnerToken(c *C) {
+	s.v.OwnerToken = "3f2b8c1e"
 
-func NewMemFilesystem() *MemFilesystem {
-	return &MemFilesystem{
-		Mounted:   make(map[string]string, 0),
-		Formatted: make(map[string]string, 0),
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.labels["foo"][LabelOwnerToken], Equals, "3f2b8c1e")
 
-		Fs: afero.NewMemMapFs(),
-	}
-}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestOwnerTokenMismatch(c *C) {
+	s.v.OwnerToken = "3f2b8c1e"
+	s.p.disks["foo"] = true
+	s.p.labels["foo"] = map[string]string{LabelOwnerToken: "9a7d4e02"}
+
+	r := s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, `mount failed at verify ownership: refusing to attach disk "foo", its ownership token "9a7d4e02" doesn't match the one of this plugin, use ForceOwnership to override`)
+	c.Assert(s.p.attached["foo"], Equals, false)
+
+	r = s.v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, `refusing to remove disk "foo", .*`)
+	c.Assert(s.p.disks["foo"], Equals, true)
+
+	s.p.disks["bar"] = true
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, Matches, `.*its ownership token "" doesn't match.*`)
+
+	r = s.v.Mount(volume.Request{Name: "foo", Options: map[string]string{"ForceOwnership": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached["foo"], Equals, true)
+}
+
+func (s *VolumeSuite) TestMaxManagedDisks(c *C) {
+	s.v.MaxManagedDisks = 2
+
+	for _, name := range []string{"foo", "bar"} {
+		r := s.v.Create(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	c.Assert(testutil.ToFloat64(managedDisks), Equals, 2.0)
+	c.Assert(testutil.ToFloat64(managedDisksLimit), Equals, 2.0)
+
+	r := s.v.Create(volume.Request{Name: "qux"})
+	c.Assert(r.Err, Equals, `refusing to manage disk "qux", the plugin reached its limit of 2 managed disks`)
+	c.Assert(s.p.disks["qux"], Equals, false)
+
+	s.p.disks["qux"] = true
+	r = s.v.Mount(volume.Request{Name: "qux"})
+	c.Assert(r.Err, Matches, `mount failed at check managed disks limit: refusing to manage disk "qux", .*`)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Remove(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "qux"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(testutil.ToFloat64(managedDisks), Equals, 2.0)
+}
+
+func (s *VolumeSuite) TestMountDeviceTimeout(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"DeviceTimeout": "30"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Timeouts["/dev/disk/by-id/google-docker-volume-foo"], Equals, int64(30))
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"DeviceTimeout": "-1"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+}
+
+func (s *VolumeSuite) TestMountReadAhead(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"ReadAheadKb": "4096"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.ReadAheads["/dev/disk/by-id/google-docker-volume-foo"], Equals, int64(4096))
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"ReadAheadKb": "128k"}})
+	c.Assert(r.Err, Matches, `invalid ReadAheadKb "128k": .*`)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"ReadAheadKb": "-1"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+}
+
+func (s *VolumeSuite) TestMountReadOnly(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Mode": "ro"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, `mount failed at format .*: disk "foo" is blank, it can't be mounted read-only`)
+	c.Assert(s.fs.Formatted[dev], Equals, "")
+
+	s.fs.Formatted[dev] = "xfs"
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Options["/mnt/foo"], DeepEquals, []string{"discard", "defaults", "ro", "norecovery"})
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Mode": "readonly"}})
+	c.Assert(r.Err, Equals, `invalid mode "readonly", must be rw, ro or an octal permission like 0770`)
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Mode": "ro", "Wipe": "zero"}})
+	c.Assert(r.Err, Equals, "invalid disk config, a read-only disk can't be reformatted or wiped")
+}
+
+func (s *VolumeSuite) TestMountMultiWriter(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Type": "hyperdisk-balanced", "MultiWriter": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, `mount failed at format .*: disk "foo" is blank, a multi-writer disk is never formatted, .*`)
+	c.Assert(s.fs.Formatted[dev], Equals, "")
+
+	s.fs.Formatted[dev] = "ext4"
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Matches, `mount failed at format .*: disk "foo" contains a ext4 filesystem, a multi-writer disk can only be mounted with a clustered filesystem .*`)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+
+	s.fs.Formatted[dev] = "gfs2"
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, dev)
+}
+
+func (s *VolumeSuite) TestMountOptions(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"MountOptions": "noatime, nobarrier"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Options["/mnt/foo"], DeepEquals, []string{"discard", "defaults", "noatime", "nobarrier"})
+
+	s.p.disks["bar"] = true
+	s.fs.Formatted["/dev/disk/by-id/google-docker-volume-bar"] = "ext4"
+	r = s.v.Mount(volume.Request{Name: "bar", Options: map[string]string{"MountOptions": "ro,noatime"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Options["/mnt/bar"], DeepEquals, []string{"discard", "defaults", "ro", "noatime", "noload"})
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"FSType": "xfs", "MountOptions": "nobarrier"}})
+	c.Assert(r.Err, Equals, `invalid mount option "nobarrier", nobarrier is only valid for btrfs, ext4`)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"Mode": "ro", "MountOptions": "rw"}})
+	c.Assert(r.Err, Equals, `invalid mount option "rw", the disk is read-only`)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"JournalMode": "journal", "MountOptions": "data=ordered"}})
+	c.Assert(r.Err, Equals, `invalid mount option "data=ordered", the journal mode is set by JournalMode`)
+}
+
+func (s *VolumeSuite) TestMountMkfsOptions(c *C) {
+	s.v.MkfsOptions = map[string]string{"ext4": "-m 0", "xfs": "-K"}
+
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"MkfsOptions": "-m 0  -E lazy_itable_init=1"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.MkfsOptions["/dev/disk/by-id/google-docker-volume-foo"], DeepEquals, []string{"-L", "foo", "-m", "0", "-E", "lazy_itable_init=1"})
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"FSType": "xfs"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.MkfsOptions["/dev/disk/by-id/google-docker-volume-bar"], DeepEquals, []string{"-L", "bar", "-K"})
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"MkfsOptions": "-F /dev/sda"}})
+	c.Assert(r.Err, Equals, `invalid mkfs option "/dev/sda", paths aren't allowed`)
+}
+
+func (s *VolumeSuite) TestMountFilesystemLabel(c *C) {
+	r := s.v.Create(volume.Request{Name: "postgres-data-primary", Options: map[string]string{"FSType": "xfs"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "postgres-data-primary"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.MkfsOptions["/dev/disk/by-id/google-docker-volume-postgres-data-primary"], DeepEquals, []string{"-L", "postgres-dat"})
+
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"MkfsOptions": "-L data"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.MkfsOptions["/dev/disk/by-id/google-docker-volume-foo"], DeepEquals, []string{"-L", "data"})
+}
+
+func (s *VolumeSuite) TestMountDeviceName(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"DeviceName": "data"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/disk/by-id/google-data")
+	c.Assert(s.v.Status().Mounts[0].Source, Equals, "/dev/disk/by-id/google-data")
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"DeviceName": "-bar"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+}
+
+func (s *VolumeSuite) TestMountNVMe(c *C) {
+	s.fs.Devices["docker-volume-foo"] = "/dev/nvme0n2"
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Formatted["/dev/nvme0n2"], Equals, "ext4")
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/nvme0n2")
+	c.Assert(s.v.Status().Mounts[0].Source, Equals, "/dev/nvme0n2")
+
+	s.fs.Devices["docker-volume-bar"] = ""
+	r = s.v.Create(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	WaitDeviceTimeout = 10 * time.Millisecond
+	defer func() { WaitDeviceTimeout = 30 * time.Second }()
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, Matches, `mount failed at resolve device .*: device of disk "bar" not found at /dev/disk/by-id/google-docker-volume-bar .*`)
+}
+
+func (s *VolumeSuite) TestMountWaitDevice(c *C) {
+	WaitDeviceBackoff.Initial = time.Millisecond
+	defer func() { WaitDeviceBackoff.Initial = 250 * time.Millisecond }()
+
+	s.fs.Missing["docker-volume-foo"] = 3
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Missing["docker-volume-foo"], Equals, 0)
+	c.Assert(s.fs.Settled, Equals, 4)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/disk/by-id/google-docker-volume-foo")
+}
+
+func (s *VolumeSuite) TestMountJournalMode(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"JournalMode": "writeback"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Options["/mnt/foo"], DeepEquals, []string{"discard", "defaults", "data=writeback"})
+
+	s.p.disks["bar"] = true
+	s.fs.Formatted["/dev/disk/by-id/google-docker-volume-bar"] = "xfs"
+	r = s.v.Mount(volume.Request{Name: "bar", Options: map[string]string{"JournalMode": "writeback", "FormatPolicy": "use-existing"}})
+	c.Assert(r.Err, Matches, `mount failed at mount .*: invalid mount option "data=writeback", data is only valid for ext4`)
+}
+
+func (s *VolumeSuite) TestMountSecurityContext(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"SecurityContext": "system_u:object_r:container_file_t:s0:c1,c2"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Options["/mnt/foo"], DeepEquals, []string{"discard", "defaults", `context="system_u:object_r:container_file_t:s0:c1,c2"`})
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"SecurityContext": "container"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Options["/mnt/bar"], DeepEquals, []string{"discard", "defaults", `context="system_u:object_r:container_file_t:s0"`})
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"SecurityContext": "container", "MountOptions": "noatime,fscontext=system_u:object_r:tmp_t:s0"}})
+	c.Assert(r.Err, Equals, `invalid mount option "fscontext=system_u:object_r:tmp_t:s0", the SELinux context is set by SecurityContext`)
+}
+
+func (s *VolumeSuite) TestMountFSType(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"FSType": "xfs"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Formatted[dev], Equals, "xfs")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Formatted[dev], Equals, "xfs")
+
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"FSType": "ntfs"}})
+	c.Assert(r.Err, Matches, `invalid disk config, unknown filesystem type "ntfs".*`)
+}
+
+func (s *VolumeSuite) TestMountGrowFilesystem(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	s.p.disks["foo"], s.p.sizes["foo"] = true, 20
+	s.fs.Formatted[dev] = "ext4"
+	s.fs.Sizes["/mnt/foo"], s.fs.DeviceSizes[dev] = 10<<30, 20<<30
+
+	r := s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Grown["/mnt/foo"], Equals, 1)
+	c.Assert(s.fs.Sizes["/mnt/foo"], Equals, int64(20<<30))
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Grown["/mnt/foo"], Equals, 1)
+}
+
+func (s *VolumeSuite) TestCreateGrowMountedFilesystem(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"SizeGb": "10"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Grown, HasLen, 0)
+
+	s.p.sizes["foo"] = 20
+	s.fs.Sizes["/mnt/foo"], s.fs.DeviceSizes[dev] = 10<<30, 20<<30
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"SizeGb": "20"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Grown["/mnt/foo"], Equals, 1)
+	c.Assert(s.fs.Sizes["/mnt/foo"], Equals, int64(20<<30))
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.p.sizes["foo"] = 30
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"SizeGb": "30"}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Grown["/mnt/foo"], Equals, 1)
+}
+
+func (s *VolumeSuite) TestMountGrowFilesystemRescan(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	s.p.disks["foo"], s.p.sizes["foo"] = true, 20
+	s.fs.Formatted[dev] = "ext4"
+	s.fs.Sizes["/mnt/foo"], s.fs.DeviceSizes[dev], s.fs.Rescanned[dev] = 10<<30, 10<<30, 20<<30
+
+	r := s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Grown["/mnt/foo"], Equals, 2)
+	c.Assert(s.fs.Sizes["/mnt/foo"], Equals, int64(20<<30))
+}
+
+func (s *VolumeSuite) TestMountGrowFilesystemFailed(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	s.p.disks["foo"], s.p.sizes["foo"] = true, 20
+	s.fs.Formatted[dev] = "ext4"
+	s.fs.Sizes["/mnt/foo"], s.fs.DeviceSizes[dev], s.fs.Rescanned[dev] = 10<<30, 10<<30, 10<<30
+
+	r := s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, `mount failed at grow filesystem after successful attach, mount; filesystem was unmounted, disk was detached: filesystem of disk "foo" didn't grow, size 10737418240 bytes, disk 21474836480 bytes`)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+	c.Assert(s.p.attached["foo"], Equals, false)
+}
+
+func (s *VolumeSuite) TestCapabilities(c *C) {
+	r := s.v.Capabilities(volume.Request{})
+	c.Assert(r.Capabilities.Scope, Equals, ScopeLocal)
+
+	s.v.Scope = ScopeGlobal
+	r = s.v.Capabilities(volume.Request{})
+	c.Assert(r.Capabilities.Scope, Equals, ScopeGlobal)
+}
+
+func (s *VolumeSuite) TestCreateDiskConfigSourceProjects(c *C) {
+	s.v.SourceProjects = []string{"images", "local"}
+
+	for _, options := range []map[string]string{
+		{"SourceImage": "projects/images/global/images/family/base"},
+		{"SourceImage": "https://www.googleapis.com/compute/v1/projects/local/global/images/foo"},
+		{"SourceImage": "global/images/foo"},
+		{"SourceSnapshot": "foo"},
+		{"SourceSnapshotLabels": "app=db"},
+		{"SourceDisk": "foo"},
+	} {
+		_, err := s.v.createDiskConfig(volume.Request{Name: "foo", Options: options})
+		c.Assert(err, IsNil, Commentf("%v", options))
+	}
+
+	for _, options := range []map[string]string{
+		{"SourceImage": "projects/debian-cloud/global/images/family/debian-12"},
+		{"SourceSnapshot": "projects/other/global/snapshots/foo"},
+		{"SourceSnapshot": "foo", "Project": "other"},
+		{"SourceSnapshotLabels": "app=db", "Project": "other"},
+		{"SourceDisk": "projects/other/zones/zone/disks/foo"},
+	} {
+		_, err := s.v.createDiskConfig(volume.Request{Name: "foo", Options: options})
+		c.Assert(err, ErrorMatches, ".* not allowed, .*: images, local", Commentf("%v", options))
+	}
+}
+
+func (s *VolumeSuite) TestCreateDiskConfigProjects(c *C) {
+	s.v.Projects = []string{"shared-data", "local"}
+
+	for _, project := range []string{"", "shared-data", "local"} {
+		_, err := s.v.createDiskConfig(volume.Request{Name: "foo", Options: map[string]string{"Project": project}})
+		c.Assert(err, IsNil)
+	}
+
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Project": "other"}})
+	c.Assert(r.Err, Equals, `project "other" not allowed, the allowed projects are: shared-data, local`)
+	c.Assert(s.p.disks["foo"], Equals, false)
+}
+
+func (s *VolumeSuite) TestMountRemapOwner(c *C) {
+	s.v.UIDOffset, s.v.GIDOffset = 100000, 200000
+
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Owners["/mnt/foo"], Equals, "100000:200000")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Owners["/mnt/foo"] = "1000:1000"
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Owners["/mnt/foo"], Equals, "1000:1000")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Owners["/mnt/foo"] = "0:0"
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Owners["/mnt/foo"], Equals, "100000:200000")
+}
+
+func (s *VolumeSuite) TestMountRemapOwnerSubpath(c *C) {
+	s.v.UIDOffset, s.v.GIDOffset = 100000, 200000
+	s.fs.Formatted["/dev/disk/by-id/google-docker-volume-shared"] = "ext4"
+
+	r := s.v.Create(volume.Request{Name: "app", Options: map[string]string{"Name": "shared", "Subpath": "data/app"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "app"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Owners["/mnt/shared"], Equals, "100000:200000")
+	c.Assert(s.fs.Owners["/mnt/shared/data"], Equals, "100000:200000")
+	c.Assert(s.fs.Owners["/mnt/shared/data/app"], Equals, "100000:200000")
+}
+
+func (s *VolumeSuite) TestMountPermissions(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Uid": "1000", "Gid": "1000", "Mode": "0770"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Owners["/mnt/foo"], Equals, "1000:1000")
+	c.Assert(s.fs.Modes["/mnt/foo"], Equals, uint32(0770))
+
+	s.v.UIDOffset, s.v.GIDOffset = 100000, 200000
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Gid": "50"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Owners["/mnt/bar"], Equals, "-1:200050")
+	c.Assert(s.fs.Modes["/mnt/bar"], Equals, uint32(0))
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"Uid": "-1"}})
+	c.Assert(r.Err, Equals, `invalid Uid "-1", must be a number`)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"Mode": "0999"}})
+	c.Assert(r.Err, Matches, `invalid mode "0999", .*`)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"Mode": "ro", "Uid": "1000"}})
+	c.Assert(r.Err, Equals, "invalid disk config, the owner of a read-only disk can't be set")
+}
+
+func (s *VolumeSuite) TestMountChownOnCreate(c *C) {
+	s.v.UIDOffset, s.v.GIDOffset = 100000, 200000
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"ChownOnCreate": "999:50"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Chowned["/mnt/foo"], Equals, "100999:200050")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	delete(s.fs.Chowned, "/mnt/foo")
+	v := newVolume(s.p, s.fs)
+	c.Assert(v.LoadState(), IsNil)
+
+	r = v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Chowned, HasLen, 0)
+
+	r = v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = v.Remove(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(v.chowned, HasLen, 0)
+
+	for _, value := range []string{"999", "a:b", "-1:0"} {
+		r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"ChownOnCreate": value}})
+		c.Assert(r.Err, Equals, fmt.Sprintf("invalid ChownOnCreate %q, must be uid:gid, like 999:999", value))
+	}
+}
+
+func (s *VolumeSuite) TestMountWipe(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Wipe": "discard"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Wiped["/dev/disk/by-id/google-docker-volume-foo"], Equals, "discard")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	delete(s.fs.Wiped, "/dev/disk/by-id/google-docker-volume-foo")
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Wiped, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestMountIOLimitsV1(c *C) {
+	c.Assert(s.fs.MkdirAll(BlkioCgroup, 0755), IsNil)
+
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{
+		"ReadIopsLimit": "500", "WriteBpsLimit": "10485760",
+	}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	s.assertCgroupFile(c, "blkio.throttle.read_iops_device", "8:16 500\n")
+	s.assertCgroupFile(c, "blkio.throttle.write_bps_device", "8:16 10485760\n")
+
+	ok, err := afero.Exists(s.fs, filepath.Join(BlkioCgroup, "blkio.throttle.read_bps_device"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	s.assertCgroupFile(c, "blkio.throttle.read_iops_device", "8:16 0\n")
+	s.assertCgroupFile(c, "blkio.throttle.write_bps_device", "8:16 0\n")
+}
+
+func (s *VolumeSuite) TestMountIOLimitsV2(c *C) {
+	c.Assert(afero.WriteFile(s.fs, filepath.Join(BlkioCgroup, "io.max"), nil, 0644), IsNil)
+
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{
+		"ReadIopsLimit": "500", "WriteBpsLimit": "10485760",
+	}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	s.assertCgroupFile(c, "io.max", "8:16 wbps=10485760 riops=500\n")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	s.assertCgroupFile(c, "io.max", "8:16 wbps=max riops=max\n")
+}
+
+func (s *VolumeSuite) TestParseSize(c *C) {
+	for value, expected := range map[string]int64{
+		"42": 42, "100G": 100, "100 GB": 100, "500GiB": 500, "1T": 1024,
+		"1.5TiB": 1536, "2048M": 2, "1tb": 1024,
+	} {
+		size, err := ParseSize(value)
+		c.Assert(err, IsNil, Commentf("%s", value))
+		c.Assert(size, Equals, expected, Commentf("%s", value))
+	}
+
+	_, err := ParseSize("512M")
+	c.Assert(err, ErrorMatches, `invalid size "512M", the min. size is 1 GB`)
+
+	_, err = ParseSize("1.5G")
+	c.Assert(err, ErrorMatches, `invalid size "1.5G", must be a whole number of GB, 1.5 GB isn't`)
+
+	_, err = ParseSize("10P")
+	c.Assert(err, ErrorMatches, `invalid size "10P", unknown unit "P", .*`)
+
+	_, err = ParseSize("-10")
+	c.Assert(err, ErrorMatches, `invalid size "-10", expected .*`)
+}
+
+func (s *VolumeSuite) TestCreateIOLimitsInvalid(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"ReadBpsLimit": "fast"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"WriteIopsLimit": "-1"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+}
+
+func (s *VolumeSuite) assertCgroupFile(c *C, name, content string) {
+	b, err := afero.ReadFile(s.fs, filepath.Join(BlkioCgroup, name))
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, content)
+}
+
+func (s *VolumeSuite) TestMountEffectivePerformance(c *C) {
+	s.p.perf["foo"] = &providers.EffectivePerformance{
+		Type: "hyperdisk-balanced", ProvisionedIops: 10000, ProvisionedThroughput: 200,
+		Iops: 6250, Throughput: 100,
+	}
+
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(testutil.ToFloat64(provisionedPerformance.WithLabelValues("foo", "iops")), Equals, 10000.0)
+	c.Assert(testutil.ToFloat64(effectivePerformance.WithLabelValues("foo", "iops")), Equals, 6250.0)
+	c.Assert(testutil.ToFloat64(effectivePerformance.WithLabelValues("foo", "throughput")), Equals, 100.0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(testutil.CollectAndCount(effectivePerformance), Equals, 0)
+}
+
+func (s *VolumeSuite) TestMountFormatDecisions(c *C) {
+	formatted := testutil.ToFloat64(formatDecisions.WithLabelValues(FormatOutcomeFormatted))
+	skipped := testutil.ToFloat64(formatDecisions.WithLabelValues(FormatOutcomeSkipped))
+
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(testutil.ToFloat64(formatDecisions.WithLabelValues(FormatOutcomeFormatted)), Equals, formatted+1)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(testutil.ToFloat64(formatDecisions.WithLabelValues(FormatOutcomeSkipped)), Equals, skipped+1)
+}
+
+func (s *VolumeSuite) TestMountFormatPolicy(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Formatted[dev] = "xfs"
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, `mount failed at format after successful attach; disk was detached: disk "foo" contains a xfs filesystem but ext4 was requested, use FormatPolicy to mount or reformat it`)
+
+	r = s.v.Create(volume.Request{Name: "foo", Options: map[string]string{
+		"FormatPolicy": "use-existing",
+	}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Formatted[dev], Equals, "xfs")
+
+	r = s.v.Mount(volume.Request{Name: "foo", Options: map[string]string{
+		"FormatPolicy": "reformat",
+	}})
+	c.Assert(r.Err, Not(HasLen), 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo", Options: map[string]string{
+		"FormatPolicy": "reformat", "ForceFormat": "true",
+	}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Formatted[dev], Equals, "ext4")
+}
+
+func (s *VolumeSuite) TestMountFsck(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Fsck": "true"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Repaired, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Repaired[dev], Equals, "ext4")
+
+	s.v.Fsck = true
+	r = s.v.Create(volume.Request{Name: "bar", Options: map[string]string{"Fsck": "false"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Repaired, HasLen, 1)
+
+	r = s.v.Create(volume.Request{Name: "baz", Options: map[string]string{"Fsck": "foo"}})
+	c.Assert(r.Err, Not(HasLen), 0)
+}
+
+func (s *VolumeSuite) TestMountSignatures(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Signed[dev] = []string{"dos"}
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, `mount failed at format after successful attach; disk was detached: disk "foo" has no filesystem but isn't blank, it contains dos signatures, use FormatPolicy to reformat it`)
+	c.Assert(s.fs.Formatted[dev], Equals, "")
+
+	r = s.v.Mount(volume.Request{Name: "foo", Options: map[string]string{
+		"FormatPolicy": "reformat", "ForceFormat": "true",
+	}})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Formatted[dev], Equals, "ext4")
+}
+
+func (s *VolumeSuite) TestUnmount(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "/mnt/foo")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	c.Assert(s.p.attached, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+}
+
+func (s *VolumeSuite) TestMountReferences(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Failures["/mnt/foo"] = []error{fmt.Errorf("already mounted")}
+	r = s.v.Mount(volume.Request{Name: "foo", ID: "b2"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "/mnt/foo")
+
+	r = s.v.Mount(volume.Request{Name: "foo", ID: "b2"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/disk/by-id/google-docker-volume-foo")
+	c.Assert(s.p.attached["foo"], Equals, true)
+
+	s.fs.Failures["/mnt/foo"] = nil
+	r = s.v.Unmount(volume.Request{Name: "foo", ID: "b2"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+	c.Assert(s.p.attached["foo"], Equals, false)
+}
+
+func (s *VolumeSuite) TestUnmountReferencesWithoutID(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	for _, id := range []string{"a1", "b2"} {
+		r = s.v.Mount(volume.Request{Name: "foo", ID: id})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+	c.Assert(s.v.refs, HasLen, 0)
+}
+
+func (s *VolumeSuite) TestMountSubpath(c *C) {
+	for name, subpath := range map[string]string{"app": "data/app", "logs": "logs"} {
+		r := s.v.Create(volume.Request{Name: name, Options: map[string]string{"Name": "shared", "Subpath": subpath, "Uid": "1000"}})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	r := s.v.Mount(volume.Request{Name: "app", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "/mnt/shared/data/app")
+	c.Assert(s.fs.Owners["/mnt/shared/data/app"], Equals, "1000:-1")
+
+	r = s.v.Mount(volume.Request{Name: "logs", ID: "b2"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(r.Mountpoint, Equals, "/mnt/shared/logs")
+
+	ok, err := afero.DirExists(s.fs, "/mnt/shared/logs")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	r = s.v.Path(volume.Request{Name: "logs"})
+	c.Assert(r.Mountpoint, Equals, "/mnt/shared/logs")
+
+	r = s.v.Unmount(volume.Request{Name: "app", ID: "a1"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/shared"], Not(Equals), "")
+
+	r = s.v.Unmount(volume.Request{Name: "logs", ID: "b2"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/shared"], Equals, "")
+
+	c.Assert(afero.WriteFile(s.fs, "/mnt/shared/file", nil, 0644), IsNil)
+	r = s.v.Create(volume.Request{Name: "file", Options: map[string]string{"Name": "shared", "Subpath": "file"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "file"})
+	c.Assert(r.Err, Matches, `mount failed at create subpath .*/mnt/shared/file isn't a directory.*`)
+
+	r = s.v.Create(volume.Request{Name: "bad", Options: map[string]string{"Subpath": "../etc"}})
+	c.Assert(r.Err, Matches, `invalid disk config, subpath "../etc" .*`)
+}
+
+func (s *VolumeSuite) TestUnmountFlush(c *C) {
+	dev := "/dev/disk/by-id/google-docker-volume-foo"
+	s.v.FlushOnUnmount = true
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Events, DeepEquals, []string{"flush " + dev})
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Failures[dev] = []error{fmt.Errorf("foo")}
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, "unmount failed at flush after successful unmount: foo")
+	c.Assert(s.p.attached["foo"], Equals, true)
+}
+
+func (s *VolumeSuite) TestUnmountBusy(c *C) {
+	UnmountRetryInterval = time.Millisecond
+	busy := fmt.Errorf("umount: /mnt/foo: target is busy.")
+
+	r := s.v.Create(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Failures["/mnt/foo"] = []error{busy, busy}
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "")
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.fs.Holding["/mnt/foo"] = []string{"4242 (postgres)"}
+	s.fs.Failures["/mnt/foo"] = []error{busy, busy, busy, busy}
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, Equals, "unmount failed at unmount: umount: /mnt/foo: target is busy., used by 4242 (postgres)")
+	c.Assert(s.p.attached["foo"], Equals, true)
+
+	s.v.LazyUnmount = true
+	s.v.FlushOnUnmount = true
+	s.fs.Failures["/mnt/foo"] = []error{busy, busy, busy, busy}
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.fs.Lazy["/mnt/foo"], Equals, true)
+	c.Assert(s.p.attached["foo"], Equals, true)
+	c.Assert(s.fs.Events, HasLen, 0)
+
+	mounts := s.v.Status().Mounts
+	c.Assert(mounts, HasLen, 1)
+	c.Assert(mounts[0].Lazy, Equals, true)
+	c.Assert(mounts[0].Healthy, Equals, false)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached["foo"], Equals, true)
+
+	delete(s.p.attached, "foo")
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached["foo"], Equals, false)
+	c.Assert(s.fs.Mounted["/mnt/foo"], Equals, "/dev/disk/by-id/google-docker-volume-foo")
+	c.Assert(s.v.Status().Mounts[0].Lazy, Equals, false)
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+	c.Assert(s.p.attached["foo"], Equals, false)
+	c.Assert(s.fs.Events, HasLen, 1)
+}
+
+func (s *VolumeSuite) TestMountConsumer(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Consumer": "My.App"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.background.Wait()
+	c.Assert(s.p.labels["foo"][LabelConsumer], Equals, "my-app")
+
+	r = s.v.Unmount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.background.Wait()
+	c.Assert(s.p.labels["foo"], HasLen, 0)
+}
+
+func (s *VolumeSuite) TestMountConsumerLabelError(c *C) {
+	r := s.v.Create(volume.Request{Name: "foo", Options: map[string]string{"Consumer": "app"}})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.p.labelsErr = fmt.Errorf("permission denied")
+	r = s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.background.Wait()
+	c.Assert(s.p.labels["foo"], HasLen, 0)
+}
+
+func (s *VolumeSuite) TestMountConsumerLabelPermissionDenied(c *C) {
+	for _, name := range []string{"foo", "bar"} {
+		r := s.v.Create(volume.Request{Name: name, Options: map[string]string{"Consumer": "app"}})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	s.p.labelsErr = &googleapi.Error{Code: 403, Message: "Required 'compute.disks.setLabels' permission"}
+	r := s.v.Mount(volume.Request{Name: "foo"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.background.Wait()
+	c.Assert(s.v.Status().LabelsDisabled, Equals, true)
+
+	s.p.labelsErr = nil
+	r = s.v.Mount(volume.Request{Name: "bar"})
+	c.Assert(r.Err, HasLen, 0)
+
+	s.v.background.Wait()
+	c.Assert(s.p.labels["bar"], HasLen, 0)
+}
+
+func (s *VolumeSuite) TestClose(c *C) {
+	before := runtime.NumGoroutine()
+	s.v.ResponseTimeout = time.Minute
+
+	for _, name := range []string{"foo", "bar", "qux"} {
+		r := s.v.Create(volume.Request{Name: name, Options: map[string]string{"Consumer": "app"}})
+		c.Assert(r.Err, HasLen, 0)
+
+		r = s.v.Mount(volume.Request{Name: name})
+		c.Assert(r.Err, HasLen, 0)
+	}
+
+	c.Assert(s.fs.Remove(s.v.statePath()), IsNil)
+	c.Assert(s.v.Close(), IsNil)
+	c.Assert(s.p.closed, Equals, true)
+	c.Assert(s.p.labels["qux"][LabelConsumer], Equals, "app")
+
+	ok, err := afero.Exists(s.fs, s.v.statePath())
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	r := s.v.Mount(volume.Request{Name: "foo", ID: "a1"})
+	c.Assert(r.Err, Equals, ErrClosed.Error())
+	c.Assert(s.v.Reconcile(), Equals, ErrClosed)
+
+	// the goroutines are done but may not have returned yet
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Assert(runtime.NumGoroutine() <= before, Equals, true)
+}
+
+type DiskProviderFixture struct {
+	disks     map[string]bool
+	attached  map[string]bool
+	labels    map[string]map[string]string
+	users     map[string][]string
+	status    map[string][]string
+	sizes     map[string]int64
+	perf      map[string]*providers.EffectivePerformance
+	snapshots []string
+	schedules map[string]string
+	panic     bool
+
+	getErr      error
+	labelsErr   error
+	detachErr   error
+	snapshotErr error
+	scheduleErr error
+	zoneErr     error
+	zone        string
+	lastGet     *providers.DiskConfig
+	closed      bool
+	sync.Mutex
+}
+
+func NewDiskProviderFixture() *DiskProviderFixture {
+	return &DiskProviderFixture{
+		disks:     make(map[string]bool, 0),
+		attached:  make(map[string]bool, 0),
+		labels:    make(map[string]map[string]string, 0),
+		users:     make(map[string][]string, 0),
+		status:    make(map[string][]string, 0),
+		sizes:     make(map[string]int64, 0),
+		perf:      make(map[string]*providers.EffectivePerformance, 0),
+		schedules: make(map[string]string, 0),
+	}
+}
+
+func (d *DiskProviderFixture) Create(c *providers.DiskConfig) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.panic {
+		panic("unexpected failure")
+	}
+
+	if !d.disks[c.Name] && len(c.Labels) != 0 {
+		d.labels[c.Name] = make(map[string]string, 0)
+		for k, v := range c.Labels {
+			d.labels[c.Name][k] = v
+		}
+	}
+
+	d.disks[c.Name] = true
+	return nil
+}
+
+func (d *DiskProviderFixture) Attach(c *providers.DiskConfig) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.disks[c.Name]; !ok {
+		return fmt.Errorf("unable to find disk %s", c.Name)
+	}
+
+	d.attached[c.Name] = true
+	return nil
+}
+
+func (d *DiskProviderFixture) Detach(c *providers.DiskConfig) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.detachErr != nil {
+		return d.detachErr
+	}
+
+	delete(d.attached, c.Name)
+	return nil
+}
+
+func (d *DiskProviderFixture) Delete(c *providers.DiskConfig) error {
+	d.Lock()
+	defer d.Unlock()
+
+	delete(d.disks, c.Name)
+	return nil
+}
+
+func (d *DiskProviderFixture) UpdateLabels(c *providers.DiskConfig, labels map[string]string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.labelsErr != nil {
+		return d.labelsErr
+	}
+
+	if _, ok := d.disks[c.Name]; !ok {
+		return fmt.Errorf("unable to find disk %s", c.Name)
+	}
+
+	if d.labels[c.Name] == nil {
+		d.labels[c.Name] = make(map[string]string, 0)
+	}
+
+	for k, v := range labels {
+		if v == "" {
+			delete(d.labels[c.Name], k)
+			continue
+		}
+
+		d.labels[c.Name][k] = v
+	}
+
+	return nil
+}
+
+func (d *DiskProviderFixture) Close() error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.closed = true
+	return nil
+}
+
+func (d *DiskProviderFixture) SetZone(zone string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.zoneErr != nil {
+		return d.zoneErr
+	}
+
+	d.zone = zone
+	return nil
+}
+
+func (d *DiskProviderFixture) RemainingSlots() (int, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return MaxFixtureSlots - len(d.attached), nil
+}
+
+func (d *DiskProviderFixture) Snapshot(c *providers.DiskConfig) (*compute.Snapshot, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.snapshotErr != nil {
+		return nil, d.snapshotErr
+	}
+
+	d.snapshots = append(d.snapshots, c.Name)
+	return &compute.Snapshot{Name: c.Name + "-removed"}, nil
+}
+
+func (d *DiskProviderFixture) EffectivePerformance(c *providers.DiskConfig) (*providers.EffectivePerformance, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.perf[c.Name], nil
+}
+
+func (d *DiskProviderFixture) AttachedDisks() (map[string]bool, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	attached := make(map[string]bool, 0)
+	for name := range d.attached {
+		attached[name] = true
+	}
+
+	return attached, nil
+}
+
+func (d *DiskProviderFixture) CheckResourcePolicies(policies []string) error {
+	return nil
+}
+
+// DryRun returns a fixture with a copy of the disks, so the changes made
+// through it are lost.
+func (d *DiskProviderFixture) DryRun() (providers.DiskProvider, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	dry := NewDiskProviderFixture()
+	for name, ok := range d.disks {
+		dry.disks[name] = ok
+	}
+
+	return dry, nil
+}
+
+func (d *DiskProviderFixture) AddSnapshotSchedule(c *providers.DiskConfig) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.scheduleErr != nil {
+		return d.scheduleErr
+	}
+
+	d.schedules[c.Name] = c.SnapshotSchedule
+	return nil
+}
+
+func (d *DiskProviderFixture) Get(c *providers.DiskConfig) (*compute.Disk, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.lastGet = c
+	if d.getErr != nil {
+		return nil, d.getErr
+	}
+
+	if _, ok := d.disks[c.Name]; !ok {
+		return nil, fmt.Errorf("unable to find disk %s", c.Name)
+	}
+
+	status := "READY"
+	if s := d.status[c.Name]; len(s) != 0 {
+		status, d.status[c.Name] = s[0], s[1:]
+	}
+
+	return &compute.Disk{Name: c.Name, Status: status, Labels: d.labels[c.Name], SizeGb: d.sizes[c.Name]}, nil
+}
+
+func (d *DiskProviderFixture) List() ([]*compute.Disk, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	var l []*compute.Disk
+	for name, _ := range d.disks {
+		l = append(l, &compute.Disk{Name: name, Status: "READY", Labels: d.labels[name], Users: d.users[name]})
+	}
+
+	l = append(l, &compute.Disk{Name: "no-ready", Status: "PENDING"})
+	return l, nil
+}
+
+type MemFilesystem struct {
+	Mounted     map[string]string
+	Formatted   map[string]string
+	Wiped       map[string]string
+	Unhealthy   map[string]error
+	Failures    map[string][]error
+	Owners      map[string]string
+	Chowned     map[string]string
+	Modes       map[string]uint32
+	MkfsOptions map[string][]string
+	Signed      map[string][]string
+	Repaired    map[string]string
+	Grown       map[string]int
+	Sizes       map[string]int64
+	DeviceSizes map[string]int64
+	Rescanned   map[string]int64
+	Timeouts    map[string]int64
+	ReadAheads  map[string]int64
+	Options     map[string][]string
+	Devices     map[string]string
+	Missing     map[string]int
+	Holding     map[string][]string
+	Lazy        map[string]bool
+	FSTypes     map[string]string
+	Settled     int
+	Events      []string
+	afero.Fs
+}
+
+func NewMemFilesystem() *MemFilesystem {
+	return &MemFilesystem{
+		Mounted:     make(map[string]string, 0),
+		Formatted:   make(map[string]string, 0),
+		Wiped:       make(map[string]string, 0),
+		Unhealthy:   make(map[string]error, 0),
+		Failures:    make(map[string][]error, 0),
+		Owners:      make(map[string]string, 0),
+		Chowned:     make(map[string]string, 0),
+		Modes:       make(map[string]uint32, 0),
+		MkfsOptions: make(map[string][]string, 0),
+		Signed:      make(map[string][]string, 0),
+		Repaired:    make(map[string]string, 0),
+		Grown:       make(map[string]int, 0),
+		Sizes:       make(map[string]int64, 0),
+		DeviceSizes: make(map[string]int64, 0),
+		Rescanned:   make(map[string]int64, 0),
+		Timeouts:    make(map[string]int64, 0),
+		ReadAheads:  make(map[string]int64, 0),
+		Options:     make(map[string][]string, 0),
+		Devices:     make(map[string]string, 0),
+		Missing:     make(map[string]int, 0),
+		Holding:     make(map[string][]string, 0),
+		Lazy:        make(map[string]bool, 0),
+		FSTypes:     make(map[string]string, 0),
+
+		Fs: afero.NewMemMapFs(),
+	}
+}
+
+// failure returns the next queued failure of the target, if any.
+func (fs *MemFilesystem) failure(target string) error {
+	errs := fs.Failures[target]
+	if len(errs) == 0 {
+		return nil
+	}
+
+	fs.Failures[target] = errs[1:]
+	return errs[0]
+}
+
+func (fs *MemFilesystem) Mount(source, target, fstype string, options []string) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	if err := ValidateMountOptions(fstype, options); err != nil {
+		return err
+	}
 
-func (fs *MemFilesystem) Mount(source string, target string) error {
 	fs.Mounted[target] = source
+	fs.Options[target] = options
 	return nil
 }
 
 func (fs *MemFilesystem) Unmount(target string) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	fs.Mounted[target] = ""
+	return nil
+}
+
+func (fs *MemFilesystem) LazyUnmount(target string) error {
 	fs.Mounted[target] = ""
+	fs.Lazy[target] = true
+	return nil
+}
+
+func (fs *MemFilesystem) Bind(source, target string, readOnly bool) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	fs.Mounted[target] = source
+	fs.Options[target] = []string{"bind"}
+	if readOnly {
+		fs.Options[target] = append(fs.Options[target], "ro")
+	}
+
+	return nil
+}
+
+func (fs *MemFilesystem) Unbind(target string) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	delete(fs.Mounted, target)
+	delete(fs.Options, target)
+	return nil
+}
+
+func (fs *MemFilesystem) Holders(target string) ([]string, error) {
+	return fs.Holding[target], nil
+}
+
+func (fs *MemFilesystem) Flush(source string) error {
+	if err := fs.failure(source); err != nil {
+		return err
+	}
+
+	fs.Events = append(fs.Events, "flush "+source)
+	return nil
+}
+
+func (fs *MemFilesystem) Trim(target string) (int64, error) {
+	if err := fs.failure(target); err != nil {
+		return 0, err
+	}
+
+	fs.Events = append(fs.Events, "trim "+target)
+	return 1024, nil
+}
+
+func (fs *MemFilesystem) Format(source, fstype string, force bool, options []string) error {
+	if _, ok := fs.Formatted[source]; ok && !force {
+		return fmt.Errorf("%s already formatted", source)
+	}
+
+	fs.Formatted[source] = fstype
+	fs.MkfsOptions[source] = options
+	return nil
+}
+
+func (fs *MemFilesystem) Wipe(source, method string) error {
+	fs.Wiped[source] = method
+	return nil
+}
+
+func (fs *MemFilesystem) SetOwner(target string, uid, gid int) error {
+	fs.Owners[target] = fmt.Sprintf("%d:%d", uid, gid)
+	return nil
+}
+
+func (fs *MemFilesystem) RemapOwner(target string, uid, gid int) error {
+	if owner, ok := fs.Owners[target]; ok && owner != "0:0" {
+		return nil
+	}
+
+	return fs.SetOwner(target, uid, gid)
+}
+
+func (fs *MemFilesystem) SetOwnerRecursive(target string, uid, gid int) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	fs.Chowned[target] = fmt.Sprintf("%d:%d", uid, gid)
+	return nil
+}
+
+func (fs *MemFilesystem) SetMode(target string, mode uint32) error {
+	fs.Modes[target] = mode
+	return nil
+}
+
+func (fs *MemFilesystem) Repair(source, fstype string) error {
+	fs.Repaired[source] = fstype
+	return nil
+}
+
+func (fs *MemFilesystem) Grow(source, target, fstype string) error {
+	fs.Grown[target]++
+	fs.Sizes[target] = fs.DeviceSizes[source]
+	return nil
+}
+
+func (fs *MemFilesystem) Size(target string) (int64, error) {
+	return fs.Sizes[target], nil
+}
+
+func (fs *MemFilesystem) Rescan(source string) error {
+	fs.DeviceSizes[source] = fs.Rescanned[source]
+	return nil
+}
+
+func (fs *MemFilesystem) SetDeviceTimeout(source string, seconds int64) error {
+	fs.Timeouts[source] = seconds
+	return nil
+}
+
+func (fs *MemFilesystem) SetReadAhead(source string, kb int64) error {
+	fs.ReadAheads[source] = kb
+	return nil
+}
+
+func (fs *MemFilesystem) Freeze(target string, frozen bool) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	if frozen {
+		fs.Events = append(fs.Events, "freeze "+target)
+	} else {
+		fs.Events = append(fs.Events, "unfreeze "+target)
+	}
+
 	return nil
 }
 
-func (fs *MemFilesystem) Format(source string) error {
-	fs.Formatted[source] = "ext4"
+func (fs *MemFilesystem) Hook(command string, env []string) error {
+	fs.Events = append(fs.Events, command+" "+strings.Join(env, " "))
+	return fs.failure(command)
+}
+
+func (fs *MemFilesystem) Probe(source string) (string, error) {
+	return fs.Formatted[source], nil
+}
+
+func (fs *MemFilesystem) Signatures(source string) ([]string, error) {
+	return fs.Signed[source], nil
+}
+
+func (fs *MemFilesystem) Device(source string) (string, error) {
+	return "8:16", nil
+}
+
+func (fs *MemFilesystem) Resolve(source, deviceName string) (string, error) {
+	if fs.Missing[deviceName] > 0 {
+		fs.Missing[deviceName]--
+		return "", nil
+	}
+
+	if dev, ok := fs.Devices[deviceName]; ok {
+		return dev, nil
+	}
+
+	return source, nil
+}
+
+func (fs *MemFilesystem) Settle(timeout time.Duration) error {
+	fs.Settled++
 	return nil
 }
+
+func (fs *MemFilesystem) Mounts() ([]*MountInfo, error) {
+	var mounts []*MountInfo
+	for target, source := range fs.Mounted {
+		if source == "" {
+			continue
+		}
+
+		fstype := "ext4"
+		if t, ok := fs.FSTypes[target]; ok {
+			fstype = t
+		}
+
+		mounts = append(mounts, &MountInfo{Source: source, Target: target, FSType: fstype})
+	}
+
+	return mounts, nil
+}
+
+func (fs *MemFilesystem) Check(source string, target string) error {
+	if err := fs.failure(target); err != nil {
+		return err
+	}
+
+	return fs.Unhealthy[target]
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// RunZoneRefresh reads the zone of the instance every interval until the
+// plugin is closed, moving the provider to it when it changes, so a
+// re-provisioned instance doesn't look up the disks in its previous zone.
+func (v *Volume) RunZoneRefresh(interval time.Duration, zone func() (string, error)) {
+	v.Lock()
+	started := v.addBackground()
+	v.Unlock()
+	if !started {
+		return
+	}
+
+	defer v.background.Done()
+	log15.Info("refreshing zone periodically", "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.refreshZone(zone)
+		case <-v.done:
+			log15.Debug("zone refresh stopped")
+			return
+		}
+	}
+}
+
+func (v *Volume) refreshZone(zone func() (string, error)) {
+	z, err := zone()
+	if err != nil {
+		log15.Warn("error refreshing zone", "error", err)
+		return
+	}
+
+	if err := v.p.SetZone(z); err != nil {
+		log15.Error("error moving to refreshed zone", "zone", z, "error", err)
+	}
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *VolumeSuite) TestRefreshZone(c *C) {
+	s.v.refreshZone(func() (string, error) { return "zone-b", nil })
+	c.Assert(s.p.zone, Equals, "zone-b")
+
+	s.v.refreshZone(func() (string, error) { return "", fmt.Errorf("metadata unavailable") })
+	c.Assert(s.p.zone, Equals, "zone-b")
+
+	s.p.zoneErr = fmt.Errorf("unknown zone")
+	s.v.refreshZone(func() (string, error) { return "zone-c", nil })
+	c.Assert(s.p.zone, Equals, "zone-b")
+}
+
+func (s *VolumeSuite) TestRunZoneRefresh(c *C) {
+	refreshed := make(chan struct{}, 1)
+	stopped := make(chan struct{})
+	go func() {
+		s.v.RunZoneRefresh(time.Millisecond, func() (string, error) {
+			select {
+			case refreshed <- struct{}{}:
+			default:
+			}
+
+			return "zone-b", nil
+		})
+		close(stopped)
+	}()
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		c.Fatal("zone not refreshed")
+	}
+
+	c.Assert(s.v.Close(), IsNil)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		c.Fatal("zone refresh not stopped on close")
+	}
+
+	s.p.Lock()
+	defer s.p.Unlock()
+	c.Assert(s.p.zone, Equals, "zone-b")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"google.golang.org/api/compute/v1"
@@ -11,14 +12,31 @@ import (
 	"gopkg.in/inconshreveable/log15.v2"
 )
 
-const MaxWaitDuration = time.Minute
+const (
+	MaxWaitDuration         = time.Minute
+	MaxSnapshotWaitDuration = 30 * time.Minute
+)
+
+var (
+	WaitDoneInterval = 1 * time.Second
+	MaxRetries       = 5
+)
 
 type Client struct {
+	c        *http.Client
 	s        *compute.Service
-	zone     string
-	region   string
+	loc      *location
 	project  string
 	instance string
+	backoff  Backoff
+}
+
+// location is the zone of the instance and its region, shared by the copies
+// of the client, since the zone can be refreshed while they are in use.
+type location struct {
+	zone   string
+	region string
+	sync.RWMutex
 }
 
 func NewClient(c *http.Client, project, zone, instance string) (*Client, error) {
@@ -28,54 +46,114 @@ func NewClient(c *http.Client, project, zone, instance string) (*Client, error)
 	}
 
 	client := &Client{
+		c:        c,
 		s:        s,
 		project:  project,
-		zone:     zone,
+		loc:      &location{},
 		instance: instance,
+		backoff:  DefaultBackoff,
 	}
 
-	return client, client.loadRegion()
+	return client, client.setZone(zone)
 }
 
-func (c *Client) loadRegion() error {
-	z, err := c.s.Zones.Get(c.project, c.zone).Do()
+// setZone moves the client to zone, loading its region.
+func (c *Client) setZone(zone string) error {
+	z, err := c.s.Zones.Get(c.project, zone).Do()
 	if err != nil {
 		return fmt.Errorf("error retrieving region from zone: %s", err)
 	}
 
 	region := strings.Split(z.Region, "/")
-	c.region = region[len(region)-1]
+
+	c.loc.Lock()
+	defer c.loc.Unlock()
+
+	c.loc.zone, c.loc.region = zone, region[len(region)-1]
+	return nil
+}
+
+func (c *Client) zone() string {
+	c.loc.RLock()
+	defer c.loc.RUnlock()
+
+	return c.loc.zone
+}
+
+func (c *Client) region() string {
+	c.loc.RLock()
+	defer c.loc.RUnlock()
+
+	return c.loc.region
+}
+
+// Close releases the idle connections of the HTTP client.
+func (c *Client) Close() error {
+	c.c.CloseIdleConnections()
 	return nil
 }
 
 func (c *Client) WaitDone(op *compute.Operation) error {
-	var doer func(...googleapi.CallOption) (*compute.Operation, error)
-	switch {
-	case op.Region != "":
-		doer = c.s.RegionOperations.Get(c.project, c.region, op.Name).Do
-	case op.Zone != "":
-		doer = c.s.ZoneOperations.Get(c.project, c.zone, op.Name).Do
-	default:
-		doer = c.s.GlobalOperations.Get(c.project, op.Name).Do
-	}
+	return c.waitDone(op, MaxWaitDuration)
+}
+
+func (c *Client) waitDone(op *compute.Operation, max time.Duration) error {
+	doer := c.operationGetter(op)
+	clock := c.backoff.Clock()
+	start := clock.Now()
+	var failures int
+	for {
+		clock.Sleep(WaitDoneInterval)
 
-	start := time.Now()
-	ticker := time.Tick(1 * time.Second)
-	for range ticker {
 		rop, err := doer()
-		if err != nil {
-			log15.Error("error waiting for operation %q: %s", "name", op.Name, err)
-			continue
+		if err == nil && rop.Status == "DONE" {
+			return operationError(rop)
 		}
 
-		if rop.Status == "DONE" {
-			return nil
+		if clock.Now().Sub(start) > max {
+			return fmt.Errorf("max. time reached waiting for operation %q", op.Name)
 		}
 
-		if time.Since(start) > MaxWaitDuration {
-			return fmt.Errorf("max. time reached waiting for operation %q", op.Name)
+		if err != nil {
+			log15.Error("error waiting for operation", "name", op.Name, "error", err)
+			clock.Sleep(c.backoff.Delay(failures))
+			failures++
+			continue
 		}
+
+		failures = 0
 	}
+}
 
-	return nil
+// operationGetter returns the call retrieving the current state of the
+// operation, zonal, regional or global.
+func (c *Client) operationGetter(op *compute.Operation) func(...googleapi.CallOption) (*compute.Operation, error) {
+	project := operationProject(op, c.project)
+	switch {
+	case op.Region != "":
+		return c.s.RegionOperations.Get(project, c.region(), op.Name).Do
+	case op.Zone != "":
+		return c.s.ZoneOperations.Get(project, c.zone(), op.Name).Do
+	default:
+		return c.s.GlobalOperations.Get(project, op.Name).Do
+	}
+}
+
+// retry calls f until it succeeds or fails with an error not worth retrying,
+// only for calls safe to repeat.
+func (c *Client) retry(f func() error) error {
+	return c.backoff.Retry(MaxRetries, IsRetryableError, f)
+}
+
+// IsRetryableError reports whether a failed API call may succeed if retried,
+// the rate limit and server errors.
+func IsRetryableError(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && (apiErr.Code == 429 || apiErr.Code >= 500)
+}
+
+// operationProject returns the project the operation runs in, taken from its
+// self link since the disks may be in a project other than the instance.
+func operationProject(op *compute.Operation, project string) string {
+	return ResourceProject(op.SelfLink, project)
 }
@@ -1,6 +1,19 @@
 package providers
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const MaxLabelLength = 63
+
+var invalidLabelChars = regexp.MustCompile("[^a-z0-9_-]+")
+
+var (
+	labelKeyFormat   = regexp.MustCompile("^[a-z][a-z0-9_-]{0,62}$")
+	labelValueFormat = regexp.MustCompile("^[a-z0-9_-]{0,63}$")
+)
 
 func contains(haystack []string, needle string) bool {
 	for _, e := range haystack {
@@ -12,6 +25,46 @@ func contains(haystack []string, needle string) bool {
 	return false
 }
 
+// ResourceProject returns the project of a resource URL like
+// projects/<project>/global/images/<image>, or the given project if the URL
+// doesn't contain one, e.g. a bare resource name.
+func ResourceProject(url, project string) string {
+	parts := strings.Split(url, "/")
+	for i, p := range parts[:len(parts)-1] {
+		if p == "projects" {
+			return parts[i+1]
+		}
+	}
+
+	return project
+}
+
+// resourceRegion returns the region of a regional resource URL, or the given
+// region if the URL doesn't contain one.
+func resourceRegion(url, region string) string {
+	parts := strings.Split(url, "/")
+	for i, p := range parts[:len(parts)-1] {
+		if p == "regions" {
+			return parts[i+1]
+		}
+	}
+
+	return region
+}
+
+// resourceZone returns the zone of a zonal resource URL, empty if it isn't
+// zonal.
+func resourceZone(url string) string {
+	parts := strings.Split(url, "/")
+	for i, p := range parts[:len(parts)-1] {
+		if p == "zones" {
+			return parts[i+1]
+		}
+	}
+
+	return ""
+}
+
 func DiskURL(project, zone, disks string) string {
 	return fmt.Sprintf(
 		"https://www.googleapis.com/compute/v1/projects/%s/zones/%s/disks/%s",
@@ -19,6 +72,17 @@ func DiskURL(project, zone, disks string) string {
 	)
 }
 
+func RegionDiskURL(project, region, disk string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/regions/%s/disks/%s",
+		project, region, disk,
+	)
+}
+
+func ZoneURL(project, zone string) string {
+	return fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s", project, zone)
+}
+
 func InstanceURL(project, zone, instance string) string {
 	return fmt.Sprintf(
 		"https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s",
@@ -33,6 +97,40 @@ func TargetPoolURL(project, region, targetPool string) string {
 	)
 }
 
+func SnapshotURL(project, snapshot string) string {
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/global/snapshots/%s",
+		project, snapshot,
+	)
+}
+
+// ResourcePolicyURL returns the URL of a resource policy, a policy already
+// given as projects/<project>/regions/<region>/resourcePolicies/<policy> is
+// kept in its project and region.
+func ResourcePolicyURL(project, region, policy string) string {
+	if strings.Contains(policy, "/") {
+		return policy
+	}
+
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/regions/%s/resourcePolicies/%s",
+		project, region, policy,
+	)
+}
+
+// StoragePoolURL returns the URL of a storage pool, a pool already given as
+// projects/<project>/zones/<zone>/storagePools/<pool> is kept as is.
+func StoragePoolURL(project, zone, pool string) string {
+	if strings.Contains(pool, "/") {
+		return pool
+	}
+
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/zones/%s/storagePools/%s",
+		project, zone, pool,
+	)
+}
+
 func DiskTypeURL(project, zone, diskType string) string {
 	if diskType == "" {
 		diskType = "pd-standard"
@@ -43,3 +141,46 @@ func DiskTypeURL(project, zone, diskType string) string {
 		project, zone, diskType,
 	)
 }
+
+func RegionDiskTypeURL(project, region, diskType string) string {
+	if diskType == "" {
+		diskType = "pd-standard"
+	}
+
+	return fmt.Sprintf(
+		"https://www.googleapis.com/compute/v1/projects/%s/regions/%s/diskTypes/%s",
+		project, region, diskType,
+	)
+}
+
+func ResourceName(url string) string {
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
+
+// ValidateLabel checks that key and value are a valid GCE label.
+func ValidateLabel(key, value string) error {
+	if !labelKeyFormat.MatchString(key) {
+		return fmt.Errorf(
+			"invalid label key %q, must be 1-63 lowercase letters, numbers, _ or -, starting with a letter",
+			key,
+		)
+	}
+
+	if !labelValueFormat.MatchString(value) {
+		return fmt.Errorf("invalid label value %q of %q, must be up to 63 lowercase letters, numbers, _ or -", value, key)
+	}
+
+	return nil
+}
+
+// LabelValue converts a string into a valid GCE label value: lowercase
+// letters, numbers, underscores and dashes, up to 63 characters.
+func LabelValue(value string) string {
+	value = invalidLabelChars.ReplaceAllString(strings.ToLower(value), "-")
+	if len(value) > MaxLabelLength {
+		value = value[:MaxLabelLength]
+	}
+
+	return value
+}
@@ -2,9 +2,14 @@ package providers
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -55,3 +60,136 @@ func (s *BaseSuite) initEnviroment(c *C) {
 func (s *BaseSuite) getRandomName() string {
 	return time.Now().Format("20060102150405000000")
 }
+
+func (s *CommonSuite) TestResourceName(c *C) {
+	c.Assert(ResourceName(DiskTypeURL("foo", "bar", "pd-ssd")), Equals, "pd-ssd")
+	c.Assert(ResourceName("baz"), Equals, "baz")
+	c.Assert(ResourceName(""), Equals, "")
+}
+
+func (s *CommonSuite) TestOperationProject(c *C) {
+	op := &compute.Operation{SelfLink: "https://www.googleapis.com/compute/v1/projects/other/zones/zone/operations/op"}
+	c.Assert(operationProject(op, "project"), Equals, "other")
+	c.Assert(operationProject(&compute.Operation{}, "project"), Equals, "project")
+}
+
+func (s *CommonSuite) TestResourceProject(c *C) {
+	c.Assert(ResourceProject("projects/images/global/images/family/base", "local"), Equals, "images")
+	c.Assert(ResourceProject("https://www.googleapis.com/compute/v1/projects/other/global/snapshots/foo", "local"), Equals, "other")
+	c.Assert(ResourceProject("global/images/foo", "local"), Equals, "local")
+	c.Assert(ResourceProject("foo", "local"), Equals, "local")
+}
+
+func (s *CommonSuite) TestLabelValue(c *C) {
+	c.Assert(LabelValue("my_app.Web/1"), Equals, "my_app-web-1")
+	c.Assert(LabelValue(strings.Repeat("a", 70)), HasLen, MaxLabelLength)
+}
+
+func (s *CommonSuite) TestValidateLabel(c *C) {
+	c.Assert(ValidateLabel("team", "infra"), IsNil)
+	c.Assert(ValidateLabel("cost_center", ""), IsNil)
+	c.Assert(ValidateLabel("Team", "infra"), ErrorMatches, `invalid label key "Team", .*`)
+	c.Assert(ValidateLabel("1team", "infra"), ErrorMatches, `invalid label key "1team", .*`)
+	c.Assert(ValidateLabel("team", "Infra Ops"), ErrorMatches, `invalid label value "Infra Ops" of "team", .*`)
+	c.Assert(ValidateLabel("team", strings.Repeat("a", 64)), NotNil)
+}
+
+type ComputeHandler func(r *http.Request) (int, interface{})
+
+// ComputeFixture is a fake Compute Engine API, requests are matched against
+// the registered handlers by method and path suffix, where * matches any path
+// segment. Operations are reported as done unless handled.
+type ComputeFixture struct {
+	*httptest.Server
+	Requests []string
+
+	handlers map[string]ComputeHandler
+	sync.Mutex
+}
+
+func NewComputeFixture() *ComputeFixture {
+	WaitDoneInterval = time.Millisecond
+
+	f := &ComputeFixture{handlers: make(map[string]ComputeHandler, 0)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *ComputeFixture) Handle(method, suffix string, h ComputeHandler) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.handlers[method+" "+suffix] = h
+}
+
+func (f *ComputeFixture) Disk() *Disk {
+	s, err := compute.New(f.Client())
+	if err != nil {
+		panic(err)
+	}
+
+	s.BasePath = f.URL + "/"
+	return &Disk{Client: Client{
+		c:        f.Client(),
+		s:        s,
+		project:  "project",
+		loc:      &location{zone: "zone", region: "region"},
+		instance: "instance",
+		backoff:  Backoff{Strategy: BackoffConstant, Initial: time.Second, clock: &FakeClock{}},
+	}}
+}
+
+func (f *ComputeFixture) serve(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	f.Requests = append(f.Requests, r.Method+" "+r.URL.Path)
+	var handler ComputeHandler
+	for key, h := range f.handlers {
+		p := strings.SplitN(key, " ", 2)
+		if r.Method == p[0] && matchPath(r.URL.Path, p[1]) {
+			handler = h
+		}
+	}
+	f.Unlock()
+
+	code, body := http.StatusNotFound, interface{}(ComputeError(http.StatusNotFound, "not found"))
+	switch {
+	case handler != nil:
+		code, body = handler(r)
+	case strings.Contains(r.URL.Path, "/operations/"):
+		code, body = http.StatusOK, &compute.Operation{Name: "op", Status: "DONE"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (f *ComputeFixture) Count(method, suffix string) int {
+	f.Lock()
+	defer f.Unlock()
+
+	var count int
+	for _, r := range f.Requests {
+		p := strings.SplitN(r, " ", 2)
+		if p[0] == method && matchPath(p[1], suffix) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func matchPath(path, suffix string) bool {
+	expr := strings.Replace(regexp.QuoteMeta(suffix), `\*`, "[^/]+", -1)
+	return regexp.MustCompile(expr + "$").MatchString(path)
+}
+
+func ComputeError(code int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	}
+}
+
+func ComputeOperation(zone string) (int, interface{}) {
+	return http.StatusOK, &compute.Operation{Name: "op", Zone: zone, Status: "PENDING"}
+}
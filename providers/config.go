@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/fsouza/go-dockerclient"
 	"google.golang.org/api/compute/v1"
@@ -16,29 +18,239 @@ var (
 	DiskDevBasePath        = "/dev/disk/by-id/google-%s"
 )
 
+// MaxDeviceTimeout is the max. DeviceTimeout, in seconds.
+const MaxDeviceTimeout = 3600
+
+// MaxReadAheadKb is the max. ReadAheadKb, in KB.
+const MaxReadAheadKb = 65536
+
+// MaxDeviceNameLength is the max. length of the device names.
+const MaxDeviceNameLength = 63
+
+// AccessModeMultiWriter is the access mode of the disks attached read-write
+// to several instances at once.
+const AccessModeMultiWriter = "READ_WRITE_MANY"
+
+var deviceNameFormat = regexp.MustCompile("^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$")
+
+// securityContextFormat is the format of a SELinux context, user:role:type
+// and an optional MLS level, e.g. s0:c1,c2.
+var securityContextFormat = regexp.MustCompile(
+	"^[a-zA-Z0-9_]+:[a-zA-Z0-9_]+:[a-zA-Z0-9_]+(:[a-zA-Z0-9_.:,-]+)?$",
+)
+
+var licenseFormat = regexp.MustCompile(
+	"^(https://www.googleapis.com/compute/v1/)?projects/[^/]+/global/licenses/[^/]+$",
+)
+
+// MinSizesGb are the min. sizes, in GB, of the disks of each type.
+var MinSizesGb = map[string]int64{
+	"pd-standard":          10,
+	"pd-balanced":          10,
+	"pd-ssd":               10,
+	"pd-extreme":           500,
+	"hyperdisk-balanced":   4,
+	"hyperdisk-extreme":    64,
+	"hyperdisk-throughput": 2048,
+	"hyperdisk-ml":         4,
+}
+
+// RegionalMinSizesGb are the min. sizes of the regional disks, where they
+// differ from the zonal ones.
+var RegionalMinSizesGb = map[string]int64{
+	"pd-standard": 200,
+}
+
 type DiskConfig struct {
-	Name           string
-	Type           string
-	SizeGb         int64
-	SourceSnapshot string
-	SourceImage    string
+	Name                  string
+	Project               string
+	Type                  string
+	DefaultType           string
+	SizeGb                int64
+	DefaultSizeGb         int64
+	SizePolicy            SizePolicy
+	SourceSnapshot        string
+	SourceSnapshotLabels  map[string]string
+	SourceImage           string
+	SourceDisk            string
+	Licenses              []string
+	Description           string
+	CustomDeviceName      string
+	DevicePath            string
+	ResourcePolicies      []string
+	SnapshotSchedule      string
+	KmsKeyName            string
+	CsekKey               string
+	AllowTypeChange       bool
+	IgnoreDrift           bool
+	DryRun                bool
+	Exists                bool
+	ReclaimPolicy         ReclaimPolicy
+	SnapshotOnRemove      bool
+	WaitFor               WaitFor
+	Consumer              string
+	FormatPolicy          FormatPolicy
+	ForceFormat           bool
+	Wipe                  WipeMethod
+	FSType                string
+	JournalMode           JournalMode
+	MountOptions          []string
+	Fsck                  bool
+	MkfsOptions           []string
+	ReadIopsLimit         int64
+	WriteIopsLimit        int64
+	ReadBpsLimit          int64
+	WriteBpsLimit         int64
+	DeviceTimeout         int64
+	ReadAheadKb           int64
+	ProvisionedIops       int64
+	ProvisionedThroughput int64
+	StoragePool           string
+	ReadOnly              bool
+	MultiWriter           bool
+	UID                   *int
+	GID                   *int
+	ChownUID              *int
+	ChownGID              *int
+	FileMode              uint32
+	Regional              bool
+	ReplicaZones          []string
+	Labels                map[string]string
+	ForceOwnership        bool
+	Subpath               string
+	SecurityContext       string
 }
 
+type WaitFor string
+
+const (
+	// WaitForOperation returns from create once the insert operation is done.
+	WaitForOperation WaitFor = "operation"
+	// WaitForReady returns from create once the disk is READY, restoring a
+	// disk from a snapshot or image can take longer than the insert.
+	WaitForReady WaitFor = "ready"
+)
+
+type SizePolicy string
+
+const (
+	// SizePolicyError refuses to create a disk that already exists with
+	// another size.
+	SizePolicyError SizePolicy = "error"
+	// SizePolicyGrowOnly resizes an existing smaller disk, GCE disks can't
+	// shrink so a smaller size is refused. It's the default.
+	SizePolicyGrowOnly SizePolicy = "grow-only"
+	// SizePolicyIgnore keeps the size of the existing disk.
+	SizePolicyIgnore SizePolicy = "ignore"
+)
+
+type FormatPolicy string
+
+const (
+	// FormatPolicyError refuses to mount a disk with a filesystem other than
+	// the requested one.
+	FormatPolicyError FormatPolicy = "error"
+	// FormatPolicyUseExisting mounts the existing filesystem.
+	FormatPolicyUseExisting FormatPolicy = "use-existing"
+	// FormatPolicyReformat formats the disk with the requested filesystem,
+	// destroying its data, it requires ForceFormat.
+	FormatPolicyReformat FormatPolicy = "reformat"
+)
+
+type ReclaimPolicy string
+
+const (
+	// ReclaimPolicyDelete deletes the disk when the volume is removed.
+	ReclaimPolicyDelete ReclaimPolicy = "delete"
+	// ReclaimPolicyRetain keeps the disk when the volume is removed, only
+	// the volume is deregistered.
+	ReclaimPolicyRetain ReclaimPolicy = "retain"
+)
+
+type WipeMethod string
+
+const (
+	// WipeDiscard discards every block of the device, fast on SSDs.
+	WipeDiscard WipeMethod = "discard"
+	// WipeZero overwrites the whole device with zeros.
+	WipeZero WipeMethod = "zero"
+	// WipeShred overwrites the whole device with random data and then zeros.
+	WipeShred WipeMethod = "shred"
+)
+
+type JournalMode string
+
+const (
+	// JournalModeJournal writes the data to the journal before the
+	// filesystem, the safest and slowest mode.
+	JournalModeJournal JournalMode = "journal"
+	// JournalModeOrdered writes the data before its metadata is committed to
+	// the journal, the ext4 default.
+	JournalModeOrdered JournalMode = "ordered"
+	// JournalModeWriteback only journals the metadata, after a crash the
+	// recently written files may contain stale data.
+	JournalModeWriteback JournalMode = "writeback"
+)
+
 func (c *DiskConfig) Disk(project, zone string) *compute.Disk {
-	return &compute.Disk{
+	disk := &compute.Disk{
 		Name:           c.Name,
 		Type:           DiskTypeURL(project, zone, c.Type),
 		SizeGb:         c.SizeGb,
 		SourceSnapshot: c.SourceSnapshot,
 		SourceImage:    c.SourceImage,
+		SourceDisk:     c.SourceDisk,
+		Licenses:       c.Licenses,
+	}
+
+	disk.ProvisionedIops = c.ProvisionedIops
+	disk.ProvisionedThroughput = c.ProvisionedThroughput
+	if c.MultiWriter {
+		disk.AccessMode = AccessModeMultiWriter
+	}
+
+	if c.StoragePool != "" {
+		disk.StoragePool = StoragePoolURL(project, zone, c.StoragePool)
+	}
+
+	if len(c.Labels) != 0 {
+		disk.Labels = c.Labels
+	}
+
+	disk.DiskEncryptionKey = c.EncryptionKey()
+	return disk
+}
+
+// EncryptionKey returns the KMS or customer-supplied key the disk is
+// encrypted with, nil if it's encrypted with a Google managed key.
+func (c *DiskConfig) EncryptionKey() *compute.CustomerEncryptionKey {
+	switch {
+	case c.KmsKeyName != "":
+		return &compute.CustomerEncryptionKey{KmsKeyName: c.KmsKeyName}
+	case c.CsekKey != "":
+		return csekEncryptionKey(c.CsekKey)
 	}
+
+	return nil
 }
 
+// DeviceName returns the name the disk is attached with, exposed to the guest
+// as /dev/disk/by-id/google-<device name>, CustomDeviceName if set.
 func (c *DiskConfig) DeviceName() string {
+	if c.CustomDeviceName != "" {
+		return c.CustomDeviceName
+	}
+
 	return fmt.Sprintf(DiskDeviceNameBaseName, c.Name)
 }
 
+// Dev returns the path of the device of the attached disk, DevicePath once
+// resolved, otherwise the /dev/disk/by-id/google-<device name> link.
 func (c *DiskConfig) Dev() string {
+	if c.DevicePath != "" {
+		return c.DevicePath
+	}
+
 	return fmt.Sprintf(DiskDevBasePath, c.DeviceName())
 }
 
@@ -46,6 +258,12 @@ func (c *DiskConfig) MountPoint(root string) string {
 	return filepath.Join(root, c.Name)
 }
 
+// Path returns the directory the volume is used from, the Subpath within the
+// filesystem mounted at MountPoint.
+func (c *DiskConfig) Path(root string) string {
+	return filepath.Join(c.MountPoint(root), c.Subpath)
+}
+
 func (c *DiskConfig) Validate() error {
 	if c.Name == "" {
 		return fmt.Errorf("invalid disk config, name field cannot be empty")
@@ -55,6 +273,269 @@ func (c *DiskConfig) Validate() error {
 		return fmt.Errorf("invalid dick config, source snapshot and source image can't be presents at the same time.")
 	}
 
+	if len(c.SourceSnapshotLabels) != 0 && (c.SourceSnapshot != "" || c.SourceImage != "") {
+		return fmt.Errorf("invalid disk config, source snapshot labels can't be used with a source snapshot or image")
+	}
+
+	if c.SourceDisk != "" && (c.SourceSnapshot != "" || c.SourceImage != "" || len(c.SourceSnapshotLabels) != 0) {
+		return fmt.Errorf("invalid disk config, source disk can't be used with a source snapshot or image")
+	}
+
+	if c.Exists && (c.SourceSnapshot != "" || c.SourceImage != "" || len(c.SourceSnapshotLabels) != 0 || c.SourceDisk != "") {
+		return fmt.Errorf("invalid disk config, an existing disk can't be created from a source")
+	}
+
+	if c.KmsKeyName != "" {
+		if err := ValidateKmsKeyName(c.KmsKeyName); err != nil {
+			return err
+		}
+	}
+
+	if c.CsekKey != "" {
+		if c.KmsKeyName != "" {
+			return fmt.Errorf("invalid disk config, csek key and kms key can't be used at the same time")
+		}
+
+		if c.AllowTypeChange {
+			return fmt.Errorf("invalid disk config, type changes aren't supported on disks with a csek key")
+		}
+
+		if err := ValidateCsekKey(c.CsekKey); err != nil {
+			return err
+		}
+	}
+
+	if c.CustomDeviceName != "" && !deviceNameFormat.MatchString(c.CustomDeviceName) {
+		return fmt.Errorf(
+			"invalid disk config, device name %q must be 1-63 lowercase letters, numbers or -, starting with a letter",
+			c.CustomDeviceName,
+		)
+	}
+
+	for k, v := range c.Labels {
+		if err := ValidateLabel(k, v); err != nil {
+			return fmt.Errorf("invalid disk config, %s", err)
+		}
+	}
+
+	for _, l := range c.Licenses {
+		if err := ValidateLicense(l); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range []int64{c.ReadIopsLimit, c.WriteIopsLimit, c.ReadBpsLimit, c.WriteBpsLimit} {
+		if l < 0 {
+			return fmt.Errorf("invalid disk config, I/O limits can't be negative")
+		}
+	}
+
+	if c.DeviceTimeout < 0 || c.DeviceTimeout > MaxDeviceTimeout {
+		return fmt.Errorf("invalid disk config, device timeout must be between 0 and %d seconds", MaxDeviceTimeout)
+	}
+
+	if c.ReadAheadKb < 0 || c.ReadAheadKb > MaxReadAheadKb {
+		return fmt.Errorf("invalid disk config, read-ahead must be between 0 and %d KB", MaxReadAheadKb)
+	}
+
+	if len(c.ReplicaZones) != 0 && !c.Regional {
+		return fmt.Errorf("invalid disk config, replica zones can only be set on regional disks")
+	}
+
+	if c.Regional && len(c.ReplicaZones) != 2 {
+		return fmt.Errorf("invalid disk config, regional disks require two replica zones, got %d", len(c.ReplicaZones))
+	}
+
+	if c.StoragePool != "" && !strings.HasPrefix(c.diskType(), "hyperdisk-") {
+		return fmt.Errorf("invalid disk config, storage pools only hold hyperdisk types, not %q", c.diskType())
+	}
+
+	if c.StoragePool != "" && c.Regional {
+		return fmt.Errorf("invalid disk config, storage pools are zonal, they can't hold regional disks")
+	}
+
+	if err := c.validateSize(); err != nil {
+		return err
+	}
+
+	if err := c.validatePerformance(); err != nil {
+		return err
+	}
+
+	switch c.WaitFor {
+	case "", WaitForOperation, WaitForReady:
+	default:
+		return fmt.Errorf("invalid disk config, unknown wait for %q", c.WaitFor)
+	}
+
+	switch c.SizePolicy {
+	case "", SizePolicyError, SizePolicyGrowOnly, SizePolicyIgnore:
+	default:
+		return fmt.Errorf("invalid disk config, unknown size policy %q", c.SizePolicy)
+	}
+
+	switch c.ReclaimPolicy {
+	case "", ReclaimPolicyDelete, ReclaimPolicyRetain:
+	default:
+		return fmt.Errorf("invalid disk config, unknown reclaim policy %q", c.ReclaimPolicy)
+	}
+
+	switch c.Wipe {
+	case "", WipeDiscard, WipeZero, WipeShred:
+	default:
+		return fmt.Errorf("invalid disk config, unknown wipe method %q", c.Wipe)
+	}
+
+	switch c.FSType {
+	case "", "ext4", "xfs", "btrfs":
+	default:
+		return fmt.Errorf("invalid disk config, unknown filesystem type %q, must be ext4, xfs or btrfs", c.FSType)
+	}
+
+	switch c.JournalMode {
+	case "", JournalModeJournal, JournalModeOrdered, JournalModeWriteback:
+	default:
+		return fmt.Errorf("invalid disk config, unknown journal mode %q", c.JournalMode)
+	}
+
+	if c.JournalMode != "" && c.FSType != "" && c.FSType != "ext4" {
+		return fmt.Errorf("invalid disk config, journal mode is only valid for ext4, not %s", c.FSType)
+	}
+
+	if c.MultiWriter && !strings.HasPrefix(c.diskType(), "hyperdisk-") {
+		return fmt.Errorf("invalid disk config, multi-writer is only supported by the hyperdisk types, not %q", c.diskType())
+	}
+
+	if c.MultiWriter && c.ReadOnly {
+		return fmt.Errorf("invalid disk config, a multi-writer disk can't be read-only")
+	}
+
+	if c.MultiWriter && (c.FSType != "" || c.FormatPolicy == FormatPolicyReformat || c.Wipe != "" || len(c.MkfsOptions) != 0) {
+		return fmt.Errorf("invalid disk config, a multi-writer disk is never formatted, it must already contain a clustered filesystem")
+	}
+
+	if c.ReadOnly && (c.FormatPolicy == FormatPolicyReformat || c.Wipe != "") {
+		return fmt.Errorf("invalid disk config, a read-only disk can't be reformatted or wiped")
+	}
+
+	if c.ReadOnly && (c.UID != nil || c.GID != nil || c.ChownUID != nil) {
+		return fmt.Errorf("invalid disk config, the owner of a read-only disk can't be set")
+	}
+
+	if c.SecurityContext != "" && !securityContextFormat.MatchString(c.SecurityContext) {
+		return fmt.Errorf("invalid disk config, security context %q must be a SELinux context, like system_u:object_r:container_file_t:s0", c.SecurityContext)
+	}
+
+	if c.Subpath != "" && (filepath.IsAbs(c.Subpath) || filepath.Clean(c.Subpath) != c.Subpath || c.Subpath == "." || c.Subpath == ".." || strings.HasPrefix(c.Subpath, "../")) {
+		return fmt.Errorf("invalid disk config, subpath %q must be a clean relative path within the disk, like data/app", c.Subpath)
+	}
+
+	if c.FileMode > 07777 {
+		return fmt.Errorf("invalid disk config, mode %o isn't a permission", c.FileMode)
+	}
+
+	switch c.FormatPolicy {
+	case "", FormatPolicyError, FormatPolicyUseExisting:
+	case FormatPolicyReformat:
+		if !c.ForceFormat {
+			return fmt.Errorf("invalid disk config, format policy %q requires force format", c.FormatPolicy)
+		}
+	default:
+		return fmt.Errorf("invalid disk config, unknown format policy %q", c.FormatPolicy)
+	}
+
+	return nil
+}
+
+// diskType returns the type of the disk, the default one unless the volume
+// sets it, which is only used when the disk is created.
+func (c *DiskConfig) diskType() string {
+	if c.Type != "" {
+		return c.Type
+	}
+
+	return c.DefaultType
+}
+
+// validateSize checks the size against the min. size of the disk type, GCE
+// only reports it once the create operation fails.
+func (c *DiskConfig) validateSize() error {
+	if c.SizeGb == 0 {
+		return nil
+	}
+
+	diskType := c.diskType()
+	if diskType == "" {
+		diskType = "pd-standard"
+	}
+
+	kind, min := "", MinSizesGb[diskType]
+	if m, ok := RegionalMinSizesGb[diskType]; ok && c.Regional {
+		kind, min = "regional ", m
+	}
+
+	if c.SizeGb < min {
+		return withCode(ErrorCodeInvalidArgument, fmt.Errorf(
+			"invalid disk config, %s%s disks must be at least %d GB, got %d GB, set SizeGb=%d or more",
+			kind, diskType, min, c.SizeGb, min,
+		))
+	}
+
+	return nil
+}
+
+// validatePerformance checks the provisioned IOPS and throughput against the
+// ranges allowed by the disk type.
+func (c *DiskConfig) validatePerformance() error {
+	if c.ProvisionedIops == 0 && c.ProvisionedThroughput == 0 {
+		return nil
+	}
+
+	r := provisionedRange(c.diskType())
+	if r == nil {
+		r = &ProvisionedRange{Type: c.diskType()}
+	}
+
+	if err := checkRange("IOPS", c.ProvisionedIops, r.Type, r.MinIops, r.MaxIops); err != nil {
+		return err
+	}
+
+	return checkRange("throughput", c.ProvisionedThroughput, r.Type, r.MinThroughput, r.MaxThroughput)
+}
+
+func checkRange(name string, value int64, diskType string, min, max int64) error {
+	if value == 0 {
+		return nil
+	}
+
+	if max == 0 {
+		if diskType == "" {
+			diskType = "pd-standard"
+		}
+
+		return fmt.Errorf("invalid disk config, provisioned %s can't be set on %s disks", name, diskType)
+	}
+
+	if value < min || value > max {
+		return fmt.Errorf(
+			"invalid disk config, provisioned %s of %s disks must be between %d and %d, got %d",
+			name, diskType, min, max, value,
+		)
+	}
+
+	return nil
+}
+
+// ValidateLicense checks that license is the resource name or URL of a GCE
+// license.
+func ValidateLicense(license string) error {
+	if !licenseFormat.MatchString(license) {
+		return fmt.Errorf(
+			"invalid license %q, expected projects/<project>/global/licenses/<license>",
+			license,
+		)
+	}
+
 	return nil
 }
 
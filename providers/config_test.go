@@ -24,6 +24,26 @@ func (s *ConfigSuite) TestNetworkConfigDisk(c *C) {
 	c.Assert(d.SizeGb, Equals, int64(42))
 	c.Assert(d.SourceSnapshot, Equals, "bar")
 	c.Assert(d.SourceImage, Equals, "baz")
+	c.Assert(d.DiskEncryptionKey, IsNil)
+	c.Assert(d.Labels, IsNil)
+	c.Assert(d.Licenses, IsNil)
+
+	config.KmsKeyName = "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux"
+	d = config.Disk("project", "foo-c")
+	c.Assert(d.DiskEncryptionKey.KmsKeyName, Equals, config.KmsKeyName)
+
+	config.Labels = map[string]string{"foo": "bar"}
+	d = config.Disk("project", "foo-c")
+	c.Assert(d.Labels, DeepEquals, map[string]string{"foo": "bar"})
+
+	config.Licenses = []string{"projects/foo/global/licenses/bar"}
+	d = config.Disk("project", "foo-c")
+	c.Assert(d.Licenses, DeepEquals, config.Licenses)
+
+	config.ProvisionedIops, config.ProvisionedThroughput = 5000, 200
+	d = config.Disk("project", "foo-c")
+	c.Assert(d.ProvisionedIops, Equals, int64(5000))
+	c.Assert(d.ProvisionedThroughput, Equals, int64(200))
 }
 
 func (s *ConfigSuite) TestNetworkConfigValidate(c *C) {
@@ -38,6 +58,188 @@ func (s *ConfigSuite) TestNetworkConfigValidate(c *C) {
 	config = &DiskConfig{Name: "foo", SourceSnapshot: "foo", SourceImage: "foo"}
 	err = config.Validate()
 	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", SourceSnapshot: "foo", SourceSnapshotLabels: map[string]string{"foo": "bar"}}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", SizePolicy: SizePolicyGrowOnly}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", SizePolicy: "foo"}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", KmsKeyName: "foo"}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", Licenses: []string{
+		"projects/foo/global/licenses/bar",
+		"https://www.googleapis.com/compute/v1/projects/foo/global/licenses/qux",
+	}}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", Licenses: []string{"projects/foo/global/licenses/bar", "bar"}}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", ReadIopsLimit: 100, WriteBpsLimit: -1}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", DeviceTimeout: 30}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", DeviceTimeout: MaxDeviceTimeout + 1}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	uid := 999
+	config = &DiskConfig{Name: "foo", ReadOnly: true, ChownUID: &uid, ChownGID: &uid}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, the owner of a read-only disk can't be set")
+
+	config = &DiskConfig{Name: "foo", ReadAheadKb: 4096}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", ReadAheadKb: MaxReadAheadKb + 1}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, read-ahead must be between 0 and 65536 KB")
+
+	config = &DiskConfig{Name: "foo", JournalMode: JournalModeWriteback}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", Type: "hyperdisk-balanced", ProvisionedIops: 5000, ProvisionedThroughput: 200}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", Type: "hyperdisk-balanced", ProvisionedIops: 1000}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, provisioned IOPS of hyperdisk-balanced disks must be between 3000 and 160000, got 1000")
+
+	config = &DiskConfig{Name: "foo", Type: "pd-extreme", ProvisionedThroughput: 200}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, provisioned throughput can't be set on pd-extreme disks")
+
+	config = &DiskConfig{Name: "foo", ProvisionedIops: 5000}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, provisioned IOPS can't be set on pd-standard disks")
+
+	config = &DiskConfig{Name: "foo", Regional: true, ReplicaZones: []string{"a", "b"}}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", Regional: true}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, regional disks require two replica zones, got 0")
+
+	config = &DiskConfig{Name: "foo", ReplicaZones: []string{"a", "b"}}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, replica zones can only be set on regional disks")
+
+	config = &DiskConfig{Name: "foo", CsekKey: "SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0="}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+	c.Assert(config.Disk("project", "zone").DiskEncryptionKey.RawKey, Equals, config.CsekKey)
+
+	config.KmsKeyName = "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux"
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, csek key and kms key can't be used at the same time")
+
+	config = &DiskConfig{Name: "foo", CsekKey: "foo"}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid csek key, .*")
+
+	config = &DiskConfig{Name: "foo", SourceDisk: "bar", SourceImage: "baz"}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, source disk can't be used with a source snapshot or image")
+
+	config = &DiskConfig{Name: "foo", Type: "hyperdisk-balanced", MultiWriter: true}
+	c.Assert(config.Validate(), IsNil)
+	c.Assert(config.Disk("project", "zone").AccessMode, Equals, "READ_WRITE_MANY")
+
+	config = &DiskConfig{Name: "foo", Type: "pd-ssd", SizeGb: 10}
+	c.Assert(config.Validate(), IsNil)
+
+	config = &DiskConfig{Name: "foo", SizeGb: 5}
+	c.Assert(config.Validate(), ErrorMatches, `invalid disk config, pd-standard disks must be at least 10 GB, got 5 GB, set SizeGb=10 or more`)
+
+	config = &DiskConfig{Name: "foo", Type: "hyperdisk-throughput", SizeGb: 100}
+	c.Assert(config.Validate(), ErrorMatches, `invalid disk config, hyperdisk-throughput disks must be at least 2048 GB, .*`)
+
+	config = &DiskConfig{Name: "foo", SizeGb: 100, Regional: true, ReplicaZones: []string{"zone-a", "zone-b"}}
+	c.Assert(config.Validate(), ErrorMatches, `invalid disk config, regional pd-standard disks must be at least 200 GB, .*`)
+
+	config = &DiskConfig{Name: "foo", Type: "pd-ssd", StoragePool: "pool"}
+	c.Assert(config.Validate(), ErrorMatches, `invalid disk config, storage pools only hold hyperdisk types, not "pd-ssd"`)
+
+	config = &DiskConfig{Name: "foo", Type: "pd-ssd", MultiWriter: true}
+	c.Assert(config.Validate(), ErrorMatches, `invalid disk config, multi-writer is only supported by the hyperdisk types, not "pd-ssd"`)
+
+	config = &DiskConfig{Name: "foo", Type: "hyperdisk-balanced", MultiWriter: true, FSType: "ext4"}
+	c.Assert(config.Validate(), ErrorMatches, `invalid disk config, a multi-writer disk is never formatted, .*`)
+
+	config = &DiskConfig{Name: "foo", Exists: true, SourceDisk: "bar"}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, an existing disk can't be created from a source")
+
+	config = &DiskConfig{Name: "foo", FSType: "xfs"}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", FSType: "ntfs"}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, `invalid disk config, unknown filesystem type "ntfs", .*`)
+
+	config = &DiskConfig{Name: "foo", FSType: "xfs", JournalMode: JournalModeWriteback}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, "invalid disk config, journal mode is only valid for ext4, not xfs")
+
+	config = &DiskConfig{Name: "foo", JournalMode: "foo"}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", SecurityContext: "system_u:object_r:container_file_t:s0:c1,c2"}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", SecurityContext: "container_file_t"}
+	err = config.Validate()
+	c.Assert(err, ErrorMatches, `invalid disk config, security context "container_file_t" must be a SELinux context, .*`)
+
+	config = &DiskConfig{Name: "foo", SecurityContext: `system_u:object_r:container_file_t:s0",exec`}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", Wipe: WipeDiscard}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", Wipe: "foo"}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", FormatPolicy: FormatPolicyUseExisting}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", FormatPolicy: FormatPolicyReformat}
+	err = config.Validate()
+	c.Assert(err, NotNil)
+
+	config = &DiskConfig{Name: "foo", FormatPolicy: FormatPolicyReformat, ForceFormat: true}
+	err = config.Validate()
+	c.Assert(err, IsNil)
+
+	config = &DiskConfig{Name: "foo", FormatPolicy: "foo"}
+	err = config.Validate()
+	c.Assert(err, NotNil)
 }
 
 func (s *ConfigSuite) TestNetworkConfigDeviceName(c *C) {
@@ -45,14 +247,42 @@ func (s *ConfigSuite) TestNetworkConfigDeviceName(c *C) {
 	c.Assert(config.DeviceName(), Equals, "docker-volume-foo")
 }
 
+func (s *ConfigSuite) TestNetworkConfigCustomDeviceName(c *C) {
+	config := &DiskConfig{Name: "foo", CustomDeviceName: "data"}
+	c.Assert(config.DeviceName(), Equals, "data")
+	c.Assert(config.Dev(), Equals, "/dev/disk/by-id/google-data")
+	c.Assert(config.Validate(), IsNil)
+
+	config.CustomDeviceName = "Data"
+	c.Assert(config.Validate(), NotNil)
+}
+
 func (s *ConfigSuite) TestNetworkConfigDev(c *C) {
 	config := &DiskConfig{Name: "docker-volume-foo"}
 	c.Assert(config.Dev(), Equals, "/dev/disk/by-id/google-docker-volume-docker-volume-foo")
+
+	config.DevicePath = "/dev/nvme0n2"
+	c.Assert(config.Dev(), Equals, "/dev/nvme0n2")
 }
 
 func (s *ConfigSuite) TestNetworkConfigMountPoint(c *C) {
 	config := &DiskConfig{Name: "foo"}
 	c.Assert(config.MountPoint("/mnt/"), Equals, "/mnt/foo")
+	c.Assert(config.Path("/mnt/"), Equals, "/mnt/foo")
+
+	config.Subpath = "data/app1"
+	c.Assert(config.Path("/mnt/"), Equals, "/mnt/foo/data/app1")
+	c.Assert(config.Validate(), IsNil)
+
+	for _, subpath := range []string{"..data", "data/..app", "..."} {
+		config.Subpath = subpath
+		c.Assert(config.Validate(), IsNil, Commentf(subpath))
+	}
+
+	for _, subpath := range []string{"/data", "../data", "data/../../etc", "data/", ".", ".."} {
+		config.Subpath = subpath
+		c.Assert(config.Validate(), ErrorMatches, "invalid disk config, subpath .*", Commentf(subpath))
+	}
 }
 
 func (s *ConfigSuite) TestNetworkConfigGroup(c *C) {
@@ -1,10 +1,29 @@
 package providers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	DiskTypeChangeSnapshotBaseName = "%s-type-change-%s"
+	RegionSnapshotBaseName         = "%s-%s-%s"
+	RemoveSnapshotBaseName         = "%s-removed-%s"
+	MaxLabelUpdateRetries          = 5
+	DebugAttach                    = false
+	CreatedBy                      = "gce-docker"
+	LabelCreatedBy                 = "created-by"
+	LabelInstance                  = "instance"
+	LabelInstanceProject           = "instance-project"
 )
 
 type DiskProvider interface {
@@ -13,10 +32,31 @@ type DiskProvider interface {
 	Detach(c *DiskConfig) error
 	Delete(c *DiskConfig) error
 	List() ([]*compute.Disk, error)
+	Get(c *DiskConfig) (*compute.Disk, error)
+	Snapshot(c *DiskConfig) (*compute.Snapshot, error)
+	UpdateLabels(c *DiskConfig, labels map[string]string) error
+	RemainingSlots() (int, error)
+	AttachedDisks() (map[string]bool, error)
+	EffectivePerformance(c *DiskConfig) (*EffectivePerformance, error)
+	CheckResourcePolicies(policies []string) error
+	AddSnapshotSchedule(c *DiskConfig) error
+	DryRun() (DiskProvider, error)
+	SetZone(zone string) error
+	Close() error
 }
 
 type Disk struct {
 	Client
+
+	// the machine type can only change with the instance stopped, so its
+	// disk limit is cached by machine type, its URL naming the zone, and the
+	// disk types offered by the zone are cached by project and zone. Both are
+	// dropped by SetZone, when the instance is re-provisioned in another zone.
+	machineType string
+	maxDisks    int64
+	cpus        int64
+	diskTypes   map[string][]string
+	sync.Mutex
 }
 
 func NewDisk(c *http.Client, project, zone, instance string) (*Disk, error) {
@@ -29,48 +69,519 @@ func NewDisk(c *http.Client, project, zone, instance string) (*Disk, error) {
 }
 
 func (d *Disk) Create(c *DiskConfig) error {
-	disk := c.Disk(d.project, d.zone)
-	if _, err := d.s.Disks.Get(d.project, d.zone, disk.Name).Do(); err != nil {
-		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
+	project := d.diskProject(c)
+	if !c.Regional {
+		if err := d.checkDiskType(project, c.diskType()); err != nil {
 			return err
 		}
+	}
 
-		op, err := d.s.Disks.Insert(d.project, d.zone, disk).Do()
-		if err != nil {
+	disk := c.Disk(project, d.zone())
+	d.stamp(disk, c.Description)
+	if c.Regional {
+		if err := d.regionalDisk(project, c, disk); err != nil {
+			return err
+		}
+	}
+
+	current, err := d.getDisk(project, disk.Name, c.Regional)
+	if err != nil {
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
+			return d.projectError(c, "compute.disks.get", err)
+		}
+
+		if len(c.SourceSnapshotLabels) != 0 {
+			snapshot, err := d.latestSnapshot(project, c.SourceSnapshotLabels)
+			if err != nil {
+				return err
+			}
+
+			log15.Info("creating disk from snapshot", "disk", disk.Name, "snapshot", snapshot.Name)
+			disk.SourceSnapshot = snapshot.SelfLink
+		}
+
+		if c.SourceImage != "" {
+			image, err := d.resolveImage(project, c.SourceImage)
+			if err != nil {
+				return err
+			}
+
+			disk.SourceImage = image
+		}
+
+		if c.SourceSnapshot != "" {
+			snapshot, err := d.resolveSnapshot(project, c.SourceSnapshot)
+			if err != nil {
+				return err
+			}
+
+			disk.SourceSnapshot = snapshot
+		}
+
+		if disk.StoragePool != "" {
+			if err := d.checkStoragePool(disk); err != nil {
+				return err
+			}
+		}
+
+		if c.SourceDisk != "" {
+			source, err := d.resolveSourceDisk(project, c.SourceDisk)
+			if err != nil {
+				return err
+			}
+
+			log15.Info("cloning disk", "disk", disk.Name, "source", source)
+			disk.SourceDisk = source
+		}
+
+		// an existing disk keeps its type, the default only types new disks
+		if c.Type == "" && c.DefaultType != "" {
+			disk.Type = DiskTypeURL(project, d.zone(), c.DefaultType)
+			if c.Regional {
+				disk.Type = RegionDiskTypeURL(project, d.region(), c.DefaultType)
+			}
+		}
+
+		// an existing disk keeps its size, the default only sizes new blank disks
+		if disk.SizeGb == 0 && disk.SourceSnapshot == "" && disk.SourceImage == "" && disk.SourceDisk == "" {
+			disk.SizeGb = c.DefaultSizeGb
+		}
+
+		disk.ResourcePolicies = d.resourcePolicyURLs(project, c.ResourcePolicies)
+		return d.projectError(c, "compute.disks.create", d.insert(project, disk, c.Regional))
+	}
+
+	if c.KmsKeyName != "" && !encryptedWith(current, c.KmsKeyName) {
+		log15.Warn("existing disk isn't encrypted with the requested key, GCE can't change it",
+			"disk", current.Name, "kms-key", c.KmsKeyName,
+		)
+	}
+
+	if drift := diskDrift(project, c, current); len(drift) != 0 {
+		if !c.IgnoreDrift {
+			return withCode(ErrorCodeConflict, fmt.Errorf(
+				"disk %q already exists but doesn't match the volume, %s, use --ignore-drift to accept it",
+				current.Name, strings.Join(drift, ", "),
+			))
+		}
+
+		log15.Warn("existing disk doesn't match the volume, drift ignored", "disk", current.Name, "drift", strings.Join(drift, ", "))
+	}
+
+	if c.SizeGb != 0 && c.SizeGb != current.SizeGb {
+		if err := checkSizePolicy(c, current); err != nil {
 			return err
 		}
+	}
+
+	if c.SizeGb == 0 || c.SizePolicy == SizePolicyIgnore {
+		disk.SizeGb = current.SizeGb
+	}
+
+	if c.AllowTypeChange && c.Type != "" && ResourceName(current.Type) != ResourceName(disk.Type) {
+		if c.Regional {
+			return fmt.Errorf("unable to change type of disk %q, not supported on regional disks", current.Name)
+		}
+
+		disk.ResourcePolicies = d.resourcePolicyURLs(project, c.ResourcePolicies)
+		return d.changeType(project, current, disk)
+	}
 
-		return d.WaitDone(op)
+	if disk.SizeGb > current.SizeGb {
+		return d.resize(project, current, disk.SizeGb, c.Regional)
 	}
 
 	return nil
 }
 
-func (d *Disk) Attach(c *DiskConfig) error {
-	ad := &compute.AttachedDisk{
-		Source:     DiskURL(d.project, d.zone, c.Name),
-		DeviceName: c.DeviceName(),
+// stamp labels and describes the disk as created by the plugin from this
+// instance, so it can be traced back to it, appending the stamp to the user
+// description.
+func (d *Disk) stamp(disk *compute.Disk, description string) {
+	labels := make(map[string]string, 0)
+	for k, v := range disk.Labels {
+		labels[k] = v
+	}
+
+	labels[LabelCreatedBy] = CreatedBy
+	labels[LabelInstance] = LabelValue(d.instance)
+	labels[LabelInstanceProject] = LabelValue(d.project)
+	disk.Labels = labels
+
+	disk.Description = fmt.Sprintf("Created by %s on instance %s of project %s", CreatedBy, d.instance, d.project)
+	if description != "" {
+		disk.Description = description + "\n\n" + disk.Description
+	}
+}
+
+// regionalDisk makes the disk a regional one, replicated in the given zones,
+// which must be in the region of the instance and include its zone, since the
+// disk can only be attached from one of them.
+func (d *Disk) regionalDisk(project string, c *DiskConfig, disk *compute.Disk) error {
+	var local bool
+	for _, z := range c.ReplicaZones {
+		if !strings.HasPrefix(z, d.region()+"-") {
+			return fmt.Errorf("invalid replica zone %q, it must be in the region of the instance, %s", z, d.region())
+		}
+
+		local = local || z == d.zone()
+		disk.ReplicaZones = append(disk.ReplicaZones, ZoneURL(project, z))
+	}
+
+	if !local {
+		return fmt.Errorf("invalid replica zones %q, they must include the zone of the instance, %s", c.ReplicaZones, d.zone())
+	}
+
+	disk.Type = RegionDiskTypeURL(project, d.region(), c.Type)
+	return nil
+}
+
+func (d *Disk) getDisk(project, name string, regional bool) (*compute.Disk, error) {
+	if regional {
+		return d.s.RegionDisks.Get(project, d.region(), name).Do()
+	}
+
+	return d.s.Disks.Get(project, d.zone(), name).Do()
+}
+
+// diskURL returns the URL of the disk, zonal or regional.
+func (d *Disk) diskURL(c *DiskConfig) string {
+	if c.Regional {
+		return RegionDiskURL(d.diskProject(c), d.region(), c.Name)
+	}
+
+	return DiskURL(d.diskProject(c), d.zone(), c.Name)
+}
+
+// checkStoragePool verifies that the storage pool exists in the zone of the
+// instance and holds disks of the type of the disk.
+func (d *Disk) checkStoragePool(disk *compute.Disk) error {
+	url := disk.StoragePool
+	zone := resourceZone(url)
+	if zone != d.zone() {
+		return fmt.Errorf("invalid storage pool %q, it must be in zone %s", url, d.zone())
 	}
 
-	op, err := d.s.Instances.AttachDisk(d.project, d.zone, d.instance, ad).Do()
+	pool, err := d.s.StoragePools.Get(ResourceProject(url, d.project), zone, ResourceName(url)).Do()
 	if err != nil {
-		return err
+		return fmt.Errorf("error retrieving storage pool %q: %s", ResourceName(url), err)
 	}
 
-	return d.WaitDone(op)
+	if t := ResourceName(pool.StoragePoolType); t != ResourceName(disk.Type) {
+		return fmt.Errorf(
+			"invalid storage pool %q, it holds %s disks, not %s",
+			pool.Name, t, ResourceName(disk.Type),
+		)
+	}
+
+	return nil
 }
 
-func (d *Disk) Detach(c *DiskConfig) error {
-	op, err := d.s.Instances.DetachDisk(d.project, d.zone, d.instance, c.DeviceName()).Do()
+// checkDiskType verifies that the zone offers the disk type, the error lists
+// the valid ones.
+func (d *Disk) checkDiskType(project, diskType string) error {
+	if diskType == "" {
+		return nil
+	}
+
+	types, err := d.zoneDiskTypes(project)
 	if err != nil {
 		return err
 	}
 
+	for _, t := range types {
+		if t == diskType {
+			return nil
+		}
+	}
+
+	return withCode(ErrorCodeInvalidArgument, fmt.Errorf(
+		"invalid disk type %q, the types available in zone %s are: %s",
+		diskType, d.zone(), strings.Join(types, ", "),
+	))
+}
+
+func (d *Disk) zoneDiskTypes(project string) ([]string, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	zone := d.zone()
+	key := project + "/" + zone
+	if types, ok := d.diskTypes[key]; ok {
+		return types, nil
+	}
+
+	var l *compute.DiskTypeList
+	err := d.retry(func() error {
+		var err error
+		l, err = d.s.DiskTypes.List(project, zone).Do()
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing disk types of zone %s: %s", zone, err)
+	}
+
+	var types []string
+	for _, t := range l.Items {
+		if t.Deprecated == nil || t.Deprecated.State == "" {
+			types = append(types, t.Name)
+		}
+	}
+
+	sort.Strings(types)
+	if d.diskTypes == nil {
+		d.diskTypes = make(map[string][]string, 0)
+	}
+
+	d.diskTypes[key] = types
+	return types, nil
+}
+
+// diskDrift compares the type and the source snapshot requested with the ones
+// of the existing disk, the size is left to the SizePolicy.
+func diskDrift(project string, c *DiskConfig, current *compute.Disk) []string {
+	var drift []string
+	if c.Type != "" && !c.AllowTypeChange && ResourceName(current.Type) != c.Type {
+		drift = append(drift, fmt.Sprintf("type is %s, not %s", ResourceName(current.Type), c.Type))
+	}
+
+	if c.SourceSnapshot != "" {
+		actual, requested := snapshotName(project, current.SourceSnapshot), snapshotName(project, c.SourceSnapshot)
+		if actual != requested {
+			if actual == "" {
+				actual = "none"
+			}
+
+			drift = append(drift, fmt.Sprintf("source snapshot is %s, not %s", actual, requested))
+		}
+	}
+
+	return drift
+}
+
+// snapshotName returns the name of the snapshot, prefixed by its project if
+// it isn't the given one.
+func snapshotName(project, snapshot string) string {
+	if p := ResourceProject(snapshot, project); p != project {
+		return p + "/" + ResourceName(snapshot)
+	}
+
+	return ResourceName(snapshot)
+}
+
+// encryptedWith reports whether the disk is encrypted with the KMS key, GCE
+// returns the key version used.
+func encryptedWith(disk *compute.Disk, key string) bool {
+	if disk.DiskEncryptionKey == nil {
+		return false
+	}
+
+	used := disk.DiskEncryptionKey.KmsKeyName
+	return used == key || strings.HasPrefix(used, key+"/cryptoKeyVersions/")
+}
+
+// resolveImage resolves an image family, given as family/<family> or as
+// projects/<project>/global/images/family/<family>, to its latest image, so
+// the image the disk was created from is known. A bare name is an image or,
+// if there isn't an image with that name, a family. The images of other
+// projects are retrieved first, failing early if they can't be used.
+func (d *Disk) resolveImage(project, image string) (string, error) {
+	parts := strings.Split(image, "/")
+	if len(parts) < 2 || parts[len(parts)-2] != "family" {
+		project = ResourceProject(image, project)
+		i, err := d.s.Images.Get(project, ResourceName(image)).Do()
+		if err == nil {
+			return i.SelfLink, checkReady("image", image, i.Status)
+		}
+
+		if apiErr, ok := err.(*googleapi.Error); len(parts) != 1 || !ok || apiErr.Code != 404 {
+			return "", sourceError("image", image, project, "compute.images.useReadOnly", err)
+		}
+	}
+
+	project = ResourceProject(image, project)
+	family := parts[len(parts)-1]
+	i, err := d.s.Images.GetFromFamily(project, family).Do()
+	if IsPermissionError(err) {
+		return "", sourceError("image family", image, project, "compute.images.useReadOnly", err)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error resolving image %q, no image or image family found: %s", image, err)
+	}
+
+	log15.Info("image family resolved", "family", family, "project", project, "image", i.Name)
+	return i.SelfLink, checkReady("image", i.Name, i.Status)
+}
+
+// resolveSnapshot retrieves the snapshot, of the disk project if given by
+// name, failing early if it doesn't exist, isn't ready or can't be used.
+func (d *Disk) resolveSnapshot(project, snapshot string) (string, error) {
+	project = ResourceProject(snapshot, project)
+	s, err := d.s.Snapshots.Get(project, ResourceName(snapshot)).Do()
+	if err != nil {
+		return "", sourceError("snapshot", snapshot, project, "compute.snapshots.useReadOnly", err)
+	}
+
+	return s.SelfLink, checkReady("snapshot", snapshot, s.Status)
+}
+
+// checkReady fails if the source of a disk isn't READY, a disk created from
+// it would only fail once the insert operation is done.
+func checkReady(kind, source, status string) error {
+	if status == "READY" {
+		return nil
+	}
+
+	return fmt.Errorf("unable to create disk from %s %q, it isn't ready, its status is %s", kind, source, status)
+}
+
+// resolveSourceDisk retrieves the disk to clone, of the disk project and zone
+// if given by name, failing early if it doesn't exist or can't be used.
+func (d *Disk) resolveSourceDisk(project, source string) (string, error) {
+	url := source
+	if !strings.Contains(source, "/") {
+		url = DiskURL(project, d.zone(), source)
+	}
+
+	project = ResourceProject(url, project)
+	var disk *compute.Disk
+	var err error
+	if zone := resourceZone(url); zone != "" {
+		disk, err = d.s.Disks.Get(project, zone, ResourceName(url)).Do()
+	} else {
+		disk, err = d.s.RegionDisks.Get(project, resourceRegion(url, d.region()), ResourceName(url)).Do()
+	}
+
+	if err != nil {
+		return "", sourceError("disk", source, project, "compute.disks.useReadOnly", err)
+	}
+
+	return disk.SelfLink, nil
+}
+
+// sourceError explains why the source of a disk can't be used, naming the
+// permission it requires when it's denied.
+func sourceError(kind, source, project, permission string, err error) error {
+	if IsPermissionError(err) {
+		return fmt.Errorf(
+			"unable to use %s %q of project %q, the service account needs %s on it: %s",
+			kind, source, project, permission, err,
+		)
+	}
+
+	return fmt.Errorf("error retrieving %s %q: %s", kind, source, err)
+}
+
+// checkSizePolicy decides if an existing disk with a size other than the
+// requested one can be used.
+func checkSizePolicy(c *DiskConfig, current *compute.Disk) error {
+	switch c.SizePolicy {
+	case SizePolicyIgnore:
+		return nil
+	case SizePolicyGrowOnly, "":
+		if c.SizeGb > current.SizeGb {
+			return nil
+		}
+
+		return withCode(ErrorCodeInvalidArgument, fmt.Errorf(
+			"unable to resize disk %q from %dGB to %dGB, GCE disks can't shrink",
+			current.Name, current.SizeGb, c.SizeGb,
+		))
+	default:
+		return withCode(ErrorCodeInvalidArgument, fmt.Errorf(
+			"disk %q already exists with %dGB but %dGB were requested, use SizePolicy to grow or ignore it",
+			current.Name, current.SizeGb, c.SizeGb,
+		))
+	}
+}
+
+func (d *Disk) resize(project string, current *compute.Disk, size int64, regional bool) error {
+	log15.Info("resizing disk", "disk", current.Name, "from", current.SizeGb, "to", size)
+
+	var op *compute.Operation
+	var err error
+	if regional {
+		op, err = d.s.RegionDisks.Resize(project, d.region(), current.Name, &compute.RegionDisksResizeRequest{
+			SizeGb: size,
+		}).Do()
+	} else {
+		op, err = d.s.Disks.Resize(project, d.zone(), current.Name, &compute.DisksResizeRequest{
+			SizeGb: size,
+		}).Do()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error resizing disk %q: %s", current.Name, err)
+	}
+
 	return d.WaitDone(op)
 }
 
-func (d *Disk) Delete(c *DiskConfig) error {
-	op, err := d.s.Disks.Delete(d.project, d.zone, c.Name).Do()
+// latestSnapshot returns the most recent ready snapshot having all the given
+// labels.
+func (d *Disk) latestSnapshot(project string, labels map[string]string) (*compute.Snapshot, error) {
+	var filters []string
+	for k, v := range labels {
+		filters = append(filters, fmt.Sprintf("labels.%s = %q", k, v))
+	}
+
+	sort.Strings(filters)
+
+	var latest *compute.Snapshot
+	var latestTime time.Time
+	err := d.s.Snapshots.List(project).Filter(strings.Join(filters, " AND ")).Pages(
+		context.Background(), func(l *compute.SnapshotList) error {
+			for _, s := range l.Items {
+				if s.Status != "READY" || !matchLabels(s.Labels, labels) {
+					continue
+				}
+
+				created, err := time.Parse(time.RFC3339, s.CreationTimestamp)
+				if err != nil {
+					return fmt.Errorf("invalid creation timestamp of snapshot %q: %s", s.Name, err)
+				}
+
+				if latest == nil || created.After(latestTime) {
+					latest, latestTime = s, created
+				}
+			}
+
+			return nil
+		},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %s", err)
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("no ready snapshot found with labels %s", strings.Join(filters, ", "))
+	}
+
+	return latest, nil
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *Disk) insert(project string, disk *compute.Disk, regional bool) error {
+	var op *compute.Operation
+	var err error
+	if regional {
+		op, err = d.s.RegionDisks.Insert(project, d.region(), disk).Do()
+	} else {
+		op, err = d.s.Disks.Insert(project, d.zone(), disk).Do()
+	}
+
 	if err != nil {
 		return err
 	}
@@ -78,11 +589,942 @@ func (d *Disk) Delete(c *DiskConfig) error {
 	return d.WaitDone(op)
 }
 
-func (d *Disk) List() ([]*compute.Disk, error) {
-	op, err := d.s.Disks.List(d.project, d.zone).Do()
+func (d *Disk) resourcePolicyURLs(project string, policies []string) []string {
+	var urls []string
+	for _, p := range policies {
+		urls = append(urls, ResourcePolicyURL(project, d.region(), p))
+	}
+
+	return urls
+}
+
+// changeType replaces the current disk with a new one of the requested type,
+// since GCE can't change it in place. The data is carried over with a
+// snapshot, which is only deleted once the new disk is created; if it can't
+// be, the disk is restored with its original type. The new disk keeps the
+// resource policies, the KMS key, the labels and the description of the
+// current one.
+func (d *Disk) changeType(project string, current, disk *compute.Disk) error {
+	if len(current.Users) != 0 {
+		return fmt.Errorf(
+			"unable to change type of disk %q, it's attached to %q, unmount it first",
+			current.Name, current.Users,
+		)
+	}
+
+	if err := keepEncryption(current, disk); err != nil {
+		return err
+	}
+
+	disk.ResourcePolicies = mergeResourcePolicies(current.ResourcePolicies, disk.ResourcePolicies)
+	disk.Description = current.Description
+	labels := make(map[string]string, 0)
+	for k, v := range current.Labels {
+		labels[k] = v
+	}
+
+	for k, v := range disk.Labels {
+		labels[k] = v
+	}
+
+	disk.Labels = labels
+
+	from, to := ResourceName(current.Type), ResourceName(disk.Type)
+	log15.Info("changing disk type", "disk", current.Name, "from", from, "to", to)
+
+	snapshot, err := d.snapshot(project, current.Name)
+	if err != nil {
+		return fmt.Errorf("error changing disk type, snapshot failed: %s", err)
+	}
+
+	if err := d.delete(project, current.Name, false); err != nil {
+		d.deleteSnapshot(project, snapshot)
+		return fmt.Errorf("error changing disk type, deleting old disk failed: %s", err)
+	}
+
+	disk.SourceSnapshot = SnapshotURL(project, snapshot)
+	disk.SourceImage = ""
+	if disk.SizeGb < current.SizeGb {
+		disk.SizeGb = current.SizeGb
+	}
+
+	if err := d.insert(project, disk, false); err != nil {
+		restored := restoredDisk(current)
+		restored.SourceSnapshot = disk.SourceSnapshot
+		if rerr := d.insert(project, restored, false); rerr != nil {
+			return fmt.Errorf(
+				"error changing disk type to %q: %s, restoring disk with type %q failed: %s, data is kept in snapshot %q",
+				to, err, from, rerr, snapshot,
+			)
+		}
+
+		d.deleteSnapshot(project, snapshot)
+		return fmt.Errorf("error changing disk type to %q: %s, disk was restored with type %q", to, err, from)
+	}
+
+	d.deleteSnapshot(project, snapshot)
+	return nil
+}
+
+// keepEncryption encrypts the new disk of a type change with the KMS key of
+// the current one, GCE would otherwise use a Google managed key, refusing the
+// change if the volume requests another encryption.
+func keepEncryption(current, disk *compute.Disk) error {
+	key := current.DiskEncryptionKey
+	if key != nil && key.KmsKeyName == "" {
+		return fmt.Errorf("unable to change type of disk %q, not supported on disks encrypted with a customer-supplied key", current.Name)
+	}
+
+	requested := disk.DiskEncryptionKey
+	switch {
+	case requested == nil && key == nil:
+	case requested == nil:
+		disk.DiskEncryptionKey = &compute.CustomerEncryptionKey{KmsKeyName: kmsKeyName(key.KmsKeyName)}
+	case requested.KmsKeyName == "" || !encryptedWith(current, requested.KmsKeyName):
+		return fmt.Errorf("unable to change type of disk %q, it isn't encrypted with the requested key, GCE can't change it", current.Name)
+	}
+
+	return nil
+}
+
+// kmsKeyName returns the key of a key version, the disks are created with
+// the key and GCE picks its primary version.
+func kmsKeyName(version string) string {
+	return strings.Split(version, "/cryptoKeyVersions/")[0]
+}
+
+// mergeResourcePolicies returns the policies of the current disk followed by
+// the requested ones it doesn't have yet, compared by name since they are
+// given either as self links or as relative URLs.
+func mergeResourcePolicies(current, requested []string) []string {
+	policies := append([]string{}, current...)
+	for _, r := range requested {
+		var found bool
+		for _, p := range current {
+			if ResourceName(p) == ResourceName(r) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			policies = append(policies, r)
+		}
+	}
+
+	return policies
+}
+
+// restoredDisk returns a disk like the current one, to recreate it when a
+// type change fails.
+func restoredDisk(current *compute.Disk) *compute.Disk {
+	disk := &compute.Disk{
+		Name:                  current.Name,
+		Type:                  current.Type,
+		SizeGb:                current.SizeGb,
+		Description:           current.Description,
+		Labels:                current.Labels,
+		ResourcePolicies:      current.ResourcePolicies,
+		ProvisionedIops:       current.ProvisionedIops,
+		ProvisionedThroughput: current.ProvisionedThroughput,
+		AccessMode:            current.AccessMode,
+		StoragePool:           current.StoragePool,
+	}
+
+	if current.DiskEncryptionKey != nil {
+		disk.DiskEncryptionKey = &compute.CustomerEncryptionKey{
+			KmsKeyName: kmsKeyName(current.DiskEncryptionKey.KmsKeyName),
+		}
+	}
+
+	return disk
+}
+
+func (d *Disk) snapshot(project, disk string) (string, error) {
+	suffix := fmt.Sprintf(DiskTypeChangeSnapshotBaseName, "", time.Now().Format("20060102150405"))
+	name := disk
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+
+	name += suffix
+
+	op, err := d.s.Disks.CreateSnapshot(project, d.zone(), disk, &compute.Snapshot{
+		Name: name,
+	}).Do()
+	if err != nil {
+		return "", err
+	}
+
+	return name, d.waitDone(op, MaxSnapshotWaitDuration)
+}
+
+// SnapshotToRegion creates a snapshot of the disk stored in the given region,
+// for disaster recovery. The snapshot is labeled with the disk and the
+// regions, if it fails the incomplete snapshot is deleted. taken is called
+// once the content of the disk is captured, before the snapshot is uploaded,
+// so the disk can be written again.
+func (d *Disk) SnapshotToRegion(c *DiskConfig, region string, taken func()) (*compute.Snapshot, error) {
+	if region == d.region() {
+		return nil, fmt.Errorf("invalid region %q, the disk is already in it", region)
+	}
+
+	if c.Regional {
+		return nil, fmt.Errorf("unable to snapshot disk %q to another region, not supported on regional disks", c.Name)
+	}
+
+	if _, err := d.s.Regions.Get(d.project, region).Do(); err != nil {
+		return nil, fmt.Errorf("invalid region %q: %s", region, err)
+	}
+
+	suffix := fmt.Sprintf(RegionSnapshotBaseName, "", region, time.Now().Format("20060102150405"))
+	name := c.Name
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+
+	snapshot := &compute.Snapshot{
+		Name:             name + suffix,
+		StorageLocations: []string{region},
+		Labels: map[string]string{
+			"source-disk":   c.Name,
+			"source-region": d.region(),
+			"dr-region":     region,
+		},
+	}
+
+	if c.CsekKey != "" {
+		snapshot.SourceDiskEncryptionKey = csekEncryptionKey(c.CsekKey)
+		snapshot.SnapshotEncryptionKey = csekEncryptionKey(c.CsekKey)
+	}
+
+	project := d.diskProject(c)
+	op, err := d.s.Disks.CreateSnapshot(project, d.zone(), c.Name, snapshot).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.waitSnapshotTaken(project, op, snapshot.Name, MaxSnapshotWaitDuration); err != nil {
+		log15.Warn("error waiting for the snapshot to be taken", "snapshot", snapshot.Name, "error", err)
+	}
+
+	taken()
+	if err := d.waitDone(op, MaxSnapshotWaitDuration); err != nil {
+		d.deleteSnapshot(project, snapshot.Name)
+		return nil, fmt.Errorf("error creating snapshot %q, it was deleted: %s", snapshot.Name, err)
+	}
+
+	created, err := d.s.Snapshots.Get(project, snapshot.Name).Do()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %q created but can't be retrieved: %s", snapshot.Name, err)
+	}
+
+	if created.Status != "READY" {
+		return created, fmt.Errorf("snapshot %q created but it's %s", snapshot.Name, created.Status)
+	}
+
+	return created, nil
+}
+
+// waitSnapshotTaken waits for the snapshot to leave CREATING, once it's
+// UPLOADING the content of the disk is captured and only the upload is left,
+// or for its operation to be done, if it failed.
+func (d *Disk) waitSnapshotTaken(project string, op *compute.Operation, name string, max time.Duration) error {
+	doer := d.operationGetter(op)
+	clock := d.backoff.Clock()
+	start := clock.Now()
+	for {
+		clock.Sleep(WaitDoneInterval)
+
+		s, err := d.s.Snapshots.Get(project, name).Do()
+		if err == nil && s.Status != "CREATING" {
+			return nil
+		}
+
+		if rop, err := doer(); err == nil && rop.Status == "DONE" {
+			return nil
+		}
+
+		if clock.Now().Sub(start) > max {
+			return fmt.Errorf("max. time reached waiting for snapshot %q to be taken", name)
+		}
+	}
+}
+
+// Snapshot creates a snapshot of the disk labeled with its name, kept after
+// the disk is deleted. If it fails the incomplete snapshot is deleted.
+func (d *Disk) Snapshot(c *DiskConfig) (*compute.Snapshot, error) {
+	suffix := fmt.Sprintf(RemoveSnapshotBaseName, "", time.Now().Format("20060102150405"))
+	name := c.Name
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+
+	snapshot := &compute.Snapshot{
+		Name:   name + suffix,
+		Labels: map[string]string{"source-disk": c.Name},
+	}
+
+	if c.CsekKey != "" {
+		snapshot.SourceDiskEncryptionKey = csekEncryptionKey(c.CsekKey)
+		snapshot.SnapshotEncryptionKey = csekEncryptionKey(c.CsekKey)
+	}
+
+	project := d.diskProject(c)
+	var op *compute.Operation
+	var err error
+	if c.Regional {
+		op, err = d.s.RegionDisks.CreateSnapshot(project, d.region(), c.Name, snapshot).Do()
+	} else {
+		op, err = d.s.Disks.CreateSnapshot(project, d.zone(), c.Name, snapshot).Do()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.waitDone(op, MaxSnapshotWaitDuration); err != nil {
+		d.deleteSnapshot(project, snapshot.Name)
+		return nil, fmt.Errorf("error creating snapshot %q, it was deleted: %s", snapshot.Name, err)
+	}
+
+	return snapshot, nil
+}
+
+func (d *Disk) deleteSnapshot(project, name string) {
+	op, err := d.s.Snapshots.Delete(project, name).Do()
+	if err == nil {
+		err = d.WaitDone(op)
+	}
+
+	if err != nil {
+		log15.Warn("error deleting snapshot", "snapshot", name, "error", err)
+	}
+}
+
+func (d *Disk) Attach(c *DiskConfig) error {
+	instance, err := d.s.Instances.Get(d.project, d.zone(), d.instance).Do()
+	if c.MultiWriter && instance != nil {
+		if name, ok := d.attachedTo(c, instance); ok {
+			log15.Info("multi-writer disk still attached, reusing it", "disk", c.Name, "device-name", name)
+			c.CustomDeviceName = name
+			return nil
+		}
+	}
+
+	if err := d.checkAttachable(c); err != nil {
+		return err
+	}
+
+	var remaining int
+	if err == nil {
+		remaining, err = d.remainingSlots(instance)
+	}
+
+	if err != nil {
+		log15.Warn("error checking attached disk limit", "disk", c.Name, "error", err)
+	}
+
+	if err == nil && remaining <= 0 {
+		return withCode(ErrorCodeLimitExceeded, fmt.Errorf(
+			"unable to attach disk %q, instance %q reached its limit of attached disks",
+			c.Name, d.instance,
+		))
+	}
+
+	ad := &compute.AttachedDisk{
+		Source:     d.diskURL(c),
+		DeviceName: c.DeviceName(),
+	}
+
+	if instance != nil {
+		ad.DeviceName = d.freeDeviceName(c, instance)
+	}
+
+	if c.ReadOnly {
+		ad.Mode = "READ_ONLY"
+	}
+
+	if ad.DeviceName != c.DeviceName() {
+		log15.Warn("device name already used by another disk of the instance, using a unique one",
+			"disk", c.Name, "requested", c.DeviceName(), "device-name", ad.DeviceName,
+		)
+
+		c.CustomDeviceName = ad.DeviceName
+	}
+
+	if c.CsekKey != "" {
+		ad.DiskEncryptionKey = csekEncryptionKey(c.CsekKey)
+	}
+
+	op, err := d.s.Instances.AttachDisk(d.project, d.zone(), d.instance, ad).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 403 && c.Project != "" {
+		return withCode(ErrorCodePermissionDenied, fmt.Errorf(
+			"unable to attach disk %q of project %q to instance %q, the service account needs compute.disks.use on it: %s",
+			c.Name, c.Project, d.instance, err,
+		))
+	}
+
+	if err != nil {
+		return d.encryptionKeyError(c, err)
+	}
+
+	if err := d.WaitDone(op); err != nil {
+		return err
+	}
+
+	if DebugAttach {
+		d.logAttachedDisk(c, op)
+	}
+
+	return nil
+}
+
+// checkAttachable checks a disk of another project than the instance one
+// before attaching it, attachDisk only failing with a bare permission or not
+// found error when the service account can't use it or it isn't in the zone
+// of the instance.
+func (d *Disk) checkAttachable(c *DiskConfig) error {
+	if c.Project == "" || c.Project == d.project {
+		return nil
+	}
+
+	disk, err := d.Get(c)
+	if IsPermissionError(err) {
+		return withCode(ErrorCodePermissionDenied, fmt.Errorf(
+			"unable to attach disk %q of project %q to instance %q, the service account needs compute.disks.get and compute.disks.use on it: %s",
+			c.Name, c.Project, d.instance, err,
+		))
+	}
+
+	if IsNotFoundError(err) {
+		location := fmt.Sprintf("zone %q", d.zone())
+		if c.Regional {
+			location = fmt.Sprintf("region %q", d.region())
+		}
+
+		return withCode(ErrorCodeNotFound, fmt.Errorf(
+			"unable to attach disk %q of project %q to instance %q, it doesn't exist in the %s of the instance: %s",
+			c.Name, c.Project, d.instance, location, err,
+		))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.Regional && !hasReplicaZone(disk, d.zone()) {
+		return withCode(ErrorCodeInvalidArgument, fmt.Errorf(
+			"unable to attach disk %q of project %q to instance %q, it isn't replicated in zone %q of the instance",
+			c.Name, c.Project, d.instance, d.zone(),
+		))
+	}
+
+	return nil
+}
+
+func hasReplicaZone(disk *compute.Disk, zone string) bool {
+	for _, z := range disk.ReplicaZones {
+		if ResourceName(z) == zone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encryptionKeyError explains an attach failure caused by a missing or wrong
+// customer-supplied key, checking the key of the disk only once it failed.
+func (d *Disk) encryptionKeyError(c *DiskConfig, err error) error {
+	disk, gerr := d.Get(c)
+	if gerr != nil || disk.DiskEncryptionKey == nil || disk.DiskEncryptionKey.Sha256 == "" {
+		return err
+	}
+
+	if c.CsekKey == "" {
+		return fmt.Errorf(
+			"unable to attach disk %q, it's encrypted with a customer-supplied key and no CsekKey was given: %s",
+			c.Name, err,
+		)
+	}
+
+	if sum, ok := csekSha256(c.CsekKey); ok && sum != disk.DiskEncryptionKey.Sha256 {
+		return fmt.Errorf("unable to attach disk %q, CsekKey isn't the key it's encrypted with: %s", c.Name, err)
+	}
+
+	return err
+}
+
+// logAttachedDisk logs the disk as attached to the instance, to verify the
+// device it got when the device path can't be found.
+func (d *Disk) logAttachedDisk(c *DiskConfig, op *compute.Operation) {
+	instance, err := d.s.Instances.Get(d.project, d.zone(), d.instance).Do()
+	if err != nil {
+		log15.Debug("error retrieving attached disk", "disk", c.Name, "error", err)
+		return
+	}
+
+	for _, ad := range instance.Disks {
+		if ad.DeviceName != c.DeviceName() {
+			continue
+		}
+
+		log15.Debug("disk attached",
+			"disk", c.Name, "operation", op.Name, "device-name", ad.DeviceName, "dev", c.Dev(),
+			"index", ad.Index, "interface", ad.Interface, "mode", ad.Mode, "source", ad.Source,
+		)
+
+		return
+	}
+
+	log15.Debug("attached disk not found in instance", "disk", c.Name, "device-name", c.DeviceName())
+}
+
+// RemainingSlots returns how many more disks can be attached to the instance,
+// the limit depends on its machine type.
+func (d *Disk) RemainingSlots() (int, error) {
+	instance, err := d.s.Instances.Get(d.project, d.zone(), d.instance).Do()
+	if err != nil {
+		return 0, err
+	}
+
+	return d.remainingSlots(instance)
+}
+
+func (d *Disk) remainingSlots(instance *compute.Instance) (int, error) {
+	max, err := d.maxAttachedDisks(instance.MachineType)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(max) - len(instance.Disks), nil
+}
+
+// AttachedDisks returns the names of the disks attached to the instance.
+func (d *Disk) AttachedDisks() (map[string]bool, error) {
+	var instance *compute.Instance
+	err := d.retry(func() error {
+		var err error
+		instance, err = d.s.Instances.Get(d.project, d.zone(), d.instance).Do()
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, 0)
+	for _, ad := range instance.Disks {
+		names[ResourceName(ad.Source)] = true
+	}
+
+	return names, nil
+}
+
+// attachedDeviceName returns the device name the disk is attached with, which
+// may not be the one of the config, e.g. when the DeviceName option was lost
+// with a restart of the plugin.
+func (d *Disk) attachedDeviceName(c *DiskConfig) string {
+	instance, err := d.s.Instances.Get(d.project, d.zone(), d.instance).Do()
+	if err != nil {
+		log15.Warn("error retrieving attached device name", "disk", c.Name, "error", err)
+		return c.DeviceName()
+	}
+
+	if name, ok := d.attachedTo(c, instance); ok {
+		return name
+	}
+
+	return c.DeviceName()
+}
+
+// freeDeviceName returns the device name of the disk if no other disk of the
+// instance uses it, e.g. two disks of different projects with the same name,
+// or the first of name-2, name-3... not used otherwise.
+func (d *Disk) freeDeviceName(c *DiskConfig, instance *compute.Instance) string {
+	name := c.DeviceName()
+	project := d.diskProject(c)
+	used := make(map[string]bool, 0)
+	for _, ad := range instance.Disks {
+		if ResourceName(ad.Source) == c.Name && ResourceProject(ad.Source, d.project) == project {
+			continue
+		}
+
+		used[ad.DeviceName] = true
+	}
+
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		base := name
+		if len(base)+len(suffix) > MaxDeviceNameLength {
+			base = base[:MaxDeviceNameLength-len(suffix)]
+		}
+
+		candidate = base + suffix
+	}
+
+	return candidate
+}
+
+// attachedTo returns the device name of the disk if it's attached to the
+// instance.
+func (d *Disk) attachedTo(c *DiskConfig, instance *compute.Instance) (string, bool) {
+	project := d.diskProject(c)
+	for _, ad := range instance.Disks {
+		if ResourceName(ad.Source) == c.Name && ResourceProject(ad.Source, d.project) == project {
+			return ad.DeviceName, true
+		}
+	}
+
+	return "", false
+}
+
+// otherUsers returns the names of the instances, besides this one, the disk
+// is attached to.
+func (d *Disk) otherUsers(c *DiskConfig) ([]string, error) {
+	disk, err := d.Get(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, u := range disk.Users {
+		if ResourceName(u) == d.instance && resourceZone(u) == d.zone() && ResourceProject(u, d.project) == d.project {
+			continue
+		}
+
+		users = append(users, ResourceName(u))
+	}
+
+	return users, nil
+}
+
+// SetZone moves the provider to zone, e.g. read again from the metadata server
+// after the instance is re-provisioned elsewhere, loading its region and
+// dropping the zone scoped caches, so no lookup is done in the previous zone.
+func (d *Disk) SetZone(zone string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	previous := d.zone()
+	if zone == previous {
+		return nil
+	}
+
+	if err := d.setZone(zone); err != nil {
+		return err
+	}
+
+	d.machineType, d.maxDisks, d.cpus, d.diskTypes = "", 0, 0, nil
+	log15.Info("zone changed, caches invalidated", "zone", zone, "previous", previous, "region", d.region())
+	return nil
+}
+
+func (d *Disk) maxAttachedDisks(machineType string) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if err := d.loadMachineType(machineType); err != nil {
+		return 0, err
+	}
+
+	return d.maxDisks, nil
+}
+
+func (d *Disk) guestCpus(machineType string) (int64, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if err := d.loadMachineType(machineType); err != nil {
+		return 0, err
+	}
+
+	return d.cpus, nil
+}
+
+func (d *Disk) loadMachineType(machineType string) error {
+	if d.machineType == machineType {
+		return nil
+	}
+
+	mt, err := d.s.MachineTypes.Get(d.project, d.zone(), ResourceName(machineType)).Do()
+	if err != nil {
+		return fmt.Errorf("error retrieving machine type %q: %s", ResourceName(machineType), err)
+	}
+
+	d.machineType, d.maxDisks, d.cpus = machineType, mt.MaximumPersistentDisks, mt.GuestCpus
+	return nil
+}
+
+// EffectivePerformance returns the performance provisioned on a hyperdisk
+// and the one the instance gets from it, nil for the other disk types.
+func (d *Disk) EffectivePerformance(c *DiskConfig) (*EffectivePerformance, error) {
+	disk, err := d.Get(c)
+	if err != nil {
+		return nil, err
+	}
+
+	l := hyperdiskInstanceLimit(ResourceName(disk.Type))
+	if l == nil {
+		return nil, nil
+	}
+
+	instance, err := d.s.Instances.Get(d.project, d.zone(), d.instance).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	cpus, err := d.guestCpus(instance.MachineType)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEffectivePerformance(l, disk, cpus), nil
+}
+
+func (d *Disk) Detach(c *DiskConfig) error {
+	if c.MultiWriter {
+		users, err := d.otherUsers(c)
+		if err != nil {
+			log15.Warn("error checking the other users of multi-writer disk", "disk", c.Name, "error", err)
+		}
+
+		if len(users) != 0 {
+			log15.Info("multi-writer disk used by other instances, keeping it attached", "disk", c.Name, "users", users)
+			return nil
+		}
+	}
+
+	op, err := d.s.Instances.DetachDisk(d.project, d.zone(), d.instance, d.attachedDeviceName(c)).Do()
+	if err != nil {
+		return err
+	}
+
+	return d.WaitDone(op)
+}
+
+func (d *Disk) Delete(c *DiskConfig) error {
+	return d.projectError(c, "compute.disks.delete", d.delete(d.diskProject(c), c.Name, c.Regional))
+}
+
+// projectError names the permission the service account lacks when a call
+// on a disk of another project than the instance one is denied.
+func (d *Disk) projectError(c *DiskConfig, permission string, err error) error {
+	project := d.diskProject(c)
+	if !IsPermissionError(err) || project == d.project {
+		return err
+	}
+
+	return withCode(ErrorCodePermissionDenied, fmt.Errorf(
+		"unable to access disk %q of project %q from instance %q, the service account needs %s on it: %s",
+		c.Name, project, d.instance, permission, err,
+	))
+}
+
+func (d *Disk) delete(project, name string, regional bool) error {
+	var op *compute.Operation
+	var err error
+	if regional {
+		op, err = d.s.RegionDisks.Delete(project, d.region(), name).Do()
+	} else {
+		op, err = d.s.Disks.Delete(project, d.zone(), name).Do()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return d.WaitDone(op)
+}
+
+// UpdateLabels sets the given labels on the disk, keeping the rest of them,
+// an empty value removes the label. GCE rejects the update if the labels
+// changed since they were read, in that case the update is retried.
+func (d *Disk) UpdateLabels(c *DiskConfig, labels map[string]string) error {
+	var attempt int
+	err := d.backoff.Retry(MaxLabelUpdateRetries, isFingerprintMismatch, func() error {
+		attempt++
+		if attempt > 1 {
+			log15.Debug("label fingerprint mismatch, retrying", "disk", c.Name, "attempt", attempt)
+		}
+
+		return d.updateLabels(d.diskProject(c), c.Name, labels, c.Regional)
+	})
+
+	if isFingerprintMismatch(err) {
+		return fmt.Errorf("error updating labels of disk %q: %s", c.Name, err)
+	}
+
+	return err
+}
+
+// IsPermissionError reports whether a call failed because the service
+// account lacks a permission.
+func IsPermissionError(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == 403
+}
+
+// IsNotFoundError reports whether a call failed because the resource doesn't
+// exist.
+func IsNotFoundError(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == 404
+}
+
+func isFingerprintMismatch(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == 412
+}
+
+func (d *Disk) updateLabels(project, name string, labels map[string]string, regional bool) error {
+	disk, err := d.getDisk(project, name, regional)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]string, 0)
+	for k, v := range disk.Labels {
+		merged[k] = v
+	}
+
+	for k, v := range labels {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+
+		merged[k] = v
+	}
+
+	var op *compute.Operation
+	if regional {
+		op, err = d.s.RegionDisks.SetLabels(project, d.region(), name, &compute.RegionSetLabelsRequest{
+			Labels:           merged,
+			LabelFingerprint: disk.LabelFingerprint,
+		}).Do()
+	} else {
+		op, err = d.s.Disks.SetLabels(project, d.zone(), name, &compute.ZoneSetLabelsRequest{
+			Labels:           merged,
+			LabelFingerprint: disk.LabelFingerprint,
+		}).Do()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return d.WaitDone(op)
+}
+
+// CheckResourcePolicies verifies that the policies exist, the ones given by
+// name in the project and region of the instance.
+func (d *Disk) CheckResourcePolicies(policies []string) error {
+	for _, p := range policies {
+		url := ResourcePolicyURL(d.project, d.region(), p)
+		project, region, name := ResourceProject(url, d.project), resourceRegion(url, d.region()), ResourceName(url)
+		if region != d.region() {
+			return fmt.Errorf("invalid resource policy %q, it must be in the region of the disks, %s", p, d.region())
+		}
+
+		if err := d.retry(func() error {
+			_, err := d.s.ResourcePolicies.Get(project, region, name).Do()
+			return err
+		}); err != nil {
+			return fmt.Errorf("error retrieving resource policy %q: %s", p, err)
+		}
+	}
+
+	return nil
+}
+
+// AddSnapshotSchedule attaches the SnapshotSchedule resource policy to the
+// disk, if it isn't already attached, the policy must be a snapshot schedule.
+func (d *Disk) AddSnapshotSchedule(c *DiskConfig) error {
+	project := d.diskProject(c)
+	url := ResourcePolicyURL(project, d.region(), c.SnapshotSchedule)
+	policyProject, region, name := ResourceProject(url, project), resourceRegion(url, d.region()), ResourceName(url)
+	if region != d.region() {
+		return fmt.Errorf("invalid snapshot schedule %q, it must be in the region of the disks, %s", c.SnapshotSchedule, d.region())
+	}
+
+	var policy *compute.ResourcePolicy
+	if err := d.retry(func() error {
+		var err error
+		policy, err = d.s.ResourcePolicies.Get(policyProject, region, name).Do()
+		return err
+	}); err != nil {
+		return fmt.Errorf("error retrieving snapshot schedule %q: %s", c.SnapshotSchedule, err)
+	}
+
+	if policy.SnapshotSchedulePolicy == nil {
+		return fmt.Errorf("invalid snapshot schedule %q, the resource policy isn't a snapshot schedule", c.SnapshotSchedule)
+	}
+
+	disk, err := d.Get(c)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range disk.ResourcePolicies {
+		if ResourceProject(p, project) == policyProject && ResourceName(p) == name {
+			return nil
+		}
+	}
+
+	policies := []string{ResourcePolicyURL(policyProject, region, name)}
+
+	var op *compute.Operation
+	if c.Regional {
+		op, err = d.s.RegionDisks.AddResourcePolicies(project, d.region(), c.Name, &compute.RegionDisksAddResourcePoliciesRequest{
+			ResourcePolicies: policies,
+		}).Do()
+	} else {
+		op, err = d.s.Disks.AddResourcePolicies(project, d.zone(), c.Name, &compute.DisksAddResourcePoliciesRequest{
+			ResourcePolicies: policies,
+		}).Do()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error adding snapshot schedule %q to disk %q: %s", c.SnapshotSchedule, c.Name, err)
+	}
+
+	return d.WaitDone(op)
+}
+
+func (d *Disk) Get(c *DiskConfig) (*compute.Disk, error) {
+	var disk *compute.Disk
+	err := d.retry(func() error {
+		var err error
+		disk, err = d.getDisk(d.diskProject(c), c.Name, c.Regional)
+		return err
+	})
+
+	return disk, err
+}
+
+// diskProject returns the project of the disk, the one of the instance unless
+// the volume sets another one.
+func (d *Disk) diskProject(c *DiskConfig) string {
+	if c.Project != "" {
+		return c.Project
+	}
+
+	return d.project
+}
+
+func (d *Disk) List() ([]*compute.Disk, error) {
+	var l *compute.DiskList
+	err := d.retry(func() error {
+		var err error
+		l, err = d.s.Disks.List(d.project, d.zone()).Do()
+		return err
+	})
+
 	if err != nil {
 		return nil, err
 	}
 
-	return op.Items, err
+	return l.Items, err
 }
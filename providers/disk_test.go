@@ -1,6 +1,15 @@
 package providers
 
-import . "gopkg.in/check.v1"
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
 
 type DiskSuite struct {
 	BaseSuite
@@ -32,3 +41,1287 @@ func (s *DiskSuite) TestCreate(c *C) {
 	err = n.Delete(config)
 	c.Assert(err, IsNil)
 }
+
+func (s *DiskSuite) TestCreateChangeType(c *C) {
+	if !*integration {
+		c.Skip("-integration not provided")
+	}
+
+	n, err := NewDisk(s.c, s.project, s.zone, s.instance)
+	c.Assert(err, IsNil)
+
+	config := &DiskConfig{
+		Name: "test-" + s.getRandomName(),
+		Type: "pd-standard",
+	}
+
+	err = n.Create(config)
+	c.Assert(err, IsNil)
+
+	config.Type = "pd-ssd"
+	config.AllowTypeChange = true
+	err = n.Create(config)
+	c.Assert(err, IsNil)
+
+	disks, err := n.List()
+	c.Assert(err, IsNil)
+	for _, d := range disks {
+		if d.Name == config.Name {
+			c.Assert(ResourceName(d.Type), Equals, "pd-ssd")
+		}
+	}
+
+	err = n.Delete(config)
+	c.Assert(err, IsNil)
+}
+
+type DiskFixtureSuite struct {
+	f *ComputeFixture
+	d *Disk
+}
+
+var _ = Suite(&DiskFixtureSuite{})
+
+func (s *DiskFixtureSuite) SetUpTest(c *C) {
+	s.f = NewComputeFixture()
+	s.d = s.f.Disk()
+}
+
+func (s *DiskFixtureSuite) TearDownTest(c *C) {
+	s.f.Close()
+}
+
+func (s *DiskFixtureSuite) TestUpdateLabels(c *C) {
+	var set *compute.ZoneSetLabelsRequest
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{
+			Name:             "foo",
+			Labels:           map[string]string{"foo": "bar", "qux": "baz"},
+			LabelFingerprint: "42",
+		}
+	})
+
+	s.f.Handle("POST", "/disks/foo/setLabels", func(r *http.Request) (int, interface{}) {
+		set = &compute.ZoneSetLabelsRequest{}
+		json.NewDecoder(r.Body).Decode(set)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.UpdateLabels(&DiskConfig{Name: "foo"}, map[string]string{"foo": "", "bar": "qux"})
+	c.Assert(err, IsNil)
+	c.Assert(set.LabelFingerprint, Equals, "42")
+	c.Assert(set.Labels, DeepEquals, map[string]string{"bar": "qux", "qux": "baz"})
+}
+
+func (s *DiskFixtureSuite) TestUpdateLabelsFingerprintConflict(c *C) {
+	var fingerprint int
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		fingerprint++
+		return http.StatusOK, &compute.Disk{Name: "foo", LabelFingerprint: strconv.Itoa(fingerprint)}
+	})
+
+	s.f.Handle("POST", "/disks/foo/setLabels", func(r *http.Request) (int, interface{}) {
+		set := &compute.ZoneSetLabelsRequest{}
+		json.NewDecoder(r.Body).Decode(set)
+		if set.LabelFingerprint == "1" {
+			return http.StatusPreconditionFailed, ComputeError(412, "Labels fingerprint either invalid or resource labels have changed")
+		}
+
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.UpdateLabels(&DiskConfig{Name: "foo"}, map[string]string{"foo": "bar"})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("GET", "/disks/foo"), Equals, 2)
+	c.Assert(s.f.Count("POST", "/disks/foo/setLabels"), Equals, 2)
+}
+
+func (s *DiskFixtureSuite) TestUpdateLabelsMaxRetries(c *C) {
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo"}
+	})
+
+	s.f.Handle("POST", "/disks/foo/setLabels", func(r *http.Request) (int, interface{}) {
+		return http.StatusPreconditionFailed, ComputeError(412, "Labels fingerprint either invalid or resource labels have changed")
+	})
+
+	err := s.d.UpdateLabels(&DiskConfig{Name: "foo"}, map[string]string{"foo": "bar"})
+	c.Assert(err, NotNil)
+	c.Assert(s.f.Count("POST", "/disks/foo/setLabels"), Equals, MaxLabelUpdateRetries)
+}
+
+func (s *DiskFixtureSuite) TestCreateFromLatestSnapshot(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("GET", "/global/snapshots", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.SnapshotList{Items: []*compute.Snapshot{
+			{Name: "old", Status: "READY", CreationTimestamp: "2016-01-01T00:00:00.000-07:00", Labels: map[string]string{"app": "foo"}, SelfLink: "old"},
+			{Name: "new", Status: "READY", CreationTimestamp: "2016-02-01T00:00:00.000-07:00", Labels: map[string]string{"app": "foo"}, SelfLink: "new"},
+			{Name: "pending", Status: "CREATING", CreationTimestamp: "2016-03-01T00:00:00.000-07:00", Labels: map[string]string{"app": "foo"}, SelfLink: "pending"},
+			{Name: "other", Status: "READY", CreationTimestamp: "2016-03-01T00:00:00.000-07:00", Labels: map[string]string{"app": "bar"}, SelfLink: "other"},
+		}}
+	})
+
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SourceSnapshotLabels: map[string]string{"app": "foo"}})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SourceSnapshot, Equals, "new")
+}
+
+func (s *DiskFixtureSuite) TestCreateFromLatestSnapshotNotFound(c *C) {
+	s.f.Handle("GET", "/global/snapshots", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.SnapshotList{}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SourceSnapshotLabels: map[string]string{"app": "foo"}})
+	c.Assert(err, ErrorMatches, `no ready snapshot found with labels labels.app = "foo"`)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestSnapshotToRegion(c *C) {
+	var created *compute.Snapshot
+	s.f.Handle("GET", "/regions/other", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Region{Name: "other"}
+	})
+
+	s.f.Handle("POST", "/disks/foo/createSnapshot", func(r *http.Request) (int, interface{}) {
+		created = &compute.Snapshot{}
+		json.NewDecoder(r.Body).Decode(created)
+		return ComputeOperation("zone")
+	})
+
+	statuses := []string{"CREATING", "UPLOADING", "READY"}
+	var gets, takenAfter int
+	s.f.Handle("GET", "/global/snapshots/*", func(r *http.Request) (int, interface{}) {
+		created.Status = statuses[gets]
+		if gets < len(statuses)-1 {
+			gets++
+		}
+
+		return http.StatusOK, created
+	})
+
+	s.f.Handle("GET", "/operations/op", func(r *http.Request) (int, interface{}) {
+		if created.Status == "CREATING" {
+			return http.StatusOK, &compute.Operation{Name: "op", Status: "RUNNING"}
+		}
+
+		return http.StatusOK, &compute.Operation{Name: "op", Status: "DONE"}
+	})
+
+	snapshot, err := s.d.SnapshotToRegion(&DiskConfig{Name: "foo"}, "other", func() { takenAfter = gets })
+	c.Assert(err, IsNil)
+	c.Assert(takenAfter, Equals, 2)
+	c.Assert(snapshot.Name, Matches, "foo-other-[0-9]+")
+	c.Assert(snapshot.StorageLocations, DeepEquals, []string{"other"})
+	c.Assert(snapshot.Labels["source-disk"], Equals, "foo")
+	c.Assert(snapshot.Labels["source-region"], Equals, "region")
+}
+
+func (s *DiskFixtureSuite) TestSnapshotToRegionFailed(c *C) {
+	s.f.Handle("GET", "/regions/other", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Region{Name: "other"}
+	})
+
+	s.f.Handle("POST", "/disks/foo/createSnapshot", func(r *http.Request) (int, interface{}) {
+		return ComputeOperation("zone")
+	})
+
+	s.f.Handle("GET", "/operations/op", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Operation{Name: "op", Status: "DONE", Error: &compute.OperationError{
+			Errors: []*compute.OperationErrorErrors{{Message: "quota exceeded"}},
+		}}
+	})
+
+	var taken bool
+	_, err := s.d.SnapshotToRegion(&DiskConfig{Name: "foo"}, "other", func() { taken = true })
+	c.Assert(err, ErrorMatches, `error creating snapshot "foo-other-[0-9]+", it was deleted: operation "op" failed: quota exceeded`)
+	c.Assert(s.f.Count("DELETE", "/global/snapshots/foo-other-*"), Equals, 1)
+	c.Assert(taken, Equals, true)
+
+	_, err = s.d.SnapshotToRegion(&DiskConfig{Name: "foo"}, "region", func() {})
+	c.Assert(err, NotNil)
+}
+
+func (s *DiskFixtureSuite) TestSnapshot(c *C) {
+	var created *compute.Snapshot
+	s.f.Handle("POST", "/zones/zone/disks/foo/createSnapshot", func(r *http.Request) (int, interface{}) {
+		created = &compute.Snapshot{}
+		json.NewDecoder(r.Body).Decode(created)
+		return ComputeOperation("zone")
+	})
+
+	snapshot, err := s.d.Snapshot(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Name, Matches, "foo-removed-[0-9]+")
+	c.Assert(created.Name, Equals, snapshot.Name)
+	c.Assert(created.Labels["source-disk"], Equals, "foo")
+
+	s.f.Handle("POST", "/regions/region/disks/bar/createSnapshot", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Operation{Name: "op", Region: "region", Status: "PENDING"}
+	})
+
+	_, err = s.d.Snapshot(&DiskConfig{Name: "bar", Regional: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/regions/region/disks/bar/createSnapshot"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestSnapshotTypeChangeLongName(c *C) {
+	disk := strings.Repeat("a", 60)
+
+	var created *compute.Snapshot
+	s.f.Handle("POST", "/zones/zone/disks/"+disk+"/createSnapshot", func(r *http.Request) (int, interface{}) {
+		created = &compute.Snapshot{}
+		json.NewDecoder(r.Body).Decode(created)
+		return ComputeOperation("zone")
+	})
+
+	name, err := s.d.snapshot("project", disk)
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks/"+disk+"/createSnapshot"), Equals, 1)
+	c.Assert(len(name) <= 63, Equals, true)
+	c.Assert(name, Matches, "a+-type-change-[0-9]+")
+	c.Assert(created.Name, Equals, name)
+}
+
+func (s *DiskFixtureSuite) handleExistingDisk(size int64) {
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo", SizeGb: size, Type: DiskTypeURL("project", "zone", "")}
+	})
+}
+
+func (s *DiskFixtureSuite) TestCreateDiskType(c *C) {
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo", SizeGb: 10, Type: DiskTypeURL("project", "zone", "pd-balanced")}
+	})
+	s.f.Handle("GET", "/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{
+			{Name: "pd-ssd"}, {Name: "hyperdisk-balanced"}, {Name: "pd-balanced"},
+			{Name: "local-ssd", Deprecated: &compute.DeprecationStatus{State: "DEPRECATED"}},
+		}}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Type: "pd-balanced"})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "pd-balance"})
+	c.Assert(err, ErrorMatches, `invalid disk type "pd-balance", the types available in zone zone are: hyperdisk-balanced, pd-balanced, pd-ssd`)
+	c.Assert(s.f.Count("GET", "/zones/zone/diskTypes"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestSetZone(c *C) {
+	s.handleInstance(1)
+	s.f.Handle("GET", "/zones/*/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-ssd"}}}
+	})
+	s.f.Handle("GET", "/zones/other", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Zone{Name: "other", Region: "https://www.googleapis.com/compute/v1/projects/project/regions/other-region"}
+	})
+
+	_, err := s.d.RemainingSlots()
+	c.Assert(err, IsNil)
+	c.Assert(s.d.checkDiskType("project", "pd-ssd"), IsNil)
+	c.Assert(s.f.Count("GET", "/zones/zone/machineTypes/n1-standard-1"), Equals, 1)
+	c.Assert(s.f.Count("GET", "/zones/zone/diskTypes"), Equals, 1)
+
+	c.Assert(s.d.SetZone("other"), IsNil)
+	c.Assert(s.d.zone(), Equals, "other")
+	c.Assert(s.d.region(), Equals, "other-region")
+	c.Assert(s.d.machineType, Equals, "")
+	c.Assert(s.d.diskTypes, HasLen, 0)
+
+	_, err = s.d.RemainingSlots()
+	c.Assert(err, IsNil)
+	c.Assert(s.d.checkDiskType("project", "pd-ssd"), IsNil)
+	c.Assert(s.f.Count("GET", "/zones/other/instances/instance"), Equals, 1)
+	c.Assert(s.f.Count("GET", "/zones/other/machineTypes/n1-standard-1"), Equals, 1)
+	c.Assert(s.f.Count("GET", "/zones/other/diskTypes"), Equals, 1)
+
+	c.Assert(s.d.SetZone("other"), IsNil)
+	c.Assert(s.f.Count("GET", "/zones/other"), Equals, 1)
+	c.Assert(s.d.machineType, Not(Equals), "")
+
+	err = s.d.SetZone("missing")
+	c.Assert(err, ErrorMatches, "error retrieving region from zone: .*")
+	c.Assert(s.d.zone(), Equals, "other")
+	c.Assert(s.d.machineType, Not(Equals), "")
+}
+
+func (s *DiskFixtureSuite) TestCreateDiskTypeByProject(c *C) {
+	s.f.Handle("GET", "/projects/project/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-ssd"}, {Name: "hyperdisk-balanced"}}}
+	})
+	s.f.Handle("GET", "/projects/other/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-ssd"}}}
+	})
+
+	c.Assert(s.d.checkDiskType("project", "hyperdisk-balanced"), IsNil)
+	c.Assert(s.d.checkDiskType("other", "hyperdisk-balanced"), ErrorMatches, `invalid disk type "hyperdisk-balanced", .*: pd-ssd`)
+	c.Assert(s.d.checkDiskType("project", "hyperdisk-balanced"), IsNil)
+	c.Assert(s.f.Count("GET", "/projects/project/zones/zone/diskTypes"), Equals, 1)
+	c.Assert(s.f.Count("GET", "/projects/other/zones/zone/diskTypes"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestCreateDrift(c *C) {
+	s.f.Handle("GET", "/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-standard"}, {Name: "pd-ssd"}}}
+	})
+
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{
+			Name: "foo", SizeGb: 10, Type: DiskTypeURL("project", "zone", "pd-standard"),
+			SourceSnapshot: SnapshotURL("project", "base"),
+		}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Type: "pd-standard", SourceSnapshot: "base"})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "projects/project/global/snapshots/base"})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "pd-ssd", SourceSnapshot: "other"})
+	c.Assert(err, ErrorMatches, `disk "foo" already exists but doesn't match the volume, type is pd-standard, not pd-ssd, source snapshot is base, not other, use --ignore-drift to accept it`)
+	c.Assert(err.(*CodedError).Code, Equals, ErrorCodeConflict)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "projects/golden/global/snapshots/base"})
+	c.Assert(err, ErrorMatches, `disk "foo" already exists but doesn't match the volume, source snapshot is base, not golden/base, .*`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "pd-ssd", IgnoreDrift: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCreateSizePolicyError(c *C) {
+	s.handleExistingDisk(10)
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SizeGb: 10, SizePolicy: SizePolicyError})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SizePolicy: SizePolicyError})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SizeGb: 20, SizePolicy: SizePolicyError})
+	c.Assert(err, ErrorMatches, `disk "foo" already exists with 10GB but 20GB were requested, .*`)
+	c.Assert(s.f.Count("POST", "/disks/foo/resize"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCreateSizePolicyGrowOnly(c *C) {
+	var resize *compute.DisksResizeRequest
+	s.handleExistingDisk(10)
+	s.f.Handle("POST", "/disks/foo/resize", func(r *http.Request) (int, interface{}) {
+		resize = &compute.DisksResizeRequest{}
+		json.NewDecoder(r.Body).Decode(resize)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SizeGb: 20, SizePolicy: SizePolicyGrowOnly})
+	c.Assert(err, IsNil)
+	c.Assert(resize.SizeGb, Equals, int64(20))
+}
+
+func (s *DiskFixtureSuite) TestCreateSizePolicyDefault(c *C) {
+	var resize *compute.DisksResizeRequest
+	s.handleExistingDisk(10)
+	s.f.Handle("POST", "/disks/foo/resize", func(r *http.Request) (int, interface{}) {
+		resize = &compute.DisksResizeRequest{}
+		json.NewDecoder(r.Body).Decode(resize)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SizeGb: 10})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/disks/foo/resize"), Equals, 0)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SizeGb: 20})
+	c.Assert(err, IsNil)
+	c.Assert(resize.SizeGb, Equals, int64(20))
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SizeGb: 5})
+	c.Assert(err, ErrorMatches, `unable to resize disk "foo" from 10GB to 5GB, GCE disks can't shrink`)
+}
+
+func (s *DiskFixtureSuite) TestCreateSizePolicyGrowOnlyShrink(c *C) {
+	s.handleExistingDisk(10)
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SizeGb: 5, SizePolicy: SizePolicyGrowOnly})
+	c.Assert(err, ErrorMatches, `unable to resize disk "foo" from 10GB to 5GB, GCE disks can't shrink`)
+	c.Assert(s.f.Count("POST", "/disks/foo/resize"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCreateSizePolicyIgnore(c *C) {
+	s.handleExistingDisk(10)
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SizeGb: 20, SizePolicy: SizePolicyIgnore})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SizeGb: 5, SizePolicy: SizePolicyIgnore})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/disks/foo/resize"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCreateDefaultSize(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", DefaultSizeGb: 100})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SizeGb, Equals, int64(100))
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SizeGb: 20, DefaultSizeGb: 100})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SizeGb, Equals, int64(20))
+
+	s.f.Handle("GET", "/global/snapshots/snap", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Snapshot{Name: "snap", SelfLink: "projects/project/global/snapshots/snap", Status: "READY"}
+	})
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "snap", DefaultSizeGb: 100})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SizeGb, Equals, int64(0))
+}
+
+func (s *DiskFixtureSuite) TestCreateDefaultSizeExisting(c *C) {
+	s.handleExistingDisk(10)
+
+	err := s.d.Create(&DiskConfig{Name: "foo", DefaultSizeGb: 100})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/disks/foo/resize"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCreateDefaultType(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+	s.f.Handle("GET", "/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-ssd"}, {Name: "pd-balanced"}}}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", DefaultType: "pd-balanced"})
+	c.Assert(err, IsNil)
+	c.Assert(ResourceName(inserted.Type), Equals, "pd-balanced")
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "pd-ssd", DefaultType: "pd-balanced"})
+	c.Assert(err, IsNil)
+	c.Assert(ResourceName(inserted.Type), Equals, "pd-ssd")
+}
+
+func (s *DiskFixtureSuite) TestCreateDefaultTypeExisting(c *C) {
+	s.handleExistingDisk(10)
+	s.f.Handle("GET", "/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-standard"}, {Name: "pd-balanced"}}}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", DefaultType: "pd-balanced"})
+	c.Assert(err, IsNil)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", DefaultType: "pd-balanced", AllowTypeChange: true})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) handleTypeChange(current *compute.Disk) *[]*compute.Disk {
+	var inserted []*compute.Disk
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, current
+	})
+	s.f.Handle("GET", "/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "pd-standard"}, {Name: "pd-ssd"}}}
+	})
+	s.f.Handle("POST", "/disks/foo/createSnapshot", func(r *http.Request) (int, interface{}) {
+		return ComputeOperation("zone")
+	})
+	s.f.Handle("DELETE", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return ComputeOperation("zone")
+	})
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		disk := &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(disk)
+		inserted = append(inserted, disk)
+		return ComputeOperation("zone")
+	})
+
+	return &inserted
+}
+
+func (s *DiskFixtureSuite) TestCreateChangeTypeKeepsDisk(c *C) {
+	key := "projects/project/locations/global/keyRings/ring/cryptoKeys/key"
+	inserted := s.handleTypeChange(&compute.Disk{
+		Name:              "foo",
+		SizeGb:            10,
+		Type:              DiskTypeURL("project", "zone", "pd-standard"),
+		Description:       "postgres data",
+		Labels:            map[string]string{"team": "infra"},
+		ResourcePolicies:  []string{"https://www.googleapis.com/compute/v1/projects/project/regions/region/resourcePolicies/daily"},
+		DiskEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: key + "/cryptoKeyVersions/1"},
+	})
+
+	err := s.d.Create(&DiskConfig{
+		Name: "foo", Type: "pd-ssd", AllowTypeChange: true,
+		ResourcePolicies: []string{"daily", "weekly"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(*inserted, HasLen, 1)
+
+	disk := (*inserted)[0]
+	c.Assert(ResourceName(disk.Type), Equals, "pd-ssd")
+	c.Assert(disk.DiskEncryptionKey.KmsKeyName, Equals, key)
+	c.Assert(disk.ResourcePolicies, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/project/regions/region/resourcePolicies/daily",
+		"https://www.googleapis.com/compute/v1/projects/project/regions/region/resourcePolicies/weekly",
+	})
+	c.Assert(disk.Description, Equals, "postgres data")
+	c.Assert(disk.Labels["team"], Equals, "infra")
+	c.Assert(disk.Labels["created-by"], Equals, "gce-docker")
+}
+
+func (s *DiskFixtureSuite) TestCreateChangeTypeEncryptionMismatch(c *C) {
+	key := "projects/project/locations/global/keyRings/ring/cryptoKeys/key"
+	s.handleTypeChange(&compute.Disk{
+		Name:              "foo",
+		SizeGb:            10,
+		Type:              DiskTypeURL("project", "zone", "pd-standard"),
+		DiskEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: key + "/cryptoKeyVersions/1"},
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Type: "pd-ssd", AllowTypeChange: true, KmsKeyName: key + "2"})
+	c.Assert(err, ErrorMatches, `unable to change type of disk "foo", it isn't encrypted with the requested key, .*`)
+	c.Assert(s.f.Count("POST", "/disks/foo/createSnapshot"), Equals, 0)
+	c.Assert(s.f.Count("DELETE", "/disks/foo"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCreateChangeTypeRestore(c *C) {
+	current := &compute.Disk{
+		Name:             "foo",
+		SizeGb:           10,
+		Type:             DiskTypeURL("project", "zone", "pd-standard"),
+		ResourcePolicies: []string{"https://www.googleapis.com/compute/v1/projects/project/regions/region/resourcePolicies/daily"},
+	}
+	inserted := s.handleTypeChange(current)
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		disk := &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(disk)
+		*inserted = append(*inserted, disk)
+		if len(*inserted) == 1 {
+			return http.StatusBadRequest, ComputeError(400, "Invalid value for field 'resource.provisionedIops'")
+		}
+
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Type: "pd-ssd", AllowTypeChange: true, ProvisionedIops: 5000})
+	c.Assert(err, ErrorMatches, `error changing disk type to "pd-ssd": .*, disk was restored with type "pd-standard"`)
+	c.Assert(*inserted, HasLen, 2)
+
+	restored := (*inserted)[1]
+	c.Assert(restored.Type, Equals, current.Type)
+	c.Assert(restored.ProvisionedIops, Equals, int64(0))
+	c.Assert(restored.ResourcePolicies, DeepEquals, current.ResourcePolicies)
+	c.Assert(restored.SourceSnapshot, Not(Equals), "")
+}
+
+func (s *DiskFixtureSuite) handleInstance(disks int) {
+	s.f.Handle("GET", "/instances/instance", func(r *http.Request) (int, interface{}) {
+		instance := &compute.Instance{Name: "instance", MachineType: "zones/zone/machineTypes/n1-standard-1"}
+		for i := 0; i < disks; i++ {
+			instance.Disks = append(instance.Disks, &compute.AttachedDisk{DeviceName: strconv.Itoa(i)})
+		}
+
+		return http.StatusOK, instance
+	})
+
+	s.handleMachineType()
+}
+
+func (s *DiskFixtureSuite) handleMachineType() {
+	s.f.Handle("GET", "/machineTypes/n1-standard-1", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.MachineType{Name: "n1-standard-1", MaximumPersistentDisks: 3, GuestCpus: 1}
+	})
+}
+
+func (s *DiskFixtureSuite) TestRemainingSlots(c *C) {
+	s.handleInstance(1)
+
+	remaining, err := s.d.RemainingSlots()
+	c.Assert(err, IsNil)
+	c.Assert(remaining, Equals, 2)
+
+	remaining, err = s.d.RemainingSlots()
+	c.Assert(err, IsNil)
+	c.Assert(remaining, Equals, 2)
+	c.Assert(s.f.Count("GET", "/machineTypes/n1-standard-1"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestEffectivePerformance(c *C) {
+	s.handleInstance(1)
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{
+			Name: "foo", Type: "zones/zone/diskTypes/hyperdisk-balanced",
+			ProvisionedIops: 10000, ProvisionedThroughput: 50,
+		}
+	})
+
+	p, err := s.d.EffectivePerformance(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(p, DeepEquals, &EffectivePerformance{
+		Type: "hyperdisk-balanced", ProvisionedIops: 10000, ProvisionedThroughput: 50,
+		Iops: 6250, Throughput: 50,
+	})
+	c.Assert(p.Capped(), Equals, true)
+}
+
+func (s *DiskFixtureSuite) TestEffectivePerformanceNotHyperdisk(c *C) {
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo", Type: "zones/zone/diskTypes/pd-ssd"}
+	})
+
+	p, err := s.d.EffectivePerformance(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(p, IsNil)
+	c.Assert(s.f.Count("GET", "/instances/instance"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestAttachedDisks(c *C) {
+	s.f.Handle("GET", "/instances/instance", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Instance{Name: "instance", Disks: []*compute.AttachedDisk{
+			{Source: DiskURL("project", "zone", "boot")},
+			{Source: DiskURL("other", "zone", "foo"), DeviceName: "docker-volume-foo"},
+		}}
+	})
+
+	attached, err := s.d.AttachedDisks()
+	c.Assert(err, IsNil)
+	c.Assert(attached, DeepEquals, map[string]bool{"boot": true, "foo": true})
+}
+
+func (s *DiskFixtureSuite) TestAttachLimit(c *C) {
+	s.handleInstance(2)
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Attach(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+
+	s.handleInstance(3)
+	err = s.d.Attach(&DiskConfig{Name: "foo"})
+	c.Assert(err, ErrorMatches, `unable to attach disk "foo", instance "instance" reached its limit of attached disks`)
+	c.Assert(err.(*CodedError).Code, Equals, ErrorCodeLimitExceeded)
+	c.Assert(s.f.Count("POST", "/instances/instance/attachDisk"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestGetRetry(c *C) {
+	var calls int
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		if calls++; calls < 3 {
+			return http.StatusServiceUnavailable, ComputeError(503, "backend error")
+		}
+
+		return http.StatusOK, &compute.Disk{Name: "foo"}
+	})
+
+	disk, err := s.d.Get(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(disk.Name, Equals, "foo")
+	c.Assert(s.d.backoff.clock.(*FakeClock).Sleeps, DeepEquals, []time.Duration{time.Second, time.Second})
+}
+
+func (s *DiskFixtureSuite) TestAttachDebug(c *C) {
+	s.handleInstance(1)
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		return ComputeOperation("zone")
+	})
+
+	DebugAttach = true
+	defer func() { DebugAttach = false }()
+
+	err := s.d.Attach(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("GET", "/instances/instance"), Equals, 2)
+}
+
+func (s *DiskFixtureSuite) TestCreateInProject(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/projects/other/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Project: "other"})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.Type, Equals, DiskTypeURL("other", "zone", ""))
+	c.Assert(s.f.Count("GET", "/projects/other/zones/zone/disks/foo"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestCreateInProjectForbidden(c *C) {
+	s.f.Handle("GET", "/projects/other/zones/zone/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(403, "Required 'compute.disks.get' permission")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Project: "other"})
+	c.Assert(err, ErrorMatches, `unable to access disk "foo" of project "other" from instance "instance", the service account needs compute.disks.get on it: .*`)
+
+	s.f.Handle("GET", "/projects/other/zones/zone/disks/bar", func(r *http.Request) (int, interface{}) {
+		return http.StatusNotFound, ComputeError(404, "not found")
+	})
+
+	s.f.Handle("POST", "/projects/other/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(403, "Required 'compute.disks.create' permission")
+	})
+
+	err = s.d.Create(&DiskConfig{Name: "bar", Project: "other"})
+	c.Assert(err, ErrorMatches, `unable to access disk "bar" .*, the service account needs compute.disks.create on it: .*`)
+
+	s.f.Handle("DELETE", "/projects/other/zones/zone/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(403, "Required 'compute.disks.delete' permission")
+	})
+
+	err = s.d.Delete(&DiskConfig{Name: "foo", Project: "other"})
+	c.Assert(err, ErrorMatches, `unable to access disk "foo" .*, the service account needs compute.disks.delete on it: .*`)
+}
+
+func (s *DiskFixtureSuite) TestCreateResourcePolicies(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", ResourcePolicies: []string{
+		"daily", "projects/other/regions/region/resourcePolicies/weekly",
+	}})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.ResourcePolicies, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/project/regions/region/resourcePolicies/daily",
+		"projects/other/regions/region/resourcePolicies/weekly",
+	})
+}
+
+func (s *DiskFixtureSuite) TestEncryptedWith(c *C) {
+	key := "projects/foo/locations/global/keyRings/bar/cryptoKeys/qux"
+	c.Assert(encryptedWith(&compute.Disk{}, key), Equals, false)
+	c.Assert(encryptedWith(&compute.Disk{DiskEncryptionKey: &compute.CustomerEncryptionKey{
+		KmsKeyName: key + "/cryptoKeyVersions/1",
+	}}, key), Equals, true)
+	c.Assert(encryptedWith(&compute.Disk{DiskEncryptionKey: &compute.CustomerEncryptionKey{
+		KmsKeyName: key + "2/cryptoKeyVersions/1",
+	}}, key), Equals, false)
+}
+
+func (s *DiskFixtureSuite) TestCreateStamp(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Labels: map[string]string{"team": "infra"}})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.Labels, DeepEquals, map[string]string{
+		"team": "infra", "created-by": "gce-docker", "instance": "instance", "instance-project": "project",
+	})
+	c.Assert(inserted.Description, Equals, "Created by gce-docker on instance instance of project project")
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Description: "postgres data"})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.Description, Equals, "postgres data\n\nCreated by gce-docker on instance instance of project project")
+}
+
+func (s *DiskFixtureSuite) TestCreateImageFamily(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	s.f.Handle("GET", "/projects/debian-cloud/global/images/family/debian-12", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Image{Name: "debian-12-v1", SelfLink: "projects/debian-cloud/global/images/debian-12-v1", Status: "READY"}
+	})
+
+	s.f.Handle("GET", "/projects/project/global/images/family/base", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Image{Name: "base-v2", SelfLink: "projects/project/global/images/base-v2", Status: "READY"}
+	})
+
+	s.f.Handle("GET", "/projects/project/global/images/golden", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Image{Name: "golden", SelfLink: "projects/project/global/images/golden", Status: "READY"}
+	})
+
+	s.f.Handle("GET", "/projects/project/global/images/other", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Image{Name: "other", SelfLink: "projects/project/global/images/other", Status: "READY"}
+	})
+
+	for image, expected := range map[string]string{
+		"projects/debian-cloud/global/images/family/debian-12": "projects/debian-cloud/global/images/debian-12-v1",
+		"family/base":                          "projects/project/global/images/base-v2",
+		"base":                                 "projects/project/global/images/base-v2",
+		"golden":                               "projects/project/global/images/golden",
+		"projects/project/global/images/other": "projects/project/global/images/other",
+	} {
+		err := s.d.Create(&DiskConfig{Name: "foo", SourceImage: image})
+		c.Assert(err, IsNil)
+		c.Assert(inserted.SourceImage, Equals, expected)
+	}
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SourceImage: "family/missing"})
+	c.Assert(err, ErrorMatches, `error resolving image "family/missing", no image or image family found: .*`)
+}
+
+func (s *DiskFixtureSuite) TestCreateCrossProjectSources(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	s.f.Handle("GET", "/projects/golden/global/snapshots/base", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Snapshot{Name: "base", SelfLink: "projects/golden/global/snapshots/base", Status: "READY"}
+	})
+
+	s.f.Handle("GET", "/projects/private/global/snapshots/base", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(http.StatusForbidden, "forbidden")
+	})
+
+	s.f.Handle("GET", "/projects/private/global/images/base", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(http.StatusForbidden, "forbidden")
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "projects/golden/global/snapshots/base"})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SourceSnapshot, Equals, "projects/golden/global/snapshots/base")
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "projects/private/global/snapshots/base"})
+	c.Assert(err, ErrorMatches, `unable to use snapshot ".*" of project "private", the service account needs compute.snapshots.useReadOnly on it: .*`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceImage: "projects/private/global/images/base"})
+	c.Assert(err, ErrorMatches, `unable to use image ".*" of project "private", the service account needs compute.images.useReadOnly on it: .*`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "missing"})
+	c.Assert(err, ErrorMatches, `error retrieving snapshot "missing": .*404.*`)
+	c.Assert(s.f.Count("GET", "/projects/project/global/snapshots/missing"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestCreateStoragePool(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	s.f.Handle("GET", "/zones/zone/diskTypes", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.DiskTypeList{Items: []*compute.DiskType{{Name: "hyperdisk-balanced"}}}
+	})
+
+	s.f.Handle("GET", "/zones/zone/storagePools/pool", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.StoragePool{
+			Name: "pool", StoragePoolType: "projects/project/zones/zone/storagePoolTypes/hyperdisk-balanced",
+		}
+	})
+
+	s.f.Handle("GET", "/zones/zone/storagePools/throughput", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.StoragePool{
+			Name: "throughput", StoragePoolType: "projects/project/zones/zone/storagePoolTypes/hyperdisk-throughput",
+		}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", Type: "hyperdisk-balanced", StoragePool: "pool"})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.StoragePool, Equals, StoragePoolURL("project", "zone", "pool"))
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "hyperdisk-balanced", StoragePool: "throughput"})
+	c.Assert(err, ErrorMatches, `invalid storage pool "throughput", it holds hyperdisk-throughput disks, not hyperdisk-balanced`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "hyperdisk-balanced", StoragePool: "missing"})
+	c.Assert(err, ErrorMatches, `error retrieving storage pool "missing": .*404.*`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", Type: "hyperdisk-balanced", StoragePool: "projects/project/zones/other/storagePools/pool"})
+	c.Assert(err, ErrorMatches, `invalid storage pool ".*", it must be in zone zone`)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks"), Equals, 1)
+}
+
+func (s *DiskFixtureSuite) TestCreateSourceNotReady(c *C) {
+	s.f.Handle("GET", "/global/snapshots/snap", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Snapshot{Name: "snap", SelfLink: "projects/project/global/snapshots/snap", Status: "CREATING"}
+	})
+
+	s.f.Handle("GET", "/global/images/golden", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Image{Name: "golden", SelfLink: "projects/project/global/images/golden", Status: "FAILED"}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "snap"})
+	c.Assert(err, ErrorMatches, `unable to create disk from snapshot "snap", it isn't ready, its status is CREATING`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceImage: "golden"})
+	c.Assert(err, ErrorMatches, `unable to create disk from image "golden", it isn't ready, its status is FAILED`)
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceSnapshot: "missing"})
+	c.Assert(err, ErrorMatches, `error retrieving snapshot "missing": .*404.*`)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestDryRun(c *C) {
+	d, err := s.d.DryRun()
+	c.Assert(err, IsNil)
+
+	err = d.Create(&DiskConfig{Name: "foo", SizeGb: 10})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("GET", "/zones/zone/disks/foo"), Equals, 1)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks"), Equals, 0)
+
+	err = d.Delete(&DiskConfig{Name: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("DELETE", "/zones/zone/disks/foo"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestDryRunRedactBody(c *C) {
+	body := redactBody([]byte(`{"name":"foo","diskEncryptionKey":{"rawKey":"secret"},"disks":[{"diskEncryptionKey":{"rsaEncryptedKey":"secret"}}]}`))
+	c.Assert(strings.Contains(body, "secret"), Equals, false)
+	c.Assert(body, Matches, `.*"rawKey":"REDACTED".*`)
+	c.Assert(body, Matches, `.*"rsaEncryptedKey":"REDACTED".*`)
+	c.Assert(body, Matches, `.*"name":"foo".*`)
+
+	c.Assert(redactBody([]byte("foo")), Equals, "foo")
+}
+
+func (s *DiskFixtureSuite) TestCreateSourceDisk(c *C) {
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/zones/zone/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return ComputeOperation("zone")
+	})
+
+	s.f.Handle("GET", "/projects/project/zones/zone/disks/base", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "base", SelfLink: DiskURL("project", "zone", "base")}
+	})
+
+	s.f.Handle("GET", "/projects/other/regions/region/disks/shared", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "shared", SelfLink: RegionDiskURL("other", "region", "shared")}
+	})
+
+	err := s.d.Create(&DiskConfig{Name: "foo", SourceDisk: "base"})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SourceDisk, Equals, DiskURL("project", "zone", "base"))
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceDisk: "projects/other/regions/region/disks/shared"})
+	c.Assert(err, IsNil)
+	c.Assert(inserted.SourceDisk, Equals, RegionDiskURL("other", "region", "shared"))
+
+	err = s.d.Create(&DiskConfig{Name: "foo", SourceDisk: "missing"})
+	c.Assert(err, ErrorMatches, `error retrieving disk "missing": .*`)
+}
+
+func (s *DiskFixtureSuite) TestCreateRegional(c *C) {
+	s.d.loc.zone = "region-a"
+	var inserted *compute.Disk
+	s.f.Handle("POST", "/regions/region/disks", func(r *http.Request) (int, interface{}) {
+		inserted = &compute.Disk{}
+		json.NewDecoder(r.Body).Decode(inserted)
+		return http.StatusOK, &compute.Operation{Name: "op", Region: "region", Status: "PENDING"}
+	})
+
+	config := &DiskConfig{Name: "foo", Type: "pd-ssd", Regional: true, ReplicaZones: []string{"region-a", "region-b"}}
+	c.Assert(s.d.Create(config), IsNil)
+	c.Assert(inserted.Type, Equals, "https://www.googleapis.com/compute/v1/projects/project/regions/region/diskTypes/pd-ssd")
+	c.Assert(inserted.ReplicaZones, DeepEquals, []string{
+		"https://www.googleapis.com/compute/v1/projects/project/zones/region-a",
+		"https://www.googleapis.com/compute/v1/projects/project/zones/region-b",
+	})
+	c.Assert(s.f.Count("GET", "/regions/region/disks/foo"), Equals, 1)
+	c.Assert(s.f.Count("GET", "/regions/region/operations/op"), Equals, 1)
+	c.Assert(s.f.Count("GET", "/zones/region-a/diskTypes"), Equals, 0)
+
+	config.ReplicaZones = []string{"region-b", "region-c"}
+	err := s.d.Create(config)
+	c.Assert(err, ErrorMatches, `invalid replica zones .*, they must include the zone of the instance, region-a`)
+
+	config.ReplicaZones = []string{"region-a", "other-b"}
+	err = s.d.Create(config)
+	c.Assert(err, ErrorMatches, `invalid replica zone "other-b", it must be in the region of the instance, region`)
+}
+
+func (s *DiskFixtureSuite) TestAttachCsekKey(c *C) {
+	s.handleInstance(1)
+	var attached *compute.AttachedDisk
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		attached = &compute.AttachedDisk{}
+		json.NewDecoder(r.Body).Decode(attached)
+		return ComputeOperation("zone")
+	})
+
+	key := "SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0="
+	c.Assert(s.d.Attach(&DiskConfig{Name: "foo", CsekKey: key}), IsNil)
+	c.Assert(attached.DiskEncryptionKey.RawKey, Equals, key)
+}
+
+func (s *DiskFixtureSuite) TestAttachCsekKeyMissing(c *C) {
+	s.handleInstance(1)
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		return http.StatusBadRequest, ComputeError(http.StatusBadRequest, "resourceIsEncryptedWithCustomerEncryptionKey")
+	})
+
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo", DiskEncryptionKey: &compute.CustomerEncryptionKey{
+			Sha256: "esTuF7d4eatX4cnc4JsiEiaI+Rff78JgPhA/v1zxX9E=",
+		}}
+	})
+
+	err := s.d.Attach(&DiskConfig{Name: "foo"})
+	c.Assert(err, ErrorMatches, `unable to attach disk "foo", it's encrypted with a customer-supplied key and no CsekKey was given: .*`)
+
+	err = s.d.Attach(&DiskConfig{Name: "foo", CsekKey: "b3RoZXIga2V5IGZyb20gR29vZ2xlIENsb3VkIFBsYXQ="})
+	c.Assert(err, ErrorMatches, `unable to attach disk "foo", CsekKey isn't the key it's encrypted with: .*`)
+
+	err = s.d.Attach(&DiskConfig{Name: "foo", CsekKey: "SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0="})
+	c.Assert(err, ErrorMatches, `googleapi: .*`)
+}
+
+func (s *DiskFixtureSuite) TestAttachRegional(c *C) {
+	s.handleInstance(1)
+	var attached *compute.AttachedDisk
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		attached = &compute.AttachedDisk{}
+		json.NewDecoder(r.Body).Decode(attached)
+		return ComputeOperation("zone")
+	})
+
+	c.Assert(s.d.Attach(&DiskConfig{Name: "foo", Regional: true}), IsNil)
+	c.Assert(attached.Source, Equals, RegionDiskURL("project", "region", "foo"))
+
+	s.f.Handle("DELETE", "/regions/region/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Operation{Name: "op", Region: "region", Status: "PENDING"}
+	})
+
+	c.Assert(s.d.Delete(&DiskConfig{Name: "foo", Regional: true}), IsNil)
+	c.Assert(s.f.Count("DELETE", "/zones/zone/disks/foo"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestCheckResourcePolicies(c *C) {
+	s.f.Handle("GET", "/projects/project/regions/region/resourcePolicies/daily", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.ResourcePolicy{Name: "daily"}
+	})
+
+	c.Assert(s.d.CheckResourcePolicies([]string{"daily"}), IsNil)
+
+	err := s.d.CheckResourcePolicies([]string{"daily", "weekly"})
+	c.Assert(err, ErrorMatches, `error retrieving resource policy "weekly": .*`)
+
+	err = s.d.CheckResourcePolicies([]string{"projects/project/regions/other/resourcePolicies/daily"})
+	c.Assert(err, ErrorMatches, `invalid resource policy .*, it must be in the region of the disks, region`)
+}
+
+func (s *DiskFixtureSuite) TestAddSnapshotSchedule(c *C) {
+	s.f.Handle("GET", "/regions/region/resourcePolicies/daily", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.ResourcePolicy{Name: "daily", SnapshotSchedulePolicy: &compute.ResourcePolicySnapshotSchedulePolicy{}}
+	})
+
+	s.f.Handle("GET", "/regions/region/resourcePolicies/placement", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.ResourcePolicy{Name: "placement"}
+	})
+
+	policies := []string{}
+	s.f.Handle("GET", "/zones/zone/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo", ResourcePolicies: policies}
+	})
+
+	var added *compute.DisksAddResourcePoliciesRequest
+	s.f.Handle("POST", "/zones/zone/disks/foo/addResourcePolicies", func(r *http.Request) (int, interface{}) {
+		added = &compute.DisksAddResourcePoliciesRequest{}
+		json.NewDecoder(r.Body).Decode(added)
+		return ComputeOperation("zone")
+	})
+
+	err := s.d.AddSnapshotSchedule(&DiskConfig{Name: "foo", SnapshotSchedule: "daily"})
+	c.Assert(err, IsNil)
+	c.Assert(added.ResourcePolicies, DeepEquals, []string{ResourcePolicyURL("project", "region", "daily")})
+
+	policies = added.ResourcePolicies
+	err = s.d.AddSnapshotSchedule(&DiskConfig{Name: "foo", SnapshotSchedule: "daily"})
+	c.Assert(err, IsNil)
+	c.Assert(s.f.Count("POST", "/zones/zone/disks/foo/addResourcePolicies"), Equals, 1)
+
+	err = s.d.AddSnapshotSchedule(&DiskConfig{Name: "foo", SnapshotSchedule: "placement"})
+	c.Assert(err, ErrorMatches, `invalid snapshot schedule "placement", the resource policy isn't a snapshot schedule`)
+
+	err = s.d.AddSnapshotSchedule(&DiskConfig{Name: "foo", SnapshotSchedule: "weekly"})
+	c.Assert(err, ErrorMatches, `error retrieving snapshot schedule "weekly": .*`)
+
+	err = s.d.AddSnapshotSchedule(&DiskConfig{Name: "foo", SnapshotSchedule: "projects/project/regions/other/resourcePolicies/daily"})
+	c.Assert(err, ErrorMatches, `invalid snapshot schedule .*, it must be in the region of the disks, region`)
+}
+
+func (s *DiskFixtureSuite) TestAttachCustomDeviceName(c *C) {
+	s.handleInstance(1)
+	var attached *compute.AttachedDisk
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		attached = &compute.AttachedDisk{}
+		json.NewDecoder(r.Body).Decode(attached)
+		return ComputeOperation("zone")
+	})
+
+	config := &DiskConfig{Name: "foo", CustomDeviceName: "data"}
+	c.Assert(s.d.Attach(config), IsNil)
+	c.Assert(attached.DeviceName, Equals, "data")
+	c.Assert(config.Dev(), Equals, "/dev/disk/by-id/google-"+attached.DeviceName)
+}
+
+func (s *DiskFixtureSuite) TestAttachReadOnly(c *C) {
+	s.handleInstance(1)
+	var attached *compute.AttachedDisk
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		attached = &compute.AttachedDisk{}
+		json.NewDecoder(r.Body).Decode(attached)
+		return ComputeOperation("zone")
+	})
+
+	c.Assert(s.d.Attach(&DiskConfig{Name: "foo", ReadOnly: true}), IsNil)
+	c.Assert(attached.Mode, Equals, "READ_ONLY")
+
+	c.Assert(s.d.Attach(&DiskConfig{Name: "foo"}), IsNil)
+	c.Assert(attached.Mode, Equals, "")
+}
+
+func (s *DiskFixtureSuite) TestAttachMultiWriter(c *C) {
+	s.handleMachineType()
+	s.f.Handle("GET", "/instances/instance", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Instance{Name: "instance", MachineType: "zones/zone/machineTypes/n1-standard-1", Disks: []*compute.AttachedDisk{
+			{Source: DiskURL("project", "zone", "foo"), DeviceName: "shared"},
+		}}
+	})
+
+	config := &DiskConfig{Name: "foo", MultiWriter: true}
+	c.Assert(s.d.Attach(config), IsNil)
+	c.Assert(config.DeviceName(), Equals, "shared")
+	c.Assert(s.f.Count("POST", "/instances/instance/attachDisk"), Equals, 0)
+}
+
+func (s *DiskFixtureSuite) TestDetachMultiWriter(c *C) {
+	users := []string{InstanceURL("project", "zone", "instance"), InstanceURL("project", "zone", "other")}
+	s.f.Handle("GET", "/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo", Users: users}
+	})
+
+	s.f.Handle("POST", "/instances/instance/detachDisk", func(r *http.Request) (int, interface{}) {
+		return ComputeOperation("zone")
+	})
+
+	c.Assert(s.d.Detach(&DiskConfig{Name: "foo", MultiWriter: true}), IsNil)
+	c.Assert(s.f.Count("POST", "/instances/instance/detachDisk"), Equals, 0)
+
+	c.Assert(s.d.Detach(&DiskConfig{Name: "foo"}), IsNil)
+	c.Assert(s.f.Count("POST", "/instances/instance/detachDisk"), Equals, 1)
+
+	users = users[:1]
+	c.Assert(s.d.Detach(&DiskConfig{Name: "foo", MultiWriter: true}), IsNil)
+	c.Assert(s.f.Count("POST", "/instances/instance/detachDisk"), Equals, 2)
+}
+
+func (s *DiskFixtureSuite) TestAttachDeviceNameCollision(c *C) {
+	s.handleMachineType()
+	disks := []*compute.AttachedDisk{
+		{Source: DiskURL("other", "zone", "foo"), DeviceName: "docker-volume-foo"},
+		{Source: DiskURL("project", "zone", "bar"), DeviceName: "docker-volume-foo-2"},
+	}
+
+	s.f.Handle("GET", "/instances/instance", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Instance{Name: "instance", MachineType: "zones/zone/machineTypes/n1-standard-1", Disks: disks}
+	})
+
+	var attached *compute.AttachedDisk
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		attached = &compute.AttachedDisk{}
+		json.NewDecoder(r.Body).Decode(attached)
+		return ComputeOperation("zone")
+	})
+
+	config := &DiskConfig{Name: "foo"}
+	c.Assert(s.d.Attach(config), IsNil)
+	c.Assert(attached.DeviceName, Equals, "docker-volume-foo-3")
+	c.Assert(config.Dev(), Equals, "/dev/disk/by-id/google-docker-volume-foo-3")
+
+	config = &DiskConfig{Name: "foo", Project: "other"}
+	c.Assert(s.d.Attach(config), IsNil)
+	c.Assert(attached.DeviceName, Equals, "docker-volume-foo")
+
+	disks[1] = &compute.AttachedDisk{Source: DiskURL("project", "zone", "bar"), DeviceName: strings.Repeat("a", 63)}
+	config = &DiskConfig{Name: "foo", CustomDeviceName: strings.Repeat("a", 63)}
+	c.Assert(s.d.Attach(config), IsNil)
+	c.Assert(attached.DeviceName, Equals, strings.Repeat("a", 61)+"-2")
+}
+
+func (s *DiskFixtureSuite) TestDetachAttachedDeviceName(c *C) {
+	s.f.Handle("GET", "/instances/instance", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Instance{Name: "instance", Disks: []*compute.AttachedDisk{
+			{Source: DiskURL("other", "zone", "foo"), DeviceName: "other-foo"},
+			{Source: DiskURL("project", "zone", "foo"), DeviceName: "data"},
+		}}
+	})
+
+	var device string
+	s.f.Handle("POST", "/instances/instance/detachDisk", func(r *http.Request) (int, interface{}) {
+		device = r.URL.Query().Get("deviceName")
+		return ComputeOperation("zone")
+	})
+
+	c.Assert(s.d.Detach(&DiskConfig{Name: "foo"}), IsNil)
+	c.Assert(device, Equals, "data")
+}
+
+func (s *DiskFixtureSuite) TestAttachInProjectForbidden(c *C) {
+	s.handleInstance(1)
+	s.f.Handle("GET", "/projects/other/zones/zone/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "foo"}
+	})
+	s.f.Handle("POST", "/instances/instance/attachDisk", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(403, "Required 'compute.disks.use' permission")
+	})
+
+	err := s.d.Attach(&DiskConfig{Name: "foo", Project: "other"})
+	c.Assert(err, ErrorMatches, `unable to attach disk "foo" of project "other" to instance "instance", .*`)
+	c.Assert(err.(*CodedError).Code, Equals, ErrorCodePermissionDenied)
+}
+
+func (s *DiskFixtureSuite) TestAttachInProjectCheck(c *C) {
+	s.handleInstance(1)
+	s.f.Handle("GET", "/projects/other/zones/zone/disks/foo", func(r *http.Request) (int, interface{}) {
+		return http.StatusForbidden, ComputeError(403, "Required 'compute.disks.get' permission")
+	})
+	s.f.Handle("GET", "/projects/other/regions/region/disks/bar", func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, &compute.Disk{Name: "bar", ReplicaZones: []string{
+			"https://www.googleapis.com/compute/v1/projects/other/zones/zone-a",
+			"https://www.googleapis.com/compute/v1/projects/other/zones/zone-b",
+		}}
+	})
+
+	err := s.d.Attach(&DiskConfig{Name: "foo", Project: "other"})
+	c.Assert(err, ErrorMatches, `.* the service account needs compute.disks.get and compute.disks.use on it: .*`)
+	c.Assert(err.(*CodedError).Code, Equals, ErrorCodePermissionDenied)
+
+	err = s.d.Attach(&DiskConfig{Name: "baz", Project: "other"})
+	c.Assert(err, ErrorMatches, `.* it doesn't exist in the zone "zone" of the instance: .*`)
+	c.Assert(err.(*CodedError).Code, Equals, ErrorCodeNotFound)
+
+	err = s.d.Attach(&DiskConfig{Name: "bar", Project: "other", Regional: true})
+	c.Assert(err, ErrorMatches, `.* it isn't replicated in zone "zone" of the instance`)
+	c.Assert(err.(*CodedError).Code, Equals, ErrorCodeInvalidArgument)
+
+	err = s.d.Attach(&DiskConfig{Name: "foo", Project: "project"})
+	c.Assert(err, NotNil)
+	c.Assert(s.f.Count("POST", "/instances/instance/attachDisk"), Equals, 1)
+}
@@ -0,0 +1,117 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// DryRunOperation is the name of the operations answered to the requests not
+// sent in dry-run mode.
+const DryRunOperation = "dry-run"
+
+// DryRunRedactedFields are the fields of the request bodies replaced by
+// DryRunRedacted when logged, the customer-supplied encryption keys.
+var DryRunRedactedFields = []string{"rawKey", "rsaEncryptedKey"}
+
+// DryRunRedacted replaces the DryRunRedactedFields in the logged bodies.
+const DryRunRedacted = "REDACTED"
+
+// dryRunTransport sends the read-only requests and only logs the ones that
+// would change something, answering them with a done operation.
+type dryRunTransport struct {
+	base http.RoundTripper
+}
+
+func (t *dryRunTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodGet && !strings.HasSuffix(r.URL.Path, "/operations/"+DryRunOperation) {
+		return t.base.RoundTrip(r)
+	}
+
+	if r.Method != http.MethodGet {
+		var body []byte
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+		}
+
+		log15.Info("dry run, request not sent", "method", r.Method, "url", r.URL.String(), "body", redactBody(body))
+	}
+
+	op, err := json.Marshal(&compute.Operation{Name: DryRunOperation, Status: "DONE"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      r.Proto,
+		ProtoMajor: r.ProtoMajor,
+		ProtoMinor: r.ProtoMinor,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(op)),
+		Request:    r,
+	}, nil
+}
+
+// redactBody returns the JSON body with the DryRunRedactedFields replaced,
+// at any depth, a body that isn't JSON is returned as is.
+func redactBody(body []byte) string {
+	var content interface{}
+	if err := json.Unmarshal(body, &content); err != nil {
+		return string(body)
+	}
+
+	redacted, err := json.Marshal(redact(content))
+	if err != nil {
+		return string(body)
+	}
+
+	return string(redacted)
+}
+
+func redact(content interface{}) interface{} {
+	switch c := content.(type) {
+	case map[string]interface{}:
+		for key, value := range c {
+			c[key] = redact(value)
+			for _, field := range DryRunRedactedFields {
+				if key == field {
+					c[key] = DryRunRedacted
+				}
+			}
+		}
+	case []interface{}:
+		for i, value := range c {
+			c[i] = redact(value)
+		}
+	}
+
+	return content
+}
+
+// DryRun returns a provider reading from GCE but only logging the requests
+// that would change anything, method, URL and body, instead of sending them.
+func (d *Disk) DryRun() (DiskProvider, error) {
+	base := d.c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	c := &http.Client{Transport: &dryRunTransport{base: base}, Timeout: d.c.Timeout}
+	s, err := compute.New(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s.BasePath = d.s.BasePath
+	client := d.Client
+	client.c, client.s = c, s
+	return &Disk{Client: client}, nil
+}
@@ -0,0 +1,62 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Error codes of the errors found by the provider itself, shared with the
+// responses of the plugin.
+const (
+	ErrorCodeInvalidArgument  = "invalid-argument"
+	ErrorCodeNotFound         = "not-found"
+	ErrorCodeConflict         = "conflict"
+	ErrorCodeLimitExceeded    = "limit-exceeded"
+	ErrorCodePermissionDenied = "permission-denied"
+)
+
+// CodedError is an error with its error code.
+type CodedError struct {
+	Code string
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+func withCode(code string, err error) error {
+	return &CodedError{Code: code, Err: err}
+}
+
+// OperationError is returned when a GCE operation finishes with errors, Codes
+// are the codes reported by GCE, e.g. QUOTA_EXCEEDED.
+type OperationError struct {
+	Name     string
+	Codes    []string
+	Messages []string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %q failed: %s", e.Name, strings.Join(e.Messages, ", "))
+}
+
+func operationError(op *compute.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+
+	e := &OperationError{Name: op.Name}
+	for _, oe := range op.Error.Errors {
+		e.Codes = append(e.Codes, oe.Code)
+		e.Messages = append(e.Messages, oe.Message)
+	}
+
+	return e
+}
@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/compute/v1"
+)
+
+var kmsKeyNameFormat = regexp.MustCompile(
+	"^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$",
+)
+
+// ValidateKmsKeyName checks that name is the resource name of a KMS key.
+func ValidateKmsKeyName(name string) error {
+	if !kmsKeyNameFormat.MatchString(name) {
+		return fmt.Errorf(
+			"invalid kms key %q, expected projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>",
+			name,
+		)
+	}
+
+	return nil
+}
+
+// Lengths of the customer-supplied keys: a raw AES-256 key, or one wrapped
+// with the 2048 bit RSA public key of GCE.
+const (
+	csekRawKeyLength = 32
+	csekRsaKeyLength = 256
+)
+
+// ValidateCsekKey checks that key is a base64 encoded customer-supplied
+// encryption key, raw or RSA-wrapped.
+func ValidateCsekKey(key string) error {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("invalid csek key, expected a base64 encoded key: %s", err)
+	}
+
+	if len(b) != csekRawKeyLength && len(b) != csekRsaKeyLength {
+		return fmt.Errorf(
+			"invalid csek key, expected a 256 bit key or a 2048 bit RSA-wrapped one, got %d bits",
+			len(b)*8,
+		)
+	}
+
+	return nil
+}
+
+// csekEncryptionKey returns the customer-supplied key, as a raw or RSA-wrapped
+// key depending on its length.
+func csekEncryptionKey(key string) *compute.CustomerEncryptionKey {
+	b, _ := base64.StdEncoding.DecodeString(key)
+	if len(b) == csekRsaKeyLength {
+		return &compute.CustomerEncryptionKey{RsaEncryptedKey: key}
+	}
+
+	return &compute.CustomerEncryptionKey{RawKey: key}
+}
+
+// csekSha256 returns the hash GCE reports for a raw key, the one of a wrapped
+// key can't be computed without unwrapping it.
+func csekSha256(key string) (string, bool) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(b) != csekRawKeyLength {
+		return "", false
+	}
+
+	sum := sha256.Sum256(b)
+	return base64.StdEncoding.EncodeToString(sum[:]), true
+}
+
+// CheckKmsKey verifies that the key exists and has an enabled primary
+// version that can be used to encrypt disks.
+func CheckKmsKey(c *http.Client, name string) error {
+	if err := ValidateKmsKeyName(name); err != nil {
+		return err
+	}
+
+	s, err := cloudkms.New(c)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.Projects.Locations.KeyRings.CryptoKeys.Get(name).Do()
+	if err != nil {
+		return fmt.Errorf("error retrieving kms key %q: %s", name, err)
+	}
+
+	if key.Purpose != "ENCRYPT_DECRYPT" {
+		return fmt.Errorf("invalid kms key %q, purpose is %s, ENCRYPT_DECRYPT is required", name, key.Purpose)
+	}
+
+	if key.Primary == nil || key.Primary.State != "ENABLED" {
+		return fmt.Errorf("invalid kms key %q, it has no enabled primary version", name)
+	}
+
+	return nil
+}
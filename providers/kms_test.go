@@ -0,0 +1,48 @@
+package providers
+
+import (
+	"encoding/base64"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type KmsSuite struct{}
+
+var _ = Suite(&KmsSuite{})
+
+func (s *KmsSuite) TestValidateKmsKeyName(c *C) {
+	err := ValidateKmsKeyName("projects/foo/locations/global/keyRings/bar/cryptoKeys/qux")
+	c.Assert(err, IsNil)
+
+	err = ValidateKmsKeyName("projects/foo/locations/global/keyRings/bar")
+	c.Assert(err, ErrorMatches, `invalid kms key .*, expected .*`)
+
+	err = ValidateKmsKeyName("projects/foo/locations/global/keyRings/bar/cryptoKeys/qux/cryptoKeyVersions/1")
+	c.Assert(err, NotNil)
+}
+
+func (s *KmsSuite) TestValidateCsekKey(c *C) {
+	raw := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	c.Assert(ValidateCsekKey(raw), IsNil)
+	c.Assert(csekEncryptionKey(raw).RawKey, Equals, raw)
+
+	wrapped := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 256)))
+	c.Assert(ValidateCsekKey(wrapped), IsNil)
+	c.Assert(csekEncryptionKey(wrapped).RsaEncryptedKey, Equals, wrapped)
+
+	err := ValidateCsekKey(base64.StdEncoding.EncodeToString([]byte("short")))
+	c.Assert(err, ErrorMatches, "invalid csek key, expected a 256 bit key or a 2048 bit RSA-wrapped one, got 40 bits")
+
+	err = ValidateCsekKey("not base64!")
+	c.Assert(err, ErrorMatches, "invalid csek key, expected a base64 encoded key: .*")
+}
+
+func (s *KmsSuite) TestCsekSha256(c *C) {
+	sum, ok := csekSha256("SGVsbG8gZnJvbSBHb29nbGUgQ2xvdWQgUGxhdGZvcm0=")
+	c.Assert(ok, Equals, true)
+	c.Assert(sum, Equals, "esTuF7d4eatX4cnc4JsiEiaI+Rff78JgPhA/v1zxX9E=")
+
+	_, ok = csekSha256(base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 256))))
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,190 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/logging/v2"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+var (
+	CloudLoggingName          = "gce-docker"
+	CloudLoggingFlushInterval = 5 * time.Second
+	CloudLoggingBatchSize     = 100
+	CloudLoggingBufferSize    = 1000
+	RedactedLogFields         = []string{"kms-key", "token"}
+)
+
+var cloudLoggingSeverities = map[log15.Lvl]string{
+	log15.LvlCrit:  "CRITICAL",
+	log15.LvlError: "ERROR",
+	log15.LvlWarn:  "WARNING",
+	log15.LvlInfo:  "INFO",
+	log15.LvlDebug: "DEBUG",
+}
+
+// CloudLogging is a log15 handler writing the records about a disk to Cloud
+// Logging as structured entries, labeled with the instance, zone and disk.
+// The entries are buffered and written in batches, in the background, so
+// logging never waits for the API, and dropped if the buffer is full.
+type CloudLogging struct {
+	s        *logging.Service
+	logName  string
+	resource *logging.MonitoredResource
+	labels   map[string]string
+	entries  chan *logging.LogEntry
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	dropped int
+	sync.Mutex
+}
+
+func NewCloudLogging(c *http.Client, project, zone, instance, instanceID string) (*CloudLogging, error) {
+	s, err := logging.New(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCloudLogging(s, project, zone, instance, instanceID), nil
+}
+
+func newCloudLogging(s *logging.Service, project, zone, instance, instanceID string) *CloudLogging {
+	l := &CloudLogging{
+		s:       s,
+		logName: fmt.Sprintf("projects/%s/logs/%s", project, CloudLoggingName),
+		resource: &logging.MonitoredResource{
+			Type:   "gce_instance",
+			Labels: map[string]string{"project_id": project, "zone": zone, "instance_id": instanceID},
+		},
+		labels:  map[string]string{"instance": instance, "zone": zone},
+		entries: make(chan *logging.LogEntry, CloudLoggingBufferSize),
+		done:    make(chan struct{}),
+	}
+
+	l.wg.Add(1)
+	go l.run()
+	return l
+}
+
+// Log queues the record if it's about a disk, the others are ignored.
+func (l *CloudLogging) Log(r *log15.Record) error {
+	payload := map[string]interface{}{"message": r.Msg}
+	var disk string
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		key := fmt.Sprint(r.Ctx[i])
+		payload[key] = logValue(key, r.Ctx[i+1])
+		if key == "disk" {
+			disk = fmt.Sprint(r.Ctx[i+1])
+		}
+	}
+
+	if disk == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	entry := &logging.LogEntry{
+		JsonPayload: body,
+		Labels:      map[string]string{"disk": disk},
+		Severity:    cloudLoggingSeverities[r.Lvl],
+		Timestamp:   r.Time.UTC().Format(time.RFC3339Nano),
+	}
+
+	select {
+	case l.entries <- entry:
+	default:
+		l.Lock()
+		l.dropped++
+		l.Unlock()
+	}
+
+	return nil
+}
+
+func logValue(key string, value interface{}) interface{} {
+	if contains(RedactedLogFields, key) {
+		return "[redacted]"
+	}
+
+	switch value.(type) {
+	case string, bool, int, int64, float64:
+		return value
+	}
+
+	return fmt.Sprint(value)
+}
+
+func (l *CloudLogging) run() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(CloudLoggingFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*logging.LogEntry
+	for {
+		select {
+		case e := <-l.entries:
+			if batch = append(batch, e); len(batch) >= CloudLoggingBatchSize {
+				batch = l.flush(batch)
+			}
+		case <-ticker.C:
+			batch = l.flush(batch)
+		case <-l.done:
+			for {
+				select {
+				case e := <-l.entries:
+					if batch = append(batch, e); len(batch) >= CloudLoggingBatchSize {
+						batch = l.flush(batch)
+					}
+				default:
+					l.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *CloudLogging) flush(batch []*logging.LogEntry) []*logging.LogEntry {
+	l.Lock()
+	dropped := l.dropped
+	l.dropped = 0
+	l.Unlock()
+
+	if dropped != 0 {
+		log15.Warn("cloud logging buffer full, entries dropped", "dropped", dropped)
+	}
+
+	if len(batch) == 0 {
+		return batch
+	}
+
+	_, err := l.s.Entries.Write(&logging.WriteLogEntriesRequest{
+		LogName:  l.logName,
+		Resource: l.resource,
+		Labels:   l.labels,
+		Entries:  batch,
+	}).Do()
+
+	if err != nil {
+		log15.Warn("error writing to cloud logging", "entries", len(batch), "error", err)
+	}
+
+	return batch[:0]
+}
+
+// Close writes the queued entries and stops the background writer.
+func (l *CloudLogging) Close() error {
+	close(l.done)
+	l.wg.Wait()
+	return nil
+}
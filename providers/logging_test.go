@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/logging/v2"
+	. "gopkg.in/check.v1"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+type CloudLoggingSuite struct {
+	f        *ComputeFixture
+	requests []*logging.WriteLogEntriesRequest
+}
+
+var _ = Suite(&CloudLoggingSuite{})
+
+func (s *CloudLoggingSuite) SetUpTest(c *C) {
+	s.requests = nil
+	s.f = NewComputeFixture()
+	s.f.Handle("POST", "/v2/entries:write", func(r *http.Request) (int, interface{}) {
+		req := &logging.WriteLogEntriesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return http.StatusBadRequest, ComputeError(http.StatusBadRequest, err.Error())
+		}
+
+		s.requests = append(s.requests, req)
+		return http.StatusOK, &logging.WriteLogEntriesResponse{}
+	})
+}
+
+func (s *CloudLoggingSuite) TearDownTest(c *C) {
+	s.f.Close()
+}
+
+func (s *CloudLoggingSuite) CloudLogging() *CloudLogging {
+	ls, err := logging.New(s.f.Client())
+	if err != nil {
+		panic(err)
+	}
+
+	ls.BasePath = s.f.URL + "/"
+	return newCloudLogging(ls, "project", "zone", "instance", "42")
+}
+
+func (s *CloudLoggingSuite) TestLog(c *C) {
+	l := s.CloudLogging()
+	logger := log15.New()
+	logger.SetHandler(l)
+
+	logger.Info("disk created", "disk", "foo", "kms-key", "projects/p/locations/l/keyRings/r/cryptoKeys/k", "elapsed", time.Second)
+	logger.Error("request failed", "error", fmt.Errorf("not found"))
+	logger.Warn("disk not attached", "disk", "bar", "users", []string{"other"})
+	c.Assert(l.Close(), IsNil)
+
+	c.Assert(s.requests, HasLen, 1)
+	req := s.requests[0]
+	c.Assert(req.LogName, Equals, "projects/project/logs/gce-docker")
+	c.Assert(req.Resource.Type, Equals, "gce_instance")
+	c.Assert(req.Resource.Labels["instance_id"], Equals, "42")
+	c.Assert(req.Labels, DeepEquals, map[string]string{"instance": "instance", "zone": "zone"})
+	c.Assert(req.Entries, HasLen, 2)
+
+	e := req.Entries[0]
+	c.Assert(e.Labels, DeepEquals, map[string]string{"disk": "foo"})
+	c.Assert(e.Severity, Equals, "INFO")
+
+	var payload map[string]interface{}
+	c.Assert(json.Unmarshal(e.JsonPayload, &payload), IsNil)
+	c.Assert(payload, DeepEquals, map[string]interface{}{
+		"message": "disk created", "disk": "foo", "kms-key": "[redacted]", "elapsed": "1s",
+	})
+
+	c.Assert(req.Entries[1].Severity, Equals, "WARNING")
+	c.Assert(json.Unmarshal(req.Entries[1].JsonPayload, &payload), IsNil)
+	c.Assert(payload["users"], Equals, "[other]")
+}
+
+func (s *CloudLoggingSuite) TestLogBatches(c *C) {
+	defer func(size int) { CloudLoggingBatchSize = size }(CloudLoggingBatchSize)
+	CloudLoggingBatchSize = 2
+
+	l := s.CloudLogging()
+	for i := 0; i < 5; i++ {
+		c.Assert(l.Log(&log15.Record{Msg: "disk mounted", Lvl: log15.LvlInfo, Ctx: []interface{}{"disk", "foo"}}), IsNil)
+	}
+
+	c.Assert(l.Close(), IsNil)
+	c.Assert(s.requests, HasLen, 3)
+	c.Assert(s.requests[2].Entries, HasLen, 1)
+}
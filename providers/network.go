@@ -51,7 +51,7 @@ func (n *Network) Create(c *NetworkConfig) error {
 }
 
 func (n *Network) updateInstanceTags(c *NetworkConfig) error {
-	i, err := n.s.Instances.Get(n.project, n.zone, n.instance).Do()
+	i, err := n.s.Instances.Get(n.project, n.zone(), n.instance).Do()
 	if err != nil {
 		return err
 	}
@@ -61,7 +61,7 @@ func (n *Network) updateInstanceTags(c *NetworkConfig) error {
 		return nil
 	}
 
-	op, err := n.s.Instances.SetTags(n.project, n.zone, n.instance, &compute.Tags{
+	op, err := n.s.Instances.SetTags(n.project, n.zone(), n.instance, &compute.Tags{
 		Items:       append(i.Tags.Items, tag),
 		Fingerprint: i.Tags.Fingerprint,
 	}).Do()
@@ -75,8 +75,8 @@ func (n *Network) updateInstanceTags(c *NetworkConfig) error {
 }
 
 func (n *Network) createOrUpdateTargetPool(c *NetworkConfig) error {
-	new := c.TargetPool(n.project, n.zone, n.instance)
-	old, err := n.s.TargetPools.Get(n.project, n.region, new.Name).Do()
+	new := c.TargetPool(n.project, n.zone(), n.instance)
+	old, err := n.s.TargetPools.Get(n.project, n.region(), new.Name).Do()
 	if err != nil {
 		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
 			return err
@@ -89,7 +89,7 @@ func (n *Network) createOrUpdateTargetPool(c *NetworkConfig) error {
 }
 
 func (n *Network) createTargetPool(pool *compute.TargetPool) error {
-	op, err := n.s.TargetPools.Insert(n.project, n.region, pool).Do()
+	op, err := n.s.TargetPools.Insert(n.project, n.region(), pool).Do()
 	if err != nil {
 		return err
 	}
@@ -98,9 +98,9 @@ func (n *Network) createTargetPool(pool *compute.TargetPool) error {
 }
 
 func (n *Network) updateTargetPool(old, new *compute.TargetPool) error {
-	op, err := n.s.TargetPools.AddInstance(n.project, n.region, new.Name, &compute.TargetPoolsAddInstanceRequest{
+	op, err := n.s.TargetPools.AddInstance(n.project, n.region(), new.Name, &compute.TargetPoolsAddInstanceRequest{
 		Instances: []*compute.InstanceReference{{
-			Instance: InstanceURL(n.project, n.zone, n.instance),
+			Instance: InstanceURL(n.project, n.zone(), n.instance),
 		}},
 	}).Do()
 
@@ -112,7 +112,7 @@ func (n *Network) updateTargetPool(old, new *compute.TargetPool) error {
 }
 
 func (n *Network) createForwardingRules(c *NetworkConfig) error {
-	targetPoolURL := TargetPoolURL(n.project, n.region, c.Name(n.instance))
+	targetPoolURL := TargetPoolURL(n.project, n.region(), c.Name(n.instance))
 	for _, rule := range c.ForwardingRule(n.instance, targetPoolURL) {
 		if err := n.createForwardingRule(rule); err != nil {
 			return err
@@ -127,7 +127,7 @@ func (n *Network) createForwardingRule(rule *compute.ForwardingRule) error {
 		return err
 	}
 
-	_, err := n.s.ForwardingRules.Get(n.project, n.region, rule.Name).Do()
+	_, err := n.s.ForwardingRules.Get(n.project, n.region(), rule.Name).Do()
 	if err == nil {
 		return nil
 	}
@@ -136,7 +136,7 @@ func (n *Network) createForwardingRule(rule *compute.ForwardingRule) error {
 		return err
 	}
 
-	op, err := n.s.ForwardingRules.Insert(n.project, n.region, rule).Do()
+	op, err := n.s.ForwardingRules.Insert(n.project, n.region(), rule).Do()
 	if err != nil {
 		return err
 	}
@@ -150,7 +150,7 @@ func (n *Network) resolveForwardingRule(rule *compute.ForwardingRule) error {
 		return nil
 	}
 
-	addr, err := n.s.Addresses.Get(n.project, n.region, rule.IPAddress).Do()
+	addr, err := n.s.Addresses.Get(n.project, n.region(), rule.IPAddress).Do()
 	if err != nil {
 		return err
 	}
@@ -204,7 +204,7 @@ func (n *Network) deleteFirewall(c *NetworkConfig) error {
 }
 
 func (n *Network) deleteForwardingRules(c *NetworkConfig) error {
-	targetPoolURL := TargetPoolURL(n.project, n.region, c.Name(n.instance))
+	targetPoolURL := TargetPoolURL(n.project, n.region(), c.Name(n.instance))
 	for _, rule := range c.ForwardingRule(n.instance, targetPoolURL) {
 		if err := n.deleteForwardingRule(rule); err != nil {
 			return err
@@ -215,7 +215,7 @@ func (n *Network) deleteForwardingRules(c *NetworkConfig) error {
 }
 
 func (n *Network) deleteForwardingRule(rule *compute.ForwardingRule) error {
-	op, err := n.s.ForwardingRules.Delete(n.project, n.region, rule.Name).Do()
+	op, err := n.s.ForwardingRules.Delete(n.project, n.region(), rule.Name).Do()
 	if err != nil {
 		return err
 	}
@@ -224,8 +224,8 @@ func (n *Network) deleteForwardingRule(rule *compute.ForwardingRule) error {
 }
 
 func (n *Network) deleteTargetPool(c *NetworkConfig) error {
-	pool := c.TargetPool(n.project, n.zone, n.instance)
-	op, err := n.s.TargetPools.Delete(n.project, n.region, pool.Name).Do()
+	pool := c.TargetPool(n.project, n.zone(), n.instance)
+	op, err := n.s.TargetPools.Delete(n.project, n.region(), pool.Name).Do()
 	if err != nil {
 		return err
 	}
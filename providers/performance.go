@@ -0,0 +1,349 @@
+package providers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// DiskPerformance models the performance and price of a disk type with the
+// formulas published by GCE: the read IOPS and the throughput, in MB/s, grow
+// with the size from a baseline up to a per disk maximum, the instance may
+// limit them further depending on its machine type. The price is the monthly
+// list price per GB in USD, in us-central1.
+//
+// The hyperdisks are Provisioned instead: their IOPS and throughput are set
+// on the disk, from the baseline included in the price of the size up to a
+// limit per GB of size, the rest is billed monthly per IOPS and MB/s. The
+// IOPS of the types only provisioning their throughput follow it.
+type DiskPerformance struct {
+	Type               string
+	IopsPerGb          float64
+	BaseIops           float64
+	MaxIops            float64
+	ThroughputPerGb    float64
+	BaseThroughput     float64
+	MaxThroughput      float64
+	PricePerGb         float64
+	MinSizeGb          int64
+	MaxSizeGb          int64
+	Provisioned        bool
+	MaxIopsPerGb       float64
+	MaxThroughputPerGb float64
+	IopsPerThroughput  float64
+	PricePerIops       float64
+	PricePerThroughput float64
+}
+
+var DiskPerformances = []*DiskPerformance{{
+	Type:            "pd-standard",
+	IopsPerGb:       0.75,
+	MaxIops:         7500,
+	ThroughputPerGb: 0.12,
+	MaxThroughput:   1200,
+	PricePerGb:      0.04,
+	MinSizeGb:       10,
+	MaxSizeGb:       65536,
+}, {
+	Type:            "pd-balanced",
+	IopsPerGb:       6,
+	BaseIops:        3000,
+	MaxIops:         80000,
+	ThroughputPerGb: 0.28,
+	BaseThroughput:  140,
+	MaxThroughput:   1200,
+	PricePerGb:      0.10,
+	MinSizeGb:       10,
+	MaxSizeGb:       65536,
+}, {
+	Type:            "pd-ssd",
+	IopsPerGb:       30,
+	BaseIops:        6000,
+	MaxIops:         100000,
+	ThroughputPerGb: 0.48,
+	BaseThroughput:  240,
+	MaxThroughput:   1200,
+	PricePerGb:      0.17,
+	MinSizeGb:       10,
+	MaxSizeGb:       65536,
+}, {
+	Type:               "hyperdisk-balanced",
+	Provisioned:        true,
+	BaseIops:           3000,
+	MaxIops:            160000,
+	MaxIopsPerGb:       500,
+	BaseThroughput:     140,
+	MaxThroughput:      2400,
+	PricePerGb:         0.08,
+	PricePerIops:       0.005,
+	PricePerThroughput: 0.04,
+	MinSizeGb:          4,
+	MaxSizeGb:          65536,
+}, {
+	Type:               "hyperdisk-throughput",
+	Provisioned:        true,
+	BaseThroughput:     10,
+	MaxThroughput:      600,
+	MaxThroughputPerGb: 0.09,
+	IopsPerThroughput:  4,
+	MaxIops:            2400,
+	PricePerGb:         0.005,
+	PricePerThroughput: 0.125,
+	MinSizeGb:          2048,
+	MaxSizeGb:          32768,
+}}
+
+// Workload is the performance and capacity a disk is required to provide.
+type Workload struct {
+	Iops       float64
+	Throughput float64
+	SizeGb     int64
+}
+
+func (w *Workload) Validate() error {
+	if w.Iops < 0 || w.Throughput < 0 || w.SizeGb < 0 {
+		return fmt.Errorf("invalid workload, the IOPS, throughput and size can't be negative")
+	}
+
+	return nil
+}
+
+// Recommendation is the smallest disk of a type meeting a Workload, with its
+// estimated monthly cost in USD, or the reason the type can't meet it. The
+// IOPS and throughput of a Provisioned one are the ones to provision.
+type Recommendation struct {
+	Type        string
+	SizeGb      int64
+	Iops        float64
+	Throughput  float64
+	MonthlyCost float64
+	Provisioned bool
+	Err         error
+}
+
+// Iops returns the IOPS of a disk of the given size.
+func (p *DiskPerformance) Iops(sizeGb int64) float64 {
+	return math.Min(p.BaseIops+p.IopsPerGb*float64(sizeGb), p.MaxIops)
+}
+
+// Throughput returns the throughput, in MB/s, of a disk of the given size.
+func (p *DiskPerformance) Throughput(sizeGb int64) float64 {
+	return math.Min(p.BaseThroughput+p.ThroughputPerGb*float64(sizeGb), p.MaxThroughput)
+}
+
+// Recommend returns the smallest disk of this type meeting the workload.
+func (p *DiskPerformance) Recommend(w *Workload) *Recommendation {
+	r := &Recommendation{Type: p.Type}
+	if w.Iops > p.MaxIops {
+		r.Err = fmt.Errorf("max. %.0f IOPS", p.MaxIops)
+		return r
+	}
+
+	if w.Throughput > p.MaxThroughput {
+		r.Err = fmt.Errorf("max. %.0f MB/s", p.MaxThroughput)
+		return r
+	}
+
+	if p.Provisioned {
+		return p.recommendProvisioned(w, r)
+	}
+
+	r.SizeGb = maxSize(
+		w.SizeGb, p.MinSizeGb,
+		sizeFor(w.Iops, p.BaseIops, p.IopsPerGb),
+		sizeFor(w.Throughput, p.BaseThroughput, p.ThroughputPerGb),
+	)
+
+	if r.SizeGb > p.MaxSizeGb {
+		r.Err = fmt.Errorf("requires %d GB, max. %d GB", r.SizeGb, p.MaxSizeGb)
+		return r
+	}
+
+	r.Iops = p.Iops(r.SizeGb)
+	r.Throughput = p.Throughput(r.SizeGb)
+	r.MonthlyCost = float64(r.SizeGb) * p.PricePerGb
+	return r
+}
+
+// recommendProvisioned provisions the performance of the workload, at least
+// the baseline, on the smallest disk allowing it.
+func (p *DiskPerformance) recommendProvisioned(w *Workload, r *Recommendation) *Recommendation {
+	r.Provisioned = true
+	r.Iops = math.Max(w.Iops, p.BaseIops)
+	r.Throughput = math.Max(w.Throughput, p.BaseThroughput)
+	if p.IopsPerThroughput != 0 {
+		r.Throughput = math.Max(r.Throughput, math.Ceil(w.Iops/p.IopsPerThroughput))
+		r.Iops = r.Throughput * p.IopsPerThroughput
+	}
+
+	r.SizeGb = maxSize(
+		w.SizeGb, p.MinSizeGb,
+		sizeFor(r.Iops, 0, p.MaxIopsPerGb),
+		sizeFor(r.Throughput, 0, p.MaxThroughputPerGb),
+	)
+
+	if r.SizeGb > p.MaxSizeGb {
+		r.Err = fmt.Errorf("requires %d GB, max. %d GB", r.SizeGb, p.MaxSizeGb)
+		return r
+	}
+
+	r.MonthlyCost = float64(r.SizeGb)*p.PricePerGb +
+		(r.Iops-p.BaseIops)*p.PricePerIops +
+		(r.Throughput-p.BaseThroughput)*p.PricePerThroughput
+	return r
+}
+
+func maxSize(sizes ...int64) int64 {
+	var max int64
+	for _, size := range sizes {
+		if size > max {
+			max = size
+		}
+	}
+
+	return max
+}
+
+// sizeFor returns the size required to reach the target performance, none
+// if it doesn't grow with the size.
+func sizeFor(target, base, perGb float64) int64 {
+	if target <= base || perGb == 0 {
+		return 0
+	}
+
+	return int64(math.Ceil((target - base) / perGb))
+}
+
+// Recommend returns a recommendation per disk type, the types meeting the
+// workload first, from the cheapest.
+func Recommend(w *Workload) []*Recommendation {
+	var rs []*Recommendation
+	for _, p := range DiskPerformances {
+		rs = append(rs, p.Recommend(w))
+	}
+
+	sort.SliceStable(rs, func(i, j int) bool {
+		if (rs[i].Err == nil) != (rs[j].Err == nil) {
+			return rs[i].Err == nil
+		}
+
+		return rs[i].MonthlyCost < rs[j].MonthlyCost
+	})
+
+	return rs
+}
+
+// ProvisionedRange is the IOPS and throughput, in MB/s, that can be
+// provisioned on a disk type, zero when the type doesn't allow it. GCE also
+// limits them by the disk size, it's left to the API to validate it.
+type ProvisionedRange struct {
+	Type          string
+	MinIops       int64
+	MaxIops       int64
+	MinThroughput int64
+	MaxThroughput int64
+}
+
+var ProvisionedRanges = []*ProvisionedRange{{
+	Type:    "pd-extreme",
+	MinIops: 10000,
+	MaxIops: 120000,
+}, {
+	Type:          "hyperdisk-balanced",
+	MinIops:       3000,
+	MaxIops:       160000,
+	MinThroughput: 140,
+	MaxThroughput: 2400,
+}, {
+	Type:    "hyperdisk-extreme",
+	MinIops: 2500,
+	MaxIops: 350000,
+}, {
+	Type:          "hyperdisk-throughput",
+	MinThroughput: 10,
+	MaxThroughput: 600,
+}}
+
+func provisionedRange(diskType string) *ProvisionedRange {
+	for _, r := range ProvisionedRanges {
+		if r.Type == diskType {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// InstanceLimit estimates the IOPS and throughput, in MB/s, an instance can
+// get from a hyperdisk type, growing with its vCPUs up to a maximum. The
+// actual limits depend on the machine series, these are the ones of the
+// general purpose series.
+type InstanceLimit struct {
+	Type             string
+	IopsPerCpu       float64
+	MaxIops          float64
+	ThroughputPerCpu float64
+	MaxThroughput    float64
+}
+
+var HyperdiskInstanceLimits = []*InstanceLimit{{
+	Type:             "hyperdisk-balanced",
+	IopsPerCpu:       6250,
+	MaxIops:          160000,
+	ThroughputPerCpu: 100,
+	MaxThroughput:    2400,
+}, {
+	Type:             "hyperdisk-extreme",
+	IopsPerCpu:       5000,
+	MaxIops:          350000,
+	ThroughputPerCpu: 80,
+	MaxThroughput:    5000,
+}, {
+	Type:             "hyperdisk-throughput",
+	ThroughputPerCpu: 60,
+	MaxThroughput:    2400,
+}}
+
+func hyperdiskInstanceLimit(diskType string) *InstanceLimit {
+	for _, l := range HyperdiskInstanceLimits {
+		if l.Type == diskType {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// EffectivePerformance is the performance provisioned on a hyperdisk and the
+// one the instance gets from it, capped by the instance limits.
+type EffectivePerformance struct {
+	Type                  string
+	ProvisionedIops       int64
+	ProvisionedThroughput int64
+	Iops                  int64
+	Throughput            int64
+}
+
+func newEffectivePerformance(l *InstanceLimit, disk *compute.Disk, cpus int64) *EffectivePerformance {
+	p := &EffectivePerformance{
+		Type:                  l.Type,
+		ProvisionedIops:       disk.ProvisionedIops,
+		ProvisionedThroughput: disk.ProvisionedThroughput,
+		Iops:                  disk.ProvisionedIops,
+		Throughput:            disk.ProvisionedThroughput,
+	}
+
+	if l.MaxIops != 0 {
+		p.Iops = int64(math.Min(float64(p.Iops), math.Min(l.IopsPerCpu*float64(cpus), l.MaxIops)))
+	}
+
+	p.Throughput = int64(math.Min(float64(p.Throughput), math.Min(l.ThroughputPerCpu*float64(cpus), l.MaxThroughput)))
+	return p
+}
+
+// Capped reports whether the instance gets less than what was provisioned.
+func (p *EffectivePerformance) Capped() bool {
+	return p.Iops < p.ProvisionedIops || p.Throughput < p.ProvisionedThroughput
+}
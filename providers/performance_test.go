@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"google.golang.org/api/compute/v1"
+	. "gopkg.in/check.v1"
+)
+
+type PerformanceSuite struct{}
+
+var _ = Suite(&PerformanceSuite{})
+
+func (s *PerformanceSuite) TestRecommendCapacity(c *C) {
+	rs := Recommend(&Workload{SizeGb: 500})
+	c.Assert(rs, HasLen, 5)
+	c.Assert(rs[0].Type, Equals, "hyperdisk-throughput")
+	c.Assert(rs[0].SizeGb, Equals, int64(2048))
+	c.Assert(rs[0].Iops, Equals, 40.0)
+	c.Assert(rs[0].Throughput, Equals, 10.0)
+	c.Assert(rs[0].MonthlyCost, Equals, 2048*DiskPerformances[4].PricePerGb)
+	c.Assert(rs[0].Provisioned, Equals, true)
+
+	c.Assert(rs[1].Type, Equals, "pd-standard")
+	c.Assert(rs[1].SizeGb, Equals, int64(500))
+	c.Assert(rs[1].Iops, Equals, 375.0)
+	c.Assert(rs[1].Throughput, Equals, 60.0)
+	c.Assert(rs[1].MonthlyCost, Equals, 20.0)
+	c.Assert(rs[1].Provisioned, Equals, false)
+}
+
+func (s *PerformanceSuite) TestRecommendIops(c *C) {
+	rs := Recommend(&Workload{Iops: 15000, SizeGb: 100})
+	c.Assert(rs, HasLen, 5)
+
+	c.Assert(rs[0].Type, Equals, "pd-ssd")
+	c.Assert(rs[0].SizeGb, Equals, int64(300))
+	c.Assert(rs[0].Iops, Equals, 15000.0)
+	c.Assert(rs[0].Err, IsNil)
+
+	c.Assert(rs[1].Type, Equals, "hyperdisk-balanced")
+	c.Assert(rs[1].SizeGb, Equals, int64(100))
+	c.Assert(rs[1].Iops, Equals, 15000.0)
+	c.Assert(rs[1].Throughput, Equals, 140.0)
+	c.Assert(rs[1].Err, IsNil)
+
+	c.Assert(rs[2].Type, Equals, "pd-balanced")
+	c.Assert(rs[2].SizeGb, Equals, int64(2000))
+	c.Assert(rs[2].Err, IsNil)
+
+	c.Assert(rs[3].Type, Equals, "pd-standard")
+	c.Assert(rs[3].Err, ErrorMatches, "max. 7500 IOPS")
+
+	c.Assert(rs[4].Type, Equals, "hyperdisk-throughput")
+	c.Assert(rs[4].Err, ErrorMatches, "max. 2400 IOPS")
+}
+
+func (s *PerformanceSuite) TestRecommendProvisioned(c *C) {
+	p := DiskPerformances[3]
+	r := p.Recommend(&Workload{Iops: 50000, Throughput: 1000, SizeGb: 50})
+	c.Assert(r.Type, Equals, "hyperdisk-balanced")
+	c.Assert(r.Err, IsNil)
+	c.Assert(r.SizeGb, Equals, int64(100))
+	c.Assert(r.Iops, Equals, 50000.0)
+	c.Assert(r.Throughput, Equals, 1000.0)
+	c.Assert(r.MonthlyCost, Equals, 100*p.PricePerGb+47000*p.PricePerIops+860*p.PricePerThroughput)
+
+	p = DiskPerformances[4]
+	r = p.Recommend(&Workload{Iops: 400, Throughput: 300})
+	c.Assert(r.Type, Equals, "hyperdisk-throughput")
+	c.Assert(r.Err, IsNil)
+	c.Assert(r.SizeGb, Equals, int64(3334))
+	c.Assert(r.Iops, Equals, 1200.0)
+	c.Assert(r.Throughput, Equals, 300.0)
+	c.Assert(r.MonthlyCost, Equals, 3334*p.PricePerGb+290*p.PricePerThroughput)
+
+	r = p.Recommend(&Workload{Throughput: 700})
+	c.Assert(r.Err, ErrorMatches, "max. 600 MB/s")
+}
+
+func (s *PerformanceSuite) TestRecommendMinSize(c *C) {
+	r := DiskPerformances[1].Recommend(&Workload{Iops: 100})
+	c.Assert(r.SizeGb, Equals, int64(10))
+	c.Assert(r.Iops, Equals, 3060.0)
+}
+
+func (s *PerformanceSuite) TestRecommendMaxSize(c *C) {
+	r := DiskPerformances[0].Recommend(&Workload{SizeGb: 100000})
+	c.Assert(r.Err, ErrorMatches, "requires 100000 GB, max. 65536 GB")
+}
+
+func (s *PerformanceSuite) TestEffectivePerformance(c *C) {
+	disk := &compute.Disk{ProvisionedIops: 200000, ProvisionedThroughput: 1000}
+
+	p := newEffectivePerformance(hyperdiskInstanceLimit("hyperdisk-balanced"), disk, 64)
+	c.Assert(p.Iops, Equals, int64(160000))
+	c.Assert(p.Throughput, Equals, int64(1000))
+	c.Assert(p.Capped(), Equals, true)
+
+	p = newEffectivePerformance(hyperdiskInstanceLimit("hyperdisk-throughput"), disk, 64)
+	c.Assert(p.Iops, Equals, int64(200000))
+	c.Assert(p.Throughput, Equals, int64(1000))
+	c.Assert(p.Capped(), Equals, false)
+
+	c.Assert(hyperdiskInstanceLimit("pd-ssd"), IsNil)
+}
@@ -0,0 +1,49 @@
+package providers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit limits the requests made with the client to limit per second,
+// allowing bursts of as many, shared by every caller of the client, e.g. the
+// concurrent workers of the reconcile at startup. The requests wait for
+// their turn, a zero limit disables it.
+func RateLimit(c *http.Client, limit float64) error {
+	if limit < 0 {
+		return fmt.Errorf("invalid API rate limit, it must not be negative")
+	}
+
+	if limit == 0 {
+		return nil
+	}
+
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	burst := int(math.Max(1, math.Ceil(limit)))
+	c.Transport = &rateLimitedTransport{
+		base:    base,
+		limiter: rate.NewLimiter(rate.Limit(limit), burst),
+	}
+
+	return nil
+}
+
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(r.Context()); err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,43 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RateLimitSuite struct{}
+
+var _ = Suite(&RateLimitSuite{})
+
+func (s *RateLimitSuite) TestRateLimit(c *C) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{}
+	c.Assert(RateLimit(client, 10), IsNil)
+
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		resp, err := client.Get(ts.URL)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+	}
+
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(12))
+	c.Assert(time.Since(start) >= 150*time.Millisecond, Equals, true)
+}
+
+func (s *RateLimitSuite) TestRateLimitDisabled(c *C) {
+	client := &http.Client{}
+	c.Assert(RateLimit(client, 0), IsNil)
+	c.Assert(client.Transport, IsNil)
+
+	c.Assert(RateLimit(client, -1), ErrorMatches, "invalid API rate limit.*")
+}
@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type BackoffStrategy string
+
+const (
+	// BackoffConstant waits the initial delay between every attempt.
+	BackoffConstant BackoffStrategy = "constant"
+	// BackoffExponential multiplies the delay after every attempt, up to the
+	// max. delay.
+	BackoffExponential BackoffStrategy = "exponential"
+	// BackoffExponentialJitter waits a random delay between zero and the
+	// exponential one, spreading the retries of concurrent requests.
+	BackoffExponentialJitter BackoffStrategy = "exponential-jitter"
+)
+
+var DefaultBackoff = Backoff{
+	Strategy:   BackoffExponentialJitter,
+	Initial:    time.Second,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+}
+
+// Clock abstracts the time, so the retries can be tested without waiting.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Backoff computes the delay between the attempts of a failed request.
+type Backoff struct {
+	Strategy   BackoffStrategy
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+
+	clock Clock
+	rand  func() float64
+}
+
+func (b *Backoff) Validate() error {
+	switch b.Strategy {
+	case BackoffConstant, BackoffExponential, BackoffExponentialJitter:
+	default:
+		return fmt.Errorf("invalid backoff, unknown strategy %q", b.Strategy)
+	}
+
+	if b.Initial <= 0 || b.Max < b.Initial {
+		return fmt.Errorf("invalid backoff, initial delay must be positive and not greater than max. delay")
+	}
+
+	if b.Multiplier < 1 {
+		return fmt.Errorf("invalid backoff, multiplier must be at least 1")
+	}
+
+	return nil
+}
+
+// Delay returns the time to wait before the given retry, starting at zero.
+func (b *Backoff) Delay(retry int) time.Duration {
+	if b.Strategy == BackoffConstant {
+		return b.Initial
+	}
+
+	delay := time.Duration(float64(b.Initial) * math.Pow(b.Multiplier, float64(retry)))
+	if delay > b.Max || delay <= 0 {
+		delay = b.Max
+	}
+
+	if b.Strategy == BackoffExponentialJitter {
+		delay = time.Duration(b.random() * float64(delay))
+	}
+
+	return delay
+}
+
+// Retry calls f up to attempts times, waiting between them, while it fails
+// with an error accepted by retryable. The last error is returned.
+func (b *Backoff) Retry(attempts int, retryable func(error) bool, f func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			b.Clock().Sleep(b.Delay(i - 1))
+		}
+
+		if err = f(); err == nil || !retryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (b *Backoff) Clock() Clock {
+	if b.clock == nil {
+		return systemClock{}
+	}
+
+	return b.clock
+}
+
+func (b *Backoff) random() float64 {
+	if b.rand == nil {
+		return rand.Float64()
+	}
+
+	return b.rand()
+}
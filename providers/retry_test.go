@@ -0,0 +1,107 @@
+package providers
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RetrySuite struct{}
+
+var _ = Suite(&RetrySuite{})
+
+// FakeClock is a Clock that advances when it sleeps, recording every sleep.
+type FakeClock struct {
+	now    time.Time
+	Sleeps []time.Duration
+}
+
+func (c *FakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Sleeps = append(c.Sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func (s *RetrySuite) backoff(strategy BackoffStrategy) (*Backoff, *FakeClock) {
+	clock := &FakeClock{}
+	return &Backoff{
+		Strategy:   strategy,
+		Initial:    time.Second,
+		Max:        10 * time.Second,
+		Multiplier: 2,
+		clock:      clock,
+		rand:       func() float64 { return 0.5 },
+	}, clock
+}
+
+func (s *RetrySuite) sleeps(b *Backoff, clock *FakeClock, attempts int) []time.Duration {
+	b.Retry(attempts, func(error) bool { return true }, func() error {
+		return fmt.Errorf("foo")
+	})
+
+	return clock.Sleeps
+}
+
+func (s *RetrySuite) TestRetryConstant(c *C) {
+	b, clock := s.backoff(BackoffConstant)
+	c.Assert(s.sleeps(b, clock, 4), DeepEquals, []time.Duration{
+		time.Second, time.Second, time.Second,
+	})
+}
+
+func (s *RetrySuite) TestRetryExponential(c *C) {
+	b, clock := s.backoff(BackoffExponential)
+	c.Assert(s.sleeps(b, clock, 6), DeepEquals, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second,
+	})
+}
+
+func (s *RetrySuite) TestRetryExponentialJitter(c *C) {
+	b, clock := s.backoff(BackoffExponentialJitter)
+	c.Assert(s.sleeps(b, clock, 6), DeepEquals, []time.Duration{
+		500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second,
+	})
+}
+
+func (s *RetrySuite) TestRetryStops(c *C) {
+	b, clock := s.backoff(BackoffConstant)
+
+	var calls int
+	err := b.Retry(5, func(error) bool { return false }, func() error {
+		calls++
+		return fmt.Errorf("foo")
+	})
+	c.Assert(err, ErrorMatches, "foo")
+	c.Assert(calls, Equals, 1)
+
+	err = b.Retry(5, func(error) bool { return true }, func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("foo")
+		}
+
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(clock.Sleeps, HasLen, 1)
+}
+
+func (s *RetrySuite) TestBackoffValidate(c *C) {
+	b := DefaultBackoff
+	c.Assert(b.Validate(), IsNil)
+
+	b.Strategy = "foo"
+	c.Assert(b.Validate(), NotNil)
+
+	b = DefaultBackoff
+	b.Max = b.Initial / 2
+	c.Assert(b.Validate(), NotNil)
+
+	b = DefaultBackoff
+	b.Multiplier = 0.5
+	c.Assert(b.Validate(), NotNil)
+}